@@ -0,0 +1,98 @@
+package geecache
+
+import "sync"
+
+const (
+	// smallValueBytes 是放入 smallArena 的值的上限
+	smallValueBytes = 64
+	// arenaChunkBytes 是 smallArena 每次分配的块的最大大小
+	arenaChunkBytes = 4 << 10
+	// defaultPromoteDistance 是 WithSmallValueFastPath 的默认近似距离
+	defaultPromoteDistance = 16
+)
+
+// smallArena 把很小的值复制到共享的大块内存中，每个块只分配一次，代替每个值各自分配。
+// 块中的值都被淘汰之后整个块才会被回收，因此缓存把被引用的块整块计入上限，见 overhead。
+type smallArena struct {
+	mu         sync.Mutex
+	chunkBytes int         // 每个块的大小，见 newSmallArena
+	chunk      []byte      // 当前块中尚未使用的部分
+	cur        *arenaChunk // 当前块
+	// 被缓存中的值引用的块的总字节数，以及这些值自身的字节数
+	pinned, used int64
+}
+
+// newSmallArena 为字节上限为 cacheBytes 的缓存创建 smallArena。块整块计入上限，
+// 较小的缓存使用较小的块，至少能容纳 8 个块
+func newSmallArena(cacheBytes int64) *smallArena {
+	size := int64(arenaChunkBytes)
+	if cacheBytes > 0 && cacheBytes/8 < size {
+		size = cacheBytes / 8
+	}
+	if size < smallValueBytes {
+		size = smallValueBytes
+	}
+	return &smallArena{chunkBytes: int(size)}
+}
+
+// arenaChunk 记录一个块被缓存中的多少个值引用，由 smallArena.mu 保护
+type arenaChunk struct {
+	live int
+}
+
+// view 复制 b 并返回它的视图，小值从当前块中切出并记录所在的块，其余的值单独分配
+func (a *smallArena) view(b []byte) ByteView {
+	if a == nil || len(b) == 0 || len(b) > smallValueBytes {
+		return ByteView{b: cloneBytes(b)}
+	}
+	a.mu.Lock()
+	if len(a.chunk) < len(b) {
+		a.chunk = make([]byte, a.chunkBytes)
+		a.cur = &arenaChunk{}
+	}
+	// 限制容量，避免 append 写入相邻的值
+	c := a.chunk[:len(b):len(b)]
+	a.chunk = a.chunk[len(b):]
+	chunk := a.cur
+	a.mu.Unlock()
+	copy(c, b)
+	return ByteView{b: c, chunk: chunk}
+}
+
+// retain 记录缓存保存了 v，v 是块中的第一个值时整块计入 pinned
+func (a *smallArena) retain(v ByteView) {
+	if a == nil || v.chunk == nil {
+		return
+	}
+	a.mu.Lock()
+	if v.chunk.live == 0 {
+		a.pinned += int64(a.chunkBytes)
+	}
+	v.chunk.live++
+	a.used += int64(len(v.b))
+	a.mu.Unlock()
+}
+
+// release 记录缓存不再保存 v，块中没有被缓存的值时不再计入 pinned
+func (a *smallArena) release(v ByteView) {
+	if a == nil || v.chunk == nil {
+		return
+	}
+	a.mu.Lock()
+	v.chunk.live--
+	if v.chunk.live == 0 {
+		a.pinned -= int64(a.chunkBytes)
+	}
+	a.used -= int64(len(v.b))
+	a.mu.Unlock()
+}
+
+// overhead 返回被引用的块中不属于被缓存的值的字节数，即缓存统计之外被块占用的内存
+func (a *smallArena) overhead() int64 {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.pinned - a.used
+}
@@ -0,0 +1,163 @@
+package geecache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultMaxRequestBytes 是请求体默认的最大字节数
+	defaultMaxRequestBytes = 64 << 20
+	// defaultBodyReadTimeout 是读取请求体默认的最长时间
+	defaultBodyReadTimeout = 30 * time.Second
+	// initialBodyBuffer 是读取请求体时预分配的最大字节数
+	initialBodyBuffer = 64 << 10
+)
+
+// RequestLimits 限制 PUT 和 POST 请求体，防止客户端发送过大或过慢的请求体耗尽内存和连接
+type RequestLimits struct {
+	// MaxBytes 是请求体的最大字节数，为 0 时使用默认的 64MB，负数表示不限制
+	MaxBytes int64
+	// BodyTimeout 是读取整个请求体的最长时间，为 0 时使用默认的 30 秒，负数表示不限制。
+	// 超时的请求得到 408，随后连接被关闭。
+	BodyTimeout time.Duration
+}
+
+// bodyError 是请求体被拒绝时的 JSON 响应
+type bodyError struct {
+	Error string `json:"error"`
+	Limit int64  `json:"limit,omitempty"`
+}
+
+// SetRequestLimits 设置请求体的限制，在开始处理请求之前调用
+func (p *HTTPPool) SetRequestLimits(l RequestLimits) {
+	p.limits = l
+}
+
+func (p *HTTPPool) maxRequestBytes() int64 {
+	if p.limits.MaxBytes == 0 {
+		return defaultMaxRequestBytes
+	}
+	return p.limits.MaxBytes
+}
+
+func (p *HTTPPool) bodyTimeout() time.Duration {
+	if p.limits.BodyTimeout == 0 {
+		return defaultBodyReadTimeout
+	}
+	return p.limits.BodyTimeout
+}
+
+// readBody 在限制内读取请求体，失败时写入错误响应并返回 false。
+// 没有 Content-Length 的请求得到 411，超过上限的得到 413，读取超时的得到 408。
+func (p *HTTPPool) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	max := p.maxRequestBytes()
+	if r.ContentLength < 0 {
+		atomic.AddInt64(&p.stats.RejectedBodies, 1)
+		writeJSON(w, http.StatusLengthRequired, bodyError{Error: "Content-Length is required"})
+		return nil, false
+	}
+	if max > 0 && r.ContentLength > max {
+		return nil, p.tooLargeBody(w, max)
+	}
+
+	body := r.Body
+	if max > 0 {
+		body = http.MaxBytesReader(w, r.Body, max)
+	}
+	d := p.bodyTimeout()
+	if d <= 0 {
+		b, err := readAllBody(body, r.ContentLength)
+		return p.bodyRead(w, b, err, max)
+	}
+	// 优先在连接上设置读取截止时间，超时后读取直接返回，不需要额外的协程
+	if rd := readDeadliner(w); rd != nil && rd.SetReadDeadline(time.Now().Add(d)) == nil {
+		b, err := readAllBody(body, r.ContentLength)
+		if err == nil {
+			// 读取失败（例如超时）时保留截止时间，响应后服务器无法读完剩余的请求体，连接随之关闭
+			rd.SetReadDeadline(time.Time{})
+		}
+		return p.bodyRead(w, b, err, max)
+	}
+
+	// 不支持截止时间的 ResponseWriter 只能在协程中读取，超时后放弃等待，协程直到连接关闭才退出
+	type result struct {
+		b   []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		b, err := readAllBody(body, r.ContentLength)
+		done <- result{b, err}
+	}()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case res := <-done:
+		return p.bodyRead(w, res.b, res.err, max)
+	case <-t.C:
+		return nil, p.slowBody(w)
+	}
+}
+
+// readAllBody 读取整个请求体。按 Content-Length 预分配，但不超过 initialBodyBuffer，
+// 避免慢速客户端声明很大的长度占用内存
+func readAllBody(body io.Reader, length int64) ([]byte, error) {
+	n := length
+	if n > initialBodyBuffer {
+		n = initialBodyBuffer
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, n))
+	_, err := io.Copy(buf, body)
+	return buf.Bytes(), err
+}
+
+// bodyRead 检查读取请求体的结果，失败时写入对应的错误响应
+func (p *HTTPPool) bodyRead(w http.ResponseWriter, b []byte, err error, max int64) ([]byte, bool) {
+	var ne net.Error
+	switch {
+	case err == nil:
+		return b, true
+	case errors.As(err, &ne) && ne.Timeout():
+		return nil, p.slowBody(w)
+	case max > 0 && int64(len(b)) >= max:
+		return nil, p.tooLargeBody(w, max)
+	}
+	writeJSON(w, http.StatusBadRequest, bodyError{Error: err.Error()})
+	return nil, false
+}
+
+// slowBody 响应读取超时的请求，连接上还有未读完的请求体，响应后关闭连接
+func (p *HTTPPool) slowBody(w http.ResponseWriter) bool {
+	atomic.AddInt64(&p.stats.SlowBodies, 1)
+	w.Header().Set("Connection", "close")
+	writeJSON(w, http.StatusRequestTimeout, bodyError{Error: fmt.Sprintf("request body not received within %v", p.bodyTimeout())})
+	return false
+}
+
+// readDeadliner 返回能设置连接读取截止时间的 ResponseWriter，沿 Unwrap 查找被包装的 ResponseWriter，
+// 与 http.ResponseController 的查找方式相同。不支持时返回 nil
+func readDeadliner(w http.ResponseWriter) interface{ SetReadDeadline(time.Time) error } {
+	for {
+		switch t := w.(type) {
+		case interface{ SetReadDeadline(time.Time) error }:
+			return t
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *HTTPPool) tooLargeBody(w http.ResponseWriter, max int64) bool {
+	atomic.AddInt64(&p.stats.RejectedBodies, 1)
+	writeJSON(w, http.StatusRequestEntityTooLarge, bodyError{Error: "request body too large", Limit: max})
+	return false
+}
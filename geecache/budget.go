@@ -0,0 +1,161 @@
+package geecache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BudgetArbiter 让多个 Group 的 mainCache 共享一个总的字节预算。
+// 它定期比较各 Group 最近的命中数与分配的字节数，把预算从每字节命中最少的 Group
+// 逐步转给命中最多且已用满预算的 Group，每个 Group 的分配不低于 floor。
+// 分配之和始终等于总预算；hotCache 不计入共享预算。
+type BudgetArbiter struct {
+	mu     sync.Mutex
+	total  int64
+	floor  int64
+	shares []*budgetShare
+	stop   chan struct{}
+}
+
+// budgetShare 是一个 Group 在共享预算中的份额
+type budgetShare struct {
+	g        *Group
+	alloc    int64
+	lastHits int64 // 上次重新分配时的命中数
+}
+
+// NewBudgetArbiter 创建总预算为 totalBytes 的仲裁者，每个 Group 至少分得 floorBytes。
+// interval 大于 0 时在一个后台协程中按该间隔重新分配，调用 Stop 停止。
+func NewBudgetArbiter(totalBytes, floorBytes int64, interval time.Duration) *BudgetArbiter {
+	if floorBytes < 1 {
+		floorBytes = 1
+	}
+	a := &BudgetArbiter{total: totalBytes, floor: floorBytes, stop: make(chan struct{})}
+	if interval > 0 {
+		go a.loop(interval)
+	}
+	return a
+}
+
+// Stop 停止后台的重新分配
+func (a *BudgetArbiter) Stop() {
+	select {
+	case <-a.stop:
+	default:
+		close(a.stop)
+	}
+}
+
+func (a *BudgetArbiter) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.rebalance()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Allocations 返回各 Group 当前分得的字节数，键为 Group 的名称
+func (a *BudgetArbiter) Allocations() map[string]int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := make(map[string]int64, len(a.shares))
+	for _, s := range a.shares {
+		m[s.g.name] = s.alloc
+	}
+	return m
+}
+
+// Usage 返回所有 Group 的 mainCache 当前占用的总字节数
+func (a *BudgetArbiter) Usage() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var n int64
+	for _, s := range a.shares {
+		n += s.g.mainCache.bytes()
+	}
+	return n
+}
+
+// add 加入一个 Group，并把总预算在所有 Group 之间平分
+func (a *BudgetArbiter) add(g *Group) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.shares = append(a.shares, &budgetShare{g: g, lastHits: atomic.LoadInt64(&g.stats.CacheHits)})
+	a.splitLocked()
+}
+
+// remove 移除一个 Group，它的预算由剩下的 Group 平分
+func (a *BudgetArbiter) remove(g *Group) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, s := range a.shares {
+		if s.g == g {
+			a.shares = append(a.shares[:i], a.shares[i+1:]...)
+			a.splitLocked()
+			return
+		}
+	}
+}
+
+// splitLocked 平分总预算，余数分给最早加入的 Group
+func (a *BudgetArbiter) splitLocked() {
+	n := int64(len(a.shares))
+	if n == 0 {
+		return
+	}
+	for i, s := range a.shares {
+		s.alloc = a.total / n
+		if int64(i) < a.total%n {
+			s.alloc++
+		}
+		s.g.mainCache.resize(s.alloc)
+	}
+}
+
+// rebalance 把一部分预算从每字节命中最少的 Group 转给命中最多的 Group
+func (a *BudgetArbiter) rebalance() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var best, worst *budgetShare
+	var bestScore, worstScore float64
+	for _, s := range a.shares {
+		hits := atomic.LoadInt64(&s.g.stats.CacheHits)
+		delta := hits - s.lastHits
+		if delta < 0 {
+			// 计数被 ResetStats 清零过
+			delta = hits
+		}
+		s.lastHits = hits
+		score := float64(delta) / float64(s.alloc)
+		if best == nil || score > bestScore {
+			best, bestScore = s, score
+		}
+		if s.alloc > a.floor && (worst == nil || score < worstScore) {
+			worst, worstScore = s, score
+		}
+	}
+	if best == nil || worst == nil || best == worst || bestScore <= worstScore {
+		return
+	}
+	// 只有命中多的一方确实用满了预算时，转移才有意义
+	if best.g.mainCache.bytes() < best.alloc*9/10 {
+		return
+	}
+	step := a.total / 10
+	if step < 1 {
+		step = 1
+	}
+	if worst.alloc-step < a.floor {
+		step = worst.alloc - a.floor
+	}
+	worst.alloc -= step
+	best.alloc += step
+	worst.g.mainCache.resize(worst.alloc)
+	best.g.mainCache.resize(best.alloc)
+}
@@ -1,8 +1,25 @@
 package geecache
 
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
 // ByteView 保存字节的不可变视图。
 type ByteView struct {
 	b []byte
+	// 通过 SetWithMeta 附加的元数据，不可修改
+	meta map[string]string
+	// 开启了解码缓存时保存解码后的对象，不参与 Len
+	decoded *decodedValue
+	// 开启了小值快速路径时 b 所在的块，见 smallArena
+	chunk *arenaChunk
 }
 
 // Len 返回视图的长度
@@ -15,13 +32,87 @@ func (v ByteView) ByteSlice() []byte {
 	return cloneBytes(v.b)
 }
 
+// responseBytes 返回写入远程响应的数据。不可变 Group 的值不会被替换或修改，直接共享底层数组，
+// 其它 Group 返回副本
+func (g *Group) responseBytes(v ByteView) []byte {
+	if g.immutable {
+		return v.b
+	}
+	return v.ByteSlice()
+}
+
 // String 将数据作为字符串返回，必要时进行复制。
 func (v ByteView) String() string {
 	return string(v.b)
 }
 
+// ErrRangeNotSatisfiable 表示 Range 请求的范围不在数据之内，对应 HTTP 的 416
+var ErrRangeNotSatisfiable = errors.New("geecache: range not satisfiable")
+
+// Range 返回从 start 开始、长度为 length 的视图，与原视图共享底层数据而不复制。
+// 与 HTTP 的 Range 语义一致：start 必须在数据之内，超出末尾的部分被截断；
+// start 为负数、不小于 Len 或 length 小于 1 时返回 ErrRangeNotSatisfiable。
+// 返回的视图不带元数据和解码缓存。
+func (v ByteView) Range(start, length int) (ByteView, error) {
+	if start < 0 || start >= len(v.b) || length < 1 {
+		return ByteView{}, fmt.Errorf("%w: start %d, length %d, size %d", ErrRangeNotSatisfiable, start, length, len(v.b))
+	}
+	end := len(v.b)
+	if length < end-start {
+		end = start + length
+	}
+	return ByteView{b: v.b[start:end:end]}, nil
+}
+
+// DecodeError 表示 ByteView 中的数据无法用 Codec 解码，与缓存未命中区分开
+type DecodeError struct {
+	Codec string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("geecache: decode %s: %v", e.Codec, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeJSON 将数据作为 JSON 解码到 dst，直接读取内部切片而不复制
+func (v ByteView) DecodeJSON(dst interface{}) error {
+	if err := json.Unmarshal(v.b, dst); err != nil {
+		return &DecodeError{Codec: "json", Err: err}
+	}
+	return nil
+}
+
+// DecodeGob 将数据作为 gob 解码到 dst，直接读取内部切片而不复制
+func (v ByteView) DecodeGob(dst interface{}) error {
+	if err := gob.NewDecoder(bytes.NewReader(v.b)).Decode(dst); err != nil {
+		return &DecodeError{Codec: "gob", Err: err}
+	}
+	return nil
+}
+
+// DecodeProto 将数据作为 protobuf 消息解码到 m，直接读取内部切片而不复制。
+// bytes 字段会被复制，m 不会引用 ByteView 的数据。
+func (v ByteView) DecodeProto(m proto.Message) error {
+	if err := proto.Unmarshal(v.b, m); err != nil {
+		return &DecodeError{Codec: "proto", Err: err}
+	}
+	return nil
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
 	return c
 }
+
+// cloneString 复制字符串，避免长期保存的键引用调用者的大缓冲区
+func cloneString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	b.WriteString(s)
+	return b.String()
+}
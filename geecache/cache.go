@@ -10,35 +10,362 @@ type cache struct {
 	mu         sync.Mutex
 	lru        *lru.Cache
 	cacheBytes int64
+	now        func() time.Time
+	maxAge     time.Duration
+	newPolicy  func() lru.Policy // 非 nil 时为 lru 创建淘汰策略
+	noExpiry   bool              // 为 true 时使用不支持过期的 lru
+	// 大于 0 时每次写入最多同步淘汰的条目数，以及允许暂时超过上限的字节数
+	maxEvictions  int
+	evictionSlack int64
+	// 批量淘汰的高低水位，见 lru.Cache.HighWatermark
+	highWatermark, lowWatermark float64
+	// 非 nil 时容量淘汰跳过返回 false 的条目，见 lru.Cache.EvictionFilter
+	evictionFilter func(key string, value ByteView) bool
+	// 非 nil 时压缩较大的值
+	compression *compression
+	// 非 nil 时在用量越过高低水位时通知
+	alerts *usageAlerts
+	// 非 nil 时写入带 TTL 的条目后以过期时间调用，通知后台清理提前醒来；
+	// 写入后仍超过上限时以当前时间调用
+	onExpiry func(at time.Time)
+	// 见 lru.Cache.PromoteDistance
+	promoteDistance int
+	// 非 nil 时值可能来自 arena 的块，被引用的块整块计入 cacheBytes
+	arena *smallArena
+	// 大于 1 时 arena 被这么多个分片共用，每个分片只计入块的未统计部分的相应份额
+	arenaShare int64
+
+	// 大于 1 时缓存分成 shardCount 个分片，第一次使用时按当前的配置创建，见 WithCacheShards
+	shardCount int
+	shardsOnce sync.Once
+	shards     []*cache
+	// 下一次过期清理开始的分片，由 mu 保护
+	nextClean int
+}
+
+// cacheValue 是存入 lru 的值，大小包含元数据
+type cacheValue struct {
+	view       ByteView
+	compressed bool // view 中是压缩后的数据
+}
+
+func (v cacheValue) Len() int {
+	return v.view.Len() + metaBytes(v.view.meta)
+}
+
+// add 写入缓存，返回被淘汰的条目数以及值是否最终留在了缓存中
+func (c *cache) add(key string, value ByteView, ttl time.Duration) (evicted int, stored bool) {
+	return c.addWithFlags(key, value, ttl, 0)
 }
 
-func (c *cache) add(key string, value ByteView, ttl time.Duration) {
+func (c *cache) addWithFlags(key string, value ByteView, ttl time.Duration, flags uint32) (evicted int, stored bool) {
+	if c.sharded() {
+		defer c.observeUsage()
+		return c.shardFor(key).addWithFlags(key, value, ttl, flags)
+	}
+	// 在锁外压缩
+	cv := c.compression.pack(value)
+	// wake 不为零值时在释放锁之后通知 onExpiry，条目此时已经在过期堆中
+	var wake time.Time
+	defer func() {
+		if !wake.IsZero() && c.onExpiry != nil {
+			c.onExpiry(wake)
+		}
+	}()
+	if ttl > 0 {
+		wake = c.now().Add(ttl)
+	}
 	c.mu.Lock()
+	defer c.observeUsage()
 	defer c.mu.Unlock()
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+		if c.noExpiry {
+			c.lru = lru.NewNoExpiry(c.cacheBytes, nil)
+		} else {
+			c.lru = lru.New(c.cacheBytes, nil)
+		}
+		c.lru.Logger = logger
+		c.lru.Now = c.now
+		c.lru.MaxAge = c.maxAge
+		c.lru.MaxEvictionsPerAdd = c.maxEvictions
+		c.lru.EvictionSlack = c.evictionSlack
+		c.lru.HighWatermark, c.lru.LowWatermark = c.highWatermark, c.lowWatermark
+		c.lru.PromoteDistance = c.promoteDistance
+		if c.evictionFilter != nil {
+			c.lru.EvictionFilter = c.filterEviction
+		}
+		if c.newPolicy != nil {
+			c.lru.Policy = c.newPolicy()
+		}
+		if c.arena != nil {
+			c.lru.OnEvicted = c.releaseChunk
+		}
+	}
+	if ttl >= 0 {
+		// 写入后可能立即被淘汰，必须在 AddWithFlags 之前记录
+		c.arena.retain(cv.view)
+	}
+	evicted = c.lru.AddWithFlags(key, cv, ttl, flags)
+	evicted += c.fitArenaLocked()
+	if c.maxEvictions > 0 && c.cacheBytes > 0 && c.lru.Bytes() > c.cacheBytes {
+		// 被 MaxEvictionsPerAdd 推迟的淘汰由后台清理完成，没有带 TTL 的条目时也要尽快唤醒
+		wake = c.now()
+	}
+	return evicted, c.lru.Contains(key)
+}
+
+// fitArenaLocked 把 arena 中被引用的块没有被统计的部分从 lru 的上限中扣除，淘汰条目直到总量不超过 cacheBytes。
+// 淘汰会让块中更多的字节不被统计，因此重复直到稳定。
+func (c *cache) fitArenaLocked() (evicted int) {
+	if c.arena == nil || c.cacheBytes <= 0 {
+		return 0
+	}
+	for {
+		limit := c.cacheBytes - c.arenaOverhead()
+		if limit < 1 {
+			limit = 1
+		}
+		n := c.lru.Resize(limit)
+		evicted += n
+		if n == 0 || c.lru.Len() == 0 {
+			return evicted
+		}
+	}
+}
+
+// releaseChunk 是设置了 arena 时 lru 的 OnEvicted，值被淘汰、删除或替换后释放它对块的引用
+func (c *cache) releaseChunk(key string, value lru.Value) {
+	c.arena.release(value.(cacheValue).view)
+}
+
+// filterEviction 把 lru 中的值还原为调用者看到的 ByteView 再交给 evictionFilter，解压失败的条目直接淘汰
+func (c *cache) filterEviction(key string, value lru.Value) bool {
+	view, err := c.compression.unpack(value.(cacheValue))
+	if err != nil {
+		return true
 	}
-	c.lru.Add(key, value, ttl)
+	return c.evictionFilter(key, view)
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
+	value, _, ok = c.getWithInfo(key)
+	return
+}
+
+func (c *cache) getWithInfo(key string) (value ByteView, info lru.EntryInfo, ok bool) {
+	if c.sharded() {
+		return c.shardFor(key).getWithInfo(key)
+	}
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	if c.lru == nil {
+		c.mu.Unlock()
+		return
+	}
+	v, info, ok := c.lru.GetWithInfo(key)
+	c.mu.Unlock()
+	if !ok {
 		return
 	}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+	// 在锁外解压，失败时当作未命中
+	value, err := c.compression.unpack(v.(cacheValue))
+	if err != nil {
+		logger.Printf("[GeeCache] Failed to decompress key %s: %v", key, err)
+		c.remove(key)
+		return ByteView{}, lru.EntryInfo{}, false
 	}
+	return value, info, true
+}
 
-	return
+// peek 返回键的条目信息，不解压值，也不改变淘汰顺序
+func (c *cache) peek(key string) (lru.EntryInfo, bool) {
+	if c.sharded() {
+		return c.shardFor(key).peek(key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return lru.EntryInfo{}, false
+	}
+	return c.lru.Peek(key)
 }
 
 func (c *cache) cleanExpired() {
+	if c.sharded() {
+		c.cleanShards()
+		return
+	}
 	c.mu.Lock()
+	defer c.observeUsage()
 	defer c.mu.Unlock()
 	if c.lru != nil {
 		c.lru.CleanExpired()
 	}
 }
+
+func (c *cache) remove(key string) bool {
+	if c.sharded() {
+		defer c.observeUsage()
+		return c.shardFor(key).remove(key)
+	}
+	c.mu.Lock()
+	defer c.observeUsage()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	return c.lru.Remove(key)
+}
+
+func (c *cache) removeFunc(fn func(key string) bool) int {
+	if c.sharded() {
+		defer c.observeUsage()
+		n := 0
+		for _, s := range c.shards {
+			n += s.removeFunc(fn)
+		}
+		return n
+	}
+	c.mu.Lock()
+	defer c.observeUsage()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.RemoveFunc(func(key string, value lru.Value) bool {
+		return fn(key)
+	})
+}
+
+func (c *cache) pendingExpiry() (n int, next time.Time) {
+	if c.sharded() {
+		// 逐个分片读取，不同时持有多个分片的锁
+		for _, s := range c.shards {
+			sn, snext := s.pendingExpiry()
+			n += sn
+			if !snext.IsZero() && (next.IsZero() || snext.Before(next)) {
+				next = snext
+			}
+		}
+		return n, next
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0, time.Time{}
+	}
+	return c.lru.PendingExpiry()
+}
+
+// resize 修改缓存的字节上限，缩小时立即淘汰
+func (c *cache) resize(cacheBytes int64) {
+	if c.sharded() {
+		c.resizeShards(cacheBytes)
+		return
+	}
+	c.mu.Lock()
+	defer c.observeUsage()
+	defer c.mu.Unlock()
+	c.cacheBytes = cacheBytes
+	if c.lru != nil {
+		c.lru.Resize(cacheBytes)
+		c.fitArenaLocked()
+	}
+}
+
+// observeUsage 在写入或删除之后检查用量是否越过水位，必须在释放锁之后调用
+func (c *cache) observeUsage() {
+	if c.alerts == nil {
+		return
+	}
+	c.alerts.observe(c.bytes(), c.limit(), c.now())
+}
+
+// limit 返回缓存当前的字节上限，0 表示不限制
+func (c *cache) limit() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cacheBytes
+}
+
+// bytes 返回缓存当前占用的字节数，包含 arena 中被引用的块的其余部分
+func (c *cache) bytes() int64 {
+	if c.sharded() {
+		var n int64
+		for _, s := range c.shards {
+			n += s.bytes()
+		}
+		return n
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytesLocked()
+}
+
+func (c *cache) bytesLocked() int64 {
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Bytes() + c.arenaOverhead()
+}
+
+// arenaOverhead 返回 arena 中被引用的块没有被统计的部分，共用 arena 的分片各计入一份
+func (c *cache) arenaOverhead() int64 {
+	n := c.arena.overhead()
+	if c.arenaShare > 1 {
+		n /= c.arenaShare
+	}
+	return n
+}
+
+// cacheEntry 是 entries 返回的一个条目
+type cacheEntry struct {
+	key  string
+	view ByteView
+	info lru.EntryInfo
+}
+
+// oldest 按淘汰顺序返回最多 n 个键
+func (c *cache) oldest(n int) []string {
+	if c.sharded() {
+		return c.oldestShards(n)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	return c.lru.OldestN(n)
+}
+
+// entries 从最旧到最新返回所有未过期的条目，未压缩的值不会被复制，无法解压的条目被跳过
+func (c *cache) entries() []cacheEntry {
+	if c.sharded() {
+		return c.shardEntries()
+	}
+	c.mu.Lock()
+	if c.lru == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	values := make([]cacheValue, 0, c.lru.Len())
+	es := make([]cacheEntry, 0, c.lru.Len())
+	c.lru.Range(func(key string, value lru.Value, info lru.EntryInfo) bool {
+		values = append(values, value.(cacheValue))
+		es = append(es, cacheEntry{key: key, info: info})
+		return true
+	})
+	c.mu.Unlock()
+
+	n := 0
+	for i, cv := range values {
+		v, err := c.compression.unpack(cv)
+		if err != nil {
+			continue
+		}
+		es[n] = es[i]
+		es[n].view = v
+		n++
+	}
+	return es[:n]
+}
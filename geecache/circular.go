@@ -0,0 +1,48 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrCircularLoad 表示 Getter 在加载一个键时又通过 GetCtx 请求了正在加载的同一个键，
+// 继续等待 singleflight 会永远阻塞。只有 Getter 把收到的 ctx 传给 GetCtx 时才能检测到。
+var ErrCircularLoad = errors.New("geecache: circular load")
+
+type loadChainKey struct{}
+
+// loadChain 记录当前调用链上正在加载的键，保存在传给 Getter 的 ctx 中
+type loadChain struct {
+	key    string // flightKey，包含 group 名称
+	parent *loadChain
+	// 正在加载的 Group 允许的最大值字节数，0 表示不限制
+	maxValueBytes int64
+}
+
+// loadChainFrom 返回 ctx 中记录的调用链，没有时返回 nil
+func loadChainFrom(ctx context.Context) *loadChain {
+	c, _ := ctx.Value(loadChainKey{}).(*loadChain)
+	return c
+}
+
+func (c *loadChain) contains(key string) bool {
+	for ; c != nil; c = c.parent {
+		if c.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// withLoading 返回在调用链 parent 之上记录了 key 的 ctx
+func withLoading(ctx context.Context, parent *loadChain, key string, maxValueBytes int64) context.Context {
+	return context.WithValue(ctx, loadChainKey{}, &loadChain{key: key, parent: parent, maxValueBytes: maxValueBytes})
+}
+
+// maxValueBytesFrom 返回 ctx 中正在加载的 Group 允许的最大值字节数，不是 Group 传给 Getter 的 ctx 时返回 0
+func maxValueBytesFrom(ctx context.Context) int64 {
+	if c := loadChainFrom(ctx); c != nil {
+		return c.maxValueBytes
+	}
+	return 0
+}
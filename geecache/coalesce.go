@@ -0,0 +1,151 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoalescingGetter 是 Getter 可选实现的接口，用一次调用加载多个键。
+// 返回的 map 中缺少的键视为 ErrNotFound；如果返回的错误是 MultiError，
+// 其中的键各自得到对应的错误，其余的键仍然使用 map 中的值。
+// 批次中所有等待的调用者都离开（取消或加载超时）时 ctx 被取消。
+type CoalescingGetter interface {
+	GetBatch(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+// batchResult 是批量加载中一个键的结果
+type batchResult struct {
+	value []byte
+	err   error
+}
+
+// coalescer 收集一个时间窗口内未命中的键，合并为一次 GetBatch 调用
+type coalescer struct {
+	getter  CoalescingGetter
+	window  time.Duration
+	maxKeys int
+	stats   *Stats
+
+	mu      sync.Mutex
+	pending *coalesceBatch
+	timer   *time.Timer
+}
+
+// coalesceBatch 是一个批次的等待者。GetBatch 使用批次自己的 ctx，所有等待者都离开时
+// （各自取消或加载超时）被取消，不继承其中任何一个的取消
+type coalesceBatch struct {
+	waiters map[string][]chan batchResult
+	ctx     context.Context
+	cancel  context.CancelFunc
+	live    int // 仍在等待的调用者数，由 coalescer.mu 保护
+}
+
+// get 把键加入当前批次并等待结果。批次在窗口到期或键数达到 maxKeys 时发出，
+// 因此单个键最多额外等待 window。
+func (c *coalescer) get(ctx context.Context, key string) ([]byte, error) {
+	ch := make(chan batchResult, 1)
+	c.mu.Lock()
+	if c.pending == nil {
+		bctx, cancel := context.WithCancel(context.Background())
+		c.pending = &coalesceBatch{waiters: make(map[string][]chan batchResult), ctx: bctx, cancel: cancel}
+	}
+	b := c.pending
+	b.waiters[key] = append(b.waiters[key], ch)
+	b.live++
+	if len(b.waiters) >= c.maxKeys {
+		c.takeLocked()
+		c.mu.Unlock()
+		go c.flush(b)
+	} else {
+		if len(b.waiters) == 1 {
+			c.timer = time.AfterFunc(c.window, c.flushPending)
+		}
+		c.mu.Unlock()
+	}
+
+	select {
+	case r := <-ch:
+		return r.value, r.err
+	case <-ctx.Done():
+		c.leave(b)
+		return nil, ctx.Err()
+	}
+}
+
+// leave 记录一个等待者离开批次，最后一个离开时取消批次的 ctx
+func (c *coalescer) leave(b *coalesceBatch) {
+	c.mu.Lock()
+	b.live--
+	gone := b.live == 0
+	c.mu.Unlock()
+	if gone {
+		b.cancel()
+	}
+}
+
+// takeLocked 取出当前批次并停止它的计时器
+func (c *coalescer) takeLocked() *coalesceBatch {
+	b := c.pending
+	c.pending = nil
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	return b
+}
+
+func (c *coalescer) flushPending() {
+	c.mu.Lock()
+	b := c.takeLocked()
+	c.mu.Unlock()
+	if b != nil {
+		c.flush(b)
+	}
+}
+
+// flush 为一个批次调用 GetBatch，并把每个键的结果分发给等待者
+func (c *coalescer) flush(b *coalesceBatch) {
+	defer b.cancel()
+	if b.ctx.Err() != nil {
+		// 等待者都已离开，不再加载
+		return
+	}
+	keys := make([]string, 0, len(b.waiters))
+	for key := range b.waiters {
+		keys = append(keys, key)
+	}
+	atomic.AddInt64(&c.stats.Batches, 1)
+	values, err := c.callGetBatch(b.ctx, keys)
+	multi, _ := err.(MultiError)
+	for key, chs := range b.waiters {
+		var r batchResult
+		switch {
+		case multi != nil && multi[key] != nil:
+			r.err = multi[key]
+		case err != nil && multi == nil:
+			r.err = err
+		default:
+			if v, ok := values[key]; ok {
+				r.value = v
+			} else {
+				r.err = fmt.Errorf("%s: %w", key, ErrNotFound)
+			}
+		}
+		for _, ch := range chs {
+			ch <- r
+		}
+	}
+}
+
+// callGetBatch 调用 GetBatch，并把其中的 panic 转换为错误，避免等待者永远阻塞
+func (c *coalescer) callGetBatch(ctx context.Context, keys []string) (values map[string][]byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("geecache: panic in GetBatch: %v", r)
+		}
+	}()
+	return c.getter.GetBatch(ctx, keys)
+}
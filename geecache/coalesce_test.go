@@ -0,0 +1,164 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// batchGetter 记录 GetBatch 的调用次数，"bad" 开头的键返回各自的错误，"missing" 开头的键不返回
+type batchGetter struct {
+	calls int32
+}
+
+func (b *batchGetter) Get(key string) ([]byte, error) {
+	return nil, errors.New("GetBatch should be used")
+}
+
+func (b *batchGetter) GetBatch(ctx context.Context, keys []string) (map[string][]byte, error) {
+	atomic.AddInt32(&b.calls, 1)
+	values := make(map[string][]byte)
+	errs := make(MultiError)
+	for _, key := range keys {
+		switch {
+		case key[0] == 'b':
+			errs[key] = errors.New("bad key " + key)
+		case key[0] == 'm':
+		default:
+			values[key] = []byte("v-" + key)
+		}
+	}
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+func TestCoalescingGetter(t *testing.T) {
+	getter := &batchGetter{}
+	gee := NewGroup("coalesce", 2<<10, getter, WithBatchWindow(20*time.Millisecond, 32))
+
+	keys := []string{"k1", "k2", "k3", "k1", "bad1", "missing1"}
+	var wg sync.WaitGroup
+	results := make([]error, len(keys))
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			v, err := gee.Get(key)
+			values[i], results[i] = v.String(), err
+		}(i, key)
+	}
+	wg.Wait()
+
+	if getter.calls != 1 {
+		t.Fatalf("expect concurrent misses to share one GetBatch, got %d calls", getter.calls)
+	}
+	for i, key := range keys {
+		switch key {
+		case "bad1":
+			if results[i] == nil || errors.Is(results[i], ErrNotFound) {
+				t.Fatalf("expect own error for bad1, got %v", results[i])
+			}
+		case "missing1":
+			if !errors.Is(results[i], ErrNotFound) {
+				t.Fatalf("expect ErrNotFound for missing1, got %v", results[i])
+			}
+		default:
+			if results[i] != nil || values[i] != "v-"+key {
+				t.Fatalf("Get(%s) = %v, %v", key, values[i], results[i])
+			}
+		}
+	}
+}
+
+func TestCoalescingMaxKeysAndLatency(t *testing.T) {
+	getter := &batchGetter{}
+	const window = 20 * time.Millisecond
+	gee := NewGroup("coalesce-max", 2<<10, getter, WithBatchWindow(window, 4))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			gee.Get("k" + strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	if getter.calls < 2 {
+		t.Fatalf("expect batches capped at 4 keys, got %d calls", getter.calls)
+	}
+
+	// 单个未命中最多额外等待一个窗口
+	start := time.Now()
+	if _, err := gee.Get("alone"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < window || d > 5*window {
+		t.Fatalf("expect latency close to the window, got %v", d)
+	}
+}
+
+// blockingBatchGetter 的 GetBatch 阻塞到 ctx 结束，并记录 ctx 的错误
+type blockingBatchGetter struct {
+	batchGetter
+	done chan error
+}
+
+func (b *blockingBatchGetter) GetBatch(ctx context.Context, keys []string) (map[string][]byte, error) {
+	<-ctx.Done()
+	b.done <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestCoalescingCancel(t *testing.T) {
+	getter := &blockingBatchGetter{done: make(chan error, 2)}
+	gee := NewGroup("coalesce-cancel", 2<<10, getter, WithBatchWindow(5*time.Millisecond, 32))
+	defer gee.Close()
+
+	// 所有等待者都取消后，批次的 ctx 随之取消
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			gee.GetCtx(ctx, key)
+		}(key)
+	}
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case err := <-getter.done:
+		t.Fatalf("expect GetBatch to run while callers wait, got %v", err)
+	default:
+	}
+	cancel()
+	wg.Wait()
+	select {
+	case err := <-getter.done:
+		if err != context.Canceled {
+			t.Fatalf("expect the batch cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect GetBatch cancelled once every caller is gone")
+	}
+
+	// 加载超时同样结束批次
+	timed := NewGroup("coalesce-timeout", 2<<10, getter, WithBatchWindow(5*time.Millisecond, 32),
+		WithLoadTimeout(30*time.Millisecond))
+	defer timed.Close()
+	if _, err := timed.Get("c"); err == nil {
+		t.Fatal("expect the load to time out")
+	}
+	select {
+	case <-getter.done:
+	case <-time.After(time.Second):
+		t.Fatal("expect GetBatch cancelled after the load timeout")
+	}
+}
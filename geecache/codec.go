@@ -0,0 +1,74 @@
+package geecache
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// Codec 编解码节点之间传输的消息，使非 Go 的服务也能加入集群。
+// 同一个集群中的所有节点必须使用相同的 Codec。
+type Codec interface {
+	// ContentType 是请求和响应的 Content-Type
+	ContentType() string
+	Marshal(m proto.Message) ([]byte, error)
+	Unmarshal(b []byte, m proto.Message) error
+}
+
+// ProtoCodec 使用 protobuf 二进制格式，是默认的 Codec
+type ProtoCodec struct{}
+
+func (ProtoCodec) ContentType() string { return "application/octet-stream" }
+
+func (ProtoCodec) Marshal(m proto.Message) ([]byte, error) { return proto.Marshal(m) }
+
+func (ProtoCodec) Unmarshal(b []byte, m proto.Message) error { return proto.Unmarshal(b, m) }
+
+// JSONCodec 使用 protobuf 的标准 JSON 映射，bytes 字段编码为 base64
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string { return "application/json" }
+
+func (JSONCodec) Marshal(m proto.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (JSONCodec) Unmarshal(b []byte, m proto.Message) error {
+	return (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(bytes.NewReader(b), m)
+}
+
+// codecOrDefault 在 c 为 nil 时返回 ProtoCodec
+func codecOrDefault(c Codec) Codec {
+	if c == nil {
+		return ProtoCodec{}
+	}
+	return c
+}
+
+// SetCodec 设置池与对等点通信使用的 Codec，默认为 ProtoCodec。
+// 必须在 Set 和开始处理请求之前调用。
+func (p *HTTPPool) SetCodec(c Codec) {
+	p.codec = c
+}
+
+// acceptsBody 检查请求体的 Content-Type 是否与池的 Codec 一致，不一致时写入 415。
+// 没有 Content-Type 的请求按池的 Codec 解码。
+func (p *HTTPPool) acceptsBody(w http.ResponseWriter, r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return true
+	}
+	want := codecOrDefault(p.codec).ContentType()
+	if mt, _, err := mime.ParseMediaType(ct); err == nil && mt == want {
+		return true
+	}
+	http.Error(w, "unsupported content type "+ct+", expect "+want, http.StatusUnsupportedMediaType)
+	return false
+}
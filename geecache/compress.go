@@ -0,0 +1,117 @@
+package geecache
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+)
+
+// Compressor 压缩缓存中的值，用 CPU 换取容量
+type Compressor interface {
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// FlateCompressor 使用 DEFLATE 压缩，复用编码器和解码器以减少分配
+type FlateCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// NewFlateCompressor 返回给定压缩级别的 FlateCompressor，级别无效时使用 flate.DefaultCompression
+func NewFlateCompressor(level int) *FlateCompressor {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+	return &FlateCompressor{level: level}
+}
+
+func (c *FlateCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, _ := c.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(&buf, c.level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(&buf)
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *FlateCompressor) Decompress(src []byte) ([]byte, error) {
+	r, _ := c.readers.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReader(bytes.NewReader(src))
+	} else if err := r.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+		return nil, err
+	}
+	defer c.readers.Put(r)
+	return ioutil.ReadAll(r)
+}
+
+// compression 是 cache 的压缩配置
+type compression struct {
+	c         Compressor
+	threshold int    // 超过该字节数的值才压缩
+	stats     *Stats // 所属 Group 的统计
+}
+
+// maxCompressedRatio 是压缩后与压缩前大小之比的上限，效果更差时存储原值
+const maxCompressedRatio = 0.9
+
+// pack 返回存入 lru 的值，值足够大且压缩效果足够好时存储压缩后的数据
+func (z *compression) pack(v ByteView) cacheValue {
+	if z == nil || v.Len() <= z.threshold {
+		return cacheValue{view: v}
+	}
+	b, err := z.c.Compress(v.b)
+	if err != nil || float64(len(b)) >= maxCompressedRatio*float64(v.Len()) {
+		atomic.AddInt64(&z.stats.CompressSkipped, 1)
+		return cacheValue{view: v}
+	}
+	atomic.AddInt64(&z.stats.CompressedIn, int64(v.Len()))
+	atomic.AddInt64(&z.stats.CompressedOut, int64(len(b)))
+	v.b = b
+	return cacheValue{view: v, compressed: true}
+}
+
+// unpack 返回调用者看到的值，必要时解压
+func (z *compression) unpack(cv cacheValue) (ByteView, error) {
+	if !cv.compressed {
+		return cv.view, nil
+	}
+	b, err := z.c.Decompress(cv.view.b)
+	if err != nil {
+		return ByteView{}, err
+	}
+	v := cv.view
+	v.b = b
+	return v, nil
+}
+
+// WithCompression 开启缓存值的压缩：超过 threshold 字节的值压缩后存储，读取时解压，
+// 调用者看到的 ByteView 不变。cacheBytes 按压缩后的大小计算；压缩后不小于原来 90% 的值按原样存储。
+// c 为 nil 时使用默认级别的 FlateCompressor。
+func WithCompression(c Compressor, threshold int) GroupOption {
+	return func(g *Group) {
+		if c == nil {
+			c = NewFlateCompressor(flate.DefaultCompression)
+		}
+		z := &compression{c: c, threshold: threshold, stats: &g.stats}
+		g.mainCache.compression = z
+		g.hotCache.compression = z
+	}
+}
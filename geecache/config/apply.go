@@ -0,0 +1,165 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"geecache"
+	"geecache/lru"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Apply 按配置创建 HTTPPool 和所有 Group，getters 按名字提供每个 Group 的 Getter。
+// 所有检查都在创建任何对象之前完成，出错时返回指明字段的 *FieldError，不会留下创建了一半的 Group。
+// Self 为空时返回的 HTTPPool 为 nil。返回的 Group 与 cfg.Groups 的顺序一致，
+// HTTPPool 需要由调用者挂到 HTTP 服务上，使用 TLS 时可以用 cfg.TLS.ServerConfig 配置服务端。
+func Apply(cfg *Config, getters map[string]geecache.Getter) ([]*geecache.Group, *geecache.HTTPPool, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	for i, g := range cfg.Groups {
+		if getters[g.getterName()] == nil {
+			field := fmt.Sprintf("groups[%d].getter", i)
+			if g.Getter == "" {
+				field = fmt.Sprintf("groups[%d].name", i)
+			}
+			return nil, nil, fieldErr(field, "no getter registered as %q", g.getterName())
+		}
+	}
+	var client *http.Client
+	if cfg.TLS != nil {
+		tc, err := cfg.TLS.ClientConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tc
+		client = &http.Client{Transport: transport}
+	}
+
+	var pool *geecache.HTTPPool
+	if cfg.Self != "" {
+		pool = geecache.NewHTTPPool(cfg.Self)
+		if client != nil {
+			pool.SetClient(client)
+		}
+		configurePool(cfg, pool)
+	}
+
+	groups := make([]*geecache.Group, 0, len(cfg.Groups))
+	for _, gc := range cfg.Groups {
+		g := geecache.NewGroup(gc.Name, gc.CacheBytes, getters[gc.getterName()], groupOptions(gc)...)
+		if pool != nil {
+			g.RegisterPeers(pool)
+		}
+		groups = append(groups, g)
+	}
+	return groups, pool, nil
+}
+
+// Update 在运行时重新应用配置中可以动态修改的部分：对等点列表和虚拟节点数、
+// 每个 Group 的 CacheBytes、DefaultTTL 和 Token。其余字段（淘汰策略、hotCache、负缓存、TLS）
+// 的变化需要重启才能生效，会被忽略。配置中的 Group 必须已经创建，pool 为 nil 时忽略与对等点有关的部分。
+// 所有检查都在修改任何状态之前完成。
+func Update(cfg *Config, pool *geecache.HTTPPool) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	groups := make([]*geecache.Group, len(cfg.Groups))
+	for i, gc := range cfg.Groups {
+		if groups[i] = geecache.GetGroup(gc.Name); groups[i] == nil {
+			return fieldErr(fmt.Sprintf("groups[%d].name", i), "group %q does not exist, adding groups requires a restart", gc.Name)
+		}
+	}
+	if pool != nil {
+		configurePool(cfg, pool)
+	}
+	for i, gc := range cfg.Groups {
+		groups[i].Resize(gc.CacheBytes)
+		groups[i].SetDefaultTTL(time.Duration(gc.DefaultTTL))
+	}
+	return nil
+}
+
+// configurePool 设置各 group 的令牌以及对等点列表。令牌通过 ConfigureGroup 设置，
+// 会替换调用者之前为这些 group 设置的其他服务端配置。
+func configurePool(cfg *Config, pool *geecache.HTTPPool) {
+	for _, gc := range cfg.Groups {
+		pool.ConfigureGroup(gc.Name, geecache.GroupServeConfig{Token: gc.Token})
+	}
+	pool.SetWithOptions(geecache.PeerOptions{Replicas: cfg.Replicas, HashSeed: cfg.HashSeed}, cfg.Peers...)
+}
+
+// groupOptions 返回按配置创建 Group 的选项
+func groupOptions(gc GroupConfig) []geecache.GroupOption {
+	var opts []geecache.GroupOption
+	if gc.DefaultTTL > 0 {
+		opts = append(opts, geecache.WithDefaultTTL(time.Duration(gc.DefaultTTL)))
+	}
+	switch strings.ToLower(gc.Policy) {
+	case "lfu":
+		opts = append(opts, geecache.WithEvictionPolicy(lru.NewLFUPolicy))
+	case "fifo":
+		opts = append(opts, geecache.WithEvictionPolicy(lru.NewFIFOPolicy))
+	}
+	if gc.HotCacheRatio > 0 {
+		opts = append(opts, geecache.WithHotCache(int64(float64(gc.CacheBytes)*gc.HotCacheRatio)))
+	}
+	if gc.NegativeTTL > 0 {
+		opts = append(opts, geecache.WithNegativeTTL(time.Duration(gc.NegativeTTL)))
+	}
+	return opts
+}
+
+// ClientConfig 返回访问对等点使用的 TLS 配置：用 CAFile 校验对等点，并出示本节点的证书
+func (t *TLS) ClientConfig() (*tls.Config, error) {
+	tc := &tls.Config{}
+	if err := t.load(tc); err != nil {
+		return nil, err
+	}
+	tc.RootCAs = tc.ClientCAs
+	tc.ClientCAs = nil
+	return tc, nil
+}
+
+// ServerConfig 返回对外服务使用的 TLS 配置。设置了 CAFile 时要求并校验客户端证书。
+func (t *TLS) ServerConfig() (*tls.Config, error) {
+	if t.CertFile == "" {
+		return nil, fieldErr("tls.certFile", "required to serve TLS")
+	}
+	tc := &tls.Config{}
+	if err := t.load(tc); err != nil {
+		return nil, err
+	}
+	if tc.ClientCAs != nil {
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// load 读取证书和 CA，CA 放在 tc.ClientCAs 中
+func (t *TLS) load(tc *tls.Config) error {
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return &FieldError{Field: "tls.certFile", Err: err}
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return &FieldError{Field: "tls.caFile", Err: err}
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return &FieldError{Field: "tls.caFile", Err: errors.New("no PEM certificates found")}
+		}
+		tc.ClientCAs = pool
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+// Package config 从配置文件构造 Group 和 HTTPPool，并支持在运行时重新应用配置中可以动态修改的部分。
+// 内置 JSON 格式，YAML 等其他格式可以用 RegisterFormat 注册解码函数，结构体带有 json 和 yaml 标签。
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geecache"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config 描述一个节点上的所有 Group 以及与对等点通信的 HTTPPool
+type Config struct {
+	// 本节点的地址，例如 "http://10.0.0.1:8001"。为空时不创建 HTTPPool，Group 只在本地加载
+	Self string `json:"self" yaml:"self"`
+	// 集群中所有节点的地址，包括本节点
+	Peers []string `json:"peers" yaml:"peers"`
+	// 每个节点在哈希环上的虚拟节点数，0 表示使用默认值
+	Replicas int `json:"replicas" yaml:"replicas"`
+	// 混入哈希环的 seed，共享节点的多个集群使用不同的 seed 可以让键的分布互不相关
+	HashSeed string `json:"hashSeed" yaml:"hashSeed"`
+	// 非 nil 时节点之间使用 TLS 通信
+	TLS *TLS `json:"tls" yaml:"tls"`
+	// 要创建的 Group
+	Groups []GroupConfig `json:"groups" yaml:"groups"`
+}
+
+// TLS 描述节点之间通信使用的证书文件
+type TLS struct {
+	// 本节点的证书和私钥，用于对外服务，也作为访问对等点时的客户端证书
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+	// 校验对等点证书的 CA，为空时使用系统根证书；设置后对外服务要求客户端证书
+	CAFile string `json:"caFile" yaml:"caFile"`
+}
+
+// GroupConfig 描述一个 Group
+type GroupConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// getter 注册表中的名字，为空时与 Name 相同
+	Getter string `json:"getter" yaml:"getter"`
+	// mainCache 的字节上限，0 表示不限制
+	CacheBytes int64 `json:"cacheBytes" yaml:"cacheBytes"`
+	// 加载的值写入缓存时的 TTL，0 表示不过期
+	DefaultTTL Duration `json:"defaultTTL" yaml:"defaultTTL"`
+	// 淘汰策略：lru（默认）、lfu 或 fifo
+	Policy string `json:"policy" yaml:"policy"`
+	// hotCache 的字节数相对 CacheBytes 的比例，0 表示不开启
+	HotCacheRatio float64 `json:"hotCacheRatio" yaml:"hotCacheRatio"`
+	// 大于 0 时开启负缓存
+	NegativeTTL Duration `json:"negativeTTL" yaml:"negativeTTL"`
+	// 非空时对等点访问该 group 需要携带的令牌，本节点访问对等点时也会带上它
+	Token string `json:"token" yaml:"token"`
+}
+
+// Duration 是可以写成 "30s"、"5m" 等字符串的 time.Duration
+type Duration time.Duration
+
+// UnmarshalText 解析 time.ParseDuration 接受的字符串
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText 将 Duration 写成 time.Duration.String 的格式
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// FieldError 表示配置中某个字段的值不合法，Field 形如 "groups[1].cacheBytes"
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return "config: " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+func fieldErr(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]func(data []byte, v interface{}) error{}
+)
+
+// RegisterFormat 为扩展名 ext（如 ".yaml"）注册解码函数，Load 按文件的扩展名选择。
+// 例如 RegisterFormat(".yaml", yaml.Unmarshal)。
+func RegisterFormat(ext string, unmarshal func(data []byte, v interface{}) error) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[strings.ToLower(ext)] = unmarshal
+}
+
+// Parse 解析 JSON 格式的配置，未知的字段视为错误，并检查配置是否合法
+func Parse(data []byte) (*Config, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	cfg := &Config{}
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Load 读取并解析配置文件。.json 文件按 JSON 解析，其他扩展名使用 RegisterFormat 注册的解码函数。
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".json" {
+		return Parse(data)
+	}
+	formatsMu.RLock()
+	unmarshal, ok := formats[ext]
+	formatsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("config: no format registered for %q files", ext)
+	}
+	cfg := &Config{}
+	if err := unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+var policies = map[string]bool{"": true, "lru": true, "lfu": true, "fifo": true}
+
+// Validate 检查配置是否合法，返回第一个不合法的字段的 *FieldError。
+// 不检查文件是否存在以及 getter 是否已注册，这些在 Apply 中检查。
+func (c *Config) Validate() error {
+	if len(c.Peers) > 0 && c.Self == "" {
+		return fieldErr("self", "required when peers are set")
+	}
+	if c.Self != "" {
+		if err := checkAddr(c.Self); err != nil {
+			return &FieldError{Field: "self", Err: err}
+		}
+	}
+	seenPeers := make(map[string]bool, len(c.Peers))
+	for i, peer := range c.Peers {
+		field := fmt.Sprintf("peers[%d]", i)
+		if err := checkAddr(peer); err != nil {
+			return &FieldError{Field: field, Err: err}
+		}
+		if seenPeers[peer] {
+			return fieldErr(field, "duplicate peer %q", peer)
+		}
+		seenPeers[peer] = true
+	}
+	if len(c.Peers) > 0 && !seenPeers[c.Self] {
+		return fieldErr("peers", "must include self %q", c.Self)
+	}
+	if c.Replicas < 0 {
+		return fieldErr("replicas", "must not be negative")
+	}
+	if c.TLS != nil {
+		if c.Self == "" {
+			return fieldErr("tls", "requires self")
+		}
+		if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+			return fieldErr("tls", "certFile and keyFile must be set together")
+		}
+	}
+
+	seenGroups := make(map[string]bool, len(c.Groups))
+	for i, g := range c.Groups {
+		prefix := fmt.Sprintf("groups[%d].", i)
+		if g.Name != "" {
+			// 与调试接口同名的 group 会在 NewGroup 中 panic
+			if err := geecache.ValidGroupName(g.Name); err != nil {
+				return &FieldError{Field: prefix + "name", Err: err}
+			}
+		}
+		switch {
+		case g.Name == "":
+			return fieldErr(prefix+"name", "required")
+		case seenGroups[g.Name]:
+			return fieldErr(prefix+"name", "duplicate group %q", g.Name)
+		case g.CacheBytes < 0:
+			return fieldErr(prefix+"cacheBytes", "must not be negative")
+		case g.DefaultTTL < 0:
+			return fieldErr(prefix+"defaultTTL", "must not be negative")
+		case !policies[strings.ToLower(g.Policy)]:
+			return fieldErr(prefix+"policy", "unknown policy %q, want lru, lfu or fifo", g.Policy)
+		case g.HotCacheRatio < 0 || g.HotCacheRatio > 1:
+			return fieldErr(prefix+"hotCacheRatio", "must be between 0 and 1")
+		case g.HotCacheRatio > 0 && g.CacheBytes == 0:
+			return fieldErr(prefix+"hotCacheRatio", "requires cacheBytes")
+		case g.NegativeTTL < 0:
+			return fieldErr(prefix+"negativeTTL", "must not be negative")
+		case g.Token != "" && c.Self == "":
+			return fieldErr(prefix+"token", "requires self")
+		}
+		seenGroups[g.Name] = true
+	}
+	return nil
+}
+
+// checkAddr 检查节点地址是否为 http 或 https 的 URL
+func checkAddr(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("want an http or https URL such as http://10.0.0.1:8001, got " + addr)
+	}
+	return nil
+}
+
+// getterName 返回 Group 在 getter 注册表中的名字
+func (g GroupConfig) getterName() string {
+	if g.Getter != "" {
+		return g.Getter
+	}
+	return g.Name
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geecache"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const goodConfig = `{
+	"self": "http://localhost:8001",
+	"peers": ["http://localhost:8001", "http://localhost:8002"],
+	"replicas": 10,
+	"groups": [
+		{"name": "config-scores", "cacheBytes": 4096, "defaultTTL": "1m", "policy": "lfu", "hotCacheRatio": 0.25, "token": "secret"},
+		{"name": "config-users", "getter": "users", "negativeTTL": "30s"}
+	]
+}`
+
+func TestApply(t *testing.T) {
+	cfg, err := Parse([]byte(goodConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(cfg.Groups[0].DefaultTTL) != time.Minute || cfg.Groups[1].getterName() != "users" {
+		t.Fatalf("unexpected parsed config %+v", cfg)
+	}
+
+	var userLoads int32
+	getters := map[string]geecache.Getter{
+		"config-scores": geecache.GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil }),
+		"users": geecache.GetterFunc(func(key string) ([]byte, error) {
+			atomic.AddInt32(&userLoads, 1)
+			return nil, geecache.ErrNotFound
+		}),
+	}
+	groups, pool, err := Apply(cfg, getters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, g := range groups {
+			g.Close()
+		}
+	}()
+	if len(groups) != 2 || geecache.GetGroup("config-scores") != groups[0] || pool.Replicas() != 10 || pool.RingSize() != 20 {
+		t.Fatalf("unexpected groups %v or ring of %d", groups, pool.RingSize())
+	}
+
+	// 只有本节点拥有的键在本地加载并带上默认 TTL
+	var key string
+	for i := 0; key == ""; i++ {
+		if _, remote := pool.PickPeer(fmt.Sprint("k", i)); !remote {
+			key = fmt.Sprint("k", i)
+		}
+	}
+	groups[0].Get(key)
+	if _, info, err := groups[0].GetWithInfo(key); err != nil || info.ExpireAt.IsZero() {
+		t.Fatalf("expect default TTL on loaded value, got %+v %v", info, err)
+	}
+	for i := 0; i < 2; i++ {
+		groups[1].Get(key)
+	}
+	if userLoads != 1 {
+		t.Fatalf("expect negative caching, got %d loads", userLoads)
+	}
+
+	// token 保护 group 的服务端
+	rec := httptest.NewRecorder()
+	pool.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_geecache/config-scores/"+key, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expect token to be required, got %d", rec.Code)
+	}
+
+	// 动态部分：对等点、虚拟节点数、上限和默认 TTL
+	cfg.Peers = cfg.Peers[:1]
+	cfg.Replicas = 20
+	cfg.Groups[0].CacheBytes = 1
+	cfg.Groups[0].DefaultTTL = 0
+	if err := Update(cfg, pool); err != nil {
+		t.Fatal(err)
+	}
+	if pool.RingSize() != 20 {
+		t.Fatalf("expect ring of 20 virtual nodes, got %d", pool.RingSize())
+	}
+	if len(groups[0].EvictionPreview(10)) != 0 {
+		t.Fatalf("expect entries evicted after shrinking")
+	}
+	groups[0].Resize(0)
+	groups[0].Get(key)
+	if _, info, _ := groups[0].GetWithInfo(key); !info.ExpireAt.IsZero() {
+		t.Fatalf("expect default TTL cleared, got %+v", info)
+	}
+
+	cfg.Groups = append(cfg.Groups, GroupConfig{Name: "config-new"})
+	if err := Update(cfg, pool); err == nil || !strings.Contains(err.Error(), "groups[2].name") {
+		t.Fatalf("expect error for unknown group, got %v", err)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	cases := []struct {
+		config string
+		field  string
+	}{
+		{`{"groups": [{"name": "a", "maxBytes": 1}]}`, `unknown field "maxBytes"`},
+		{`{"peers": ["http://a:1"]}`, "self: required"},
+		{`{"self": "localhost:8001"}`, "self: want an http or https URL"},
+		{`{"self": "http://a:1", "peers": ["http://a:1", "http://a:1"]}`, "peers[1]: duplicate"},
+		{`{"self": "http://a:1", "peers": ["http://b:1"]}`, "peers: must include self"},
+		{`{"groups": [{"name": "a"}, {"name": "a"}]}`, "groups[1].name: duplicate"},
+		{`{"groups": [{"name": "_ping"}]}`, `groups[0].name: geecache: group name "_ping" is reserved`},
+		{`{"groups": [{"name": "a", "cacheBytes": -1}]}`, "groups[0].cacheBytes"},
+		{`{"groups": [{"name": "a", "policy": "arc"}]}`, `groups[0].policy: unknown policy "arc"`},
+		{`{"groups": [{"name": "a", "hotCacheRatio": 2}]}`, "groups[0].hotCacheRatio"},
+		{`{"groups": [{"name": "a", "defaultTTL": "soon"}]}`, "invalid duration"},
+		{`{"self": "http://a:1", "tls": {"certFile": "cert.pem"}}`, "tls: certFile and keyFile"},
+	}
+	for _, c := range cases {
+		_, err := Parse([]byte(c.config))
+		if err == nil || !strings.Contains(err.Error(), c.field) {
+			t.Errorf("%s: expect error mentioning %q, got %v", c.config, c.field, err)
+		}
+	}
+
+	var fe *FieldError
+	if _, err := Parse([]byte(`{"replicas": -1}`)); !errors.As(err, &fe) || fe.Field != "replicas" {
+		t.Fatalf("expect *FieldError for replicas, got %v", err)
+	}
+
+	// getter 和证书文件在 Apply 中检查，出错时不创建任何 Group
+	cfg := &Config{Groups: []GroupConfig{{Name: "config-missing", Getter: "nope"}}}
+	if _, _, err := Apply(cfg, nil); err == nil || !strings.Contains(err.Error(), `groups[0].getter: no getter registered as "nope"`) {
+		t.Fatalf("expect missing getter error, got %v", err)
+	}
+	cfg = &Config{Self: "https://a:1", TLS: &TLS{CertFile: "missing.pem", KeyFile: "missing.key"}}
+	if _, _, err := Apply(cfg, nil); err == nil || !strings.Contains(err.Error(), "tls.certFile") {
+		t.Fatalf("expect certificate error, got %v", err)
+	}
+	if geecache.GetGroup("config-missing") != nil {
+		t.Fatalf("expect no group created on error")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geecache-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(goodConfig), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if cfg, err := Load(write("geecache.json")); err != nil || len(cfg.Groups) != 2 {
+		t.Fatalf("load json failed: %v", err)
+	}
+	if _, err := Load(write("geecache.yaml")); err == nil || !strings.Contains(err.Error(), `".yaml"`) {
+		t.Fatalf("expect unregistered format error, got %v", err)
+	}
+	RegisterFormat(".conf", json.Unmarshal)
+	if cfg, err := Load(write("geecache.conf")); err != nil || cfg.Replicas != 10 {
+		t.Fatalf("load registered format failed: %v", err)
+	}
+}
@@ -15,6 +15,10 @@ type Map struct {
 	replicas int
 	keys     []int // Sorted
 	hashMap  map[int]string
+	vnodes   map[string]int // 真实节点的虚拟节点数
+	weights  map[string]int // 用 Add 或 AddWeighted 添加的节点的权重，虚拟节点数随 replicas 变化
+	// 因哈希值与其他节点的虚拟节点相同而没有放上哈希环的虚拟节点数
+	collisions int
 }
 
 // New 创建 Map 实例
@@ -23,6 +27,8 @@ func New(replicas int, fn Hash) *Map {
 		replicas: replicas,
 		hash:     fn,
 		hashMap:  make(map[int]string),
+		vnodes:   make(map[string]int),
+		weights:  make(map[string]int),
 	}
 	if m.hash == nil {
 		m.hash = crc32.ChecksumIEEE
@@ -30,13 +36,156 @@ func New(replicas int, fn Hash) *Map {
 	return m
 }
 
-// Add 向哈希中添加一些键。
+// NewSeeded 与 New 相同，但把 seed 混入每次哈希的输入（节点和键都加上 seed 前缀）。
+// 共享同一组节点的多个哈希环使用不同的 seed 时，键的分布互不相关，避免热点在所有环上同时出现。
+// 同一个环的所有节点必须使用相同的 seed；GetHashed 的哈希值也要按带 seed 的输入计算，可以用 Hash 得到。
+// 使用默认的 CRC-32 时 seed 只在创建时计算一次，之后的哈希不会分配内存；自定义的 fn 需要拼接输入。
+func NewSeeded(replicas int, fn Hash, seed string) *Map {
+	m := New(replicas, fn)
+	switch {
+	case seed == "":
+	case fn == nil:
+		// CRC-32 可以从 seed 的校验和继续计算，结果与计算 seed+data 相同
+		state := crc32.ChecksumIEEE([]byte(seed))
+		m.hash = func(data []byte) uint32 {
+			return crc32.Update(state, crc32.IEEETable, data)
+		}
+	default:
+		prefix := []byte(seed)
+		m.hash = func(data []byte) uint32 {
+			buf := make([]byte, 0, len(prefix)+len(data))
+			return fn(append(append(buf, prefix...), data...))
+		}
+	}
+	return m
+}
+
+// Hash 返回环对 key 使用的哈希值，包含 NewSeeded 的 seed，可以传给 GetHashed
+func (m *Map) Hash(key string) uint32 {
+	return m.hash([]byte(key))
+}
+
+// Add 向哈希中添加一些键，已在哈希环上的键会被忽略。
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		m.addWeighted(key, 1)
+	}
+	sort.Ints(m.keys)
+}
+
+// AddWeighted 以权重 weight 添加一个键，它拥有 replicas*weight 个虚拟节点。
+// 键已在哈希环上时被忽略，修改权重需要先 Remove。
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	m.addWeighted(key, weight)
+	sort.Ints(m.keys)
+}
+
+func (m *Map) addWeighted(key string, weight int) {
+	if m.Contains(key) {
+		return
+	}
+	m.add(key, m.replicas*weight)
+	m.weights[key] = weight
+}
+
+// AddReplicas 添加一个拥有 n 个虚拟节点的键，不受 Map 默认副本数的影响。
+// 键已在哈希环上时被忽略。
+func (m *Map) AddReplicas(key string, n int) {
+	if n < 1 {
+		n = 1
+	}
+	m.add(key, n)
+	sort.Ints(m.keys)
+}
+
+// Contains 判断键是否在哈希环上
+func (m *Map) Contains(key string) bool {
+	_, ok := m.vnodes[key]
+	return ok
+}
+
+// Replicas 返回每个权重为 1 的键拥有的虚拟节点数
+func (m *Map) Replicas() int {
+	return m.replicas
+}
+
+// CollisionCount 返回因哈希冲突而被丢弃的虚拟节点数。冲突的一方按名称决出，
+// 数量过多说明哈希函数或虚拟节点数不合适，节点之间的负载可能不均衡。
+func (m *Map) CollisionCount() int {
+	return m.collisions
+}
+
+// Size 返回哈希环上虚拟节点的数量
+func (m *Map) Size() int {
+	return len(m.keys)
+}
+
+// add 为键添加 n 个虚拟节点。两个虚拟节点哈希值相同时，名称较小的节点胜出，
+// 因此哈希环只取决于节点集合，与 Add 的顺序和 map 的遍历顺序无关。
+// 重复添加同一个键会让 Remove 无法移除多出的虚拟节点，因此已存在的键被忽略。
+func (m *Map) add(key string, n int) {
+	if m.Contains(key) {
+		return
+	}
+	m.addVnodes(key, 0, n)
+	m.vnodes[key] = n
+}
+
+// addVnodes 添加键的第 from 到 to-1 个虚拟节点，不排序
+func (m *Map) addVnodes(key string, from, to int) {
+	for i := from; i < to; i++ {
+		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		if owner, ok := m.hashMap[hash]; ok {
+			if key < owner {
+				m.hashMap[hash] = key
+			}
+			m.collisions++
+			continue
+		}
+		m.keys = append(m.keys, hash)
+		m.hashMap[hash] = key
+	}
+}
+
+// SetGlobalReplicas 将每个权重为 1 的键的虚拟节点数改为 n，并按权重调整用 Add 和 AddWeighted 添加的键。
+// 只增删变化的虚拟节点，其余虚拟节点的位置不变，结果与用 n 重新构造哈希环相同。
+// 用 AddReplicas 添加的键不受影响。
+func (m *Map) SetGlobalReplicas(n int) {
+	if n < 1 {
+		n = 1
+	}
+	if n == m.replicas {
+		return
+	}
+	m.replicas = n
+	shrunk := false
+	for key, weight := range m.weights {
+		cur, target := m.vnodes[key], n*weight
+		if target > cur {
+			m.addVnodes(key, cur, target)
+		} else if target < cur {
+			for i := target; i < cur; i++ {
+				hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+				if m.hashMap[hash] == key {
+					delete(m.hashMap, hash)
+				}
+			}
+			shrunk = true
+		}
+		m.vnodes[key] = target
+	}
+	if shrunk && m.collisions > 0 {
+		// 被删除的虚拟节点可能在冲突中胜出，落败的一方需要重新放上哈希环
+		m.rebuild()
+		return
+	}
+	if shrunk {
+		m.keys = m.keys[:0]
+		for hash := range m.hashMap {
 			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
 		}
 	}
 	sort.Ints(m.keys)
@@ -48,11 +197,169 @@ func (m *Map) Get(key string) string {
 		return ""
 	}
 
-	hash := int(m.hash([]byte(key)))
+	return m.GetHashed(m.hash([]byte(key)))
+}
+
+// Owner 是 Get 的别名，返回拥有 key 的节点，哈希环为空时返回空字符串
+func (m *Map) Owner(key string) string {
+	return m.Get(key)
+}
+
+// GetHashed 与 Get 相同，但接受已经用 Map 的哈希函数计算好的哈希值，
+// 调用者可以预先计算并复用它，省去每次哈希和 []byte(key) 的内存分配。
+func (m *Map) GetHashed(hash uint32) string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+
+	h := int(hash)
 	// 二分查找合适的副本。
 	idx := sort.Search(len(m.keys), func(i int) bool {
-		return m.keys[i] >= hash
+		return m.keys[i] >= h
 	})
 
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
+
+// Remove 从哈希中移除一些键及其所有虚拟节点。
+// 哈希环按剩余的节点重建，冲突中落败的虚拟节点会重新出现，结果与从未添加过被移除的键相同。
+func (m *Map) Remove(keys ...string) {
+	removed := false
+	for _, key := range keys {
+		if m.Contains(key) {
+			delete(m.vnodes, key)
+			delete(m.weights, key)
+			removed = true
+		}
+	}
+	if removed {
+		m.rebuild()
+	}
+}
+
+// rebuild 按 vnodes 重新构造哈希环
+func (m *Map) rebuild() {
+	vnodes := m.vnodes
+	m.keys = m.keys[:0]
+	m.hashMap = make(map[int]string, len(m.hashMap))
+	m.vnodes = make(map[string]int, len(vnodes))
+	m.collisions = 0
+	for key, n := range vnodes {
+		m.add(key, n)
+	}
+	sort.Ints(m.keys)
+}
+
+// GetN 按顺时针方向返回与键最接近的最多 n 个不同的项，第一个即 Get 的结果。
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(m.keys) && len(nodes) < n; i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// GetLoadAware 返回键的拥有者，但当它的负载 loadOf(node) 超过 threshold 时，
+// 沿顺时针方向返回第一个负载不超过 threshold 的不同节点。
+// 所有节点都超过 threshold 时仍然返回拥有者，即与 Get 相同。
+func (m *Map) GetLoadAware(key string, loadOf func(node string) float64, threshold float64) string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+
+	owner := m.hashMap[m.keys[idx%len(m.keys)]]
+	seen := make(map[string]bool)
+	for i := 0; i < len(m.keys) && len(seen) < len(m.vnodes); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		if loadOf(node) <= threshold {
+			return node
+		}
+	}
+	return owner
+}
+
+// AddSimulate 计算如果加入 newNode，样本键中有哪些会被重新分配给它，不修改哈希环。
+func (m *Map) AddSimulate(sampleKeys []string, newNode string) (moved []string) {
+	sim := m.clone()
+	sim.Add(newNode)
+
+	for _, key := range sampleKeys {
+		if m.Get(key) != newNode && sim.Get(key) == newNode {
+			moved = append(moved, key)
+		}
+	}
+	return moved
+}
+
+// Moved 返回样本键中在 before 和 after 两个哈希环上归属不同的键，用于在增删节点后
+// 验证哈希环的行为：加入第 N 个节点时理想情况下约 1/N 的键迁移，且都迁移到新节点。
+func Moved(before, after *Map, sampleKeys []string) (moved []string) {
+	for _, key := range sampleKeys {
+		if before.Get(key) != after.Get(key) {
+			moved = append(moved, key)
+		}
+	}
+	return moved
+}
+
+// MovedFraction 返回样本键中归属发生变化的比例，在 0 到 1 之间，没有样本时为 0
+func MovedFraction(before, after *Map, sampleKeys []string) float64 {
+	if len(sampleKeys) == 0 {
+		return 0
+	}
+	return float64(len(Moved(before, after, sampleKeys))) / float64(len(sampleKeys))
+}
+
+// Without 返回移除了 keys 的副本，不修改 m，用于计算节点离开后键的新归属
+func (m *Map) Without(keys ...string) *Map {
+	c := m.clone()
+	c.Remove(keys...)
+	return c
+}
+
+// clone 返回 Map 的深拷贝
+func (m *Map) clone() *Map {
+	c := &Map{
+		hash:       m.hash,
+		replicas:   m.replicas,
+		keys:       make([]int, len(m.keys)),
+		hashMap:    make(map[int]string, len(m.hashMap)),
+		vnodes:     make(map[string]int, len(m.vnodes)),
+		weights:    make(map[string]int, len(m.weights)),
+		collisions: m.collisions,
+	}
+	copy(c.keys, m.keys)
+	for k, v := range m.hashMap {
+		c.hashMap[k] = v
+	}
+	for k, v := range m.vnodes {
+		c.vnodes[k] = v
+	}
+	for k, v := range m.weights {
+		c.weights[k] = v
+	}
+	return c
+}
@@ -1,6 +1,11 @@
 package consistenthash
 
 import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"sort"
 	"strconv"
 	"testing"
 )
@@ -41,3 +46,421 @@ func TestHashing(t *testing.T) {
 	}
 
 }
+
+func TestAddSimulate(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	// 加入 "8" 会产生 8, 18, 28，只有 27 会迁移到 8。
+	moved := hash.AddSimulate([]string{"2", "11", "23", "27"}, "8")
+	if !reflect.DeepEqual(moved, []string{"27"}) {
+		t.Fatalf("expect moved keys [27], but %v got", moved)
+	}
+
+	if hash.Get("27") != "2" {
+		t.Fatalf("AddSimulate should not mutate the ring")
+	}
+}
+
+func TestMovedFraction(t *testing.T) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	before := New(50, nil)
+	before.Add("a", "b", "c", "d")
+	if f := MovedFraction(before, before.Without(), keys); f != 0 {
+		t.Fatalf("expect no keys moved on an unchanged ring, got %v", f)
+	}
+
+	// 加入第 5 个节点时约 1/5 的键迁移，且都迁移到新节点
+	after := before.Without()
+	after.Add("e")
+	moved := Moved(before, after, keys)
+	for _, key := range moved {
+		if after.Get(key) != "e" {
+			t.Fatalf("%s moved to %s instead of the new node", key, after.Get(key))
+		}
+	}
+	if f := MovedFraction(before, after, keys); f < 0.1 || f > 0.3 {
+		t.Fatalf("expect about 1/5 of keys moved on add, got %v", f)
+	}
+
+	// 移除节点时只有它拥有的键迁移
+	removed := before.Without("a")
+	for _, key := range Moved(before, removed, keys) {
+		if before.Get(key) != "a" {
+			t.Fatalf("%s moved although its owner %s stayed", key, before.Get(key))
+		}
+	}
+	if f := MovedFraction(before, removed, keys); f < 0.15 || f > 0.35 {
+		t.Fatalf("expect about 1/4 of keys moved on remove, got %v", f)
+	}
+	if MovedFraction(before, after, nil) != 0 {
+		t.Fatal("expect 0 without sample keys")
+	}
+}
+
+func TestNewSeeded(t *testing.T) {
+	keys := make([]string, 5000)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	nodes := []string{"a", "b", "c", "d"}
+	ring := func(seed string) *Map {
+		m := NewSeeded(50, nil, seed)
+		m.Add(nodes...)
+		return m
+	}
+	plain := New(50, nil)
+	plain.Add(nodes...)
+	if MovedFraction(plain, ring(""), keys) != 0 {
+		t.Fatal("expect an empty seed to place keys like New")
+	}
+	if MovedFraction(ring("tenant-1"), ring("tenant-1"), keys) != 0 {
+		t.Fatal("expect the same seed to give the same placement")
+	}
+	// 4 个节点上互不相关的分布约有 3/4 的键归属不同
+	if f := MovedFraction(ring("tenant-1"), ring("tenant-2"), keys); f < 0.6 {
+		t.Fatalf("expect decorrelated placement across seeds, only %v of keys differ", f)
+	}
+	seeded := ring("tenant-1")
+	if seeded.GetHashed(crc32.ChecksumIEEE([]byte("tenant-1key1"))) != seeded.Get("key1") {
+		t.Fatal("expect GetHashed to take the seeded hash")
+	}
+	if seeded.Hash("key1") != crc32.ChecksumIEEE([]byte("tenant-1key1")) {
+		t.Fatal("expect Hash to include the seed")
+	}
+	custom := NewSeeded(50, func(b []byte) uint32 { return crc32.ChecksumIEEE(b) }, "tenant-1")
+	custom.Add(nodes...)
+	if MovedFraction(seeded, custom, keys) != 0 {
+		t.Fatal("expect a custom hash to see the seed prefix too")
+	}
+	if MovedFraction(seeded, seeded.Without(), keys) != 0 {
+		t.Fatal("expect copies to keep the seed")
+	}
+}
+
+func TestRemoveAndGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	if nodes := hash.GetN("11", 2); !reflect.DeepEqual(nodes, []string{"2", "4"}) {
+		t.Fatalf("expect [2 4], but %v got", nodes)
+	}
+	if nodes := hash.GetN("27", 5); !reflect.DeepEqual(nodes, []string{"2", "4", "6"}) {
+		t.Fatalf("expect [2 4 6], but %v got", nodes)
+	}
+
+	// Without 不修改原来的哈希环
+	if without := hash.Without("2"); without.Get("11") != "4" || hash.Get("11") != "2" {
+		t.Fatalf("expect Without to return a modified copy")
+	}
+
+	// 移除 "2" 后，原本属于它的键落到其后继节点上。
+	hash.Remove("2")
+	if hash.Get("11") != "4" || hash.Get("27") != "4" {
+		t.Fatalf("keys of removed node should move to its successor")
+	}
+	if len(hash.keys) != 6 {
+		t.Fatalf("expect 6 virtual nodes, but %d got", len(hash.keys))
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// "2" 的权重为 2：2, 12, 22, 32, 42, 52
+	hash.AddWeighted("2", 2)
+	hash.Add("4")
+	if len(hash.keys) != 9 {
+		t.Fatalf("expect 9 virtual nodes, but %d got", len(hash.keys))
+	}
+	if hash.Get("50") != "2" {
+		t.Fatalf("Asking for 50, should have yielded 2")
+	}
+
+	hash.Remove("2")
+	if len(hash.keys) != 3 || hash.Get("50") != "4" {
+		t.Fatalf("Remove should drop all weighted virtual nodes")
+	}
+}
+
+// ringFingerprint 把哈希环序列化后计算校验和
+func ringFingerprint(m *Map) uint32 {
+	var buf []byte
+	for _, k := range m.keys {
+		buf = strconv.AppendInt(buf, int64(k), 10)
+		buf = append(buf, ':')
+		buf = append(buf, m.hashMap[k]...)
+		buf = append(buf, ',')
+	}
+	return crc32.ChecksumIEEE(buf)
+}
+
+func TestDeterministicRing(t *testing.T) {
+	nodes := []string{"http://a:8001", "http://b:8002", "http://c:8003", "http://d:8004"}
+	build := func(order []string) *Map {
+		m := New(50, nil)
+		m.Add(order...)
+		return m
+	}
+
+	// 固定的指纹保证不同进程、不同版本之间构造出相同的哈希环
+	const expect = uint32(0x062f6ccf)
+	if got := ringFingerprint(build(nodes)); got != expect {
+		t.Fatalf("expect ring fingerprint %#x, but %#x got", expect, got)
+	}
+
+	reversed := []string{nodes[3], nodes[2], nodes[1], nodes[0]}
+	a, b := build(nodes), build(reversed)
+	if !reflect.DeepEqual(a.keys, b.keys) || !reflect.DeepEqual(a.hashMap, b.hashMap) {
+		t.Fatalf("ring depends on Add order")
+	}
+
+	// 虚拟节点哈希冲突时结果也与 Add 顺序无关
+	collide := func(key []byte) uint32 { return uint32(key[0] - '0') }
+	c1, c2 := New(2, collide), New(2, collide)
+	c1.Add("x", "y")
+	c2.Add("y", "x")
+	if !reflect.DeepEqual(c1.keys, c2.keys) || !reflect.DeepEqual(c1.hashMap, c2.hashMap) || len(c1.keys) != 2 {
+		t.Fatalf("colliding ring depends on Add order: %v %v / %v %v", c1.keys, c1.hashMap, c2.keys, c2.hashMap)
+	}
+}
+
+// TestDistribution 记录不同节点数和副本数下键分布的离散程度。
+// 默认哈希 crc32 的质量有限，副本数超过 50 后并没有稳定的改善，因此默认值取 50。
+func TestDistribution(t *testing.T) {
+	const keys = 100000
+	for _, peers := range []int{2, 10, 100} {
+		for _, replicas := range []int{10, 50, 200} {
+			m := New(replicas, nil)
+			for i := 0; i < peers; i++ {
+				m.Add(fmt.Sprintf("http://10.0.%d.%d:8001", i/256, i%256))
+			}
+			counts := make(map[string]int, peers)
+			for k := 0; k < keys; k++ {
+				counts[m.Get("key"+strconv.Itoa(k))]++
+			}
+			mean := float64(keys) / float64(peers)
+			var ss, worst float64
+			for _, c := range counts {
+				d := float64(c) - mean
+				ss += d * d
+				if r := float64(c) / mean; r > worst {
+					worst = r
+				}
+			}
+			cv := math.Sqrt(ss/float64(peers)) / mean
+			t.Logf("peers=%-3d replicas=%-3d cv=%.3f max/mean=%.2f", peers, replicas, cv, worst)
+			if replicas == 50 && worst > 1.6 {
+				t.Errorf("peers=%d: most loaded node has %.2fx the mean with default replicas", peers, worst)
+			}
+		}
+	}
+}
+
+func TestGetHashed(t *testing.T) {
+	m := New(3, nil)
+	m.Add("a", "b", "c")
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if got, want := m.GetHashed(crc32.ChecksumIEEE([]byte(key))), m.Get(key); got != want {
+			t.Fatalf("GetHashed(%s) = %s, Get = %s", key, got, want)
+		}
+		if m.Owner(key) != m.Get(key) {
+			t.Fatalf("expect Owner to agree with Get for %s", key)
+		}
+	}
+	if New(3, nil).GetHashed(1) != "" {
+		t.Fatal("expect empty result on empty ring")
+	}
+}
+
+func benchmarkRing() (*Map, []string) {
+	m := New(3, nil)
+	m.Add("http://10.0.0.1:8001", "http://10.0.0.2:8001", "http://10.0.0.3:8001")
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	return m, keys
+}
+
+func BenchmarkGet(b *testing.B) {
+	m, keys := benchmarkRing()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkGetHashed(b *testing.B) {
+	m, keys := benchmarkRing()
+	hashes := make([]uint32, len(keys))
+	for i, key := range keys {
+		hashes[i] = crc32.ChecksumIEEE([]byte(key))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.GetHashed(hashes[i%len(hashes)])
+	}
+}
+
+func TestGetLoadAware(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	load := map[string]float64{"2": 0.5, "4": 0.5, "6": 0.5}
+	loadOf := func(node string) float64 { return load[node] }
+	if got := hash.GetLoadAware("11", loadOf, 0.8); got != "2" {
+		t.Fatalf("expect owner under threshold, got %s", got)
+	}
+	load["2"] = 0.9
+	if got := hash.GetLoadAware("11", loadOf, 0.8); got != "4" {
+		t.Fatalf("expect next node when owner is overloaded, got %s", got)
+	}
+	load["4"] = 0.9
+	if got := hash.GetLoadAware("11", loadOf, 0.8); got != "6" {
+		t.Fatalf("expect next node under threshold, got %s", got)
+	}
+	load["6"] = 0.9
+	if got := hash.GetLoadAware("11", loadOf, 0.8); got != "2" {
+		t.Fatalf("expect owner when all nodes are overloaded, got %s", got)
+	}
+	if got := hash.Get("11"); got != "2" {
+		t.Fatalf("expect Get to ignore load, got %s", got)
+	}
+}
+
+func TestDuplicateAdd(t *testing.T) {
+	m := New(3, nil)
+	m.Add("a", "b")
+	size := m.Size()
+	m.Add("a")
+	m.AddWeighted("b", 4)
+	m.AddReplicas("a", 10)
+	if m.Size() != size {
+		t.Fatalf("expect duplicate adds to be ignored, ring size %d -> %d", size, m.Size())
+	}
+	if !m.Contains("a") || m.Contains("c") {
+		t.Fatal("unexpected Contains result")
+	}
+	m.Remove("a", "b")
+	if m.Size() != 0 || m.Contains("a") {
+		t.Fatalf("expect empty ring after Remove, got %d virtual nodes", m.Size())
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	// 每个节点的第 i 个虚拟节点哈希值都是 i，所有节点两两冲突
+	collide := func(key []byte) uint32 { return uint32(key[0] - '0') }
+	build := func(order ...string) *Map {
+		m := New(3, collide)
+		m.Add(order...)
+		return m
+	}
+
+	m := build("y", "x", "z")
+	if m.CollisionCount() != 6 || m.Size() != 3 {
+		t.Fatalf("expect 6 collisions on 3 virtual nodes, got %d on %d", m.CollisionCount(), m.Size())
+	}
+	if got := m.Get("1"); got != "x" {
+		t.Fatalf("expect smaller name to win the collision, got %s", got)
+	}
+
+	// 移除胜出的节点后，落败的虚拟节点重新出现，与只添加剩余节点的结果相同
+	m.Remove("x")
+	for _, expect := range []*Map{build("y", "z"), build("z", "y")} {
+		if !reflect.DeepEqual(m.keys, expect.keys) || !reflect.DeepEqual(m.hashMap, expect.hashMap) {
+			t.Fatalf("ring depends on history: %v %v / %v %v", m.keys, m.hashMap, expect.keys, expect.hashMap)
+		}
+	}
+	if m.CollisionCount() != 3 || m.Get("1") != "y" {
+		t.Fatalf("expect y to own the ring with 3 collisions, got %s with %d", m.Get("1"), m.CollisionCount())
+	}
+}
+
+func TestSetGlobalReplicas(t *testing.T) {
+	build := func(replicas int) *Map {
+		m := New(replicas, nil)
+		m.Add("http://a:8001", "http://b:8002")
+		m.AddWeighted("http://c:8003", 2)
+		m.AddReplicas("http://d:8004", 7)
+		return m
+	}
+	sample := make([]string, 10000)
+	for i := range sample {
+		sample[i] = strconv.Itoa(i)
+	}
+
+	m := build(50)
+	before := make(map[string]string, len(sample))
+	for _, key := range sample {
+		before[key] = m.Get(key)
+	}
+	oldVnodes := make(map[int]bool, m.Size())
+	for _, hash := range m.keys {
+		oldVnodes[hash] = true
+	}
+	m.SetGlobalReplicas(60)
+	expect := build(60)
+	if !reflect.DeepEqual(m.keys, expect.keys) || !reflect.DeepEqual(m.hashMap, expect.hashMap) {
+		t.Fatalf("expect the same ring as a fresh build with 60 replicas")
+	}
+	if m.Size() != 60*4+7 || m.Replicas() != 60 {
+		t.Fatalf("expect %d virtual nodes, got %d", 60*4+7, m.Size())
+	}
+	// 只有落在新增虚拟节点上的键会移动
+	moved := 0
+	for _, key := range sample {
+		if got := m.Get(key); got != before[key] {
+			moved++
+			idx := sort.SearchInts(m.keys, int(m.hash([]byte(key))))
+			if oldVnodes[m.keys[idx%len(m.keys)]] {
+				t.Fatalf("key %s moved but still lands on an old virtual node", key)
+			}
+		}
+	}
+	if moved == 0 || moved > len(sample)/4 {
+		t.Fatalf("expect a small fraction of keys to move, got %d", moved)
+	}
+
+	m.SetGlobalReplicas(10)
+	expect = build(10)
+	if !reflect.DeepEqual(m.keys, expect.keys) || !reflect.DeepEqual(m.hashMap, expect.hashMap) {
+		t.Fatalf("expect the same ring as a fresh build with 10 replicas")
+	}
+
+	// 缩小时冲突中落败的虚拟节点重新出现
+	collide := func(key []byte) uint32 { return uint32(key[0] - '0') }
+	c := New(3, collide)
+	c.Add("x", "y")
+	c.AddWeighted("z", 2)
+	c.SetGlobalReplicas(1)
+	fresh := New(1, collide)
+	fresh.Add("x", "y")
+	fresh.AddWeighted("z", 2)
+	if !reflect.DeepEqual(c.hashMap, fresh.hashMap) || c.CollisionCount() != fresh.CollisionCount() {
+		t.Fatalf("colliding ring differs after shrink: %v %d / %v %d", c.hashMap, c.CollisionCount(), fresh.hashMap, fresh.CollisionCount())
+	}
+}
@@ -0,0 +1,63 @@
+package geecache
+
+import (
+	"errors"
+	"sync"
+)
+
+// Decoder 把缓存的字节解码为对象
+type Decoder func(b []byte) (interface{}, error)
+
+// ErrNoDecoder 表示 Group 没有通过 WithDecoder 配置解码函数
+var ErrNoDecoder = errors.New("geecache: group has no decoder")
+
+// decodedValue 保存一个缓存条目解码后的对象。它随 ByteView 一起存入缓存，
+// 条目被替换或淘汰时一并失效。
+type decodedValue struct {
+	mu   sync.Mutex
+	done bool
+	v    interface{}
+}
+
+// WithDecoder 开启解码结果缓存：GetDecoded 第一次读取某个条目时调用 decode，
+// 之后直到条目被替换、删除或淘汰都返回同一个对象。
+// 解码后的对象不计入 cacheBytes，会额外占用内存；它被所有调用者共享，不应修改。
+func WithDecoder(decode Decoder) GroupOption {
+	return func(g *Group) {
+		g.decode = decode
+	}
+}
+
+// GetDecoded 获取键的值并返回解码后的对象，解码失败的结果不会被缓存
+func (g *Group) GetDecoded(key string) (interface{}, error) {
+	if g.decode == nil {
+		return nil, ErrNoDecoder
+	}
+	v, err := g.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if v.decoded == nil {
+		// 未写入缓存的值（例如超过 maxValueBytes）每次都重新解码
+		return g.decode(v.b)
+	}
+	d := v.decoded
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.done {
+		obj, err := g.decode(v.b)
+		if err != nil {
+			return nil, err
+		}
+		d.v, d.done = obj, true
+	}
+	return d.v, nil
+}
+
+// decodable 开启了解码缓存时，为即将写入缓存的值附加存放解码结果的位置
+func (g *Group) decodable(v ByteView) ByteView {
+	if g.decode != nil && !g.tooLarge(v.Len()) {
+		v.decoded = &decodedValue{}
+	}
+	return v
+}
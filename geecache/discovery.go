@@ -0,0 +1,228 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRemoveAfter 是对等点从解析结果中连续缺失多少次后才被移除
+const defaultRemoveAfter = 3
+
+// Resolver 解析 DNS 记录，*net.Resolver 实现了该接口，测试中可以替换为固定的结果
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+// DiscoveryOptions 配置 DiscoverDNS
+type DiscoveryOptions struct {
+	// Resolver 为 nil 时使用 net.DefaultResolver
+	Resolver Resolver
+	// RemoveAfter 是对等点连续多少次不在解析结果中才被移除，容忍 DNS 短暂的抖动。
+	// 为 0 时使用默认的 3 次。新出现的对等点立即加入。
+	RemoveAfter int
+}
+
+// discovery 是 DiscoverDNS 在两次解析之间保存的状态
+type discovery struct {
+	p           *HTTPPool
+	name        string
+	port        int
+	resolver    Resolver
+	removeAfter int
+	// 当前应用到池上的对等点及其连续缺失的次数
+	members map[string]int
+	// 上一次解析是否失败，连续的失败只记录一次日志
+	failing bool
+}
+
+// SetDiscovery 设置 DiscoverDNS 使用的解析器和移除的阈值，在 DiscoverDNS 之前调用
+func (p *HTTPPool) SetDiscovery(opts DiscoveryOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.discoveryOpts = opts
+}
+
+// DiscoverDNS 解析 name 得到对等点列表并通过 SetPeers 应用到池上，之后大约每隔 interval
+// 重新解析一次直到 ctx 结束，每次的间隔带有随机抖动，避免所有节点同时解析。
+// 先查询 name 的 SRV 记录，没有时查询 A/AAAA 记录；对等点的地址为 scheme://host:port，
+// scheme 与 self 相同，port 为 0 时使用 SRV 记录中的端口。适用于 Kubernetes 的 headless service，
+// 此时 self 应为本节点的 Pod IP 加端口。
+// SRV 记录的目标会再解析为 IP 地址，使本节点能在结果中认出自己。
+// 对等点连续 RemoveAfter 次不在结果中才被移除。解析失败时保留原来的对等点，错误记录在日志
+// 和 LastDiscoveryError 中。第一次解析在返回前完成并返回它的错误，失败时同样会继续重试。
+// 再次调用时停止之前的解析循环，以新的参数重新开始。
+func (p *HTTPPool) DiscoverDNS(ctx context.Context, name string, port int, interval time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	p.mu.Lock()
+	opts := p.discoveryOpts
+	if p.stopDiscovery != nil {
+		p.stopDiscovery()
+	}
+	p.stopDiscovery = cancel
+	p.mu.Unlock()
+	d := &discovery{
+		p:           p,
+		name:        name,
+		port:        port,
+		resolver:    opts.Resolver,
+		removeAfter: opts.RemoveAfter,
+	}
+	if d.resolver == nil {
+		d.resolver = net.DefaultResolver
+	}
+	if d.removeAfter <= 0 {
+		d.removeAfter = defaultRemoveAfter
+	}
+	err := d.refresh(ctx)
+	go func() {
+		defer cancel()
+		timer := time.NewTimer(discoveryJitter(interval))
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				d.refresh(ctx)
+				timer.Reset(discoveryJitter(interval))
+			}
+		}
+	}()
+	return err
+}
+
+// LastDiscoveryError 返回 DiscoverDNS 最近一次解析的错误，成功时为 nil
+func (p *HTTPPool) LastDiscoveryError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastDiscoveryErr
+}
+
+// discoveryJitter 返回 interval 上下浮动 10% 的随机时长
+func discoveryJitter(interval time.Duration) time.Duration {
+	if interval < 10 {
+		return interval
+	}
+	spread := interval / 5
+	return interval - spread/2 + time.Duration(rand.Int63n(int64(spread)))
+}
+
+// refresh 解析一次并把变化应用到池上
+func (d *discovery) refresh(ctx context.Context) error {
+	peers, err := d.resolve(ctx)
+	if ctx.Err() != nil {
+		// 解析期间循环已被停止或被新的 DiscoverDNS 取代，结果不再应用
+		return ctx.Err()
+	}
+	d.p.mu.Lock()
+	d.p.lastDiscoveryErr = err
+	d.p.mu.Unlock()
+	if err != nil {
+		if !d.failing {
+			d.p.Log("discover peers from %s failed: %v", d.name, err)
+		}
+		d.failing = true
+		return err
+	}
+	d.failing = false
+
+	changed := d.members == nil
+	if d.members == nil {
+		d.members = make(map[string]int, len(peers))
+	}
+	found := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		found[peer] = true
+		if _, ok := d.members[peer]; !ok {
+			changed = true
+		}
+		d.members[peer] = 0
+	}
+	for peer := range d.members {
+		if found[peer] {
+			continue
+		}
+		d.members[peer]++
+		if d.members[peer] >= d.removeAfter {
+			delete(d.members, peer)
+			changed = true
+		}
+	}
+	if changed {
+		current := make([]string, 0, len(d.members))
+		for peer := range d.members {
+			current = append(current, peer)
+		}
+		sort.Strings(current)
+		d.p.SetPeers(current...)
+	}
+	return nil
+}
+
+// resolve 查询 SRV 记录，没有时查询 A/AAAA 记录，返回对等点的地址。
+// SRV 记录的目标是主机名，而 self 通常是 Pod IP，因此目标也解析为地址：
+// 地址中有本节点时使用本节点，否则使用排序后的第一个地址；无法解析的目标本次被跳过。
+func (d *discovery) resolve(ctx context.Context) ([]string, error) {
+	scheme := "http"
+	if strings.HasPrefix(d.p.self, "https://") {
+		scheme = "https"
+	}
+	peer := func(host string, port int) string {
+		return scheme + "://" + net.JoinHostPort(strings.TrimSuffix(host, "."), strconv.Itoa(port))
+	}
+
+	var peers []string
+	_, srvs, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+	if err == nil && len(srvs) > 0 {
+		var lookupErr error
+		for _, srv := range srvs {
+			port := d.port
+			if port == 0 {
+				port = int(srv.Port)
+			}
+			hosts, err := d.resolver.LookupHost(ctx, strings.TrimSuffix(srv.Target, "."))
+			if err != nil || len(hosts) == 0 {
+				lookupErr = err
+				continue
+			}
+			sort.Strings(hosts)
+			addr := peer(hosts[0], port)
+			for _, host := range hosts {
+				if p := peer(host, port); p == d.p.self {
+					addr = p
+				}
+			}
+			peers = append(peers, addr)
+		}
+		if len(peers) == 0 && lookupErr != nil {
+			return nil, lookupErr
+		}
+	} else {
+		if d.port == 0 {
+			// 没有端口时只能使用 SRV 记录
+			if err == nil {
+				err = fmt.Errorf("no SRV records for %s", d.name)
+			}
+			return nil, err
+		}
+		hosts, err := d.resolver.LookupHost(ctx, d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			peers = append(peers, peer(host, d.port))
+		}
+	}
+	// 空的结果按错误处理，避免一次异常的应答清空哈希环
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no peers found for %s", d.name)
+	}
+	return peers, nil
+}
@@ -0,0 +1,194 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver 返回预先设置的记录，addrs 中有的主机名解析为其中的地址
+type fakeResolver struct {
+	mu    sync.Mutex
+	srvs  []*net.SRV
+	hosts []string
+	addrs map[string][]string
+	err   error
+	calls int
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return name, r.srvs, nil
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if addrs, ok := r.addrs[host]; ok {
+		return addrs, nil
+	}
+	return r.hosts, nil
+}
+
+func (r *fakeResolver) set(hosts []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts, r.err = hosts, err
+}
+
+func poolPeers(p *HTTPPool) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var peers []string
+	for peer := range p.httpGetters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+func TestDiscoverDNS(t *testing.T) {
+	r := &fakeResolver{hosts: []string{"10.0.0.1", "10.0.0.2"}}
+	pool := NewHTTPPool("http://10.0.0.1:8001")
+	pool.SetDiscovery(DiscoveryOptions{Resolver: r, RemoveAfter: 2})
+	d := &discovery{p: pool, name: "cache.ns.svc.cluster.local", port: 8001, resolver: r, removeAfter: 2}
+
+	if err := d.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if peers := poolPeers(pool); !reflect.DeepEqual(peers, []string{"http://10.0.0.1:8001", "http://10.0.0.2:8001"}) {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+
+	// 新的对等点立即加入，缺失的对等点要连续缺失 RemoveAfter 次才被移除
+	r.set([]string{"10.0.0.1", "10.0.0.3"}, nil)
+	d.refresh(context.Background())
+	if peers := poolPeers(pool); len(peers) != 3 {
+		t.Fatalf("expect 10.0.0.3 added and 10.0.0.2 kept, got %v", peers)
+	}
+	r.set([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil)
+	d.refresh(context.Background())
+	r.set([]string{"10.0.0.1", "10.0.0.3"}, nil)
+	d.refresh(context.Background())
+	if peers := poolPeers(pool); len(peers) != 3 {
+		t.Fatalf("expect the miss count reset after 10.0.0.2 came back, got %v", peers)
+	}
+
+	// 解析失败时保留原来的对等点，也不计入缺失
+	r.set(nil, errors.New("SERVFAIL"))
+	if err := d.refresh(context.Background()); err == nil || pool.LastDiscoveryError() == nil {
+		t.Fatalf("expect the resolution error surfaced")
+	}
+	if peers := poolPeers(pool); len(peers) != 3 {
+		t.Fatalf("expect peers kept on error, got %v", peers)
+	}
+	r.set([]string{}, nil)
+	if err := d.refresh(context.Background()); err == nil {
+		t.Fatalf("expect an empty answer treated as an error")
+	}
+
+	r.set([]string{"10.0.0.1", "10.0.0.3"}, nil)
+	d.refresh(context.Background())
+	if peers := poolPeers(pool); !reflect.DeepEqual(peers, []string{"http://10.0.0.1:8001", "http://10.0.0.3:8001"}) || pool.LastDiscoveryError() != nil {
+		t.Fatalf("expect 10.0.0.2 removed after 2 misses, got %v", peers)
+	}
+
+	// 有 SRV 记录时使用其中的端口，目标解析为地址，本节点能在结果中认出自己
+	srvResolver := &fakeResolver{
+		srvs: []*net.SRV{
+			{Target: "cache-0.cache.ns.svc.cluster.local.", Port: 9000},
+			{Target: "cache-1.cache.ns.svc.cluster.local.", Port: 9000},
+			{Target: "cache-2.cache.ns.svc.cluster.local.", Port: 9000},
+		},
+		addrs: map[string][]string{
+			"cache-0.cache.ns.svc.cluster.local": {"10.0.1.9", "10.0.0.1"},
+			"cache-1.cache.ns.svc.cluster.local": {"10.0.0.2"},
+			"cache-2.cache.ns.svc.cluster.local": {},
+		},
+	}
+	self := NewHTTPPool("https://10.0.0.1:9000")
+	srv := &discovery{p: self, name: "cache", resolver: srvResolver, removeAfter: 1}
+	if err := srv.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if peers := poolPeers(self); !reflect.DeepEqual(peers, []string{"https://10.0.0.1:9000", "https://10.0.0.2:9000"}) {
+		t.Fatalf("unexpected SRV peers %v", peers)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key", i)
+		if addr, isSelf := self.OwnerOf(key); addr == "https://10.0.0.1:9000" && !isSelf {
+			t.Fatalf("expect self recognized in the SRV answer")
+		}
+		if peer, ok := self.PickPeer(key); ok && peer.(*httpGetter).addr == "https://10.0.0.1:9000" {
+			t.Fatalf("expect keys owned by self not to be routed to a peer")
+		}
+	}
+}
+
+func TestDiscoverDNSLoop(t *testing.T) {
+	r := &fakeResolver{hosts: []string{"10.0.0.1"}}
+	pool := NewHTTPPool("http://10.0.0.1:8001")
+	pool.SetDiscovery(DiscoveryOptions{Resolver: r, RemoveAfter: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := pool.DiscoverDNS(ctx, "cache", 8001, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	r.set([]string{"10.0.0.2"}, nil)
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(poolPeers(pool), []string{"http://10.0.0.2:8001"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("peers did not follow DNS, %v", poolPeers(pool))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 再次调用时取代之前的循环
+	other := &fakeResolver{hosts: []string{"10.0.0.5"}}
+	pool.SetDiscovery(DiscoveryOptions{Resolver: other, RemoveAfter: 1})
+	if err := pool.DiscoverDNS(ctx, "cache", 8001, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	r.mu.Lock()
+	replaced := r.calls
+	r.mu.Unlock()
+	time.Sleep(30 * time.Millisecond)
+	r.mu.Lock()
+	if r.calls != replaced {
+		t.Fatalf("expect the first loop stopped by the second DiscoverDNS")
+	}
+	r.mu.Unlock()
+	if peers := poolPeers(pool); !reflect.DeepEqual(peers, []string{"http://10.0.0.5:8001"}) {
+		t.Fatalf("expect peers from the second loop, got %v", peers)
+	}
+
+	// ctx 结束后不再解析
+	r = other
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	r.mu.Lock()
+	calls := r.calls
+	r.mu.Unlock()
+	time.Sleep(30 * time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.calls != calls {
+		t.Fatalf("expect resolution to stop with ctx")
+	}
+}
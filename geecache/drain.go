@@ -0,0 +1,175 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "geecache/geecachepb"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DrainProgress 描述 Drain 的进度
+type DrainProgress struct {
+	Pushed    int // 已推送给新拥有者的条目数，包括之前的 Drain 调用
+	Remaining int // 本次 Drain 开始时待推送、尚未处理的条目数
+	Failed    int // 本次 Drain 中推送失败的条目数，下次 Drain 会重试
+}
+
+// drainState 记录已推送的条目，使 Drain 可以在中断后继续
+type drainState struct {
+	mu       sync.Mutex
+	rate     float64                // 每秒最多推送的条目数，0 表示不限制
+	pushed   map[drainKey]time.Time // 已推送的条目和推送时它的写入时间
+	progress DrainProgress
+}
+
+// drainKey 标识一个 Group 中的键
+type drainKey struct {
+	group, key string
+}
+
+// SetDrainRate 限制 Drain 每秒推送的条目数，0 表示不限制
+func (p *HTTPPool) SetDrainRate(entriesPerSecond float64) {
+	p.drain.mu.Lock()
+	p.drain.rate = entriesPerSecond
+	p.drain.mu.Unlock()
+}
+
+// DrainProgress 返回最近一次 Drain 的进度
+func (p *HTTPPool) DrainProgress() DrainProgress {
+	p.drain.mu.Lock()
+	defer p.drain.mu.Unlock()
+	return p.drain.progress
+}
+
+// Drain 在本节点计划离开集群前迁移它的缓存：对注册了本池的每个 Group，按去掉本节点后的哈希环
+// 计算 mainCache 中每个键的新拥有者，并以副本写入推送给它，TTL 为剩余的存活时间。
+// 负缓存条目和即将过期的条目不推送。推送按 SetDrainRate 限速，可以用 DrainProgress 查看进度。
+// ctx 结束时返回 ctx 的错误，已推送的条目被记住，再次调用 Drain 从中断处继续；推送失败的条目，
+// 以及推送之后被重新写入的条目会在下次推送。
+// Drain 不修改哈希环，完成后再把本节点从各节点的对等点列表中移除，之后的读取会命中新拥有者上的副本。
+func (p *HTTPPool) Drain(ctx context.Context) error {
+	p.mu.Lock()
+	if p.peers == nil {
+		p.mu.Unlock()
+		return errors.New("geecache: drain: no peers")
+	}
+	ring := p.peers.Without(p.self)
+	getters := make(map[string]*httpGetter, len(p.httpGetters))
+	for addr, getter := range p.httpGetters {
+		getters[addr] = getter
+	}
+	p.mu.Unlock()
+	if ring.Size() == 0 {
+		return errors.New("geecache: drain: no other peers to take over")
+	}
+
+	type item struct {
+		group *Group
+		entry cacheEntry
+	}
+	var items []item
+	p.drain.mu.Lock()
+	if p.drain.pushed == nil {
+		p.drain.pushed = make(map[drainKey]time.Time)
+	}
+	for _, g := range p.registeredGroups() {
+		for _, e := range g.mainCache.entries() {
+			if e.info.Flags&flagNotFound != 0 {
+				continue
+			}
+			// 写入时间不同说明推送之后条目被替换，需要重新推送
+			if at, ok := p.drain.pushed[drainKey{g.name, e.key}]; !ok || !at.Equal(e.info.CreatedAt) {
+				items = append(items, item{g, e})
+			}
+		}
+	}
+	p.drain.progress.Remaining = len(items)
+	p.drain.progress.Failed = 0
+	var bucket *tokenBucket
+	if p.drain.rate > 0 {
+		bucket = newTokenBucket(RateLimit{Rate: p.drain.rate, Burst: 1}, time.Now())
+	}
+	p.drain.mu.Unlock()
+
+	var firstErr error
+	for _, it := range items {
+		if err := waitForToken(ctx, bucket); err != nil {
+			return err
+		}
+		err := p.drainEntry(it.group, it.entry, ring.Get(it.entry.key), getters)
+		p.drain.mu.Lock()
+		p.drain.progress.Remaining--
+		if err != nil {
+			p.drain.progress.Failed++
+		} else {
+			p.drain.progress.Pushed++
+			p.drain.pushed[drainKey{it.group.name, it.entry.key}] = it.entry.info.CreatedAt
+		}
+		p.drain.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("geecache: drain: %d entries not pushed: %v", p.DrainProgress().Failed, firstErr)
+	}
+	return nil
+}
+
+// drainEntry 把一个条目推送给新拥有者，已过期的条目直接跳过
+func (p *HTTPPool) drainEntry(g *Group, e cacheEntry, owner string, getters map[string]*httpGetter) error {
+	var ttl time.Duration
+	if !e.info.ExpireAt.IsZero() {
+		if ttl = e.info.ExpireAt.Sub(g.now()); ttl < time.Millisecond {
+			return nil
+		}
+	}
+	getter := getters[owner]
+	if getter == nil {
+		return fmt.Errorf("no client for peer %s", owner)
+	}
+	req := &pb.SetRequest{
+		Group:   g.name,
+		Key:     e.key,
+		Value:   e.view.ByteSlice(),
+		TtlMs:   ttlMillis(ttl),
+		Replica: true,
+		Meta:    e.view.meta,
+	}
+	return getter.Set(req, &pb.SetResponse{})
+}
+
+// registeredGroups 返回注册了本池作为 PeerPicker 的 Group，按名字排序
+func (p *HTTPPool) registeredGroups() []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	var gs []*Group
+	for _, g := range groups {
+		if picker, ok := g.peerPicker().(*HTTPPool); ok && picker == p {
+			gs = append(gs, g)
+		}
+	}
+	sort.Slice(gs, func(i, j int) bool { return gs[i].name < gs[j].name })
+	return gs
+}
+
+// waitForToken 从 bucket 中取出一个令牌，没有时等待，bucket 为 nil 时不限速
+func waitForToken(ctx context.Context, bucket *tokenBucket) error {
+	for bucket != nil {
+		ok, wait := bucket.take(time.Now())
+		if ok {
+			return nil
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+	return ctx.Err()
+}
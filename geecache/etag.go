@@ -0,0 +1,89 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// MetaETag 是保存条目 ETag 的元数据键。ETagGetter 返回的 ETag 保存在这里，
+// 也可以用 SetWithMeta 为写入的值指定 ETag。
+const MetaETag = "etag"
+
+// ETagGetter 是 Getter 可选实现的接口，加载值的同时返回它的 ETag，例如数据源中的版本号。
+// ETag 与值一起缓存，随值在对等点之间传递；返回空字符串表示没有 ETag。需要加载的 ctx 时同时实现 ETagCtxGetter。
+type ETagGetter interface {
+	GetWithETag(key string) (value []byte, etag string, err error)
+}
+
+// ETagCtxGetter 是 ETagGetter 可选实现的接口，与 CtxGetter 一样收到加载的 ctx
+type ETagCtxGetter interface {
+	GetWithETagCtx(ctx context.Context, key string) (value []byte, etag string, err error)
+}
+
+// getWithETag 调用 ETagGetter，把 ETag 放入值的元数据。与普通的 Getter 一样，实现了 ETagCtxGetter 时传入 ctx，
+// 否则设置了加载超时时在超时后放弃等待
+func (g *Group) getWithETag(ctx context.Context, eg ETagGetter, key string) (ByteView, error) {
+	var b []byte
+	var etag string
+	var err error
+	switch cg, ok := eg.(ETagCtxGetter); {
+	case ok:
+		b, etag, err = cg.GetWithETagCtx(ctx, key)
+	case g.timeoutFor(key) > 0:
+		// etag 只在 get 返回之后读取，被放弃的调用不会再被读取
+		var tag string
+		b, err = g.getAsync(ctx, key, func() ([]byte, error) {
+			b, t, err := eg.GetWithETag(key)
+			tag = t
+			return b, err
+		})
+		if err == nil || uncacheableValue(err) {
+			etag = tag
+		}
+	default:
+		b, etag, err = eg.GetWithETag(key)
+	}
+	view, err := g.wrapGetter(b, err)
+	if (err == nil || uncacheableValue(err)) && etag != "" {
+		view.meta = map[string]string{MetaETag: etag}
+	}
+	return view, err
+}
+
+// GetWithETag 获取键的值和它的 ETag，用于实现条件请求。ifNoneMatch 是调用者的 If-None-Match，
+// 可以是逗号分隔的多个 ETag 或 "*"，按弱比较匹配。与条目的 ETag 匹配时 notModified 为 true，
+// 不返回值。条目没有 ETag 时按值的内容计算一个，同样的内容总是得到同样的 ETag。
+func (g *Group) GetWithETag(key, ifNoneMatch string) (value ByteView, etag string, notModified bool, err error) {
+	v, info, err := g.GetWithInfo(key)
+	if err != nil {
+		return ByteView{}, "", false, err
+	}
+	etag = info.Meta[MetaETag]
+	if etag == "" {
+		etag = contentETag(v)
+	}
+	if etagMatch(ifNoneMatch, etag) {
+		return ByteView{}, etag, true, nil
+	}
+	return v, etag, false, nil
+}
+
+// contentETag 用值的 FNV-1a 哈希生成强 ETag
+func contentETag(v ByteView) string {
+	h := fnv.New64a()
+	h.Write(v.b)
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// etagMatch 判断 If-None-Match 是否与 etag 匹配，忽略弱 ETag 的 W/ 前缀
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || (candidate != "" && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package geecache
+
+import (
+	"errors"
+	pb "geecache/geecachepb"
+	"sync/atomic"
+)
+
+// PeerErrorPolicy 决定从远程节点获取失败后的行为。
+// 拥有者返回的 ErrNotFound 表示键确实不存在，任何策略下都不会回退到本地加载。
+type PeerErrorPolicy struct {
+	failFast bool
+	retries  int
+}
+
+var (
+	// Fallback 在远程节点出错时回退到本地 Getter 加载，这是默认行为
+	Fallback = PeerErrorPolicy{}
+	// FailFast 直接返回远程节点的错误，保证只有拥有者访问数据源
+	FailFast = PeerErrorPolicy{failFast: true}
+)
+
+// FallbackAfterRetries 先向远程节点重试 n 次，仍然失败时再回退到本地加载
+func FallbackAfterRetries(n int) PeerErrorPolicy {
+	if n < 0 {
+		n = 0
+	}
+	return PeerErrorPolicy{retries: n}
+}
+
+// shouldFallback 判断远程节点返回 err 后是否回退到本地加载
+func (p PeerErrorPolicy) shouldFallback(err error) bool {
+	return !p.failFast && !errors.Is(err, ErrNotFound)
+}
+
+// getBatchWithPolicy 向远程节点发起批量请求，失败时像 getFromPeerWithPolicy 一样按策略重试
+func (g *Group) getBatchWithPolicy(batcher BatchPeerGetter, req *pb.BatchRequest, res *pb.BatchResponse) error {
+	for i := 0; ; i++ {
+		res.Reset()
+		err := batcher.GetBatch(req, res)
+		if err == nil {
+			return nil
+		}
+		atomic.AddInt64(&g.stats.PeerErrors, int64(len(req.GetKeys())))
+		peerLog.Printf(peerName(batcher), "[GeeCache] Failed to get batch from peer %s: %v", peerName(batcher), err)
+		if i >= g.peerPolicy.retries || errors.Is(err, ErrThrottled) {
+			return err
+		}
+	}
+}
+
+// getFromPeerWithPolicy 从远程节点获取值，传输类错误按策略重试
+func (g *Group) getFromPeerWithPolicy(peer PeerGetter, key string) (ByteView, error) {
+	for i := 0; ; i++ {
+		value, err := g.getFromPeer(peer, key)
+		if err == nil || errors.Is(err, ErrNotFound) || uncacheableValue(err) {
+			return value, err
+		}
+		atomic.AddInt64(&g.stats.PeerErrors, 1)
+		peerLog.Printf(peerName(peer), "[GeeCache] Failed to get from peer %s: %v", peerName(peer), err)
+		// 被限流时立即按策略处理，重试只会加重对方的负担
+		if i >= g.peerPolicy.retries || errors.Is(err, ErrThrottled) {
+			return value, err
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package geecache
+
+import (
+	"context"
+	"geecache/singleflight"
+)
+
+// Flight 是 Group 用来合并同一个键的并发加载的接口，*singleflight.Group 满足该接口。
+// 测试可以用 WithFlight 注入记录调用或控制时序的实现。
+// 多个 Group 可以共享一个 Flight，键已按 Group 名称区分。
+type Flight interface {
+	// Do 执行 fn，同一个键同时只有一次执行，其他调用者等待并得到相同的结果
+	Do(key string, fn func() (interface{}, error)) (interface{}, error)
+	// DoContext 与 Do 相同，但调用者可以通过 ctx 放弃等待
+	DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error)
+	// Forget 使之后对该键的调用不再等待正在进行的执行
+	Forget(key string)
+}
+
+var _ Flight = (*singleflight.Group)(nil)
@@ -1,29 +1,144 @@
 package geecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	pb "geecache/geecachepb"
+	"geecache/lru"
 	"geecache/singleflight"
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Group 是一个缓存命名空间和相关的数据加载分布
 type Group struct {
+	stats     Stats // 放在首位以保证原子操作的 64 位对齐
 	name      string
 	getter    Getter
 	mainCache cache
-	peers     PeerPicker
-	// 使用 singleflight.Group 确保每个键只被获取一次
-	loader *singleflight.Group
+	peers     atomic.Value // peersHolder，RegisterPeers 之前为空
+	// 保存从远程节点获取的值，避免热点键的网络开销，cacheBytes 为 0 时不启用
+	hotCache cache
+	// 查找 mainCache 和 hotCache 的顺序
+	tierOrder TierOrder
+	// 确保每个键只被获取一次，默认为 singleflight.Group
+	loader Flight
+	// GetLocalOnly 的加载单独合并，避免拿到经由远程节点的结果
+	localLoader *singleflight.Group
+	now         func() time.Time
+	// 大于 0 时，超过该字节数的值不会被缓存
+	maxValueBytes int64
+	readOnly      bool
+	// 为 true 时值不会改变，缓存不维护过期信息
+	immutable bool
+	// 为 true 时，远程节点拥有的键总是从拥有者读取
+	ownerReads bool
+	// 大于 0 时，ErrNotFound 会以该 TTL 被负缓存
+	negativeTTL time.Duration
+	// 过期清理的间隔
+	cleanupInterval time.Duration
+	// 共享调度器中已计划的清理所对应的过期时间（UnixNano，按 Group 的时钟），0 表示没有计划。
+	// 写入更晚过期的条目时据此跳过调度器的全局锁
+	sweepAt int64
+	// 非 nil 时由注入的 Ticker 驱动过期清理，不使用共享的调度器
+	sweeper *sweeper
+	// 非 nil 时按条目的过期时间精确清理，代替周期性的清理
+	precise *preciseExpiry
+	// 非 nil 时很小的值从共享的块中分配，见 WithSmallValueFastPath
+	arena *smallArena
+	// 非 nil 时，本节点拥有的键写入后会异步复制到后继节点，replicaStop 关闭后复制协程退出
+	replicas    chan replica
+	replicaStop chan struct{}
+	replicaOnce sync.Once
+	// 非 nil 时，本地加载失败后按策略重试
+	retry *retryPolicy
+	// 从远程节点获取失败后的行为
+	peerPolicy PeerErrorPolicy
+	// 非 nil 时，加载成功后在后台预热预测的键，prefetchSem 限制并发数
+	prefetcher  Prefetcher
+	prefetchSem chan struct{}
+	// 非 nil 时，Set 写入的数据先追加到预写日志
+	wal *wal
+	// 非 nil 时，本地加载在时间窗口内合并为一次 GetBatch
+	coalescer *coalescer
+	// 非 nil 时，GetDecoded 缓存解码后的对象
+	decode Decoder
+	// 非 nil 时，加载在 RegisterPeers 之前等待，直到 NewGroup 之后 peersWait 时 peersTimer 关闭 peersGaveUp
+	peersReady  chan struct{}
+	peersGaveUp chan struct{}
+	peersTimer  *time.Timer
+	peersWait   time.Duration
+	// 保证 RegisterPeers 只生效一次
+	registerOnce sync.Once
+	// 非 nil 时，mainCache 的字节上限由共享预算决定
+	budget *BudgetArbiter
+	// 本地加载的超时，keyTimeouts 中匹配的规则优先
+	loadTimeout time.Duration
+	keyTimeouts []keyTimeout
+	// 超时后仍未返回的 Getter 调用数及其上限，见 WithMaxAbandonedLoads
+	abandonedLoads    int32
+	maxAbandonedLoads int32
+	// 非 nil 时，统计回源加载最多的键
+	loadTracker *loadTracker
+	// 统计从数据源加载的错误率和最近的错误
+	health *healthTracker
+	// 非 nil 时，按内存压力调整 mainCache 的上限
+	pressure *pressureMonitor
+	// 非 nil 时，刚删除的键在窗口期内不会被重新填充
+	tombstones *tombstones
+	// 被固定的条目，设置了 WithPinBudget 时非 nil
+	pins *pins
+	// 加载的值写入 mainCache 时使用的 TTL，纳秒，原子地读写
+	loadTTL int64
+}
+
+// EntryInfo 描述 Get 返回值的元信息
+type EntryInfo struct {
+	CreatedAt time.Time     // 写入缓存的时间，不可变的 Group 命中缓存或值没有写入缓存时为零值
+	ExpireAt  time.Time     // 过期时间，零值表示没有 TTL
+	Age       time.Duration // 条目已存在的时长，CreatedAt 为零值时为 0
+	Source    Source        // 值的来源
+	// 通过 SetWithMeta 附加的元数据，没有时为 nil；调用者不应修改
+	Meta map[string]string
+	// 值没有写入缓存，例如 Getter 返回了 Uncacheable(nil) 或加载被放弃，值可能不完整
+	Uncacheable bool
+}
+
+// Source 表示一次 Get 的值来自哪里
+type Source int
+
+const (
+	SourceLocalCache Source = iota // 本地 mainCache
+	SourceHotCache                 // 本地 hotCache，保存从远程节点获取的热点数据
+	SourcePeer                     // 远程节点
+	SourceLocalLoad                // 本地 Getter 新加载
+)
+
+var sourceNames = [...]string{"local-cache", "hot-cache", "peer", "local-load"}
+
+func (s Source) String() string {
+	if s < 0 || int(s) >= len(sourceNames) {
+		return fmt.Sprintf("Source(%d)", int(s))
+	}
+	return sourceNames[s]
 }
 
 // Getter 为键加载数据。
+// 返回 (nil, nil) 表示键存在但值为空：缓存一个长度为 0 的值，之后的 Get 直接命中而不再加载。
+// 键不存在时应返回 ErrNotFound 或包装了它的错误，以便与空值区分并按 WithNegativeTTL 负缓存。
 type Getter interface {
 	Get(key string) ([]byte, error)
 }
 
+// CtxGetter 是 Getter 可选实现的接口。实现了它的 Getter 通过 GetCtx 加载，
+// 收到的 ctx 带有发起这次加载的调用者 ctx 中的值（如请求 ID），便于 Getter 继续传递追踪信息；
+// 它不继承任何调用者的取消，只在所有等待该键的调用者都放弃后被取消。
+type CtxGetter interface {
+	GetCtx(ctx context.Context, key string) ([]byte, error)
+}
+
 // GetterFunc 使用函数实现 Getter 接口。
 type GetterFunc func(key string) ([]byte, error)
 
@@ -32,16 +147,42 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+var (
+	// ErrValueTooLarge 表示值超过了 Group 允许的最大字节数
+	ErrValueTooLarge = errors.New("geecache: value too large")
+	// ErrReadOnly 表示 Group 是只读的，不接受 Set
+	ErrReadOnly = errors.New("geecache: group is read-only")
+	// ErrTTLNotAllowed 表示 Group 是不可变的，不接受带 TTL 的写入
+	ErrTTLNotAllowed = errors.New("geecache: ttl not allowed on immutable group")
+	// ErrNotFound 表示键不存在，Getter 返回包装了它的错误时可以被负缓存
+	ErrNotFound = errors.New("geecache: not found")
+	// ErrThrottled 表示远程节点因限流拒绝了请求
+	ErrThrottled = errors.New("geecache: throttled by peer")
+	// ErrPeerCodec 表示远程节点无法编码响应，与获取值本身的错误区分开
+	ErrPeerCodec = errors.New("geecache: peer failed to encode response")
+	// ErrResponseTooLarge 表示响应超过了远程节点允许的最大字节数
+	ErrResponseTooLarge = errors.New("geecache: response too large")
+	// ErrLoadBacklog 表示超时后仍未返回的 Getter 调用过多，新的加载直接失败
+	ErrLoadBacklog = errors.New("geecache: too many abandoned loads")
+)
+
+// flagNotFound 标记负缓存条目，与合法的空值区分开
+const flagNotFound uint32 = 1 << 0
+
 var (
 	mu     sync.RWMutex
 	groups = make(map[string]*Group)
 )
 
-// NewGroup 创建 Group 的新实例
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+// NewGroup 创建 Group 的新实例。name 不能为空，也不能与 HTTPPool 的调试接口同名，
+// 否则 panic；其余名字（包括含有空格、"/" 或非 ASCII 字符的名字）在对等点之间转义传递。
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
+	if err := ValidGroupName(name); err != nil {
+		panic(err.Error())
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
@@ -49,21 +190,116 @@ func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 		getter:    getter,
 		mainCache: cache{cacheBytes: cacheBytes},
 		loader:    &singleflight.Group{},
+		now:       time.Now,
+
+		localLoader: &singleflight.Group{},
+
+		cleanupInterval: defaultCleanupInterval,
+		health:          newHealthTracker(defaultHealthWindow, defaultRecentErrors),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.mainCache.now = g.now
+	g.hotCache.now = g.now
+	if g.immutable {
+		g.mainCache.noExpiry = true
+		g.hotCache.noExpiry = true
+		g.negativeTTL = 0
+	}
+	if g.peersWait > 0 {
+		g.peersReady = make(chan struct{})
+		g.peersGaveUp = make(chan struct{})
+		g.peersTimer = time.AfterFunc(g.peersWait, func() { close(g.peersGaveUp) })
+	}
+	if g.budget != nil {
+		g.budget.add(g)
+	}
+	if g.pressure != nil {
+		g.pressure.start()
 	}
 	groups[name] = g
+	if g.replicas != nil {
+		g.replicaStop = make(chan struct{})
+		go g.replicateLoop()
+	}
 
-	// 启动后台清理协程
-	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		defer ticker.Stop()
-		for range ticker.C {
-			g.mainCache.cleanExpired()
+	// 由共享的调度协程在最早的条目过期后清理，不可变的 Group 没有需要清理的条目
+	switch {
+	case g.immutable:
+		if g.sweeper != nil {
+			g.sweeper.ticker.Stop()
 		}
-	}()
+		g.precise = nil
+	case g.precise != nil:
+		if g.sweeper != nil {
+			g.sweeper.ticker.Stop()
+			g.sweeper = nil
+		}
+		g.mainCache.onExpiry = g.precise.schedule
+		g.hotCache.onExpiry = g.precise.schedule
+		g.precise.start()
+	case g.sweeper != nil:
+		g.sweeper.start()
+	default:
+		g.mainCache.onExpiry = func(at time.Time) { cleanupScheduler.expireAt(g, at) }
+		g.hotCache.onExpiry = g.mainCache.onExpiry
+		cleanupScheduler.add(g, g.cleanupInterval)
+	}
 
 	return g
 }
 
+// Resize 修改 mainCache 的字节上限，0 表示不限制，缩小时立即淘汰。
+// 使用共享预算时上限由预算决定，调用无效；开启了 WithMemoryPressure 时，新的上限也是压力缓解后恢复的上限。
+func (g *Group) Resize(cacheBytes int64) {
+	if g.budget != nil {
+		return
+	}
+	g.mainCache.resize(cacheBytes)
+	if g.pressure != nil {
+		atomic.StoreInt64(&g.pressure.base, cacheBytes)
+	}
+}
+
+// SetDefaultTTL 修改加载的值写入缓存时使用的 TTL，0 表示不过期，只影响之后的加载
+func (g *Group) SetDefaultTTL(ttl time.Duration) {
+	atomic.StoreInt64(&g.loadTTL, int64(ttl))
+}
+
+func (g *Group) defaultTTL() time.Duration {
+	if g.immutable {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&g.loadTTL))
+}
+
+// Close 停止 Group 的后台过期清理和内存压力检查，关闭预写日志，并归还共享预算
+func (g *Group) Close() {
+	cleanupScheduler.remove(g)
+	if g.sweeper != nil {
+		g.sweeper.close()
+	}
+	if g.precise != nil {
+		g.precise.close()
+	}
+	if g.budget != nil {
+		g.budget.remove(g)
+	}
+	if g.pressure != nil {
+		g.pressure.close()
+	}
+	if g.wal != nil {
+		g.wal.close()
+	}
+	if g.peersTimer != nil {
+		g.peersTimer.Stop()
+	}
+	if g.replicas != nil {
+		g.replicaOnce.Do(func() { close(g.replicaStop) })
+	}
+}
+
 // GetGroup 返回之前用 NewGroup 创建的指定名称的组，如果没有这样的组则返回 nil。
 func GetGroup(name string) *Group {
 	mu.RLock()
@@ -74,67 +310,612 @@ func GetGroup(name string) *Group {
 
 // 从缓存中获取键的值
 func (g *Group) Get(key string) (ByteView, error) {
+	v, _, err := g.GetWithInfo(key)
+	return v, err
+}
+
+// GetCtx 与 Get 相同，但调用者可以通过 ctx 放弃等待加载。
+// 同一个键的并发加载共享一个 context，只有当所有等待者都放弃时它才会被取消，
+// Getter 实现了 CtxGetter 时可以借此中止不再有人需要的加载。
+func (g *Group) GetCtx(ctx context.Context, key string) (ByteView, error) {
+	v, _, err := g.getWithInfo(ctx, key)
+	return v, err
+}
+
+// GetWithInfo 获取键的值，并返回条目的写入时间、过期时间和存在时长
+func (g *Group) GetWithInfo(key string) (ByteView, EntryInfo, error) {
+	return g.getWithInfo(context.Background(), key)
+}
+
+func (g *Group) getWithInfo(ctx context.Context, key string) (ByteView, EntryInfo, error) {
 	if key == "" {
-		return ByteView{}, fmt.Errorf("key is required")
+		return ByteView{}, EntryInfo{}, fmt.Errorf("key is required")
+	}
+
+	if g.ownerReads && g.ownedByPeer(key) {
+		// 远程节点拥有的键总是从拥有者读取，保证读到最新的写入
+		atomic.AddInt64(&g.stats.Gets, 1)
+	} else if v, info, src, ok := g.lookupCache(key); ok {
+		if info.Flags&flagNotFound != 0 {
+			return ByteView{}, EntryInfo{}, ErrNotFound
+		}
+		ei := EntryInfo{CreatedAt: info.CreatedAt, ExpireAt: info.ExpireAt, Source: src, Meta: v.meta}
+		if !info.CreatedAt.IsZero() {
+			ei.Age = g.now().Sub(info.CreatedAt)
+		}
+		return v, ei, nil
 	}
 
-	if v, ok := g.mainCache.get(key); ok {
-		log.Println("[GeeCache] hit")
+	l, err := g.load(ctx, key)
+	if err != nil {
+		return ByteView{}, EntryInfo{}, err
+	}
+	g.prefetch(key)
+	ei := EntryInfo{Source: l.source, Meta: l.view.meta, Uncacheable: l.uncacheable}
+	if !l.uncacheable {
+		ei.CreatedAt = g.now()
+	}
+	return l.view, ei, nil
+}
+
+// GetLocalOnly 获取键的值，但不使用远程节点：只查找 mainCache，未命中时直接用本地 Getter 加载。
+// 从远程节点获取的 hotCache 条目被忽略。并发的调用仍然合并为一次加载。
+func (g *Group) GetLocalOnly(key string) (ByteView, error) {
+	if key == "" {
+		return ByteView{}, fmt.Errorf("key is required")
+	}
+	atomic.AddInt64(&g.stats.Gets, 1)
+	if v, info, ok := g.mainCache.getWithInfo(key); ok {
+		atomic.AddInt64(&g.stats.CacheHits, 1)
+		if info.Flags&flagNotFound != 0 {
+			return ByteView{}, ErrNotFound
+		}
 		return v, nil
 	}
 
-	return g.load(key)
+	atomic.AddInt64(&g.stats.Loads, 1)
+	viewi, err := g.localLoader.Do(g.flightKey(key), func() (interface{}, error) {
+		value, _, err := g.getLocally(context.Background(), key)
+		return value, err
+	})
+	if err != nil {
+		return ByteView{}, err
+	}
+	return viewi.(ByteView), nil
+}
+
+// GetOrDefault 获取键的值，失败时返回包装 def 的 ByteView，def 不会被缓存
+func (g *Group) GetOrDefault(key string, def []byte) ByteView {
+	v, err := g.Get(key)
+	if err != nil {
+		return ByteView{b: cloneBytes(def)}
+	}
+	return v
+}
+
+// GetWithSource 获取键的值，并返回它来自哪里，便于按请求追踪
+func (g *Group) GetWithSource(key string) (ByteView, Source, error) {
+	v, info, err := g.GetWithInfo(key)
+	return v, info.Source, err
+}
+
+// Has 判断键是否已被缓存，不会触发 Getter 加载。
+// 本地缓存未命中且键由远程节点拥有时，向拥有者发起不传输值的存在性查询。
+func (g *Group) Has(key string) (bool, error) {
+	if key == "" {
+		return false, fmt.Errorf("key is required")
+	}
+	if g.hasLocally(key) {
+		return true, nil
+	}
+	if g.peerPicker() == nil {
+		return false, nil
+	}
+	peer, ok := g.peerPicker().PickPeer(key)
+	if !ok {
+		return false, nil
+	}
+	checker, ok := peer.(PeerChecker)
+	if !ok {
+		return false, nil
+	}
+	res := &pb.HasResponse{}
+	if err := checker.Has(&pb.Request{Group: g.name, Key: key}, res); err != nil {
+		return false, err
+	}
+	return res.GetExists(), nil
+}
+
+// EvictionPreview 返回 mainCache 容量不足时最先被淘汰的最多 n 个键，不改变条目的顺序。
+// 顺序按最近使用计算，设置了 WithEvictionPolicy 时只是近似。
+func (g *Group) EvictionPreview(n int) []string {
+	return g.mainCache.oldest(n)
+}
+
+// hasLocally 判断本地 mainCache 或 hotCache 中是否有键的值，负缓存条目不算。
+// 与 cached 按相同的顺序查找，但不改变条目的最近使用顺序，也不通知淘汰策略
+func (g *Group) hasLocally(key string) bool {
+	minfo, mok := g.mainCache.peek(key)
+	if g.hotCache.cacheBytes > 0 {
+		if info, ok := g.hotCache.peek(key); ok && (!mok || g.hotFirst(key)) {
+			return info.Flags&flagNotFound == 0
+		}
+	}
+	if mok {
+		return minfo.Flags&flagNotFound == 0
+	}
+	_, ok, _ := g.cachedPin(key)
+	return ok
+}
+
+// lookupCache 按 TierOrder 查找 mainCache 和 hotCache，并记录命中统计
+func (g *Group) lookupCache(key string) (ByteView, lru.EntryInfo, Source, bool) {
+	atomic.AddInt64(&g.stats.Gets, 1)
+	v, info, src, ok := g.cached(key)
+	if ok {
+		atomic.AddInt64(&g.stats.CacheHits, 1)
+		if info.Flags&flagPrefetched != 0 {
+			atomic.AddInt64(&g.stats.PrefetchHits, 1)
+		}
+		logger.Printf("[GeeCache] hit")
+	}
+	return v, info, src, ok
 }
 
-// RegisterPeers 注册 PeerPicker 用于选择远程对等点
+// TierOrder 决定开启 hotCache 时 Get 查找 mainCache 和 hotCache 的顺序，前一层未命中时查找另一层
+type TierOrder int
+
+const (
+	// TierOrderByOwner 是默认的顺序：本节点拥有的键先查 mainCache，其中的值是权威的；
+	// 远程节点拥有的键先查 hotCache，mainCache 中可能只是哈希环变化前留下的旧值
+	TierOrderByOwner TierOrder = iota
+	// TierOrderMainFirst 总是先查 mainCache
+	TierOrderMainFirst
+	// TierOrderHotFirst 总是先查 hotCache
+	TierOrderHotFirst
+)
+
+// hotFirst 判断是否应先查找 hotCache
+func (g *Group) hotFirst(key string) bool {
+	if g.hotCache.cacheBytes == 0 {
+		return false
+	}
+	switch g.tierOrder {
+	case TierOrderMainFirst:
+		return false
+	case TierOrderHotFirst:
+		return true
+	}
+	return g.ownedByPeer(key)
+}
+
+// cached 查找 mainCache 和 hotCache，两层都命中时按 TierOrder 选择，最后查找固定的值，不计入统计。
+// 只有两层都命中时才需要判断键的归属，其余命中不会查询哈希环。
+func (g *Group) cached(key string) (ByteView, lru.EntryInfo, Source, bool) {
+	mv, minfo, mok := g.mainCache.getWithInfo(key)
+	if g.hotCache.cacheBytes > 0 {
+		if v, info, ok := g.hotCache.getWithInfo(key); ok && (!mok || g.hotFirst(key)) {
+			return v, info, SourceHotCache, true
+		}
+	}
+	if mok {
+		return mv, minfo, SourceLocalCache, true
+	}
+	if v, ok, at := g.cachedPin(key); ok {
+		return v, lru.EntryInfo{CreatedAt: at}, SourceLocalCache, true
+	}
+	return ByteView{}, lru.EntryInfo{}, SourceHotCache, false
+}
+
+// peersHolder 包装 PeerPicker，使 atomic.Value 总是保存同一种类型
+type peersHolder struct {
+	PeerPicker
+}
+
+// RegisterPeers 注册 PeerPicker 用于选择远程对等点。
+// 可以在 Group 开始服务之后调用，开启了 WithWaitForPeers 时会唤醒等待的加载。
 func (g *Group) RegisterPeers(peers PeerPicker) {
-	if g.peers != nil {
+	registered := false
+	g.registerOnce.Do(func() {
+		g.peers.Store(peersHolder{peers})
+		if g.peersReady != nil {
+			g.peersTimer.Stop()
+			close(g.peersReady)
+		}
+		registered = true
+	})
+	if !registered {
 		panic("RegisterPeerPicker called more than once")
 	}
-	g.peers = peers
 }
 
-func (g *Group) load(key string) (value ByteView, err error) {
+// peerPicker 返回注册的 PeerPicker，尚未注册时返回 nil
+func (g *Group) peerPicker() PeerPicker {
+	h, _ := g.peers.Load().(peersHolder)
+	return h.PeerPicker
+}
+
+// waitForPeers 在开启了 WithWaitForPeers 且尚未注册对等点时，
+// 等待 RegisterPeers、超时或 ctx 结束，超时后不使用对等点继续加载。
+// 超时从 NewGroup 开始计算，超时之后的加载不再等待
+func (g *Group) waitForPeers(ctx context.Context) error {
+	if g.peersReady == nil {
+		return nil
+	}
+	select {
+	case <-g.peersReady:
+		return nil
+	default:
+	}
+	select {
+	case <-g.peersReady:
+	case <-g.peersGaveUp:
+		atomic.AddInt64(&g.stats.LoadsWithoutPeers, 1)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// EvictUnowned 移除本地缓存中归属于其他节点的键，返回移除的数量。
+// 通常在哈希环变化后调用；注意热备复制得到的副本也会被移除。
+func (g *Group) EvictUnowned() int {
+	if g.peerPicker() == nil {
+		return 0
+	}
+	return g.mainCache.removeFunc(func(key string) bool {
+		_, remote := g.peerPicker().PickPeer(key)
+		return remote
+	})
+}
+
+// loaded 是 singleflight 中共享的加载结果
+type loaded struct {
+	view        ByteView
+	source      Source
+	uncacheable bool // 值没有写入缓存，见 EntryInfo.Uncacheable
+}
+
+func (g *Group) load(ctx context.Context, key string) (loaded, error) {
 	// 每个键只被获取一次（本地或远程）
 	// 无论并发调用者的数量如何。
-	viewi, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
-					return value, nil
+	atomic.AddInt64(&g.stats.Loads, 1)
+	if err := g.waitForPeers(ctx); err != nil {
+		return loaded{}, err
+	}
+	fk := g.flightKey(key)
+	chain := loadChainFrom(ctx)
+	if chain.contains(fk) {
+		return loaded{}, fmt.Errorf("%w: key %s in group %s", ErrCircularLoad, key, g.name)
+	}
+	viewi, err := g.loader.DoContext(ctx, fk, func(ctx context.Context) (interface{}, error) {
+		// 未命中之后、进入 singleflight 之前，值可能刚被另一次加载或 Set 写入
+		if !g.ownerReads || !g.ownedByPeer(key) {
+			if v, info, src, ok := g.cached(key); ok {
+				atomic.AddInt64(&g.stats.LoadsAvoided, 1)
+				if info.Flags&flagNotFound != 0 {
+					return loaded{}, ErrNotFound
+				}
+				return loaded{view: v, source: src}, nil
+			}
+		}
+		ctx = withLoading(ctx, chain, fk, g.maxValueBytes)
+		if g.peerPicker() != nil {
+			if peer, ok := g.peerPicker().PickPeer(key); ok {
+				value, err := g.getFromPeerWithPolicy(peer, key)
+				if err == nil || uncacheableValue(err) {
+					atomic.AddInt64(&g.stats.PeerLoads, 1)
+					// 拥有者标记为不可缓存的值同样不写入 hotCache
+					if err == nil {
+						g.populateHotCache(key, value)
+					}
+					return loaded{view: value, source: SourcePeer, uncacheable: err != nil}, nil
+				}
+				if !g.peerPolicy.shouldFallback(err) {
+					return loaded{}, err
+				}
+				atomic.AddInt64(&g.stats.PeerFallbacks, 1)
+				if g.ownerReads {
+					// 回退加载的值不写入本地缓存，避免之后读到旧值
+					value, cacheable, err := g.fetchLocally(ctx, key)
+					return loaded{view: value, source: SourceLocalLoad, uncacheable: !cacheable}, err
 				}
-				log.Println("[GeeCache] Failed to get from peer", err)
 			}
 		}
 
-		return g.getLocally(key)
+		value, cacheable, err := g.getLocally(ctx, key)
+		return loaded{view: value, source: SourceLocalLoad, uncacheable: !cacheable}, err
 	})
 
-	if err == nil {
-		return viewi.(ByteView), nil
+	if err != nil {
+		return loaded{}, err
+	}
+	return viewi.(loaded), nil
+}
+
+// flightKey 返回键在 singleflight 中使用的键。加上 group 名称作为命名空间，
+// 即使多个 Group 共享同一个 singleflight.Group，同名的键也不会拿到彼此的结果。
+func (g *Group) flightKey(key string) string {
+	return g.name + "\x00" + key
+}
+
+// ownedByPeer 判断键是否由远程节点拥有，PeerPicker 实现了 OwnerLocator 时不经过 PickPeer
+func (g *Group) ownedByPeer(key string) bool {
+	picker := g.peerPicker()
+	if picker == nil {
+		return false
+	}
+	if l, ok := picker.(OwnerLocator); ok {
+		_, self := l.OwnerOf(key)
+		return !self
+	}
+	_, ok := picker.PickPeer(key)
+	return ok
+}
+
+// populateHotCache 开启 hotCache 时，缓存从远程节点获取的值
+func (g *Group) populateHotCache(key string, value ByteView) {
+	if g.hotCache.cacheBytes > 0 && !g.ownerReads && !g.tooLarge(value.Len()) && !g.buried(key) {
+		g.hotCache.add(key, value, 0)
 	}
-	return
 }
 
 func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value, 0) // 加载的数据没有 TTL
+	if g.tooLarge(value.Len()) || g.buried(key) {
+		return
+	}
+	ttl := g.defaultTTL()
+	g.mainCache.add(key, value, ttl)
+	g.replicate(key, value, ttl)
 }
 
-// Set 设置键值对，可选 TTL
+func (g *Group) tooLarge(n int) bool {
+	return g.maxValueBytes > 0 && int64(n) > g.maxValueBytes
+}
+
+// SetResult 描述一次 Set 的结果
+type SetResult struct {
+	Stored       bool   // 值是否留在了缓存中
+	Evicted      int    // 因本次写入被淘汰的条目数
+	RoutedToPeer string // 写入被转发到的远程节点，本地写入时为空
+}
+
+// Set 设置键值对，可选 TTL，忽略写入结果
 func (g *Group) Set(key string, value []byte, ttl time.Duration) {
-	view := ByteView{b: cloneBytes(value)}
-	g.mainCache.add(key, view, ttl)
+	g.SetE(key, value, ttl)
 }
 
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
-	if err != nil {
-		return ByteView{}, err
+// SetE 设置键值对，可选 TTL。
+// 注册了对等点时，写入会被转发给拥有该键的节点；返回写入结果及失败原因。
+func (g *Group) SetE(key string, value []byte, ttl time.Duration) (SetResult, error) {
+	return g.set(key, value, ttl, nil)
+}
 
+func (g *Group) set(key string, value []byte, ttl time.Duration, meta map[string]string) (SetResult, error) {
+	if err := g.checkSet(key, value, ttl); err != nil {
+		return SetResult{}, err
+	}
+	if setter := g.ownerSetter(key); setter != nil {
+		return g.setToPeer(setter, key, value, ttl, meta)
+	}
+	view := g.arena.view(value)
+	view.meta = meta
+	return g.setOwned(key, view, ttl), nil
+}
+
+// checkSet 检查一次写入是否被允许
+func (g *Group) checkSet(key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if g.readOnly {
+		return ErrReadOnly
+	}
+	if g.immutable && ttl != 0 {
+		return ErrTTLNotAllowed
+	}
+	if g.tooLarge(len(value)) {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// ownerSetter 返回拥有键的远程节点，键属于本节点或该节点不支持写入时返回 nil
+func (g *Group) ownerSetter(key string) PeerSetter {
+	if g.peerPicker() != nil {
+		if peer, ok := g.peerPicker().PickPeer(key); ok {
+			if setter, ok := peer.(PeerSetter); ok {
+				return setter
+			}
+		}
 	}
-	value := ByteView{b: cloneBytes(bytes)}
+	return nil
+}
+
+// setOwned 把本节点拥有的键写入本地缓存，并复制到后继节点
+func (g *Group) setOwned(key string, view ByteView, ttl time.Duration) SetResult {
+	if ttl < 0 {
+		g.tombstones.add(key, g.now())
+	} else {
+		g.tombstones.clear(key)
+	}
+	res := g.setLocally(key, view, ttl)
+	g.replicate(key, view, ttl)
+	return res
+}
+
+// Delete 从本地所有缓存层中移除键，并通知拥有该键的远程节点删除。
+// 返回键是否在某一层中存在。进行中的加载会被忘记，之后的 Get 会重新加载。
+func (g *Group) Delete(key string) (bool, error) {
+	if key == "" {
+		return false, fmt.Errorf("key is required")
+	}
+	if g.readOnly {
+		return false, ErrReadOnly
+	}
+	deleted := g.deleteLocally(key)
+	if g.peerPicker() != nil {
+		if peer, ok := g.peerPicker().PickPeer(key); ok {
+			if deleter, ok := peer.(PeerDeleter); ok {
+				res := &pb.DeleteResponse{}
+				err := deleter.Delete(&pb.Request{Group: g.name, Key: key}, res)
+				return deleted || res.GetDeleted(), err
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// deleteLocally 从 mainCache 和 hotCache 中移除键，并把删除同步到预写日志和后继节点
+func (g *Group) deleteLocally(key string) bool {
+	g.loader.Forget(g.flightKey(key))
+	g.localLoader.Forget(g.flightKey(key))
+	g.tombstones.add(key, g.now())
+	g.logSet(key, ByteView{}, -1)
+	main := g.mainCache.remove(key)
+	hot := g.hotCache.remove(key)
+	_, pinned := g.pins.remove(key)
+	// 负 TTL 的副本写入会删除后继节点上的副本
+	g.replicate(key, ByteView{}, -time.Millisecond)
+	return main || hot || pinned
+}
+
+// deleteFromPeer 处理远程节点转发来的删除
+func (g *Group) deleteFromPeer(in *pb.Request, out *pb.DeleteResponse) error {
+	if g.readOnly {
+		return ErrReadOnly
+	}
+	out.Deleted = g.deleteLocally(in.GetKey())
+	return nil
+}
+
+func (g *Group) setLocally(key string, value ByteView, ttl time.Duration) SetResult {
+	value = g.decodable(value)
+	g.logSet(key, value, ttl)
+	evicted, stored := g.mainCache.add(key, value, ttl)
+	if ttl < 0 {
+		g.pins.remove(key)
+	} else {
+		g.pins.update(key, value)
+	}
+	return SetResult{Stored: stored, Evicted: evicted}
+}
+
+// ttlMillis 把 TTL 转换为请求中的毫秒数。不足 1ms 的 TTL 向远离 0 的方向取整为 ±1ms，
+// 避免截断为表示永不过期的 0
+func ttlMillis(ttl time.Duration) int64 {
+	ms := int64(ttl / time.Millisecond)
+	switch {
+	case ms == 0 && ttl > 0:
+		return 1
+	case ms == 0 && ttl < 0:
+		return -1
+	}
+	return ms
+}
+
+func (g *Group) setToPeer(peer PeerSetter, key string, value []byte, ttl time.Duration, meta map[string]string) (SetResult, error) {
+	req := &pb.SetRequest{
+		Group: g.name,
+		Key:   key,
+		Value: value,
+		TtlMs: ttlMillis(ttl),
+		Meta:  meta,
+	}
+	res := &pb.SetResponse{}
+	result := SetResult{RoutedToPeer: peerName(peer)}
+	if err := peer.Set(req, res); err != nil {
+		return result, err
+	}
+	result.Stored = res.GetStored()
+	result.Evicted = int(res.GetEvicted())
+	return result, nil
+}
+
+// setFromPeer 处理远程节点转发来的写入，只写入本地缓存。
+// 副本写入不受只读限制，也不会再次复制。
+func (g *Group) setFromPeer(in *pb.SetRequest, out *pb.SetResponse) error {
+	if !in.GetReplica() && g.readOnly {
+		return ErrReadOnly
+	}
+	// 负 TTL 的副本写入表示删除，仍然接受
+	if g.immutable && in.GetTtlMs() > 0 {
+		return ErrTTLNotAllowed
+	}
+	if g.tooLarge(len(in.GetValue())) {
+		return ErrValueTooLarge
+	}
+	if metaBytes(in.GetMeta()) > maxMetaBytes {
+		return ErrMetaTooLarge
+	}
+	ttl := time.Duration(in.GetTtlMs()) * time.Millisecond
+	switch {
+	case ttl < 0:
+		// 删除的副本也带上墓碑
+		g.tombstones.add(in.GetKey(), g.now())
+	case !in.GetReplica():
+		g.tombstones.clear(in.GetKey())
+	case g.buried(in.GetKey()):
+		// 副本可能是删除之前复制的旧值
+		return nil
+	}
+	view := g.arena.view(in.GetValue())
+	view.meta = cloneMeta(in.GetMeta())
+	res := g.setLocally(in.GetKey(), view, ttl)
+	out.Stored = res.Stored
+	out.Evicted = int32(res.Evicted)
+	return nil
+}
+
+// peerName 返回对等点的名字，对等点实现了 fmt.Stringer 时使用其 String 方法
+func peerName(peer interface{}) string {
+	if s, ok := peer.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", peer)
+}
+
+// getLocally 通过 Getter 加载并写入 mainCache，cacheable 为 false 时值没有写入缓存
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, bool, error) {
+	value, cacheable, err := g.fetchLocally(ctx, key)
+	if !cacheable {
+		return value, false, err
+	}
+	if err != nil {
+		if g.negativeTTL > 0 && errors.Is(err, ErrNotFound) {
+			g.mainCache.addWithFlags(key, ByteView{}, g.negativeTTL, flagNotFound)
+		}
+		return ByteView{}, true, err
+	}
+	value = g.decodable(value)
 	g.populateCache(key, value)
-	return value, nil
+	return value, true, nil
+}
+
+// fetchLocally 通过 Getter 加载，不写入缓存。Getter 返回 Uncacheable，或返回时 ctx 已经结束
+// （所有调用者都已放弃或加载超时，值可能不完整）时，cacheable 为 false
+func (g *Group) fetchLocally(ctx context.Context, key string) (view ByteView, cacheable bool, err error) {
+	if g.loadTracker != nil {
+		g.loadTracker.record(key, g.now())
+	}
+	if d := g.timeoutFor(key); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	view, err = g.getWithRetry(ctx, key)
+	err, cacheable = splitUncacheable(err)
+	if err == nil && ctx.Err() != nil {
+		cacheable = false
+	}
+	g.health.record(key, err, g.now())
+	if err != nil {
+		atomic.AddInt64(&g.stats.LocalLoadErrs, 1)
+		return ByteView{}, cacheable, err
+
+	}
+	atomic.AddInt64(&g.stats.LocalLoads, 1)
+	return view, cacheable, nil
 }
 
 func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
@@ -144,8 +925,8 @@ func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
 	}
 	res := &pb.Response{}
 	err := peer.Get(req, res)
-	if err != nil {
+	if err != nil && !uncacheableValue(err) {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	return ByteView{b: res.Value, meta: cloneMeta(res.GetMeta())}, err
 }
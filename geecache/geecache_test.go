@@ -1,10 +1,32 @@
 package geecache
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"geecache/lru"
+	"geecache/singleflight"
+	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
 )
 
 var db = map[string]string{
@@ -65,3 +87,1948 @@ func TestGetGroup(t *testing.T) {
 		t.Fatalf("expect nil, but %s got", group.name)
 	}
 }
+
+func TestMaxEntryAge(t *testing.T) {
+	now := time.Unix(1000, 0)
+	loads := 0
+	gee := NewGroup("max-age", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		}),
+		WithClock(func() time.Time { return now }),
+		WithMaxEntryAge(15*time.Minute))
+
+	gee.Set("Tom", []byte("630"), time.Hour)
+	now = now.Add(10 * time.Minute)
+	view, info, err := gee.GetWithInfo("Tom")
+	if err != nil || view.String() != "630" || loads != 0 {
+		t.Fatalf("expect cached 630 without load, got %v %v loads=%d", view, err, loads)
+	}
+	if info.Age != 10*time.Minute {
+		t.Fatalf("expect age 10m, but %v got", info.Age)
+	}
+
+	// 超过最大存活时间，即使 TTL 未到也要重新加载
+	now = now.Add(6 * time.Minute)
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "Tom" || loads != 1 {
+		t.Fatalf("expect reload after max age, got %v %v loads=%d", view, err, loads)
+	}
+}
+
+func TestSetE(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	})
+
+	gee := NewGroup("set-e", 2<<10, getter, WithMaxValueBytes(8))
+	if res, err := gee.SetE("Tom", []byte("630"), 0); err != nil || !res.Stored || res.RoutedToPeer != "" {
+		t.Fatalf("normal set failed: %+v %v", res, err)
+	}
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("get after set failed")
+	}
+	if res, err := gee.SetE("Jack", []byte("0123456789"), 0); err != ErrValueTooLarge || res.Stored {
+		t.Fatalf("expect ErrValueTooLarge, but %+v %v got", res, err)
+	}
+
+	small := NewGroup("set-e-small", int64(len("k1v1k2v2")), getter)
+	small.SetE("k1", []byte("v1"), 0)
+	small.SetE("k2", []byte("v2"), 0)
+	if res, err := small.SetE("k3", []byte("v3"), 0); err != nil || !res.Stored || res.Evicted != 1 {
+		t.Fatalf("expect 1 eviction, but %+v %v got", res, err)
+	}
+
+	ro := NewGroup("set-e-ro", 2<<10, getter, WithReadOnly())
+	if _, err := ro.SetE("Tom", []byte("630"), 0); err != ErrReadOnly {
+		t.Fatalf("expect ErrReadOnly, but %v got", err)
+	}
+}
+
+func TestSetERoutedToPeer(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	})
+
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("A", "B")
+	nodes := map[string]*testPeer{
+		"A": {name: "A", g: NewGroup("set-routed", 2<<10, getter)},
+		"B": {name: "B", g: NewGroup("set-routed", 2<<10, getter, WithReadOnly())},
+	}
+	nodes["A"].g.RegisterPeers(&testPicker{self: "A", ring: ring, nodes: nodes})
+
+	var ownedByA, ownedByB string
+	for i := 0; ownedByA == "" || ownedByB == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if ring.Get(key) == "A" {
+			ownedByA = key
+		} else {
+			ownedByB = key
+		}
+	}
+
+	if res, err := nodes["A"].g.SetE(ownedByA, []byte("v"), 0); err != nil || !res.Stored || res.RoutedToPeer != "" {
+		t.Fatalf("local set failed: %+v %v", res, err)
+	}
+	// B 是只读的，会拒绝转发过去的写入
+	res, err := nodes["A"].g.SetE(ownedByB, []byte("v"), 0)
+	if err != ErrReadOnly || res.RoutedToPeer != "B" || res.Stored {
+		t.Fatalf("expect write rejected by B, but %+v %v got", res, err)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	loads := make(map[string]int)
+	gee := NewGroup("negative", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads[key]++
+			if key == "empty" {
+				return []byte{}, nil
+			}
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}), WithNegativeTTL(time.Minute))
+
+	for i := 0; i < 2; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, but %v got", err)
+		}
+		if view, err := gee.Get("empty"); err != nil || view.Len() != 0 {
+			t.Fatalf("expect empty value, but %v got", err)
+		}
+	}
+	if loads["unknown"] != 1 || loads["empty"] != 1 {
+		t.Fatalf("expect one load per key, but %v got", loads)
+	}
+}
+
+func TestGetOrDefault(t *testing.T) {
+	gee := NewGroup("default", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+
+	if view := gee.GetOrDefault("Tom", []byte("0")); view.String() != "630" {
+		t.Fatalf("expect 630, but %s got", view)
+	}
+	if view := gee.GetOrDefault("unknown", []byte("0")); view.String() != "0" {
+		t.Fatalf("expect default 0, but %s got", view)
+	}
+	if _, ok := gee.mainCache.get("unknown"); ok {
+		t.Fatalf("default value should not be cached")
+	}
+}
+
+func TestStatsPendingExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	gee := NewGroup("pending-expiry", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithClock(func() time.Time { return now }))
+
+	gee.Set("Tom", []byte("630"), time.Minute)
+	gee.Set("Jack", []byte("589"), 0)
+	if s := gee.Stats(); s.PendingExpiry != 1 || !s.NextExpiry.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected pending expiry %d %v", s.PendingExpiry, s.NextExpiry)
+	}
+}
+
+func TestLoadRetry(t *testing.T) {
+	calls := 0
+	errTransient := errors.New("transient")
+	gee := NewGroup("load-retry", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		calls++
+		switch {
+		case key == "missing":
+			return nil, ErrNotFound
+		case calls <= 2:
+			return nil, errTransient
+		}
+		return []byte("v-" + key), nil
+	}), WithLoadRetry(3, time.Millisecond, nil))
+
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "v-Tom" {
+		t.Fatalf("expect value after retries, got %v %v", view, err)
+	}
+	if s := gee.Stats(); s.LoadRetries != 2 || s.LocalLoadErrs != 0 {
+		t.Fatalf("expect 2 retries without load errors, got %+v", s)
+	}
+
+	// 不可重试的错误立即失败
+	calls = 0
+	if _, err := gee.Get("missing"); !errors.Is(err, ErrNotFound) || calls != 1 {
+		t.Fatalf("expect immediate ErrNotFound, got %v after %d calls", err, calls)
+	}
+	if s := gee.Stats(); s.LoadRetries != 2 || s.LocalLoadErrs != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestPrefetcher(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v" + key), nil
+	})
+	next := func(key string) []string {
+		i, _ := strconv.Atoi(key)
+		return []string{strconv.Itoa(i + 1)}
+	}
+	run := func(g *Group) Stats {
+		for i := 0; i < 20; i++ {
+			key := strconv.Itoa(i)
+			if view, err := g.Get(key); err != nil || view.String() != "v"+key {
+				t.Fatalf("Get(%s) = %v, %v", key, view, err)
+			}
+			// 等待后台预取完成，使结果可重复
+			for len(g.prefetchSem) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		return g.Stats()
+	}
+
+	plain := run(NewGroup("no-prefetch", 2<<10, getter))
+	gee := NewGroup("prefetch", 2<<10, getter)
+	gee.RegisterPrefetcher(next)
+	s := run(gee)
+	if plain.Loads != 20 || s.Loads != 10 {
+		t.Fatalf("expect loads to be halved, got %d without and %d with prefetcher", plain.Loads, s.Loads)
+	}
+	if s.Prefetches != 10 || s.PrefetchHits != 10 {
+		t.Fatalf("unexpected prefetch stats %+v", s)
+	}
+}
+
+// getCountingPolicy 记录每个键的 OnGet 次数
+type getCountingPolicy struct {
+	lru.Policy
+	mu   sync.Mutex
+	gets map[string]int
+}
+
+func (p *getCountingPolicy) OnGet(key string) {
+	p.mu.Lock()
+	p.gets[key]++
+	p.mu.Unlock()
+	p.Policy.OnGet(key)
+}
+
+func TestPrefetcherDoesNotTouch(t *testing.T) {
+	policy := &getCountingPolicy{Policy: lru.NewLRUPolicy(), gets: make(map[string]int)}
+	gee := NewGroup("prefetch-peek", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v" + key), nil
+	}), WithEvictionPolicy(func() lru.Policy { return policy }))
+	defer gee.Close()
+	gee.Set("a", []byte("va"), 0)
+	gee.Set("b", []byte("vb"), 0)
+	gee.RegisterPrefetcher(func(key string) []string { return []string{"a"} })
+
+	if _, err := gee.Get("x"); err != nil {
+		t.Fatal(err)
+	}
+	for len(gee.prefetchSem) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// 检查 a 是否已缓存不应使它成为最近使用的条目
+	if keys := gee.EvictionPreview(1); len(keys) != 1 || keys[0] != "a" {
+		t.Fatalf("expect a to stay the oldest entry, got %v", keys)
+	}
+	policy.mu.Lock()
+	defer policy.mu.Unlock()
+	if n := policy.gets["a"]; n != 0 {
+		t.Fatalf("expect no OnGet for a, got %d", n)
+	}
+	if s := gee.Stats(); s.Prefetches != 0 {
+		t.Fatalf("expect the cached key not to be prefetched, got %+v", s)
+	}
+}
+
+// blockingGetter 的 GetCtx 阻塞到 ctx 被取消
+type blockingGetter struct {
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func (b *blockingGetter) Get(key string) ([]byte, error) {
+	return nil, errors.New("GetCtx should be used")
+}
+
+func (b *blockingGetter) GetCtx(ctx context.Context, key string) ([]byte, error) {
+	close(b.started)
+	<-ctx.Done()
+	close(b.cancelled)
+	return nil, ctx.Err()
+}
+
+func TestGetCtxCancelsAbandonedLoad(t *testing.T) {
+	getter := &blockingGetter{started: make(chan struct{}), cancelled: make(chan struct{})}
+	gee := NewGroup("get-ctx", 2<<10, getter)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err := gee.GetCtx(ctx1, "Tom")
+		errs <- err
+	}()
+	<-getter.started
+	go func() {
+		_, err := gee.GetCtx(ctx2, "Tom")
+		errs <- err
+	}()
+	// 等待第二个调用者进入 singleflight
+	for gee.Stats().Loads < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// 只有一个调用者放弃时加载继续
+	cancel1()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expect context.Canceled, but %v got", err)
+	}
+	select {
+	case <-getter.cancelled:
+		t.Fatalf("load cancelled while another caller is waiting")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel2()
+	<-errs
+	select {
+	case <-getter.cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("load not cancelled after all callers gave up")
+	}
+}
+
+// partialGetter 在 ctx 被取消后返回不完整的值，done 在返回后关闭
+type partialGetter struct {
+	started chan struct{}
+	done    chan struct{}
+}
+
+func (g *partialGetter) Get(key string) ([]byte, error) {
+	return []byte("full"), nil
+}
+
+func (g *partialGetter) GetCtx(ctx context.Context, key string) ([]byte, error) {
+	defer close(g.done)
+	close(g.started)
+	<-ctx.Done()
+	return []byte("part"), nil
+}
+
+func TestUncacheable(t *testing.T) {
+	var loads int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		if key == "missing" {
+			return nil, Uncacheable(ErrNotFound)
+		}
+		return []byte("v-" + key), Uncacheable(nil)
+	})
+	gee := NewGroup("uncacheable", 2<<10, getter, WithNegativeTTL(time.Minute),
+		WithLoadRetry(3, time.Millisecond, nil))
+	defer gee.Close()
+
+	for i := 0; i < 2; i++ {
+		if v, err := gee.Get("Tom"); err != nil || v.String() != "v-Tom" {
+			t.Fatalf("expect the value handed to the caller, got %q %v", v.String(), err)
+		}
+		if _, err := gee.Get("missing"); err != ErrNotFound {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 4 || gee.mainCache.lru != nil {
+		t.Fatalf("expect every Get to load without retries or caching, got %d loads", loads)
+	}
+	// 值没有写入缓存，CreatedAt 保持零值
+	if _, info, err := gee.GetWithInfo("Tom"); err != nil || !info.Uncacheable || !info.CreatedAt.IsZero() || info.Age != 0 {
+		t.Fatalf("expect an uncacheable load without a creation time, got %+v %v", info, err)
+	}
+
+	// 所有调用者都放弃之后 Getter 返回的值不写入缓存
+	pg := &partialGetter{started: make(chan struct{}), done: make(chan struct{})}
+	gee2 := NewGroup("uncacheable-cancelled", 2<<10, pg)
+	defer gee2.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-pg.started
+		cancel()
+	}()
+	if _, err := gee2.GetCtx(ctx, "Tom"); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+	<-pg.done
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := gee2.mainCache.get("Tom"); ok {
+		t.Fatalf("partial value from a cancelled load was cached")
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	gee := NewGroup("eviction-policy", 9, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v"), nil
+	}), WithEvictionPolicy(lru.NewFIFOPolicy))
+
+	// 每个条目占 3 字节，容量为 3 个条目
+	for _, k := range []string{"k1", "k2", "k3"} {
+		gee.Get(k)
+	}
+	gee.Get("k1")
+	gee.Set("k4", []byte("v"), 0)
+	if ok, _ := gee.Has("k1"); ok {
+		t.Fatalf("expect FIFO to evict k1 even though it was read recently")
+	}
+	if ok, _ := gee.Has("k2"); !ok {
+		t.Fatalf("expect k2 to survive")
+	}
+}
+
+// recordLogger 记录输出的日志
+type recordLogger struct {
+	lines []string
+}
+
+func (r *recordLogger) Printf(format string, v ...interface{}) {
+	r.lines = append(r.lines, fmt.Sprintf(format, v...))
+}
+
+func TestRateLimitedLogger(t *testing.T) {
+	rec := &recordLogger{}
+	SetLogger(rec)
+	defer SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+
+	now := time.Unix(0, 0)
+	l := newRateLimitedLogger(time.Second)
+	l.now = func() time.Time { return now }
+	var timers []func()
+	l.afterFunc = func(d time.Duration, f func()) {
+		if d != time.Second {
+			t.Fatalf("expect flush at the end of the interval, got %v", d)
+		}
+		timers = append(timers, f)
+	}
+
+	for i := 0; i < 5; i++ {
+		l.Printf("peer1", "peer1 down")
+	}
+	l.Printf("peer2", "peer2 down")
+	if len(rec.lines) != 2 {
+		t.Fatalf("expect first message of each source, got %q", rec.lines)
+	}
+
+	now = now.Add(time.Second)
+	l.Printf("peer1", "peer1 down")
+	if len(rec.lines) != 3 || rec.lines[2] != "peer1 down (4 similar messages suppressed)" {
+		t.Fatalf("expect summary after interval, got %q", rec.lines)
+	}
+	// 计数已随新日志输出，定时器不再重复输出
+	if len(timers) != 1 {
+		t.Fatalf("expect one pending flush, got %d", len(timers))
+	}
+	timers[0]()
+	if len(rec.lines) != 3 {
+		t.Fatalf("expect no flush after the summary, got %q", rec.lines)
+	}
+
+	// 间隔内之后没有新日志时，定时器输出最近一条被抑制的日志
+	now = now.Add(time.Second)
+	l.Printf("peer1", "peer1 down")
+	l.Printf("peer1", "peer1 down %d", 1)
+	l.Printf("peer1", "peer1 down %d", 2)
+	if len(timers) != 2 {
+		t.Fatalf("expect a flush to be scheduled, got %d", len(timers))
+	}
+	now = now.Add(time.Second)
+	timers[1]()
+	if len(rec.lines) != 5 || rec.lines[4] != "peer1 down 2 (1 similar messages suppressed)" {
+		t.Fatalf("expect suppressed messages to be flushed, got %q", rec.lines)
+	}
+	l.Printf("peer1", "peer1 down")
+	if len(rec.lines) != 5 {
+		t.Fatalf("expect the flush to start a new interval, got %q", rec.lines)
+	}
+	now = now.Add(time.Second)
+	timers[2]()
+
+	l.setInterval(0)
+	l.Printf("peer1", "peer1 down")
+	l.Printf("peer1", "peer1 down")
+	if len(rec.lines) != 8 {
+		t.Fatalf("expect no limiting with zero interval, got %q", rec.lines)
+	}
+}
+
+// ownedGetter 每次返回新建的切片并记住它，granted 决定是否转移所有权
+type ownedGetter struct {
+	granted bool
+	size    int
+	last    []byte
+}
+
+func (o *ownedGetter) Get(key string) ([]byte, error) {
+	o.last = make([]byte, o.size)
+	copy(o.last, key)
+	return o.last, nil
+}
+
+func (o *ownedGetter) GrantsOwnership() bool { return o.granted }
+
+func TestOwnershipGranting(t *testing.T) {
+	getter := &ownedGetter{granted: true, size: 4}
+	gee := NewGroup("owned", 2<<10, getter)
+	v, err := gee.Get("abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 转移了所有权的切片直接被缓存
+	getter.last[0] = 'x'
+	if v, _ := gee.Get("abcd"); v.String() != "xbcd" {
+		t.Fatalf("expect owned slice to be stored without copy, got %q", v.String())
+	}
+
+	getter.granted = false
+	v, _ = gee.Get("efgh")
+	getter.last[0] = 'x'
+	if v, _ = gee.Get("efgh"); v.String() != "efgh" {
+		t.Fatalf("expect copy when ownership is not granted, got %q", v.String())
+	}
+
+	SetOwnershipDebug(true)
+	defer SetOwnershipDebug(false)
+	getter.granted = true
+	v, _ = gee.Get("ijkl")
+	if v.String() != "ijkl" {
+		t.Fatalf("expect stored copy in debug mode, got %q", v.String())
+	}
+	for _, b := range getter.last {
+		if b != poisonByte {
+			t.Fatalf("expect original slice to be poisoned, got %q", getter.last)
+		}
+	}
+}
+
+func benchmarkFetchLocally(b *testing.B, granted bool) {
+	gee := NewGroup("owned-bench", 0, &ownedGetter{granted: granted, size: 256 << 10})
+	defer gee.Close()
+	ctx := context.Background()
+	b.SetBytes(256 << 10)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := gee.fetchLocally(ctx, "key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFetchLocallyCopy(b *testing.B)  { benchmarkFetchLocally(b, false) }
+func BenchmarkFetchLocallyOwned(b *testing.B) { benchmarkFetchLocally(b, true) }
+
+func TestGetDecoded(t *testing.T) {
+	decodes := 0
+	gee := NewGroup("decoded", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithDecoder(func(b []byte) (interface{}, error) {
+		decodes++
+		return strconv.Atoi(string(b))
+	}))
+
+	for i := 0; i < 3; i++ {
+		v, err := gee.GetDecoded("Tom")
+		if err != nil || v.(int) != 630 {
+			t.Fatalf("GetDecoded(Tom) = %v, %v", v, err)
+		}
+	}
+	if decodes != 1 {
+		t.Fatalf("expect one decode for repeated gets, got %d", decodes)
+	}
+
+	// 条目被替换后重新解码
+	gee.Set("Tom", []byte("700"), 0)
+	if v, _ := gee.GetDecoded("Tom"); v.(int) != 700 || decodes != 2 {
+		t.Fatalf("expect decode after Set, got %v with %d decodes", v, decodes)
+	}
+
+	gee.Set("bad", []byte("x"), 0)
+	if _, err := gee.GetDecoded("bad"); err == nil {
+		t.Fatal("expect decode error")
+	}
+	if _, err := NewGroup("plain", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, nil
+	})).GetDecoded("Tom"); err != ErrNoDecoder {
+		t.Fatalf("expect ErrNoDecoder, got %v", err)
+	}
+}
+
+func TestSetWithMeta(t *testing.T) {
+	gee := NewGroup("meta", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	meta := map[string]string{"content-type": "application/json"}
+	if _, err := gee.SetWithMeta("Tom", []byte("{}"), 0, meta); err != nil {
+		t.Fatal(err)
+	}
+	meta["content-type"] = "changed"
+	_, info, err := gee.GetWithInfo("Tom")
+	if err != nil || info.Meta["content-type"] != "application/json" {
+		t.Fatalf("expect metadata round trip, got %v, %v", info.Meta, err)
+	}
+	// 元数据计入缓存的字节数
+	small := NewGroup("meta-small", 20, gee.getter)
+	if res, _ := small.SetE("Tom", []byte("{}"), 0); !res.Stored {
+		t.Fatal("expect value without metadata to fit")
+	}
+	if res, _ := small.SetWithMeta("Tom", []byte("{}"), 0, info.Meta); res.Stored {
+		t.Fatal("expect metadata to count toward cacheBytes")
+	}
+	// 加载的值没有元数据
+	if _, info, _ := gee.GetWithInfo("Jack"); info.Meta != nil {
+		t.Fatalf("expect no metadata for loaded value, got %v", info.Meta)
+	}
+
+	big := map[string]string{"k": strings.Repeat("x", maxMetaBytes)}
+	if _, err := gee.SetWithMeta("Sam", []byte("v"), 0, big); err != ErrMetaTooLarge {
+		t.Fatalf("expect ErrMetaTooLarge, got %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type score struct {
+		Name  string
+		Score int
+	}
+	gee := NewGroup("json", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, ErrNotFound
+	}))
+
+	if err := gee.SetJSON("tom", score{"Tom", 630}, 0); err != nil {
+		t.Fatal(err)
+	}
+	var got score
+	if err := gee.GetJSON("tom", &got); err != nil || got != (score{"Tom", 630}) {
+		t.Fatalf("GetJSON = %+v, %v", got, err)
+	}
+
+	var typeErr *json.UnsupportedTypeError
+	if err := gee.SetJSON("ch", make(chan int), 0); !errors.As(err, &typeErr) {
+		t.Fatalf("expect json.Marshal error for marshal failure, got %v", err)
+	}
+	// 加载的 "630" 是合法的数字，但不能解码为结构体
+	var decodeErr *DecodeError
+	if err := gee.GetJSON("Tom", &got); !errors.As(err, &decodeErr) || decodeErr.Codec != "json" {
+		t.Fatalf("expect *DecodeError for unmarshal failure, got %v", err)
+	}
+	if err := gee.GetJSON("unknown", &got); !errors.Is(err, ErrNotFound) || errors.As(err, &decodeErr) {
+		t.Fatalf("expect Get error to be returned as is, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gee.GetJSONCtx(ctx, "Sam", &got); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect GetJSONCtx to honour ctx, got %v", err)
+	}
+	if err := gee.GetJSONCtx(context.Background(), "tom", &got); err != nil || got != (score{"Tom", 630}) {
+		t.Fatalf("GetJSONCtx = %+v, %v", got, err)
+	}
+}
+
+func TestSharedLoaderNamespacing(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	users := NewGroup("users", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("user-" + key), nil
+	}))
+	orders := NewGroup("orders", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("order-" + key), nil
+	}))
+	orders.loader = users.loader
+
+	done := make(chan ByteView)
+	go func() {
+		v, _ := users.Get("42")
+		done <- v
+	}()
+	<-started
+	// 同名的键不能等待或拿到另一个 group 的加载结果
+	if v, err := orders.Get("42"); err != nil || v.String() != "order-42" {
+		t.Fatalf("orders.Get(42) = %v, %v", v, err)
+	}
+	close(release)
+	if v := <-done; v.String() != "user-42" {
+		t.Fatalf("users.Get(42) = %v", v)
+	}
+}
+
+func TestByteViewDecode(t *testing.T) {
+	type score struct {
+		Name  string
+		Score int
+	}
+	want := score{"Tom", 630}
+
+	jsonBytes, _ := json.Marshal(want)
+	var got score
+	if err := (ByteView{b: jsonBytes}).DecodeJSON(&got); err != nil || got != want {
+		t.Fatalf("DecodeJSON = %+v, %v", got, err)
+	}
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(want)
+	got = score{}
+	if err := (ByteView{b: buf.Bytes()}).DecodeGob(&got); err != nil || got != want {
+		t.Fatalf("DecodeGob = %+v, %v", got, err)
+	}
+
+	pbBytes, _ := proto.Marshal(&pb.Request{Group: "scores", Key: "Tom"})
+	req := &pb.Request{}
+	if err := (ByteView{b: pbBytes}).DecodeProto(req); err != nil || req.GetKey() != "Tom" {
+		t.Fatalf("DecodeProto = %v, %v", req, err)
+	}
+
+	bad := ByteView{b: []byte{0xff, 0xff}}
+	for codec, err := range map[string]error{
+		"json":  bad.DecodeJSON(&got),
+		"gob":   bad.DecodeGob(&got),
+		"proto": bad.DecodeProto(req),
+	} {
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Codec != codec || decodeErr.Err == nil {
+			t.Fatalf("expect *DecodeError for %s, got %v", codec, err)
+		}
+	}
+}
+
+// bigJSON 返回约 1MB 的 JSON 值
+func bigJSON() ByteView {
+	b, _ := json.Marshal(strings.Repeat("x", 1<<20))
+	return ByteView{b: b}
+}
+
+func BenchmarkDecodeJSON(b *testing.B) {
+	v := bigJSON()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var s string
+		if err := v.DecodeJSON(&s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeJSONByteSlice(b *testing.B) {
+	v := bigJSON()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var s string
+		if err := json.Unmarshal(v.ByteSlice(), &s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestResetStats(t *testing.T) {
+	gee := NewGroup("reset-stats", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+
+	if s := gee.ResetStats(); s.Gets != 2 || s.CacheHits != 1 || s.LocalLoads != 1 {
+		t.Fatalf("expect counters before reset, got %+v", s)
+	}
+	if s := gee.Stats(); s.Gets != 0 || s.CacheHits != 0 || s.LocalLoads != 0 {
+		t.Fatalf("expect zeroed counters, got %+v", s)
+	}
+	// 缓存内容不受影响
+	gee.Get("Tom")
+	if s := gee.Stats(); s.CacheHits != 1 || s.Loads != 0 {
+		t.Fatalf("expect cache to survive reset, got %+v", s)
+	}
+}
+
+func TestSharedBudget(t *testing.T) {
+	arbiter := NewBudgetArbiter(1000, 100, 0)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	hot := NewGroup("budget-hot", 0, getter, WithSharedBudget(arbiter))
+	cold := NewGroup("budget-cold", 0, getter, WithSharedBudget(arbiter))
+	if a := arbiter.Allocations(); a["budget-hot"] != 500 || a["budget-cold"] != 500 {
+		t.Fatalf("expect an equal split, got %v", a)
+	}
+
+	value := []byte(strings.Repeat("x", 40))
+	for i := 0; i < 20; i++ {
+		cold.Set("cold"+strconv.Itoa(i), value, 0)
+	}
+	for round := 0; round < 10; round++ {
+		// 热点 Group 的数据总是多于分配的预算，并且不断被命中
+		for i := 0; i < 30; i++ {
+			key := "hot" + strconv.Itoa(i)
+			hot.Set(key, value, 0)
+			hot.Get(key)
+		}
+		arbiter.rebalance()
+	}
+
+	a := arbiter.Allocations()
+	if a["budget-cold"] != 100 || a["budget-hot"] != 900 {
+		t.Fatalf("expect cold group to shrink to its floor, got %v", a)
+	}
+	if usage := arbiter.Usage(); usage > 1000 {
+		t.Fatalf("expect usage within the shared cap, got %d", usage)
+	}
+
+	cold.Close()
+	if a := arbiter.Allocations(); a["budget-hot"] != 1000 {
+		t.Fatalf("expect budget to return after Close, got %v", a)
+	}
+}
+
+// ctxGetterFunc 使用函数实现 CtxGetter
+type ctxGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f ctxGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+func (f ctxGetterFunc) GetCtx(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+func TestCircularLoad(t *testing.T) {
+	var self, a, b *Group
+	self = NewGroup("circular-self", 2<<10, ctxGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		v, err := self.GetCtx(ctx, key)
+		return v.ByteSlice(), err
+	}))
+	// a 的键依赖 b 的同名键，b 又依赖 a
+	a = NewGroup("circular-a", 2<<10, ctxGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		v, err := b.GetCtx(ctx, key)
+		return v.ByteSlice(), err
+	}))
+	b = NewGroup("circular-b", 2<<10, ctxGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		if key == "leaf" {
+			return []byte("ok"), nil
+		}
+		v, err := a.GetCtx(ctx, key)
+		return v.ByteSlice(), err
+	}))
+
+	for _, g := range []*Group{self, a} {
+		done := make(chan error)
+		go func(g *Group) {
+			_, err := g.Get("Tom")
+			done <- err
+		}(g)
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrCircularLoad) {
+				t.Fatalf("expect ErrCircularLoad from %s, got %v", g.name, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("circular load in %s deadlocked", g.name)
+		}
+	}
+	// 没有环的嵌套加载不受影响
+	if v, err := a.Get("leaf"); err != nil || v.String() != "ok" {
+		t.Fatalf("expect nested load to succeed, got %v %v", v, err)
+	}
+}
+
+func TestKeyTimeout(t *testing.T) {
+	gee := NewGroup("key-timeout", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+		return []byte(key), nil
+	}), WithLoadTimeout(10*time.Millisecond), WithKeyTimeout(func(key string) bool {
+		return strings.HasPrefix(key, "slow:")
+	}, time.Second))
+
+	if v, err := gee.Get("slow:report"); err != nil || v.String() != "slow:report" {
+		t.Fatalf("expect slow key to use the longer timeout, got %v %v", v, err)
+	}
+	start := time.Now()
+	if _, err := gee.Get("fast:user"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect default timeout for other keys, got %v", err)
+	}
+	if d := time.Since(start); d > 40*time.Millisecond {
+		t.Fatalf("expect the load to fail fast, took %v", d)
+	}
+}
+
+func TestMaxAbandonedLoads(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	gee := NewGroup("abandoned-loads", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte(key), nil
+	}), WithLoadTimeout(5*time.Millisecond), WithMaxAbandonedLoads(2))
+	defer gee.Close()
+
+	for _, key := range []string{"a", "b"} {
+		if _, err := gee.Get(key); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expect a timeout for %s, got %v", key, err)
+		}
+	}
+	if _, err := gee.Get("c"); !errors.Is(err, ErrLoadBacklog) || atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("expect ErrLoadBacklog without calling the getter, got %v after %d calls", err, calls)
+	}
+
+	// 挂起的调用返回后恢复
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&gee.abandonedLoads) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("abandoned loads not released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v, err := gee.Get("c"); err != nil || v.String() != "c" {
+		t.Fatalf("expect the load to succeed, got %v %v", v, err)
+	}
+
+	// 没有设置加载超时时，调用者的 deadline 不会让 Getter 被放弃
+	plain := NewGroup("abandoned-loads-plain", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		time.Sleep(20 * time.Millisecond)
+		return []byte(key), nil
+	}))
+	defer plain.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := plain.GetCtx(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect the caller to give up, got %v", err)
+	}
+	if n := atomic.LoadInt32(&plain.abandonedLoads); n != 0 {
+		t.Fatalf("expect no abandoned getter calls, got %d", n)
+	}
+}
+
+func TestTopLoadedKeys(t *testing.T) {
+	now := time.Unix(0, 0)
+	// 热点键每次都加载失败，不会被缓存，模拟无法缓存的响应
+	gee := NewGroup("top-loaded", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if strings.HasPrefix(key, "hot") {
+			return nil, errors.New("uncacheable")
+		}
+		return []byte(key), nil
+	}), WithClock(func() time.Time { return now }), WithLoadTracking(32, time.Minute))
+
+	heavy := map[string]int{"hot-a": 300, "hot-b": 200, "hot-c": 100}
+	for i := 0; i < 300; i++ {
+		for key, n := range heavy {
+			if i < n {
+				gee.Get(key)
+			}
+		}
+		for j := 0; j < 5; j++ {
+			gee.Get(fmt.Sprintf("cold-%d-%d", i, j))
+		}
+	}
+
+	top := gee.TopLoadedKeys(3)
+	if len(top) != 3 {
+		t.Fatalf("expect 3 keys, got %+v", top)
+	}
+	for i, key := range []string{"hot-a", "hot-b", "hot-c"} {
+		if top[i].Key != key || top[i].Count < int64(heavy[key]) {
+			t.Fatalf("expect %s at %d with at least %d loads, got %+v", key, i, heavy[key], top)
+		}
+	}
+
+	// 超过两个窗口没有加载后，旧的统计被丢弃
+	now = now.Add(3 * time.Minute)
+	if top := gee.TopLoadedKeys(3); len(top) != 0 {
+		t.Fatalf("expect stale counts to expire, got %+v", top)
+	}
+	if top := NewGroup("top-loaded-off", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})).TopLoadedKeys(3); top != nil {
+		t.Fatalf("expect nil without WithLoadTracking, got %+v", top)
+	}
+}
+
+func TestEvictionPreview(t *testing.T) {
+	gee := NewGroup("eviction-preview", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	for _, k := range []string{"a", "b", "c"} {
+		gee.Get(k)
+	}
+	gee.Get("a")
+	if keys := gee.EvictionPreview(2); !reflect.DeepEqual(keys, []string{"b", "c"}) {
+		t.Fatalf("unexpected eviction preview %v", keys)
+	}
+}
+
+// recordingFlight 记录每次调用的键，并在 shared 中有结果时假装已有进行中的加载
+type recordingFlight struct {
+	singleflight.Group
+	keys   []string
+	shared map[string]interface{}
+}
+
+func (f *recordingFlight) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	f.keys = append(f.keys, key)
+	if v, ok := f.shared[key]; ok {
+		return v, nil
+	}
+	return f.Group.DoContext(ctx, key, fn)
+}
+
+func TestWithFlight(t *testing.T) {
+	var calls int
+	flight := &recordingFlight{shared: make(map[string]interface{})}
+	gee := NewGroup("with-flight", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		calls++
+		return []byte(key), nil
+	}), WithFlight(flight))
+
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" || calls != 1 {
+		t.Fatalf("expect a load through the flight, got %v %v after %d calls", v, err, calls)
+	}
+	// 同一个键已有进行中的加载时，调用者直接得到它的结果
+	flight.shared[gee.flightKey("Jack")] = loaded{view: ByteView{b: []byte("shared")}, source: SourceLocalLoad}
+	if v, err := gee.Get("Jack"); err != nil || v.String() != "shared" || calls != 1 {
+		t.Fatalf("expect the shared result without calling the getter, got %v %v after %d calls", v, err, calls)
+	}
+	if expect := []string{gee.flightKey("Tom"), gee.flightKey("Jack")}; !reflect.DeepEqual(flight.keys, expect) {
+		t.Fatalf("expect flight keys %q, got %q", expect, flight.keys)
+	}
+}
+
+type requestIDKey struct{}
+
+func TestGetterContextPropagation(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	seen := make(chan interface{}, 1)
+	gee := NewGroup("ctx-propagation", 2<<10, ctxGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		seen <- ctx.Value(requestIDKey{})
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return []byte(key), nil
+	}))
+
+	leaderCtx, cancelLeader := context.WithCancel(context.WithValue(context.Background(), requestIDKey{}, "req-1"))
+	defer cancelLeader()
+	leader := make(chan error, 1)
+	go func() {
+		_, err := gee.GetCtx(leaderCtx, "Tom")
+		leader <- err
+	}()
+	<-started
+
+	followerCtx, cancelFollower := context.WithCancel(context.WithValue(context.Background(), requestIDKey{}, "req-2"))
+	follower := make(chan error, 1)
+	go func() {
+		_, err := gee.GetCtx(followerCtx, "Tom")
+		follower <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancelFollower()
+	if err := <-follower; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect the follower to give up, got %v", err)
+	}
+	close(release)
+	if err := <-leader; err != nil {
+		t.Fatalf("expect the shared load to survive the follower's cancellation, got %v", err)
+	}
+	if id := <-seen; id != "req-1" {
+		t.Fatalf("expect the getter to see the initiator's request id, got %v", id)
+	}
+}
+
+func TestGetStampede(t *testing.T) {
+	var loads int32
+	gee := NewGroup("stampede", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(100 * time.Millisecond)
+		return []byte("value of " + key), nil
+	}))
+
+	const callers = 1000
+	start := make(chan struct{})
+	views := make([]ByteView, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			views[i], errs[i] = gee.Get("cold")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("expect the getter to run exactly once, ran %d times", n)
+	}
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil || views[i].String() != "value of cold" {
+			t.Fatalf("caller %d got %q %v, expect %q", i, views[i].String(), errs[i], "value of cold")
+		}
+	}
+}
+
+func TestByteViewRange(t *testing.T) {
+	v := ByteView{b: []byte("hello world")}
+	cases := []struct {
+		start, length int
+		expect        string
+	}{
+		{0, 5, "hello"},
+		{6, 5, "world"},
+		{6, 100, "world"}, // 超出末尾的部分被截断
+		{10, 1, "d"},
+	}
+	for _, c := range cases {
+		r, err := v.Range(c.start, c.length)
+		if err != nil || r.String() != c.expect {
+			t.Fatalf("Range(%d, %d) = %q %v, expect %q", c.start, c.length, r.String(), err, c.expect)
+		}
+	}
+	for _, c := range [][2]int{{-1, 1}, {11, 1}, {0, 0}, {3, -2}} {
+		if _, err := v.Range(c[0], c[1]); !errors.Is(err, ErrRangeNotSatisfiable) {
+			t.Fatalf("Range(%d, %d): expect ErrRangeNotSatisfiable, got %v", c[0], c[1], err)
+		}
+	}
+	if _, err := (ByteView{}).Range(0, 1); !errors.Is(err, ErrRangeNotSatisfiable) {
+		t.Fatalf("expect an empty view to satisfy no range, got %v", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	value := func(key string) []byte {
+		if key == "random" {
+			b := make([]byte, 1<<10)
+			rand.New(rand.NewSource(1)).Read(b)
+			return b
+		}
+		return bytes.Repeat([]byte("compressible "+key+" "), 64)
+	}
+	gee := NewGroup("compression", 4<<10, GetterFunc(func(key string) ([]byte, error) {
+		return value(key), nil
+	}), WithCompression(nil, 64))
+
+	// 每个值约 1KB，不压缩时 4KB 的缓存只能放下 4 个
+	var keys []string
+	for i := 0; i < 10; i++ {
+		keys = append(keys, "key"+strconv.Itoa(i))
+	}
+	for _, key := range append(keys, "random", "tiny") {
+		if v, err := gee.Get(key); err != nil || !bytes.Equal(v.ByteSlice(), value(key)) {
+			t.Fatalf("expect the original value for %s, got %v", key, err)
+		}
+	}
+	for _, key := range keys {
+		if v, ok := gee.mainCache.get(key); !ok || !bytes.Equal(v.ByteSlice(), value(key)) {
+			t.Fatalf("expect %s to stay cached and decompress to the original value", key)
+		}
+	}
+
+	st := gee.Stats()
+	if st.CompressedIn == 0 || st.CompressionRatio() < 5 {
+		t.Fatalf("expect a high compression ratio, got %+v", st)
+	}
+	if st.CompressSkipped != 1 {
+		t.Fatalf("expect the incompressible value to be stored as is, got %d skipped", st.CompressSkipped)
+	}
+}
+
+func benchmarkGetCompression(b *testing.B, opts ...GroupOption) {
+	value := bytes.Repeat([]byte("a fairly compressible value "), 1<<10)
+	gee := NewGroup("compression-bench", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return value, nil
+	}), opts...)
+	defer gee.Close()
+	gee.Get("key")
+	b.SetBytes(int64(len(value)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := gee.Get("key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetUncompressed(b *testing.B) { benchmarkGetCompression(b) }
+func BenchmarkGetCompressed(b *testing.B)   { benchmarkGetCompression(b, WithCompression(nil, 64)) }
+
+func TestGetterNilResult(t *testing.T) {
+	var calls int
+	gee := NewGroup("nil-result", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		calls++
+		return nil, nil
+	}), WithNegativeTTL(time.Minute))
+
+	for i := 0; i < 3; i++ {
+		v, err := gee.Get("empty")
+		if err != nil || v.Len() != 0 {
+			t.Fatalf("expect an empty value, got %q %v", v.String(), err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expect the empty value to be cached after one load, got %d loads", calls)
+	}
+	if st := gee.Stats(); st.CacheHits != 2 {
+		t.Fatalf("expect later Gets to hit the cache, got %+v", st)
+	}
+	// 空值与负缓存的 ErrNotFound 不同
+	if ok, err := gee.Has("empty"); !ok || err != nil {
+		t.Fatalf("expect the empty value to exist, got %v %v", ok, err)
+	}
+}
+
+func TestMemoryPressure(t *testing.T) {
+	gee := NewGroup("memory-pressure", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return bytes.Repeat([]byte("x"), 60), nil
+	}), WithMemoryPressure(MemoryPressure{
+		HighHeapBytes: 100 << 20,
+		LowHeapBytes:  50 << 20,
+		MinBytes:      200,
+		Interval:      time.Hour,
+	}))
+	defer gee.Close()
+	var heap uint64
+	for i := 0; i < 16; i++ {
+		gee.Get("key" + strconv.Itoa(i))
+	}
+
+	heap = 200 << 20
+	for _, expect := range []int64{512, 256, 200, 200} {
+		gee.pressure.check(heap)
+		if limit := gee.mainCache.limit(); limit != expect {
+			t.Fatalf("expect limit %d under pressure, got %d", expect, limit)
+		}
+		if used := gee.mainCache.bytes(); used > expect {
+			t.Fatalf("expect usage to fit the new limit %d, got %d", expect, used)
+		}
+	}
+
+	// 介于两个阈值之间时保持不变
+	heap = 80 << 20
+	gee.pressure.check(heap)
+	if limit := gee.mainCache.limit(); limit != 200 {
+		t.Fatalf("expect the limit to stay between thresholds, got %d", limit)
+	}
+	heap = 10 << 20
+	gee.pressure.check(heap)
+	if limit := gee.mainCache.limit(); limit != 1<<10 {
+		t.Fatalf("expect the limit restored once pressure eases, got %d", limit)
+	}
+	if st := gee.Stats(); st.PressureShrinks != 3 || st.PressureRestores != 1 {
+		t.Fatalf("expect 3 shrinks and 1 restore, got %+v", st)
+	}
+}
+
+func TestMemoryPressureShared(t *testing.T) {
+	var reads int32
+	memoryPressure.mu.Lock()
+	memoryPressure.heap = func() uint64 {
+		atomic.AddInt32(&reads, 1)
+		return 200 << 20
+	}
+	memoryPressure.mu.Unlock()
+	defer func() {
+		memoryPressure.mu.Lock()
+		memoryPressure.heap = heapAlloc
+		memoryPressure.mu.Unlock()
+	}()
+	getter := GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil })
+	cfg := MemoryPressure{HighHeapBytes: 100 << 20, LowHeapBytes: 50 << 20, MinBytes: 64, Interval: 20 * time.Millisecond}
+	var gs []*Group
+	for i := 0; i < 4; i++ {
+		gs = append(gs, NewGroup("memory-pressure-shared-"+strconv.Itoa(i), 1<<10, getter, WithMemoryPressure(cfg)))
+	}
+	time.Sleep(110 * time.Millisecond)
+	for _, g := range gs {
+		g.Close()
+	}
+	// 4 个 Group 共享每个间隔的一次读取
+	if n := atomic.LoadInt32(&reads); n < 2 || n > 6 {
+		t.Fatalf("expect one heap read per interval for all groups, got %d", n)
+	}
+	for _, g := range gs {
+		if g.Stats().PressureShrinks == 0 {
+			t.Fatalf("%s: expect the shared monitor to shrink every group", g.name)
+		}
+	}
+	memoryPressure.mu.Lock()
+	stopped := memoryPressure.stop == nil
+	memoryPressure.mu.Unlock()
+	if !stopped {
+		t.Fatal("expect the monitor goroutine to stop with the last group")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect a zero HighHeapBytes to panic")
+		}
+	}()
+	WithMemoryPressure(MemoryPressure{LowHeapBytes: 1})
+}
+
+func TestUsageAlerts(t *testing.T) {
+	now := time.Unix(0, 0)
+	type event struct {
+		kind string
+		used int64
+	}
+	var events []event
+	gee := NewGroup("usage-alerts", 1000, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithClock(func() time.Time { return now }),
+		OnHighWatermark(func(used, max int64) { events = append(events, event{"high", used}) }),
+		OnLowWatermark(func(used, max int64) { events = append(events, event{"low", used}) }),
+		WithUsageAlerts(0.9, 0.8, time.Minute))
+	defer gee.Close()
+	// 每个条目 100 字节：2 字节的键加 98 字节的值
+	set := func(i int) { gee.Set("k"+strconv.Itoa(i), bytes.Repeat([]byte("x"), 98), 0) }
+	del := func(i int) { gee.Delete("k" + strconv.Itoa(i)) }
+	expect := func(want ...event) {
+		t.Helper()
+		if len(want) == 0 {
+			want = nil
+		}
+		if !reflect.DeepEqual(events, want) {
+			t.Fatalf("expect events %v, got %v", want, events)
+		}
+	}
+
+	for i := 0; i < 8; i++ {
+		set(i)
+	}
+	expect()
+	set(8)
+	set(9) // 仍在高水位以上，不再通知
+	expect(event{"high", 900})
+
+	del(9)
+	del(8)
+	expect(event{"high", 900})
+	del(7)
+	expect(event{"high", 900}, event{"low", 700})
+
+	// 冷却期间的越过不通知
+	set(7)
+	set(8)
+	del(8)
+	del(7)
+	expect(event{"high", 900}, event{"low", 700})
+
+	now = now.Add(time.Minute)
+	set(7)
+	set(8)
+	expect(event{"high", 900}, event{"low", 700}, event{"high", 900})
+}
+
+func TestImmutableGroup(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil })
+	gee := NewGroup("immutable", 2<<10, getter, WithImmutable(), WithNegativeTTL(time.Minute))
+	defer gee.Close()
+
+	if _, err := gee.SetE("Tom", []byte("630"), time.Minute); !errors.Is(err, ErrTTLNotAllowed) {
+		t.Fatalf("expect ErrTTLNotAllowed, but %v got", err)
+	}
+	err := gee.setFromPeer(&pb.SetRequest{Group: "immutable", Key: "Tom", Value: []byte("630"), TtlMs: 1000}, &pb.SetResponse{})
+	if !errors.Is(err, ErrTTLNotAllowed) {
+		t.Fatalf("expect ErrTTLNotAllowed from peer set, but %v got", err)
+	}
+	if res, err := gee.SetE("Tom", []byte("630"), 0); err != nil || !res.Stored {
+		t.Fatalf("set without ttl failed: %+v %v", res, err)
+	}
+	view, info, err := gee.GetWithInfo("Tom")
+	if err != nil || view.String() != "630" || info.Source != SourceLocalCache {
+		t.Fatalf("expect cached value, but %v %+v %v got", view, info, err)
+	}
+	if !info.CreatedAt.IsZero() || info.Age != 0 {
+		t.Fatalf("expect no timestamps for immutable entries, but %+v got", info)
+	}
+
+	cleanupScheduler.mu.Lock()
+	_, scheduled := cleanupScheduler.items[gee]
+	cleanupScheduler.mu.Unlock()
+	if scheduled || gee.negativeTTL != 0 {
+		t.Fatalf("immutable group should skip expiry cleanup and negative caching")
+	}
+}
+
+func TestEvictionLimit(t *testing.T) {
+	gee := NewGroup("eviction-limit", 400, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithEvictionLimit(10, 256), WithCleanupInterval(time.Millisecond))
+	defer gee.Close()
+	for i := 0; i < 100; i++ {
+		gee.Set(fmt.Sprintf("k%02d", i), []byte("v"), 0)
+	}
+	res, err := gee.SetE("big", bytes.Repeat([]byte("x"), 200), 0)
+	if err != nil || !res.Stored || res.Evicted != 10 {
+		t.Fatalf("expect 10 inline evictions, got %+v %v", res, err)
+	}
+	if n := gee.mainCache.bytes(); n <= 400 || n > 400+256 {
+		t.Fatalf("expect bytes within slack, got %d", n)
+	}
+	// 缓存中没有带 TTL 的条目，推迟的淘汰仍由后台清理完成
+	deadline := time.Now().Add(time.Second)
+	for gee.mainCache.bytes() > 400 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect deferred evictions on background cleanup, got %d bytes", gee.mainCache.bytes())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// populatingFlight 在进入 singleflight 之前调用 populate，模拟未命中之后另一个调用者刚好写入了缓存
+type populatingFlight struct {
+	singleflight.Group
+	populate func()
+}
+
+func (f *populatingFlight) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	f.populate()
+	return f.Group.DoContext(ctx, key, fn)
+}
+
+func TestLoadRechecksCache(t *testing.T) {
+	var loads int32
+	flight := &populatingFlight{}
+	gee := NewGroup("load-recheck", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("loaded"), nil
+	}), WithFlight(flight))
+	defer gee.Close()
+	flight.populate = func() { gee.Set("Tom", []byte("fresh"), 0) }
+
+	if v, src, err := gee.GetWithSource("Tom"); err != nil || v.String() != "fresh" || src != SourceLocalCache {
+		t.Fatalf("expect freshly populated value, got %v %v %v", v, src, err)
+	}
+	if loads != 0 || gee.Stats().LoadsAvoided != 1 {
+		t.Fatalf("expect reload avoided, got %d loads, %+v", loads, gee.Stats())
+	}
+
+	// 没有并发写入时照常加载
+	flight.populate = func() {}
+	if v, err := gee.Get("Jack"); err != nil || v.String() != "loaded" || loads != 1 {
+		t.Fatalf("expect normal load, got %v %v after %d loads", v, err, loads)
+	}
+}
+
+func TestInvalidatePrefix(t *testing.T) {
+	var loads int32
+	gee := NewGroup("invalidate-prefix-local", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte(key), nil
+	}))
+	for _, key := range []string{"user:123:profile", "user:123:settings", "user:1234:profile", "team:123"} {
+		gee.Get(key)
+	}
+	if n := gee.InvalidatePrefix("user:123:"); n != 2 {
+		t.Fatalf("expect 2 entries invalidated, got %d", n)
+	}
+	for _, key := range []string{"user:123:profile", "user:1234:profile", "team:123"} {
+		gee.Get(key)
+	}
+	if loads != 5 {
+		t.Fatalf("expect only invalidated keys to be reloaded, got %d loads", loads)
+	}
+	if n, err := gee.BroadcastInvalidatePrefix(""); err != nil || n != 3 {
+		t.Fatalf("expect empty prefix to invalidate everything without peers, got %d %v", n, err)
+	}
+}
+
+func TestEvictionFilter(t *testing.T) {
+	gee := NewGroup("eviction-filter", 40, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v"), nil
+	}), WithEvictionFilter(func(key string, value ByteView) bool {
+		return value.String() != "expensive"
+	}))
+	defer gee.Close()
+	gee.Set("p00", []byte("expensive"), 0)
+	for i := 0; i < 20; i++ {
+		gee.Get(fmt.Sprintf("k%02d", i))
+	}
+	if _, ok := gee.mainCache.get("p00"); !ok {
+		t.Fatalf("expect protected entry to survive eviction")
+	}
+	if _, ok := gee.mainCache.get("k00"); ok {
+		t.Fatalf("expect unprotected entries to be evicted")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var clockMu sync.Mutex
+	clock := func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		clockMu.Lock()
+		now = now.Add(d)
+		clockMu.Unlock()
+	}
+	errOrigin := errors.New("origin down")
+	gee := NewGroup("health", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		switch key[0] {
+		case 'f':
+			return nil, errOrigin
+		case 'm':
+			return nil, ErrNotFound
+		}
+		return []byte(key), nil
+	}), WithClock(clock), WithHealthWindow(time.Minute, 2))
+	defer gee.Close()
+
+	if h := gee.Health(); h.ErrorRate != 0 || h.LastError != nil || h.RecentErrors != nil {
+		t.Fatalf("expect empty health, got %+v", h)
+	}
+	for _, key := range []string{"ok1", "ok2", "fail1", "ok3"} {
+		gee.Get(key)
+	}
+	if h := gee.Health(); h.ErrorRate != 0.25 || h.LastError != errOrigin || !h.LastErrorAt.Equal(now) {
+		t.Fatalf("expect 1 of 4 loads failed, got %+v", h)
+	}
+
+	// ErrNotFound 不算作失败，缓存命中不算作加载
+	advance(30 * time.Second)
+	for _, key := range []string{"fail2", "missing1", "fail3", "missing2", "ok1"} {
+		gee.Get(key)
+	}
+	h := gee.Health()
+	if h.Loads != 8 || h.Failures != 3 || h.ErrorRate != 0.375 {
+		t.Fatalf("expect 3 of 8 loads failed, got %+v", h)
+	}
+	if len(h.RecentErrors) != 2 || h.RecentErrors[0].Key != "fail3" || h.RecentErrors[1].Key != "fail2" {
+		t.Fatalf("expect the 2 most recent errors, newest first, got %+v", h.RecentErrors)
+	}
+
+	// 第一批加载移出窗口
+	advance(40 * time.Second)
+	if h := gee.Health(); h.Loads != 4 || h.ErrorRate != 0.5 {
+		t.Fatalf("expect only the second batch in the window, got %+v", h)
+	}
+	advance(time.Minute)
+	if h := gee.Health(); h.Loads != 0 || h.ErrorRate != 0 || h.LastError != errOrigin {
+		t.Fatalf("expect an empty window that keeps the last error, got %+v", h)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			gee.Get(fmt.Sprintf("%c-concurrent-%d", "of"[i%2], i))
+			gee.Health()
+		}(i)
+	}
+	wg.Wait()
+	if h := gee.Health(); h.Loads != 50 || h.ErrorRate != 0.5 {
+		t.Fatalf("expect half of concurrent loads to fail, got %+v", h)
+	}
+
+	// 没有令牌时不返回错误信息和键
+	pool := NewHTTPPool("self")
+	rec := httptest.NewRecorder()
+	pool.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_geecache/_health/health", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"errorRate":0.5`) ||
+		strings.Contains(rec.Body.String(), "origin down") || strings.Contains(rec.Body.String(), "-concurrent-") {
+		t.Fatalf("unexpected health response %d %s", rec.Code, rec.Body.String())
+	}
+	pool.ConfigureGroup("health", GroupServeConfig{Token: "secret"})
+	rec = httptest.NewRecorder()
+	pool.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_geecache/_health/health", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 without the token, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/_geecache/_health/health", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	pool.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"lastError":"origin down"`) {
+		t.Fatalf("expect details with the token, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// streamGetter 以流的形式返回 values 中的值，短于 4 字节的值改用 Get
+type streamGetter struct {
+	values    map[string]string
+	knownSize bool
+	read      int64 // 从流中读出的字节数
+	gets      int32
+}
+
+func (s *streamGetter) Get(key string) ([]byte, error) {
+	atomic.AddInt32(&s.gets, 1)
+	return []byte(s.values[key]), nil
+}
+
+func (s *streamGetter) GetStream(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	v := s.values[key]
+	if len(v) < 4 {
+		return nil, 0, ErrUseGetter
+	}
+	size := int64(-1)
+	if s.knownSize {
+		size = int64(len(v))
+	}
+	return ioutil.NopCloser(&countingReader{r: strings.NewReader(v), n: &s.read}), size, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+func TestStreamGetter(t *testing.T) {
+	for _, knownSize := range []bool{true, false} {
+		sg := &streamGetter{knownSize: knownSize, values: map[string]string{
+			"big":   strings.Repeat("x", 64),
+			"huge":  strings.Repeat("x", 10000),
+			"small": "s",
+		}}
+		gee := NewGroup("stream-getter", 2<<10, sg, WithMaxValueBytes(100))
+		if v, err := gee.Get("big"); err != nil || v.Len() != 64 || sg.read != 64 {
+			t.Fatalf("knownSize=%v: expect big value streamed, got %d bytes, read %d, %v", knownSize, v.Len(), sg.read, err)
+		}
+		if v, err := gee.Get("small"); err != nil || v.String() != "s" || sg.gets != 1 {
+			t.Fatalf("knownSize=%v: expect small value loaded with Get, got %q %v", knownSize, v.String(), err)
+		}
+
+		// 超过上限的流在读完之前被放弃
+		sg.read = 0
+		if _, err := gee.Get("huge"); err != ErrValueTooLarge {
+			t.Fatalf("knownSize=%v: expect ErrValueTooLarge, got %v", knownSize, err)
+		}
+		if sg.read > 101 {
+			t.Fatalf("knownSize=%v: expect oversized stream aborted early, read %d bytes", knownSize, sg.read)
+		}
+		gee.Close()
+	}
+}
+
+func TestReadSized(t *testing.T) {
+	data := strings.Repeat("x", 3*maxStreamPrealloc+5)
+	b, err := readSized(strings.NewReader(data), int64(len(data)))
+	if err != nil || string(b) != data || cap(b) != len(b) {
+		t.Fatalf("expect exactly %d bytes without spare capacity, got %d/%d %v", len(data), len(b), cap(b), err)
+	}
+	// 声明很大但很快结束的流只分配了预分配的部分
+	allocs := testing.AllocsPerRun(1, func() {
+		if _, err := readSized(strings.NewReader("short"), 1<<40); err != io.ErrUnexpectedEOF {
+			t.Fatalf("expect io.ErrUnexpectedEOF for a short stream, got %v", err)
+		}
+	})
+	if allocs > 3 {
+		t.Fatalf("expect a single bounded buffer for a short stream, got %v allocations", allocs)
+	}
+
+	// 长度未知的流去掉 bytes.Buffer 多余的容量
+	sg := &streamGetter{values: map[string]string{"big": strings.Repeat("x", 700)}}
+	gee := NewGroup("stream-trim", 8<<10, sg)
+	defer gee.Close()
+	if v, err := gee.Get("big"); err != nil || cap(v.b) != v.Len() {
+		t.Fatalf("expect the unknown-size value trimmed, got %d/%d %v", v.Len(), cap(v.b), err)
+	}
+}
+
+// partialStream 以流的形式返回不完整的值，并用 Uncacheable(nil) 标记
+type partialStream struct {
+	r      *strings.Reader
+	closed int32
+}
+
+func (p *partialStream) Get(key string) ([]byte, error) {
+	return []byte("full"), nil
+}
+
+func (p *partialStream) GetStream(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	return p, 4, Uncacheable(nil)
+}
+
+func (p *partialStream) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *partialStream) Close() error {
+	atomic.AddInt32(&p.closed, 1)
+	return nil
+}
+
+func TestStreamUncacheable(t *testing.T) {
+	ps := &partialStream{r: strings.NewReader("part")}
+	gee := NewGroup("stream-uncacheable", 2<<10, ps)
+	defer gee.Close()
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "part" {
+		t.Fatalf("expect the streamed value, got %q %v", v.String(), err)
+	}
+	if gee.mainCache.lru != nil {
+		t.Fatalf("expect the uncacheable stream not to be cached")
+	}
+	if n := atomic.LoadInt32(&ps.closed); n != 1 {
+		t.Fatalf("expect the stream closed once, got %d", n)
+	}
+}
+
+type etagGetter map[string]int
+
+func (e etagGetter) Get(key string) ([]byte, error) {
+	return []byte(key), nil
+}
+
+func (e etagGetter) GetWithETag(key string) ([]byte, string, error) {
+	return []byte(key), fmt.Sprintf(`"v%d"`, e[key]), nil
+}
+
+func TestGetWithETag(t *testing.T) {
+	gee := NewGroup("etag", 2<<10, etagGetter{"Tom": 3})
+	defer gee.Close()
+	v, etag, notModified, err := gee.GetWithETag("Tom", "")
+	if err != nil || v.String() != "Tom" || etag != `"v3"` || notModified {
+		t.Fatalf("unexpected result %q %q %v %v", v.String(), etag, notModified, err)
+	}
+	for _, inm := range []string{`"v3"`, `W/"v3"`, `"v1", "v3"`, "*"} {
+		v, etag, notModified, err := gee.GetWithETag("Tom", inm)
+		if err != nil || !notModified || v.Len() != 0 || etag != `"v3"` {
+			t.Fatalf("If-None-Match %s: expect not modified, got %q %q %v %v", inm, v.String(), etag, notModified, err)
+		}
+	}
+	if _, _, notModified, _ := gee.GetWithETag("Tom", `"v2"`); notModified {
+		t.Fatalf("expect a stale etag to get the value")
+	}
+
+	// 写入时可以指定 ETag，没有 ETag 的值按内容计算
+	gee.SetWithMeta("Jack", []byte("589"), 0, map[string]string{MetaETag: `"j1"`})
+	if _, etag, _, _ := gee.GetWithETag("Jack", ""); etag != `"j1"` {
+		t.Fatalf("expect etag from SetWithMeta, got %q", etag)
+	}
+	gee.Set("Sam", []byte("567"), 0)
+	_, etag1, _, _ := gee.GetWithETag("Sam", "")
+	if _, _, notModified, _ := gee.GetWithETag("Sam", etag1); etag1 == "" || !notModified {
+		t.Fatalf("expect a content etag to match, got %q", etag1)
+	}
+	gee.Set("Sam", []byte("568"), 0)
+	if _, etag2, notModified, _ := gee.GetWithETag("Sam", etag1); notModified || etag2 == etag1 {
+		t.Fatalf("expect the content etag to change with the value")
+	}
+}
+
+// slowETagGetter 的 GetWithETag 等待 release；实现 ETagCtxGetter 时在 ctx 结束时返回
+type slowETagGetter struct {
+	release chan struct{}
+}
+
+func (s slowETagGetter) Get(key string) ([]byte, error) { return []byte(key), nil }
+
+func (s slowETagGetter) GetWithETag(key string) ([]byte, string, error) {
+	<-s.release
+	return []byte(key), `"v1"`, nil
+}
+
+type slowETagCtxGetter struct {
+	slowETagGetter
+	ctxErr chan error
+}
+
+func (s slowETagCtxGetter) GetWithETagCtx(ctx context.Context, key string) ([]byte, string, error) {
+	<-ctx.Done()
+	s.ctxErr <- ctx.Err()
+	return nil, "", ctx.Err()
+}
+
+func TestGetWithETagTimeout(t *testing.T) {
+	slow := slowETagGetter{release: make(chan struct{})}
+	defer close(slow.release)
+	gee := NewGroup("etag-timeout", 2<<10, slow, WithLoadTimeout(20*time.Millisecond))
+	defer gee.Close()
+	start := time.Now()
+	if _, _, _, err := gee.GetWithETag("Tom", ""); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Fatalf("expect the etag load abandoned at the timeout, got %v after %v", err, time.Since(start))
+	}
+
+	withCtx := slowETagCtxGetter{slowETagGetter: slow, ctxErr: make(chan error, 1)}
+	ctxGroup := NewGroup("etag-timeout-ctx", 2<<10, withCtx, WithLoadTimeout(20*time.Millisecond))
+	defer ctxGroup.Close()
+	if _, _, _, err := ctxGroup.GetWithETag("Tom", ""); err == nil {
+		t.Fatal("expect the etag load to fail at the timeout")
+	}
+	if err := <-withCtx.ctxErr; err != context.DeadlineExceeded {
+		t.Fatalf("expect GetWithETagCtx to see the load timeout, got %v", err)
+	}
+}
+
+func TestPreciseExpiry(t *testing.T) {
+	gee := NewGroup("precise-expiry", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithPreciseExpiry())
+	defer gee.Close()
+
+	// waitRemoved 等待条目数降到 n，返回用时
+	start := time.Now()
+	waitRemoved := func(n int) time.Duration {
+		for {
+			pending, _ := gee.mainCache.pendingExpiry()
+			if pending <= n {
+				return time.Since(start)
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("entries not removed, %d pending", pending)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	gee.Set("late", []byte("v"), 300*time.Millisecond)
+	// 更早过期的写入唤醒协程重新计算
+	gee.Set("early", []byte("v"), 30*time.Millisecond)
+	if d := waitRemoved(1); d < 30*time.Millisecond || d > 200*time.Millisecond {
+		t.Fatalf("expect early removed shortly after its deadline, took %v", d)
+	}
+	if _, ok := gee.mainCache.get("late"); !ok {
+		t.Fatalf("expect late to be kept until its deadline")
+	}
+	if d := waitRemoved(0); d < 300*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("expect late removed shortly after its deadline, took %v", d)
+	}
+}
+
+func TestSmallValueFastPath(t *testing.T) {
+	gee := NewGroup("small-fast-path", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v-" + key), nil
+	}), WithSmallValueFastPath(0))
+	defer gee.Close()
+	big := bytes.Repeat([]byte("x"), smallValueBytes+1)
+	src := []byte("abc")
+	gee.Set("a", src, 0)
+	gee.Set("b", []byte("def"), 0)
+	gee.Set("big", big, 0)
+	src[0] = 'X'
+	for key, expect := range map[string]string{"a": "abc", "b": "def", "big": string(big), "c": "v-c"} {
+		if v, err := gee.Get(key); err != nil || v.String() != expect {
+			t.Fatalf("Get(%s) = %q %v, expect %q", key, v.String(), err, expect)
+		}
+	}
+	// 从块中切出的值不能通过 append 覆盖相邻的值
+	a, _ := gee.Get("a")
+	_ = append(a.b, 'Z')
+	if b, _ := gee.Get("b"); b.String() != "def" {
+		t.Fatalf("expect neighbouring values untouched, got %q", b.String())
+	}
+	if gee.mainCache.promoteDistance != defaultPromoteDistance {
+		t.Fatalf("expect the default promote distance")
+	}
+}
+
+func TestSmallArenaBudget(t *testing.T) {
+	gee := NewGroup("small-arena-budget", 16<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithSmallValueFastPath(0))
+	defer gee.Close()
+	value := bytes.Repeat([]byte("v"), 32)
+	// 反复替换同一批键：旧值所在的块不再被引用，不计入上限
+	for i := 0; i < 5000; i++ {
+		gee.Set("key"+strconv.Itoa(i%10), value, 0)
+	}
+	if p := gee.arena.pinned; p > 2*int64(gee.arena.chunkBytes) {
+		t.Fatalf("expect replaced values to release their chunks, %d bytes pinned", p)
+	}
+	// 写满之后，被引用的块整块计入上限
+	for i := 0; i < 5000; i++ {
+		gee.Set("key"+strconv.Itoa(i), value, 0)
+		if n := gee.mainCache.bytes(); n > 16<<10 {
+			t.Fatalf("expect pinned chunks to count against the budget, %d bytes used", n)
+		}
+	}
+	if gee.mainCache.bytes() < gee.arena.pinned {
+		t.Fatalf("expect usage %d to include %d pinned bytes", gee.mainCache.bytes(), gee.arena.pinned)
+	}
+	gee.mainCache.removeFunc(func(string) bool { return true })
+	if gee.arena.pinned != 0 || gee.arena.overhead() != 0 {
+		t.Fatalf("expect no pinned chunks after removing every key, got %d", gee.arena.pinned)
+	}
+}
+
+func benchmarkGetSmallValues(b *testing.B, opts ...GroupOption) {
+	// 命中日志会掩盖命中路径本身的开销
+	SetLogger(nil)
+	defer SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+	value := bytes.Repeat([]byte("v"), 32)
+	gee := NewGroup("small-values-bench", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return value, nil
+	}), opts...)
+	defer gee.Close()
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		gee.Get(keys[i])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := gee.Get(keys[i%64]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetSmallValues(b *testing.B) { benchmarkGetSmallValues(b) }
+func BenchmarkGetSmallValuesFastPath(b *testing.B) {
+	benchmarkGetSmallValues(b, WithSmallValueFastPath(0))
+}
+
+func benchmarkSetSmallValues(b *testing.B, opts ...GroupOption) {
+	gee := NewGroup("small-values-set-bench", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), opts...)
+	defer gee.Close()
+	value := bytes.Repeat([]byte("v"), 32)
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gee.Set(keys[i%len(keys)], value, 0)
+	}
+}
+
+func BenchmarkSetSmallValues(b *testing.B) { benchmarkSetSmallValues(b) }
+func BenchmarkSetSmallValuesFastPath(b *testing.B) {
+	benchmarkSetSmallValues(b, WithSmallValueFastPath(0))
+}
+
+func TestTTLMillis(t *testing.T) {
+	for ttl, want := range map[time.Duration]int64{
+		0:                       0,
+		500 * time.Microsecond:  1,
+		time.Millisecond:        1,
+		1500 * time.Microsecond: 1,
+		time.Second:             1000,
+		-500 * time.Microsecond: -1,
+		-time.Millisecond:       -1,
+	} {
+		if got := ttlMillis(ttl); got != want {
+			t.Fatalf("ttlMillis(%v) = %d, want %d", ttl, got, want)
+		}
+	}
+}
@@ -68,10 +68,11 @@ func (m *Request) GetKey() string {
 }
 
 type Response struct {
-	Value                []byte   `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Value                []byte            `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Meta                 map[string]string `protobuf:"bytes,2,rep,name=meta,proto3" json:"meta,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *Response) Reset()         { *m = Response{} }
@@ -106,23 +107,359 @@ func (m *Response) GetValue() []byte {
 	return nil
 }
 
+func (m *Response) GetMeta() map[string]string {
+	if m != nil {
+		return m.Meta
+	}
+	return nil
+}
+
+type SetRequest struct {
+	Group                string            `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key                  string            `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Value                []byte            `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	TtlMs                int64             `protobuf:"varint,4,opt,name=ttl_ms,json=ttlMs,proto3" json:"ttl_ms,omitempty"`
+	Replica              bool              `protobuf:"varint,5,opt,name=replica,proto3" json:"replica,omitempty"`
+	Meta                 map[string]string `protobuf:"bytes,6,rep,name=meta,proto3" json:"meta,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *SetRequest) Reset()         { *m = SetRequest{} }
+func (m *SetRequest) String() string { return proto.CompactTextString(m) }
+func (*SetRequest) ProtoMessage()    {}
+func (*SetRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_889d0a4ad37a0d42, []int{2}
+}
+
+func (m *SetRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetRequest.Unmarshal(m, b)
+}
+func (m *SetRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetRequest.Marshal(b, m, deterministic)
+}
+func (m *SetRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetRequest.Merge(m, src)
+}
+func (m *SetRequest) XXX_Size() int {
+	return xxx_messageInfo_SetRequest.Size(m)
+}
+func (m *SetRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetRequest proto.InternalMessageInfo
+
+func (m *SetRequest) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *SetRequest) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *SetRequest) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *SetRequest) GetTtlMs() int64 {
+	if m != nil {
+		return m.TtlMs
+	}
+	return 0
+}
+
+func (m *SetRequest) GetReplica() bool {
+	if m != nil {
+		return m.Replica
+	}
+	return false
+}
+
+func (m *SetRequest) GetMeta() map[string]string {
+	if m != nil {
+		return m.Meta
+	}
+	return nil
+}
+
+type SetResponse struct {
+	Stored               bool     `protobuf:"varint,1,opt,name=stored,proto3" json:"stored,omitempty"`
+	Evicted              int32    `protobuf:"varint,2,opt,name=evicted,proto3" json:"evicted,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetResponse) Reset()         { *m = SetResponse{} }
+func (m *SetResponse) String() string { return proto.CompactTextString(m) }
+func (*SetResponse) ProtoMessage()    {}
+func (*SetResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_889d0a4ad37a0d42, []int{3}
+}
+
+func (m *SetResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetResponse.Unmarshal(m, b)
+}
+func (m *SetResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetResponse.Marshal(b, m, deterministic)
+}
+func (m *SetResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetResponse.Merge(m, src)
+}
+func (m *SetResponse) XXX_Size() int {
+	return xxx_messageInfo_SetResponse.Size(m)
+}
+func (m *SetResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetResponse proto.InternalMessageInfo
+
+func (m *SetResponse) GetStored() bool {
+	if m != nil {
+		return m.Stored
+	}
+	return false
+}
+
+func (m *SetResponse) GetEvicted() int32 {
+	if m != nil {
+		return m.Evicted
+	}
+	return 0
+}
+
+type BatchRequest struct {
+	Group                string   `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Keys                 []string `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BatchRequest) Reset()         { *m = BatchRequest{} }
+func (m *BatchRequest) String() string { return proto.CompactTextString(m) }
+func (*BatchRequest) ProtoMessage()    {}
+func (*BatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_889d0a4ad37a0d42, []int{4}
+}
+
+func (m *BatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchRequest.Unmarshal(m, b)
+}
+func (m *BatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchRequest.Marshal(b, m, deterministic)
+}
+func (m *BatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchRequest.Merge(m, src)
+}
+func (m *BatchRequest) XXX_Size() int {
+	return xxx_messageInfo_BatchRequest.Size(m)
+}
+func (m *BatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchRequest proto.InternalMessageInfo
+
+func (m *BatchRequest) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *BatchRequest) GetKeys() []string {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
+type BatchResponse struct {
+	Values               map[string][]byte `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Errors               map[string]string `protobuf:"bytes,2,rep,name=errors,proto3" json:"errors,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *BatchResponse) Reset()         { *m = BatchResponse{} }
+func (m *BatchResponse) String() string { return proto.CompactTextString(m) }
+func (*BatchResponse) ProtoMessage()    {}
+func (*BatchResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_889d0a4ad37a0d42, []int{5}
+}
+
+func (m *BatchResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchResponse.Unmarshal(m, b)
+}
+func (m *BatchResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchResponse.Marshal(b, m, deterministic)
+}
+func (m *BatchResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchResponse.Merge(m, src)
+}
+func (m *BatchResponse) XXX_Size() int {
+	return xxx_messageInfo_BatchResponse.Size(m)
+}
+func (m *BatchResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchResponse proto.InternalMessageInfo
+
+func (m *BatchResponse) GetValues() map[string][]byte {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+func (m *BatchResponse) GetErrors() map[string]string {
+	if m != nil {
+		return m.Errors
+	}
+	return nil
+}
+
+type HasResponse struct {
+	Exists               bool     `protobuf:"varint,1,opt,name=exists,proto3" json:"exists,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HasResponse) Reset()         { *m = HasResponse{} }
+func (m *HasResponse) String() string { return proto.CompactTextString(m) }
+func (*HasResponse) ProtoMessage()    {}
+func (*HasResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_889d0a4ad37a0d42, []int{6}
+}
+
+func (m *HasResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HasResponse.Unmarshal(m, b)
+}
+func (m *HasResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HasResponse.Marshal(b, m, deterministic)
+}
+func (m *HasResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HasResponse.Merge(m, src)
+}
+func (m *HasResponse) XXX_Size() int {
+	return xxx_messageInfo_HasResponse.Size(m)
+}
+func (m *HasResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_HasResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HasResponse proto.InternalMessageInfo
+
+func (m *HasResponse) GetExists() bool {
+	if m != nil {
+		return m.Exists
+	}
+	return false
+}
+
+type DeleteResponse struct {
+	Deleted              bool     `protobuf:"varint,1,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteResponse) Reset()         { *m = DeleteResponse{} }
+func (m *DeleteResponse) String() string { return proto.CompactTextString(m) }
+func (*DeleteResponse) ProtoMessage()    {}
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_889d0a4ad37a0d42, []int{7}
+}
+
+func (m *DeleteResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteResponse.Unmarshal(m, b)
+}
+func (m *DeleteResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteResponse.Marshal(b, m, deterministic)
+}
+func (m *DeleteResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteResponse.Merge(m, src)
+}
+func (m *DeleteResponse) XXX_Size() int {
+	return xxx_messageInfo_DeleteResponse.Size(m)
+}
+func (m *DeleteResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteResponse proto.InternalMessageInfo
+
+func (m *DeleteResponse) GetDeleted() bool {
+	if m != nil {
+		return m.Deleted
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterType((*Request)(nil), "geecachepb.Request")
 	proto.RegisterType((*Response)(nil), "geecachepb.Response")
+	proto.RegisterMapType((map[string]string)(nil), "geecachepb.Response.MetaEntry")
+	proto.RegisterType((*SetRequest)(nil), "geecachepb.SetRequest")
+	proto.RegisterMapType((map[string]string)(nil), "geecachepb.SetRequest.MetaEntry")
+	proto.RegisterType((*SetResponse)(nil), "geecachepb.SetResponse")
+	proto.RegisterType((*BatchRequest)(nil), "geecachepb.BatchRequest")
+	proto.RegisterType((*BatchResponse)(nil), "geecachepb.BatchResponse")
+	proto.RegisterMapType((map[string]string)(nil), "geecachepb.BatchResponse.ErrorsEntry")
+	proto.RegisterMapType((map[string][]byte)(nil), "geecachepb.BatchResponse.ValuesEntry")
+	proto.RegisterType((*HasResponse)(nil), "geecachepb.HasResponse")
+	proto.RegisterType((*DeleteResponse)(nil), "geecachepb.DeleteResponse")
 }
 
 func init() { proto.RegisterFile("geecachepb.proto", fileDescriptor_889d0a4ad37a0d42) }
 
 var fileDescriptor_889d0a4ad37a0d42 = []byte{
-	// 148 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0x48, 0x4f, 0x4d, 0x4d,
-	0x4e, 0x4c, 0xce, 0x48, 0x2d, 0x48, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x42, 0x88,
-	0x28, 0x19, 0x72, 0xb1, 0x07, 0xa5, 0x16, 0x96, 0xa6, 0x16, 0x97, 0x08, 0x89, 0x70, 0xb1, 0xa6,
-	0x17, 0xe5, 0x97, 0x16, 0x48, 0x30, 0x2a, 0x30, 0x6a, 0x70, 0x06, 0x41, 0x38, 0x42, 0x02, 0x5c,
-	0xcc, 0xd9, 0xa9, 0x95, 0x12, 0x4c, 0x60, 0x31, 0x10, 0x53, 0x49, 0x81, 0x8b, 0x23, 0x28, 0xb5,
-	0xb8, 0x20, 0x3f, 0xaf, 0x38, 0x15, 0xa4, 0xa7, 0x2c, 0x31, 0xa7, 0x34, 0x15, 0xac, 0x87, 0x27,
-	0x08, 0xc2, 0x31, 0xb2, 0xe3, 0xe2, 0x72, 0x07, 0x69, 0x76, 0x06, 0x59, 0x22, 0x64, 0xc0, 0xc5,
-	0xec, 0x9e, 0x5a, 0x22, 0x24, 0xac, 0x87, 0xe4, 0x10, 0xa8, 0x9d, 0x52, 0x22, 0xa8, 0x82, 0x10,
-	0x53, 0x93, 0xd8, 0xc0, 0xee, 0x34, 0x06, 0x04, 0x00, 0x00, 0xff, 0xff, 0x5c, 0xd5, 0xdd, 0x09,
-	0xbb, 0x00, 0x00, 0x00,
+	// 481 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x54, 0x41, 0x6b, 0xdb, 0x4c,
+	0x10, 0x65, 0x25, 0x5b, 0xb6, 0xc7, 0xfe, 0x3e, 0xc2, 0x36, 0x4d, 0xb7, 0x3a, 0x14, 0x21, 0x08,
+	0x98, 0x1e, 0x4c, 0xeb, 0x94, 0x36, 0x29, 0x94, 0x40, 0xdb, 0xe0, 0x5c, 0x72, 0xd9, 0x40, 0xaf,
+	0x45, 0x91, 0x87, 0xc4, 0x44, 0x89, 0x54, 0xed, 0x38, 0xd4, 0xe7, 0x9e, 0xfb, 0x3b, 0xfa, 0xdf,
+	0xfa, 0x2b, 0x8a, 0x66, 0x25, 0x7b, 0x4d, 0xd5, 0x86, 0x40, 0x6f, 0x7a, 0xc3, 0xbc, 0x99, 0x79,
+	0xef, 0x79, 0x0d, 0x3b, 0x97, 0x88, 0x69, 0x92, 0x5e, 0x61, 0x71, 0x31, 0x29, 0xca, 0x9c, 0x72,
+	0x09, 0x9b, 0x4a, 0xfc, 0x12, 0x7a, 0x1a, 0xbf, 0x2c, 0xd1, 0x90, 0xdc, 0x85, 0xee, 0x65, 0x99,
+	0x2f, 0x0b, 0x25, 0x22, 0x31, 0x1e, 0x68, 0x0b, 0xe4, 0x0e, 0xf8, 0xd7, 0xb8, 0x52, 0x1e, 0xd7,
+	0xaa, 0xcf, 0xf8, 0xbb, 0x80, 0xbe, 0x46, 0x53, 0xe4, 0xb7, 0x06, 0x2b, 0xd2, 0x5d, 0x92, 0x2d,
+	0x91, 0x49, 0x23, 0x6d, 0x81, 0x9c, 0x42, 0xe7, 0x06, 0x29, 0x51, 0x5e, 0xe4, 0x8f, 0x87, 0xd3,
+	0x67, 0x13, 0xe7, 0x84, 0x86, 0x39, 0x39, 0x43, 0x4a, 0x4e, 0x6e, 0xa9, 0x5c, 0x69, 0xee, 0x0d,
+	0xdf, 0xc0, 0x60, 0x5d, 0x6a, 0xb6, 0x8a, 0xf5, 0xd6, 0xcd, 0x22, 0x7b, 0x89, 0x05, 0x6f, 0xbd,
+	0x43, 0x11, 0xff, 0x14, 0x00, 0xe7, 0x48, 0x0f, 0x94, 0xb1, 0x19, 0xe8, 0xbb, 0x97, 0x3f, 0x86,
+	0x80, 0x28, 0xfb, 0x7c, 0x63, 0x54, 0x27, 0x12, 0x63, 0x5f, 0x77, 0x89, 0xb2, 0x33, 0x23, 0x15,
+	0xf4, 0x4a, 0x2c, 0xb2, 0x45, 0x9a, 0xa8, 0x6e, 0x24, 0xc6, 0x7d, 0xdd, 0x40, 0xf9, 0xaa, 0x96,
+	0x1a, 0xb0, 0xd4, 0xc8, 0x95, 0xba, 0x39, 0xea, 0xdf, 0x89, 0x3d, 0x86, 0x21, 0x8f, 0xad, 0xed,
+	0xdf, 0x83, 0xc0, 0x50, 0x5e, 0xe2, 0x9c, 0xd9, 0x7d, 0x5d, 0xa3, 0xea, 0x5e, 0xbc, 0x5b, 0xa4,
+	0x84, 0x73, 0x1e, 0xd1, 0xd5, 0x0d, 0x8c, 0x0f, 0x61, 0xf4, 0x3e, 0xa1, 0xf4, 0xea, 0xef, 0x76,
+	0x49, 0xe8, 0x5c, 0xe3, 0xca, 0x70, 0x80, 0x03, 0xcd, 0xdf, 0xf1, 0x37, 0x0f, 0xfe, 0xab, 0xa9,
+	0xf5, 0xf6, 0x77, 0x10, 0xf0, 0x65, 0x46, 0x09, 0x56, 0xbf, 0xef, 0xaa, 0xdf, 0x6a, 0x9d, 0x7c,
+	0xe2, 0x3e, 0x6b, 0x41, 0x4d, 0xaa, 0xe8, 0x58, 0x96, 0x79, 0x69, 0x94, 0x77, 0x1f, 0xfd, 0x84,
+	0xfb, 0x6a, 0xba, 0x25, 0x85, 0x47, 0x30, 0x74, 0xa6, 0xde, 0xe7, 0xe2, 0xc8, 0x71, 0xb1, 0xa2,
+	0x3a, 0x13, 0x1f, 0x14, 0xc0, 0x3e, 0x0c, 0x4f, 0x13, 0xe3, 0x06, 0x80, 0x5f, 0x17, 0x86, 0x4c,
+	0x13, 0x80, 0x45, 0xf1, 0x73, 0xf8, 0xff, 0x23, 0x66, 0x48, 0xb8, 0xee, 0x54, 0xd0, 0x9b, 0x73,
+	0xa5, 0xc9, 0xaa, 0x81, 0xd3, 0x1f, 0x1e, 0xc0, 0xac, 0xb2, 0xfd, 0x43, 0xa5, 0x5d, 0xbe, 0x00,
+	0x7f, 0x86, 0x24, 0x1f, 0x6d, 0xbf, 0x1a, 0x4e, 0x2b, 0xdc, 0x6d, 0x7b, 0x4a, 0xf2, 0x35, 0xf8,
+	0xe7, 0x48, 0x72, 0xaf, 0xfd, 0xc7, 0x17, 0x3e, 0xf9, 0xad, 0x5e, 0xf3, 0x8e, 0xa1, 0x3f, 0x43,
+	0x62, 0xa7, 0xa5, 0x6a, 0x31, 0xdf, 0xd2, 0x9f, 0xfe, 0x31, 0x16, 0x79, 0x00, 0xfe, 0x69, 0x62,
+	0xda, 0x4f, 0xdd, 0xda, 0xea, 0x5a, 0x76, 0x04, 0x81, 0xb5, 0xa6, 0x9d, 0x17, 0xba, 0xc5, 0x6d,
+	0x0f, 0x2f, 0x02, 0xfe, 0x03, 0x3b, 0xf8, 0x35, 0x00, 0xfb, 0xbb, 0x29, 0xea, 0xd4, 0x04, 0x00,
+	0x00,
 }
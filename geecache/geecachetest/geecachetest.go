@@ -0,0 +1,429 @@
+// Package geecachetest 提供进程内的假对等点和对等点选择器，
+// 用于在没有网络的情况下测试 Group 与远程节点之间的交互，例如回退和重试逻辑。
+package geecachetest
+
+import (
+	"fmt"
+	"geecache"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求的方法名，用于 Call.Method、CallCount 和错误注入
+const (
+	MethodGet      = "Get"
+	MethodGetBatch = "GetBatch"
+	MethodHas      = "Has"
+	MethodSet      = "Set"
+	MethodDelete   = "Delete"
+	// InvalidatePrefix 的前缀记录在 Call.Key 中
+	MethodInvalidatePrefix = "InvalidatePrefix"
+	MethodPin              = "Pin"
+)
+
+// Call 记录假对等点收到的一次请求
+type Call struct {
+	Method string
+	Group  string
+	Key    string // GetBatch 时为空，键记录在 Keys 中
+	Keys   []string
+}
+
+// FakePeer 是进程内的对等点，实现 geecache.PeerGetter 以及 BatchPeerGetter、PeerChecker、
+// PeerSetter、PeerDeleter、PeerPrefixInvalidator 和 PeerPinner。请求由另一个节点的 Group 处理，或者从固定的键值表中读取。
+// 每次请求都会被记录，可以注入延迟和错误。并发安全。
+type FakePeer struct {
+	name  string
+	group *geecache.Group
+
+	mu      sync.Mutex
+	values  map[string][]byte // group 为 nil 时使用
+	latency time.Duration
+	errFn   func(method, key string) error
+	calls   []Call
+}
+
+// NewFakePeer 创建名为 name 的假对等点，请求交给 g 处理，如同 g 所在的节点收到了请求。
+// g 注册的 PeerPicker 应当把 name 视为本节点，否则请求会被再次转发。
+func NewFakePeer(name string, g *geecache.Group) *FakePeer {
+	return &FakePeer{name: name, group: g}
+}
+
+// NewStaticPeer 创建名为 name 的假对等点，Get 从 values 中读取，不存在的键返回 geecache.ErrNotFound。
+// Set 和 Delete 修改一份 values 的副本。
+func NewStaticPeer(name string, values map[string][]byte) *FakePeer {
+	p := &FakePeer{name: name, values: make(map[string][]byte, len(values))}
+	for k, v := range values {
+		p.values[k] = v
+	}
+	return p
+}
+
+// String 返回对等点的名字，geecache 用它填写 SetResult.RoutedToPeer
+func (p *FakePeer) String() string {
+	return p.name
+}
+
+// SetLatency 让之后的每次请求先等待 d
+func (p *FakePeer) SetLatency(d time.Duration) {
+	p.mu.Lock()
+	p.latency = d
+	p.mu.Unlock()
+}
+
+// SetError 设置错误注入：fn 返回非 nil 时请求以该错误失败，不会被处理。fn 为 nil 时取消注入。
+// 延迟先于错误生效。
+func (p *FakePeer) SetError(fn func(method, key string) error) {
+	p.mu.Lock()
+	p.errFn = fn
+	p.mu.Unlock()
+}
+
+// SetDown 为 true 时所有请求都以传输错误失败，模拟节点下线
+func (p *FakePeer) SetDown(down bool) {
+	if !down {
+		p.SetError(nil)
+		return
+	}
+	err := fmt.Errorf("peer %s is down", p.name)
+	p.SetError(func(string, string) error { return err })
+}
+
+// FailNext 让接下来的 n 次 method 请求以 err 失败，之后恢复正常。会覆盖之前的错误注入。
+func (p *FakePeer) FailNext(method string, n int, err error) {
+	var mu sync.Mutex
+	p.SetError(func(m, _ string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if m != method || n <= 0 {
+			return nil
+		}
+		n--
+		return err
+	})
+}
+
+// Calls 返回收到的所有请求的副本，按收到的顺序排列
+func (p *FakePeer) Calls() []Call {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	calls := make([]Call, len(p.calls))
+	copy(calls, p.calls)
+	return calls
+}
+
+// CallCount 返回收到的 method 请求的次数，包括失败的请求
+func (p *FakePeer) CallCount(method string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, c := range p.calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// ResetCalls 清空请求记录
+func (p *FakePeer) ResetCalls() {
+	p.mu.Lock()
+	p.calls = nil
+	p.mu.Unlock()
+}
+
+// begin 记录请求，等待注入的延迟，返回注入的错误
+func (p *FakePeer) begin(c Call) error {
+	p.mu.Lock()
+	p.calls = append(p.calls, c)
+	latency, errFn := p.latency, p.errFn
+	p.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if errFn != nil {
+		return errFn(c.Method, c.Key)
+	}
+	return nil
+}
+
+func (p *FakePeer) Get(in *pb.Request, out *pb.Response) error {
+	if err := p.begin(Call{Method: MethodGet, Group: in.GetGroup(), Key: in.GetKey()}); err != nil {
+		return err
+	}
+	value, err := p.get(in.GetKey())
+	if err != nil {
+		return err
+	}
+	out.Value = value
+	return nil
+}
+
+func (p *FakePeer) get(key string) ([]byte, error) {
+	if p.group != nil {
+		view, err := p.group.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		return view.ByteSlice(), nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	value, ok := p.values[key]
+	if !ok {
+		return nil, geecache.ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (p *FakePeer) GetBatch(in *pb.BatchRequest, out *pb.BatchResponse) error {
+	keys := append([]string(nil), in.GetKeys()...)
+	if err := p.begin(Call{Method: MethodGetBatch, Group: in.GetGroup(), Keys: keys}); err != nil {
+		return err
+	}
+	out.Values = make(map[string][]byte, len(keys))
+	out.Errors = make(map[string]string)
+	for _, key := range keys {
+		value, err := p.get(key)
+		if err != nil {
+			out.Errors[key] = geecache.EncodeBatchError(err)
+			continue
+		}
+		out.Values[key] = value
+	}
+	return nil
+}
+
+func (p *FakePeer) Has(in *pb.Request, out *pb.HasResponse) error {
+	if err := p.begin(Call{Method: MethodHas, Group: in.GetGroup(), Key: in.GetKey()}); err != nil {
+		return err
+	}
+	if p.group != nil {
+		exists, err := p.group.Has(in.GetKey())
+		out.Exists = exists
+		return err
+	}
+	p.mu.Lock()
+	_, out.Exists = p.values[in.GetKey()]
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *FakePeer) Set(in *pb.SetRequest, out *pb.SetResponse) error {
+	if err := p.begin(Call{Method: MethodSet, Group: in.GetGroup(), Key: in.GetKey()}); err != nil {
+		return err
+	}
+	if p.group != nil {
+		res, err := p.group.SetE(in.GetKey(), in.GetValue(), time.Duration(in.GetTtlMs())*time.Millisecond)
+		out.Stored = res.Stored
+		out.Evicted = int32(res.Evicted)
+		return err
+	}
+	p.mu.Lock()
+	p.values[in.GetKey()] = append([]byte(nil), in.GetValue()...)
+	p.mu.Unlock()
+	out.Stored = true
+	return nil
+}
+
+func (p *FakePeer) Delete(in *pb.Request, out *pb.DeleteResponse) error {
+	if err := p.begin(Call{Method: MethodDelete, Group: in.GetGroup(), Key: in.GetKey()}); err != nil {
+		return err
+	}
+	if p.group != nil {
+		deleted, err := p.group.Delete(in.GetKey())
+		out.Deleted = deleted
+		return err
+	}
+	p.mu.Lock()
+	_, out.Deleted = p.values[in.GetKey()]
+	delete(p.values, in.GetKey())
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *FakePeer) InvalidatePrefix(group, prefix string) (int, error) {
+	if err := p.begin(Call{Method: MethodInvalidatePrefix, Group: group, Key: prefix}); err != nil {
+		return 0, err
+	}
+	if p.group != nil {
+		return p.group.InvalidatePrefix(prefix), nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for key := range p.values {
+		if strings.HasPrefix(key, prefix) {
+			delete(p.values, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Pin 在 Group 上固定键；使用固定键值表时只检查键是否存在
+func (p *FakePeer) Pin(group, key string, ttl time.Duration) error {
+	if err := p.begin(Call{Method: MethodPin, Group: group, Key: key}); err != nil {
+		return err
+	}
+	if p.group != nil {
+		return p.group.Pin(key, ttl)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.values[key]; !ok {
+		return geecache.ErrNotFound
+	}
+	return nil
+}
+
+// FakePicker 是按给定规则路由键的 geecache.PeerPicker，同时实现 geecache.PeerLister
+type FakePicker struct {
+	self  string
+	route func(key string) string
+	peers map[string]*FakePeer
+}
+
+// NewFuncPicker 创建 FakePicker，owner 返回键的拥有者的名字。
+// 拥有者是 self、为空或不在 peers 中时，键由本节点处理。
+func NewFuncPicker(self string, owner func(key string) string, peers ...*FakePeer) *FakePicker {
+	p := &FakePicker{self: self, route: owner, peers: make(map[string]*FakePeer, len(peers))}
+	for _, peer := range peers {
+		p.peers[peer.name] = peer
+	}
+	return p
+}
+
+// NewMapPicker 创建 FakePicker，按 owners 查找键的拥有者，不在 owners 中的键由本节点处理
+func NewMapPicker(self string, owners map[string]string, peers ...*FakePeer) *FakePicker {
+	return NewFuncPicker(self, func(key string) string { return owners[key] }, peers...)
+}
+
+func (p *FakePicker) PickPeer(key string) (geecache.PeerGetter, bool) {
+	owner := p.route(key)
+	if owner == "" || owner == p.self {
+		return nil, false
+	}
+	peer, ok := p.peers[owner]
+	if !ok {
+		return nil, false
+	}
+	return peer, true
+}
+
+// ListPeers 返回除 self 以外的所有对等点，按名字排序
+func (p *FakePicker) ListPeers() []geecache.PeerGetter {
+	names := make([]string, 0, len(p.peers))
+	for name := range p.peers {
+		if name != p.self {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	peers := make([]geecache.PeerGetter, len(names))
+	for i, name := range names {
+		peers[i] = p.peers[name]
+	}
+	return peers
+}
+
+// ManualTicker 是手动触发的 geecache.Ticker，与 geecache.WithCleanupTicker 和 WithClock 配合，
+// 在测试中确定性地驱动过期清理而不必等待真实时间
+type ManualTicker struct {
+	c        chan time.Time
+	swept    chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewManualTicker 创建 ManualTicker
+func NewManualTicker() *ManualTicker {
+	return &ManualTicker{c: make(chan time.Time), swept: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+func (t *ManualTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *ManualTicker) Stop() {
+	t.stopOnce.Do(func() { close(t.stopped) })
+}
+
+// Swept 由 Group 在每次清理完成后调用
+func (t *ManualTicker) Swept() {
+	select {
+	case t.swept <- struct{}{}:
+	case <-t.stopped:
+	}
+}
+
+// Tick 触发一次清理并等待清理完成，Ticker 已停止时直接返回
+func (t *ManualTicker) Tick(now time.Time) {
+	select {
+	case t.c <- now:
+	case <-t.stopped:
+		return
+	}
+	select {
+	case <-t.swept:
+	case <-t.stopped:
+	}
+}
+
+// Cluster 是在进程内组装的多节点集群，节点之间通过 FakePeer 直接调用，不经过 HTTP。
+// 每个节点有同名的 Group，键按一致性哈希分配给节点。
+type Cluster struct {
+	Ring   *consistenthash.Map
+	Groups map[string]*geecache.Group // 节点名到该节点的 Group
+	Peers  map[string]*FakePeer       // 节点名到指向该节点的 FakePeer
+}
+
+// NewCluster 创建由 nodes 组成的集群，每个节点用相同的参数创建名为 group 的 Group 并注册对等点。
+// geecache 按名称全局登记 Group，GetGroup 只能拿到最后一个节点的 Group。
+func NewCluster(group string, nodes []string, cacheBytes int64, getter geecache.Getter, opts ...geecache.GroupOption) *Cluster {
+	c := &Cluster{
+		Ring:   consistenthash.New(50, nil),
+		Groups: make(map[string]*geecache.Group, len(nodes)),
+		Peers:  make(map[string]*FakePeer, len(nodes)),
+	}
+	c.Ring.Add(nodes...)
+	peers := make([]*FakePeer, 0, len(nodes))
+	for _, name := range nodes {
+		g := geecache.NewGroup(group, cacheBytes, getter, opts...)
+		c.Groups[name] = g
+		c.Peers[name] = NewFakePeer(name, g)
+		peers = append(peers, c.Peers[name])
+	}
+	for _, name := range nodes {
+		c.Groups[name].RegisterPeers(NewFuncPicker(name, c.Ring.Get, peers...))
+	}
+	return c
+}
+
+// Owner 返回拥有 key 的节点
+func (c *Cluster) Owner(key string) string {
+	return c.Ring.Get(key)
+}
+
+// KeyOwnedBy 返回以 prefix 开头、由节点 node 拥有的第一个键，形如 prefix0、prefix1
+func (c *Cluster) KeyOwnedBy(node, prefix string) string {
+	if _, ok := c.Groups[node]; !ok {
+		panic("geecachetest: unknown node " + node)
+	}
+	for i := 0; ; i++ {
+		if key := fmt.Sprintf("%s%d", prefix, i); c.Owner(key) == node {
+			return key
+		}
+	}
+}
+
+// Close 关闭所有节点的 Group
+func (c *Cluster) Close() {
+	for _, g := range c.Groups {
+		g.Close()
+	}
+}
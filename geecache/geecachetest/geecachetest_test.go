@@ -0,0 +1,292 @@
+package geecachetest
+
+import (
+	"errors"
+	"fmt"
+	"geecache"
+	pb "geecache/geecachepb"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPeerErrorPolicy(t *testing.T) {
+	var originCalls int32
+	getter := geecache.GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&originCalls, 1)
+		if strings.HasPrefix(key, "missing") {
+			return nil, geecache.ErrNotFound
+		}
+		return []byte("v-" + key), nil
+	})
+	setup := func(policy geecache.PeerErrorPolicy) (*Cluster, *geecache.Group, *FakePeer) {
+		c := NewCluster("peer-policy", []string{"A", "B"}, 2<<10, getter, geecache.WithPeerErrorPolicy(policy))
+		return c, c.Groups["A"], c.Peers["B"]
+	}
+
+	// Fallback：传输错误回退到本地加载
+	c, a, b := setup(geecache.Fallback)
+	b.SetDown(true)
+	key := c.KeyOwnedBy("B", "fallback")
+	if view, src, err := a.GetWithSource(key); err != nil || src != geecache.SourceLocalLoad || view.String() != "v-"+key {
+		t.Fatalf("expect local fallback, got %v %v %v", view, src, err)
+	}
+	if s := a.Stats(); s.PeerErrors != 1 || s.PeerFallbacks != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	c.Close()
+
+	// FailFast：直接返回错误，不访问数据源
+	c, a, b = setup(geecache.FailFast)
+	b.SetDown(true)
+	atomic.StoreInt32(&originCalls, 0)
+	if _, err := a.Get(c.KeyOwnedBy("B", "failfast")); err == nil || originCalls != 0 {
+		t.Fatalf("expect peer error without origin load, got %v after %d calls", err, originCalls)
+	}
+	if s := a.Stats(); s.PeerFallbacks != 0 {
+		t.Fatalf("unexpected fallbacks %+v", s)
+	}
+	c.Close()
+
+	// FallbackAfterRetries：重试成功时不回退，重试耗尽后回退
+	c, a, b = setup(geecache.FallbackAfterRetries(2))
+	b.FailNext(MethodGet, 2, errors.New("transient"))
+	key = c.KeyOwnedBy("B", "retry")
+	if _, src, err := a.GetWithSource(key); err != nil || src != geecache.SourcePeer || b.CallCount(MethodGet) != 3 {
+		t.Fatalf("expect value from peer after 2 retries, got %v %v, %d gets", src, err, b.CallCount(MethodGet))
+	}
+	b.SetDown(true)
+	if _, src, err := a.GetWithSource(c.KeyOwnedBy("B", "exhausted")); err != nil || src != geecache.SourceLocalLoad || b.CallCount(MethodGet) != 6 {
+		t.Fatalf("expect fallback after retries, got %v %v, %d gets", src, err, b.CallCount(MethodGet))
+	}
+	if s := a.Stats(); s.PeerErrors != 5 || s.PeerFallbacks != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	c.Close()
+
+	// 拥有者返回的 ErrNotFound 在任何策略下都不回退
+	for _, policy := range []geecache.PeerErrorPolicy{geecache.Fallback, geecache.FallbackAfterRetries(2)} {
+		c, a, b = setup(policy)
+		missing := c.KeyOwnedBy("B", "missing")
+		atomic.StoreInt32(&originCalls, 0)
+		if _, err := a.Get(missing); !errors.Is(err, geecache.ErrNotFound) || originCalls != 1 || b.CallCount(MethodGet) != 1 {
+			t.Fatalf("expect ErrNotFound from owner only, got %v, %d origin calls, %d gets", err, originCalls, b.CallCount(MethodGet))
+		}
+		c.Close()
+	}
+}
+
+func TestGetMultiPeerErrorPolicy(t *testing.T) {
+	var originCalls int32
+	getter := geecache.GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&originCalls, 1)
+		if strings.HasPrefix(key, "missing") {
+			return nil, geecache.ErrNotFound
+		}
+		return []byte("v-" + key), nil
+	})
+	c := NewCluster("multi-policy", []string{"A", "B"}, 2<<10, getter, geecache.WithPeerErrorPolicy(geecache.FallbackAfterRetries(2)))
+	defer c.Close()
+	a, b := c.Groups["A"], c.Peers["B"]
+	found, missing := c.KeyOwnedBy("B", "found"), c.KeyOwnedBy("B", "missing")
+
+	// 批量请求失败时先按策略重试
+	b.FailNext(MethodGetBatch, 2, errors.New("transient"))
+	values, err := a.GetMulti([]string{found, missing})
+	merr, ok := err.(geecache.MultiError)
+	if !ok || len(merr) != 1 || !errors.Is(merr[missing], geecache.ErrNotFound) || values[found].String() != "v-"+found {
+		t.Fatalf("expect %s from B and ErrNotFound for %s, got %v %v", found, missing, values, err)
+	}
+	if n := b.CallCount(MethodGetBatch); n != 3 {
+		t.Fatalf("expect the batch retried twice, got %d calls", n)
+	}
+	// 拥有者返回的 ErrNotFound 不回退到本地加载
+	if n := atomic.LoadInt32(&originCalls); n != 2 {
+		t.Fatalf("expect only the owner to load, got %d origin calls", n)
+	}
+}
+
+func TestFakePeer(t *testing.T) {
+	peer := NewStaticPeer("B", map[string][]byte{"Tom": []byte("630")})
+	peer.SetLatency(10 * time.Millisecond)
+	peer.SetError(func(method, key string) error {
+		if key == "Jack" {
+			return fmt.Errorf("%s %s failed", method, key)
+		}
+		return nil
+	})
+
+	start := time.Now()
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "scores", Key: "Tom"}, res); err != nil || string(res.Value) != "630" {
+		t.Fatalf("expect static value, got %q %v", res.Value, err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatalf("expect injected latency")
+	}
+	if err := peer.Get(&pb.Request{Group: "scores", Key: "Jack"}, &pb.Response{}); err == nil {
+		t.Fatalf("expect injected error")
+	}
+	if err := peer.Get(&pb.Request{Group: "scores", Key: "Sam"}, &pb.Response{}); !errors.Is(err, geecache.ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	peer.Set(&pb.SetRequest{Group: "scores", Key: "Sam", Value: []byte("567")}, &pb.SetResponse{})
+
+	calls := peer.Calls()
+	if len(calls) != 4 || calls[1].Key != "Jack" || calls[3].Method != MethodSet || peer.CallCount(MethodGet) != 3 {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+
+	// 按 map 路由：只有 Sam 属于 B
+	g := geecache.NewGroup("fake-peer", 2<<10, geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}))
+	defer g.Close()
+	g.RegisterPeers(NewMapPicker("A", map[string]string{"Sam": "B"}, peer))
+	if v, src, err := g.GetWithSource("Sam"); err != nil || src != geecache.SourcePeer || v.String() != "567" {
+		t.Fatalf("expect Sam from peer, got %v %v %v", v, src, err)
+	}
+	if v, src, err := g.GetWithSource("Tom"); err != nil || src != geecache.SourceLocalLoad || v.String() != "local" {
+		t.Fatalf("expect Tom loaded locally, got %v %v %v", v, src, err)
+	}
+}
+
+func TestBroadcastInvalidatePrefix(t *testing.T) {
+	var loads int32
+	getter := geecache.GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte(key), nil
+	})
+	c := NewCluster("invalidate-prefix", []string{"A", "B", "C"}, 2<<10, getter)
+	defer c.Close()
+	a := c.Groups["A"]
+	for i := 0; i < 10; i++ {
+		a.Get(fmt.Sprintf("user:1:%d", i))
+		a.Get(fmt.Sprintf("user:2:%d", i))
+	}
+	if loads != 20 {
+		t.Fatalf("expect 20 loads, got %d", loads)
+	}
+
+	if n, err := a.BroadcastInvalidatePrefix("user:1:"); err != nil || n != 10 {
+		t.Fatalf("expect 10 entries invalidated across the cluster, got %d %v", n, err)
+	}
+	if c.Peers["B"].CallCount(MethodInvalidatePrefix) != 1 || c.Peers["A"].CallCount(MethodInvalidatePrefix) != 0 {
+		t.Fatalf("expect each remote peer to be notified once")
+	}
+	for i := 0; i < 10; i++ {
+		a.Get(fmt.Sprintf("user:1:%d", i))
+		a.Get(fmt.Sprintf("user:2:%d", i))
+	}
+	if loads != 30 {
+		t.Fatalf("expect only user:1: keys to be reloaded, got %d loads", loads)
+	}
+
+	// 失败的对等点记录在 MultiError 中，不影响其他节点
+	c.Peers["C"].SetDown(true)
+	n, err := a.BroadcastInvalidatePrefix("user:")
+	multi, ok := err.(geecache.MultiError)
+	if !ok || len(multi) != 1 || multi["C"] == nil {
+		t.Fatalf("expect error from C only, got %v", err)
+	}
+	if n == 0 || n >= 20 {
+		t.Fatalf("expect entries outside C to be invalidated, got %d", n)
+	}
+}
+
+func TestManualTicker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var mu sync.Mutex
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	ticker := NewManualTicker()
+	g := geecache.NewGroup("manual-ticker", 2<<10, geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), geecache.WithClock(clock), geecache.WithCleanupTicker(ticker))
+	for i := 0; i < 3; i++ {
+		g.Set(fmt.Sprint("short", i), []byte("v"), 30*time.Second)
+	}
+	g.Set("long", []byte("v"), time.Hour)
+
+	ticker.Tick(clock())
+	if n := g.Stats().PendingExpiry; n != 4 {
+		t.Fatalf("expect nothing swept before expiry, got %d pending", n)
+	}
+	mu.Lock()
+	now = now.Add(31 * time.Second)
+	mu.Unlock()
+	ticker.Tick(clock())
+	if n := g.Stats().PendingExpiry; n != 1 {
+		t.Fatalf("expect expired entries swept, got %d pending", n)
+	}
+
+	// Group 关闭后 Ticker 被停止，Tick 不再阻塞
+	g.Close()
+	ticker.Tick(clock())
+}
+
+func TestPinRemote(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var mu sync.Mutex
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	loads := make(map[string]int)
+	getter := geecache.GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		loads[key]++
+		mu.Unlock()
+		if strings.HasPrefix(key, "big") {
+			return make([]byte, 300), nil
+		}
+		return make([]byte, 100), nil
+	})
+	c := NewCluster("pin-remote", []string{"A", "B"}, 1<<10, getter,
+		geecache.WithClock(clock), geecache.WithPinBudget(256))
+	defer c.Close()
+	a, b := c.Groups["A"], c.Groups["B"]
+	key := c.KeyOwnedBy("B", "pinned")
+	fill := func() {
+		for i := 0; i < 30; i++ {
+			b.Get(c.KeyOwnedBy("B", fmt.Sprint("fill", i, "-")))
+		}
+	}
+	loadsOf := func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return loads[key]
+	}
+
+	if err := a.PinRemote(key, time.Minute); err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	if c.Peers["B"].CallCount(MethodPin) != 1 || loadsOf(key) != 1 {
+		t.Fatalf("expect the key pinned and loaded on its owner")
+	}
+	fill()
+	if _, src, err := b.GetWithSource(key); err != nil || src != geecache.SourceLocalCache || loadsOf(key) != 1 {
+		t.Fatalf("expect the pinned key to survive eviction, got %v %v, %d loads", src, err, loadsOf(key))
+	}
+
+	// 固定到期后值回到 mainCache，像普通条目一样被淘汰
+	mu.Lock()
+	now = now.Add(time.Minute)
+	mu.Unlock()
+	b.Get(key)
+	fill()
+	b.Get(key)
+	if loadsOf(key) != 2 {
+		t.Fatalf("expect the key reloaded after its pin lapsed, got %d loads", loadsOf(key))
+	}
+
+	if err := a.PinRemote(c.KeyOwnedBy("B", "big"), time.Minute); err != geecache.ErrPinBudget {
+		t.Fatalf("expect ErrPinBudget, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package geecache
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// GroupServeConfig 是 HTTPPool 为一个 group 处理远程请求时的配置，零值即未配置时的默认行为
+type GroupServeConfig struct {
+	// Disabled 为 true 时拒绝该 group 的所有远程请求，返回 403
+	Disabled bool
+	// Methods 是允许的 HTTP 方法，例如只允许 GET 和 HEAD 使 group 在网络上只读；
+	// 为空时允许所有方法，其他方法返回 405
+	Methods []string
+	// Token 非空时，请求必须带有 "Authorization: Bearer <Token>" 头部，否则返回 401。
+	// 本池向对等点发出的请求会自动带上该 group 的令牌，各节点应使用相同的配置。
+	Token string
+	// MaxValueBytes 大于 0 时，PUT 写入的值超过该字节数返回 413
+	MaxValueBytes int64
+}
+
+// groupServe 是一个 group 的服务端配置和请求计数
+type groupServe struct {
+	requests int64 // 放在首位以保证原子操作的 64 位对齐
+	cfg      GroupServeConfig
+}
+
+// allowMethod 判断配置是否允许该 HTTP 方法
+func (cfg *GroupServeConfig) allowMethod(method string) bool {
+	if len(cfg.Methods) == 0 {
+		return true
+	}
+	for _, m := range cfg.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigureGroup 设置 group 的服务端配置，替换之前的配置，请求计数保留
+func (p *HTTPPool) ConfigureGroup(name string, cfg GroupServeConfig) {
+	cfg.Methods = append([]string(nil), cfg.Methods...)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.groupServes[name]; ok {
+		s.cfg = cfg
+		return
+	}
+	if p.groupServes == nil {
+		p.groupServes = make(map[string]*groupServe)
+	}
+	p.groupServes[name] = &groupServe{cfg: cfg}
+}
+
+// serveConfig 统计 group 的一次请求并返回它的配置。没有配置的 group 使用默认配置，
+// 同样记录请求数；只为已存在的 group 调用，记录的数量不会无限增长。
+func (p *HTTPPool) serveConfig(name string) GroupServeConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.groupServes[name]
+	if !ok {
+		if p.groupServes == nil {
+			p.groupServes = make(map[string]*groupServe)
+		}
+		s = &groupServe{}
+		p.groupServes[name] = s
+	}
+	atomic.AddInt64(&s.requests, 1)
+	return s.cfg
+}
+
+// groupToken 返回 group 配置的令牌，向对等点发出请求时使用
+func (p *HTTPPool) groupToken(name string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.groupServes[name]; ok {
+		return s.cfg.Token
+	}
+	return ""
+}
+
+// admit 统计 group 的请求并检查配置，不允许时写入错误响应并返回 false
+func (p *HTTPPool) admit(w http.ResponseWriter, r *http.Request, name string) (GroupServeConfig, bool) {
+	cfg := p.serveConfig(name)
+	switch {
+	case cfg.Disabled:
+		http.Error(w, "group is not served remotely: "+name, http.StatusForbidden)
+		return cfg, false
+	case cfg.Token != "" && !validToken(r, cfg.Token):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return cfg, false
+	case !cfg.allowMethod(r.Method):
+		w.Header().Set("Allow", strings.Join(cfg.Methods, ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return cfg, false
+	}
+	return cfg, true
+}
+
+// validToken 判断请求是否带有令牌，按常量时间比较，避免通过响应时间逐字节猜出令牌
+func validToken(r *http.Request, token string) bool {
+	got := r.Header.Get("Authorization")
+	return subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) == 1
+}
+
+// groupRequests 返回各 group 收到的远程请求数
+func (p *HTTPPool) groupRequests() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.groupServes) == 0 {
+		return nil
+	}
+	m := make(map[string]int64, len(p.groupServes))
+	for name, s := range p.groupServes {
+		m[name] = atomic.LoadInt64(&s.requests)
+	}
+	return m
+}
@@ -0,0 +1,183 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthPath 是查看 group 健康状况的调试接口，完整路径为 <basePath>_health/<group>
+const healthPath = "_health"
+
+const (
+	defaultHealthWindow = time.Minute
+	defaultRecentErrors = 10
+	// healthBuckets 是窗口被划分的桶数，过期的数据以桶为单位移出窗口
+	healthBuckets = 12
+)
+
+// Health 描述 Group 最近从数据源加载的情况，供就绪检查等判断数据源是否出现问题，
+// 判断"降级"的阈值由调用者决定。ErrNotFound 和调用者取消不算作失败。
+type Health struct {
+	// 窗口内失败的加载占所有加载的比例，窗口内没有加载时为 0
+	ErrorRate float64
+	// 窗口内的加载次数和失败次数
+	Loads    int64
+	Failures int64
+	// 最近一次失败的错误和时间，从未失败时为零值
+	LastError   error
+	LastErrorAt time.Time
+	// 最近的若干次失败，从新到旧排列
+	RecentErrors []LoadError
+}
+
+// LoadError 记录一次失败的加载
+type LoadError struct {
+	Key string
+	Err error
+	At  time.Time
+}
+
+// healthBucket 统计一段时间内的加载
+type healthBucket struct {
+	start    time.Time
+	loads    int64
+	failures int64
+}
+
+// healthTracker 用环形的时间桶统计滑动窗口内的错误率，并用环形缓冲区保存最近的错误
+type healthTracker struct {
+	mu      sync.Mutex
+	width   time.Duration // 每个桶覆盖的时长
+	buckets [healthBuckets]healthBucket
+	errs    []LoadError // 环形缓冲区，next 指向下一个写入的位置
+	next    int
+	count   int
+}
+
+func newHealthTracker(window time.Duration, recentErrors int) *healthTracker {
+	if window <= 0 {
+		window = defaultHealthWindow
+	}
+	if recentErrors < 1 {
+		recentErrors = 1
+	}
+	width := window / healthBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &healthTracker{width: width, errs: make([]LoadError, recentErrors)}
+}
+
+// failed 判断加载的结果是否算作数据源的失败
+func failed(err error) bool {
+	return err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, context.Canceled)
+}
+
+// record 记录一次加载的结果
+func (t *healthTracker) record(key string, err error, now time.Time) {
+	start := now.Truncate(t.width)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[int(uint64(start.UnixNano()/int64(t.width))%healthBuckets)]
+	if !b.start.Equal(start) {
+		*b = healthBucket{start: start}
+	}
+	b.loads++
+	if !failed(err) {
+		return
+	}
+	b.failures++
+	t.errs[t.next] = LoadError{Key: key, Err: err, At: now}
+	t.next = (t.next + 1) % len(t.errs)
+	if t.count < len(t.errs) {
+		t.count++
+	}
+}
+
+// health 汇总窗口内的桶以及最近的错误
+func (t *healthTracker) health(now time.Time) Health {
+	oldest := now.Truncate(t.width).Add(-t.width * (healthBuckets - 1))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var h Health
+	for _, b := range t.buckets {
+		if !b.start.Before(oldest) && !b.start.After(now) {
+			h.Loads += b.loads
+			h.Failures += b.failures
+		}
+	}
+	if h.Loads > 0 {
+		h.ErrorRate = float64(h.Failures) / float64(h.Loads)
+	}
+	if t.count > 0 {
+		h.RecentErrors = make([]LoadError, t.count)
+		for i := range h.RecentErrors {
+			h.RecentErrors[i] = t.errs[(t.next-1-i+len(t.errs))%len(t.errs)]
+		}
+		h.LastError, h.LastErrorAt = h.RecentErrors[0].Err, h.RecentErrors[0].At
+	}
+	return h
+}
+
+// Health 返回 Group 最近从数据源加载的情况，默认统计最近一分钟，保留最近 10 次失败，
+// 可以用 WithHealthWindow 修改。只统计本节点的加载，不包括从对等点获取。
+func (g *Group) Health() Health {
+	return g.health.health(g.now())
+}
+
+// healthJSON 是调试接口返回的 Health，错误以字符串表示
+type healthJSON struct {
+	ErrorRate    float64         `json:"errorRate"`
+	Loads        int64           `json:"loads"`
+	Failures     int64           `json:"failures"`
+	LastError    string          `json:"lastError,omitempty"`
+	LastErrorAt  *time.Time      `json:"lastErrorAt,omitempty"`
+	RecentErrors []loadErrorJSON `json:"recentErrors"`
+}
+
+type loadErrorJSON struct {
+	Key   string    `json:"key,omitempty"`
+	Error string    `json:"error,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// serveHealth 以 JSON 返回 group 的 Health，受 group 的服务端配置限制。
+// 错误信息和失败的键可能包含数据源的内部细节，只返回给带有 group 令牌的调用者，
+// group 没有配置令牌时只返回计数和时间。
+func (p *HTTPPool) serveHealth(w http.ResponseWriter, r *http.Request, name string) {
+	group := p.lookupGroup(name)
+	if group == nil {
+		http.Error(w, "no such group: "+name, http.StatusNotFound)
+		return
+	}
+	cfg, ok := p.admit(w, r, name)
+	if !ok {
+		return
+	}
+	// admit 已经检查过令牌
+	detailed := cfg.Token != ""
+	h := group.Health()
+	res := healthJSON{
+		ErrorRate:    h.ErrorRate,
+		Loads:        h.Loads,
+		Failures:     h.Failures,
+		RecentErrors: make([]loadErrorJSON, 0, len(h.RecentErrors)),
+	}
+	if h.LastError != nil {
+		if detailed {
+			res.LastError = h.LastError.Error()
+		}
+		res.LastErrorAt = &h.LastErrorAt
+	}
+	for _, e := range h.RecentErrors {
+		le := loadErrorJSON{At: e.At}
+		if detailed {
+			le.Key, le.Error = e.Key, e.Err.Error()
+		}
+		res.RecentErrors = append(res.RecentErrors, le)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -1,15 +1,22 @@
 package geecache
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"geecache/consistenthash"
 	pb "geecache/geecachepb"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -21,12 +28,47 @@ const (
 
 // HTTPPool 为 HTTP 对等点池实现 PeerPicker。
 type HTTPPool struct {
+	stats PoolStats // 放在首位以保证原子操作的 64 位对齐
 	// 此对等点的基准 URL，例如 "https://example.net:8000"
 	self        string
 	basePath    string
-	mu          sync.Mutex // guards peers and httpGetters
+	mu          sync.Mutex // guards peers, httpGetters, weights, replicas, peerReplicas, hashSeed, limiters and groupServes
 	peers       *consistenthash.Map
 	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+	weights     map[string]int         // 对等点在哈希环上的权重
+	// 每个权重为 1 的对等点的虚拟节点数，为 0 时使用 defaultReplicas
+	replicas int
+	// 覆盖个别对等点的虚拟节点数，不再乘以权重
+	peerReplicas map[string]int
+	// 混入哈希环的 seed，见 consistenthash.NewSeeded
+	hashSeed string
+	// 可选的，哈希环变化后调用
+	onRingChange func(added, removed []string)
+	// 最近一次加载对等点文件的错误
+	lastReloadErr error
+	// DiscoverDNS 的配置和最近一次解析的错误
+	discoveryOpts    DiscoveryOptions
+	lastDiscoveryErr error
+	// 停止当前 DiscoverDNS 的解析循环，没有时为 nil
+	stopDiscovery func()
+	// 各 group 的令牌桶，没有的 group 不限流
+	limiters map[string]*tokenBucket
+	// 各 group 的服务端配置和请求计数
+	groupServes map[string]*groupServe
+	// 与对等点通信使用的编码，为 nil 时使用 ProtoCodec
+	codec Codec
+	// 大于 0 时，值的总字节数超过该值的响应在编码前被拒绝
+	maxResponseBytes int64
+	// 请求体的限制
+	limits RequestLimits
+	// 访问对等点使用的客户端，为 nil 时使用 http.DefaultClient
+	client *http.Client
+	// Drain 的进度
+	drain drainState
+	// 调试接口最近一次自检的结果
+	selfCheck selfCheckCache
+	// 非 nil 时代替 GetGroup 查找请求的 group，便于测试在一个进程中模拟多个节点上的同名 group
+	groups func(name string) *Group
 }
 
 // NewHTTPPool 初始化 HTTP 对等点池。
@@ -37,9 +79,25 @@ func NewHTTPPool(self string) *HTTPPool {
 	}
 }
 
+// lookupGroup 返回请求的 group
+func (p *HTTPPool) lookupGroup(name string) *Group {
+	if p.groups != nil {
+		return p.groups(name)
+	}
+	return GetGroup(name)
+}
+
+// groupMaxValueBytes 返回本地 group 的 maxValueBytes，group 不存在时返回 0
+func (p *HTTPPool) groupMaxValueBytes(name string) int64 {
+	if g := p.lookupGroup(name); g != nil {
+		return g.maxValueBytes
+	}
+	return 0
+}
+
 // 使用服务器名称记录信息
 func (p *HTTPPool) Log(format string, v ...interface{}) {
-	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
+	logger.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
 }
 
 // ServeHTTP 处理所有 HTTP 请求
@@ -48,48 +106,560 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
+	// 在转义的路径上切分，group 和 key 中转义的 "/" 不会被当作分隔符
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), (&url.URL{Path: p.basePath}).EscapedPath())
+	switch rest {
+	case pingPath:
+		p.servePing(w)
+		return
+	case selfCheckPath:
+		p.serveSelfCheck(w, r)
+		return
+	}
+	if strings.HasPrefix(rest, topKeysPath+"/") {
+		if name, ok := unescapeSegment(w, rest[len(topKeysPath)+1:]); ok {
+			p.serveTopKeys(w, r, name)
+		}
+		return
+	}
+	if strings.HasPrefix(rest, healthPath+"/") {
+		if name, ok := unescapeSegment(w, rest[len(healthPath)+1:]); ok {
+			p.serveHealth(w, r, name)
+		}
+		return
+	}
 	// 需要 /<basepath>/<groupname>/<key>
-	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
+	parts := strings.SplitN(rest, "/", 2)
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	groupName := parts[0]
-	key := parts[1]
+	groupName, ok := unescapeSegment(w, parts[0])
+	if !ok {
+		return
+	}
+	key, ok := unescapeSegment(w, parts[1])
+	if !ok {
+		return
+	}
 
-	group := GetGroup(groupName)
+	group := p.lookupGroup(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
+	cfg, ok := p.admit(w, r, groupName)
+	if !ok {
+		return
+	}
+	if !p.allow(w, groupName) {
+		return
+	}
 
-	view, err := group.Get(key)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	switch r.Method {
+	case http.MethodPut:
+		if r.URL.Query().Get("pin") != "" {
+			p.servePin(w, r, group, key)
+			return
+		}
+		p.serveSet(w, r, group, key, cfg.MaxValueBytes)
+		return
+	case http.MethodPost:
+		p.serveBatch(w, r, group)
+		return
+	case http.MethodDelete:
+		if r.URL.Query().Get("prefix") != "" {
+			p.serveInvalidatePrefix(w, group, key)
+			return
+		}
+		p.serveDelete(w, group, key)
+		return
+	case http.MethodHead:
+		// 只检查本地缓存，不触发加载，也不再转发给其他节点
+		w.Header().Set(headerGroup, group.name)
+		if !group.hasLocally(key) {
+			w.WriteHeader(http.StatusNotFound)
+		}
 		return
 	}
 
-	// 将值作为 proto 消息写入响应体。
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	atomic.AddInt64(&p.stats.ServerRequests, 1)
+	view, info, err := group.GetWithInfo(key)
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// 带上 group 头部，与不存在该 group 的 404 区分开
+			w.Header().Set(headerGroup, group.name)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
+	// 将值作为 pb.Response 消息写入响应体。
+	if !p.fits(w, int64(view.Len()+metaBytes(info.Meta))) {
+		return
+	}
+	p.writeMessage(w, &pb.Response{Value: group.responseBytes(view), Meta: info.Meta}, func(h http.Header) {
+		setInfoHeaders(h, group.name, view, info)
+	})
+}
+
+// ValidGroupName 检查 group 名字能否在 URL 中无歧义地传递：不能为空，也不能与 HTTPPool 的调试接口同名。
+// NewGroup 对不合法的名字 panic，配置等外部输入可以先用它检查
+func ValidGroupName(name string) error {
+	switch name {
+	case "":
+		return errors.New("geecache: empty group name")
+	case pingPath, selfCheckPath, topKeysPath, healthPath:
+		return fmt.Errorf("geecache: group name %q is reserved", name)
+	}
+	return nil
+}
+
+// escapeSegment 转义 URL 路径中的一段，"/" 也被转义；"." 和 ".." 被转义为 %2E，避免被当作相对路径清理掉
+func escapeSegment(s string) string {
+	if s == "." || s == ".." {
+		return strings.Repeat("%2E", len(s))
+	}
+	return url.PathEscape(s)
+}
+
+// unescapeSegment 还原 escapeSegment 转义的一段路径，失败时返回 400
+func unescapeSegment(w http.ResponseWriter, s string) (string, bool) {
+	u, err := url.PathUnescape(s)
+	if err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return u, true
+}
+
+// writeMessage 先完整编码 m 再写入响应，编码失败时响应 500 并用 headerError 标记为编码错误，
+// 此前不会写入任何状态或头部。header 不为 nil 时在编码成功后设置额外的头部。
+func (p *HTTPPool) writeMessage(w http.ResponseWriter, m proto.Message, header func(http.Header)) {
+	codec := codecOrDefault(p.codec)
+	body, err := codec.Marshal(m)
+	if err != nil {
+		w.Header().Set(headerError, errorCodec)
+		http.Error(w, "encoding response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", codec.ContentType())
+	if header != nil {
+		header(w.Header())
+	}
 	w.Write(body)
 }
 
+// SetMaxResponseBytes 设置单个响应中值的最大总字节数，超过时在编码前以 507 拒绝，
+// 避免编码巨大的值耗尽内存。0 表示不限制，这是默认值。
+func (p *HTTPPool) SetMaxResponseBytes(n int64) {
+	atomic.StoreInt64(&p.maxResponseBytes, n)
+}
+
+// SetClient 设置池访问对等点使用的 http.Client，例如配置了 TLS 的客户端，默认为 http.DefaultClient。
+// 必须在 Set 和开始处理请求之前调用。
+func (p *HTTPPool) SetClient(c *http.Client) {
+	p.client = c
+}
+
+// clientOrDefault 在 c 为 nil 时返回 http.DefaultClient
+func clientOrDefault(c *http.Client) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	return c
+}
+
+// fits 判断 n 字节的值能否放入响应，不能时写入 507
+func (p *HTTPPool) fits(w http.ResponseWriter, n int64) bool {
+	if max := atomic.LoadInt64(&p.maxResponseBytes); max > 0 && n > max {
+		w.Header().Set(headerError, errorTooLarge)
+		http.Error(w, fmt.Sprintf("%v: %d bytes, limit %d", ErrResponseTooLarge, n, max), http.StatusInsufficientStorage)
+		return false
+	}
+	return true
+}
+
+// peerResponseError 将服务端标记的编码错误和响应过大映射为对应的错误，其他响应返回 nil
+func peerResponseError(res *http.Response) error {
+	switch res.Header.Get(headerError) {
+	case errorCodec:
+		return fmt.Errorf("%w: %s", ErrPeerCodec, readErrorBody(res))
+	case errorTooLarge:
+		return fmt.Errorf("%w: %s", ErrResponseTooLarge, readErrorBody(res))
+	}
+	return nil
+}
+
+// readErrorBody 读取 http.Error 写入的错误信息
+func readErrorBody(res *http.Response) string {
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+	return strings.TrimSpace(string(b))
+}
+
+// 响应头，描述对等点如何得到这个值，便于调试和统计
+const (
+	headerSource = "X-Geecache-Source"
+	headerExpire = "X-Geecache-Expire"
+	headerLen    = "X-Geecache-Len"
+	headerGroup  = "X-Geecache-Group"
+	// 元数据的每一项以该前缀加上键作为头部名称
+	headerMetaPrefix = "X-Geecache-Meta-"
+	// 错误响应的类别，与处理请求本身的错误区分开
+	headerError = "X-Geecache-Error"
+	// 前缀失效移除的条目数
+	headerRemoved = "X-Geecache-Removed"
+	// 值没有写入拥有者的缓存，请求方也不应把它写入 hotCache
+	headerUncacheable = "X-Geecache-Uncacheable"
+)
+
+// headerError 的取值
+const (
+	errorCodec    = "codec"
+	errorTooLarge = "too-large"
+)
+
+func setInfoHeaders(h http.Header, group string, view ByteView, info EntryInfo) {
+	h.Set(headerGroup, group)
+	h.Set(headerSource, info.Source.String())
+	h.Set(headerLen, strconv.Itoa(view.Len()))
+	if !info.ExpireAt.IsZero() {
+		h.Set(headerExpire, info.ExpireAt.UTC().Format(time.RFC3339Nano))
+	}
+	for k, v := range info.Meta {
+		h.Set(headerMetaPrefix+k, v)
+	}
+	if info.Uncacheable {
+		h.Set(headerUncacheable, "1")
+	}
+}
+
+// serveSet 处理对等点转发的写入，值只写入本地缓存
+func (p *HTTPPool) serveSet(w http.ResponseWriter, r *http.Request, group *Group, key string, maxValueBytes int64) {
+	if !p.acceptsBody(w, r) {
+		return
+	}
+	codec := codecOrDefault(p.codec)
+	body, ok := p.readBody(w, r)
+	if !ok {
+		return
+	}
+	req := &pb.SetRequest{}
+	if err := codec.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Key = key
+	if maxValueBytes > 0 && int64(len(req.GetValue())) > maxValueBytes {
+		http.Error(w, ErrValueTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	res := &pb.SetResponse{}
+	if err := group.setFromPeer(req, res); err != nil {
+		http.Error(w, err.Error(), setErrorStatus(err))
+		return
+	}
+
+	p.writeMessage(w, res, nil)
+}
+
+// serveBatch 处理批量获取，请求体为 pb.BatchRequest
+func (p *HTTPPool) serveBatch(w http.ResponseWriter, r *http.Request, group *Group) {
+	if !p.acceptsBody(w, r) {
+		return
+	}
+	codec := codecOrDefault(p.codec)
+	body, ok := p.readBody(w, r)
+	if !ok {
+		return
+	}
+	req := &pb.BatchRequest{}
+	if err := codec.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := &pb.BatchResponse{}
+	group.serveBatch(req, res)
+	var n int64
+	for _, v := range res.GetValues() {
+		n += int64(len(v))
+	}
+	if !p.fits(w, n) {
+		return
+	}
+	p.writeMessage(w, res, nil)
+}
+
+// serveDelete 处理对等点转发的删除
+func (p *HTTPPool) serveDelete(w http.ResponseWriter, group *Group, key string) {
+	res := &pb.DeleteResponse{}
+	if err := group.deleteFromPeer(&pb.Request{Group: group.name, Key: key}, res); err != nil {
+		http.Error(w, err.Error(), setErrorStatus(err))
+		return
+	}
+	p.writeMessage(w, res, nil)
+}
+
+// serveInvalidatePrefix 处理对等点广播的前缀失效，移除的数量写在 headerRemoved 中
+func (p *HTTPPool) serveInvalidatePrefix(w http.ResponseWriter, group *Group, prefix string) {
+	w.Header().Set(headerRemoved, strconv.Itoa(group.InvalidatePrefix(prefix)))
+	w.WriteHeader(http.StatusOK)
+}
+
+// servePin 处理对等点转发的固定，pin 参数为固定的毫秒数
+func (p *HTTPPool) servePin(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	ms, err := strconv.ParseInt(r.URL.Query().Get("pin"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad pin ttl: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := group.Pin(key, time.Duration(ms)*time.Millisecond); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			w.Header().Set(headerGroup, group.name)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), setErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setErrorStatus 将写入错误映射为 HTTP 状态码，httpGetter 会做相反的映射
+func setErrorStatus(err error) int {
+	switch err {
+	case ErrReadOnly:
+		return http.StatusForbidden
+	case ErrValueTooLarge, ErrMetaTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrTTLNotAllowed:
+		return http.StatusUnprocessableEntity
+	case ErrPinBudget:
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
 // Set 更新池的对等点列表。
 func (p *HTTPPool) Set(peers ...string) {
+	p.SetPeers(peers...)
+}
+
+// SetPeers 将池的对等点列表更新为 peers。
+// 只增删发生变化的对等点，进行中的请求不受影响；已有的对等点保留对等点文件中设置的权重。
+func (p *HTTPPool) SetPeers(peers ...string) {
+	p.setPeers(false, peers...)
+}
+
+// setPeers 与 SetPeers 相同，rebuilt 为 true 时哈希环已被重建，即使成员没有变化也通知回调
+func (p *HTTPPool) setPeers(rebuilt bool, peers ...string) {
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		weights[peer] = 0
+	}
+	p.setWeightedPeers(weights, rebuilt)
+}
+
+// setWeightedPeers 将对等点列表更新为 weights 中的对等点及其权重，
+// 权重为 0 的对等点保留原来的权重，新加入时为 1
+func (p *HTTPPool) setWeightedPeers(weights map[string]int, rebuilt bool) {
+	p.mu.Lock()
+	p.initPeersLocked()
+	for peer, weight := range weights {
+		if weight == 0 {
+			if weight = p.weights[peer]; weight == 0 {
+				weight = 1
+			}
+			weights[peer] = weight
+		}
+	}
+	var added, removed []string
+	for peer := range p.httpGetters {
+		if _, ok := weights[peer]; !ok {
+			p.peers.Remove(peer)
+			delete(p.httpGetters, peer)
+			removed = append(removed, peer)
+		}
+	}
+	for peer, weight := range weights {
+		if _, ok := p.httpGetters[peer]; ok {
+			if p.weights[peer] == weight {
+				continue
+			}
+			p.peers.Remove(peer)
+		} else {
+			p.httpGetters[peer] = p.newGetter(peer)
+			added = append(added, peer)
+		}
+		p.addPeerLocked(peer, weight)
+		p.weights[peer] = weight
+	}
+	for peer := range p.weights {
+		if _, ok := weights[peer]; !ok {
+			delete(p.weights, peer)
+		}
+	}
+	p.mu.Unlock()
+	p.ringChanged(added, removed, rebuilt)
+}
+
+func (p *HTTPPool) newGetter(peer string) *httpGetter {
+	return &httpGetter{
+		addr:          peer,
+		baseURL:       peer + p.basePath,
+		stats:         &p.stats,
+		token:         p.groupToken,
+		codec:         p.codec,
+		client:        p.client,
+		maxValueBytes: p.groupMaxValueBytes,
+	}
+}
+
+func (p *HTTPPool) initPeersLocked() {
+	if p.peers == nil {
+		p.peers = consistenthash.NewSeeded(p.replicasLocked(), nil, p.hashSeed)
+		p.httpGetters = make(map[string]*httpGetter)
+		p.weights = make(map[string]int)
+	}
+}
+
+func (p *HTTPPool) replicasLocked() int {
+	if p.replicas > 0 {
+		return p.replicas
+	}
+	return defaultReplicas
+}
+
+// addPeerLocked 把对等点加入哈希环，虚拟节点数优先取 peerReplicas 中的覆盖值
+func (p *HTTPPool) addPeerLocked(peer string, weight int) {
+	if n := p.peerReplicas[peer]; n > 0 {
+		p.peers.AddReplicas(peer, n)
+		return
+	}
+	p.peers.AddWeighted(peer, weight)
+}
+
+// PeerOptions 配置哈希环的虚拟节点数和哈希 seed
+type PeerOptions struct {
+	// 每个权重为 1 的对等点的虚拟节点数，为 0 时使用默认值 50
+	Replicas int
+	// 覆盖个别对等点的虚拟节点数，用于配置不一致的集群
+	PeerReplicas map[string]int
+	// 混入哈希的 seed，为空时不使用。多个租户的集群共享节点时，为每个租户设置不同的 seed
+	// 可以让键的分布互不相关；同一个集群的所有节点必须使用相同的 seed
+	HashSeed string
+}
+
+// SetWithOptions 与 SetPeers 相同，同时设置哈希环的虚拟节点数和哈希 seed。
+// 虚拟节点数或 seed 变化时整个哈希环会被重建，键的归属随之变化，OnRingChange 的回调
+// 即使在成员没有变化时也会被调用。
+func (p *HTTPPool) SetWithOptions(opts PeerOptions, peers ...string) {
+	p.mu.Lock()
+	changed := opts.Replicas != p.replicas || opts.HashSeed != p.hashSeed || !sameCounts(opts.PeerReplicas, p.peerReplicas)
+	rebuilt := changed && p.peers != nil
+	p.replicas = opts.Replicas
+	p.hashSeed = opts.HashSeed
+	p.peerReplicas = make(map[string]int, len(opts.PeerReplicas))
+	for peer, n := range opts.PeerReplicas {
+		p.peerReplicas[peer] = n
+	}
+	if rebuilt {
+		p.peers = consistenthash.NewSeeded(p.replicasLocked(), nil, p.hashSeed)
+		for peer, weight := range p.weights {
+			p.addPeerLocked(peer, weight)
+		}
+	}
+	p.mu.Unlock()
+	p.setPeers(rebuilt, peers...)
+}
+
+func sameCounts(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if n, ok := b[k]; !ok || n != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Replicas 返回每个权重为 1 的对等点的虚拟节点数
+func (p *HTTPPool) Replicas() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	return p.replicasLocked()
+}
+
+// RingSize 返回哈希环上虚拟节点的总数
+func (p *HTTPPool) RingSize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return 0
+	}
+	return p.peers.Size()
+}
+
+// AddPeers 向池中加入对等点，已存在的对等点会被忽略。
+func (p *HTTPPool) AddPeers(peers ...string) {
+	p.mu.Lock()
+	p.initPeersLocked()
+	var added []string
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+		if _, ok := p.httpGetters[peer]; ok {
+			continue
+		}
+		p.addPeerLocked(peer, 1)
+		p.httpGetters[peer] = p.newGetter(peer)
+		p.weights[peer] = 1
+		added = append(added, peer)
+	}
+	p.mu.Unlock()
+	p.ringChanged(added, nil, false)
+}
+
+// RemovePeers 从池中移除对等点。
+func (p *HTTPPool) RemovePeers(peers ...string) {
+	p.mu.Lock()
+	var removed []string
+	for _, peer := range peers {
+		if _, ok := p.httpGetters[peer]; !ok {
+			continue
+		}
+		p.peers.Remove(peer)
+		delete(p.httpGetters, peer)
+		delete(p.weights, peer)
+		removed = append(removed, peer)
+	}
+	p.mu.Unlock()
+	p.ringChanged(nil, removed, false)
+}
+
+// OnRingChange 设置哈希环变化后的回调，参数为新加入和被移除的对等点。
+// 哈希环因虚拟节点数或 seed 变化被重建时，两个参数可能都为空。
+// 可以在回调中调用 Group.EvictUnowned 清理本节点不再拥有的键。
+func (p *HTTPPool) OnRingChange(fn func(added, removed []string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onRingChange = fn
+}
+
+// ringChanged 在成员变化或 rebuilt 为 true 时调用 OnRingChange 的回调
+func (p *HTTPPool) ringChanged(added, removed []string, rebuilt bool) {
+	p.mu.Lock()
+	fn := p.onRingChange
+	p.mu.Unlock()
+	if fn != nil && (rebuilt || len(added) > 0 || len(removed) > 0) {
+		sort.Strings(added)
+		sort.Strings(removed)
+		fn(added, removed)
 	}
 }
 
@@ -97,42 +667,428 @@ func (p *HTTPPool) Set(peers ...string) {
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+	if peer := p.ownerLocked(key); peer != "" && peer != p.self {
 		p.Log("Pick peer %s", peer)
 		return p.httpGetters[peer], true
 	}
 	return nil, false
 }
 
+// OwnerOf 返回哈希环上拥有 key 的节点地址，以及它是否是本节点，与 PickPeer 的路由一致，
+// 便于应用把与键相关的工作安排在缓存了它的节点上。没有设置对等点时所有键都属于本节点。
+func (p *HTTPPool) OwnerOf(key string) (addr string, self bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	addr = p.ownerLocked(key)
+	if addr == "" {
+		return p.self, true
+	}
+	return addr, addr == p.self
+}
+
+// Ring 返回当前哈希环的副本，没有设置对等点时返回 nil。在增删节点前后各取一次，
+// 可以用 consistenthash.Moved 计算键的迁移情况。
+func (p *HTTPPool) Ring() *consistenthash.Map {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil
+	}
+	return p.peers.Without()
+}
+
+// KeyHash 返回没有设置 HashSeed 的 HTTPPool 的哈希环对 key 使用的哈希值（CRC-32 IEEE），
+// 外部系统可以用它和 consistenthash.Map.GetHashed 推断键的位置。设置了 HashSeed 时使用 HTTPPool.KeyHash。
+func KeyHash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// KeyHash 返回池的哈希环对 key 使用的哈希值，包含 PeerOptions.HashSeed
+func (p *HTTPPool) KeyHash(key string) uint32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return consistenthash.NewSeeded(p.replicasLocked(), nil, p.hashSeed).Hash(key)
+	}
+	return p.peers.Hash(key)
+}
+
+// ownerLocked 返回拥有 key 的节点，哈希环为空时返回空字符串
+func (p *HTTPPool) ownerLocked(key string) string {
+	if p.peers == nil {
+		return ""
+	}
+	return p.peers.Get(key)
+}
+
+// PickSuccessor 当本节点拥有该键时，返回其在哈希环上的后继节点
+func (p *HTTPPool) PickSuccessor(key string) (PeerSetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	nodes := p.peers.GetN(key, 2)
+	if len(nodes) < 2 || nodes[0] != p.self || nodes[1] == p.self {
+		return nil, false
+	}
+	return p.httpGetters[nodes[1]], true
+}
+
+// ListPeers 返回除本节点以外的所有对等点，按地址排序
+func (p *HTTPPool) ListPeers() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	addrs := make([]string, 0, len(p.httpGetters))
+	for addr := range p.httpGetters {
+		if addr != p.self {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	peers := make([]PeerGetter, len(addrs))
+	for i, addr := range addrs {
+		peers[i] = p.httpGetters[addr]
+	}
+	return peers
+}
+
 var _ PeerPicker = (*HTTPPool)(nil)
+var _ SuccessorPicker = (*HTTPPool)(nil)
+var _ PeerLister = (*HTTPPool)(nil)
 
 type httpGetter struct {
+	addr    string
 	baseURL string
+	stats   *PoolStats // 所属池的统计，可以为 nil
+	// 可选的，返回访问 group 使用的令牌，为空时不带 Authorization 头部
+	token func(group string) string
+	// 消息的编码，为 nil 时使用 ProtoCodec
+	codec Codec
+	// 为 nil 时使用 http.DefaultClient
+	client *http.Client
+	// 可选的，返回本地 group 允许的最大值字节数，用于限制读取的响应大小，0 表示不限制
+	maxValueBytes func(group string) int64
+}
+
+// maxResponseBytes 返回 group 的 GET 响应体允许的最大字节数，0 表示不限制。
+// 响应中除了值还有元数据，JSONCodec 会把值编码为 base64，因此留出值上限一倍的余量
+func (h *httpGetter) maxResponseBytes(group string) int64 {
+	if h.maxValueBytes == nil {
+		return 0
+	}
+	max := h.maxValueBytes(group)
+	if max <= 0 {
+		return 0
+	}
+	return 2*max + 2*maxMetaBytes + 1<<10
+}
+
+// newRequest 创建发往对等点的请求，group 配置了令牌时带上 Authorization 头部
+func (h *httpGetter) newRequest(method, u, group string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if h.token != nil {
+		if token := h.token(group); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	return req, nil
+}
+
+// recordSource 根据远程节点返回的来源统计这次获取
+func (h *httpGetter) recordSource(src string) {
+	if h.stats == nil {
+		return
+	}
+	atomic.AddInt64(&h.stats.PeerFetches, 1)
+	switch src {
+	case SourceLocalCache.String(), SourceHotCache.String():
+		atomic.AddInt64(&h.stats.RemoteHits, 1)
+	case SourceLocalLoad.String():
+		atomic.AddInt64(&h.stats.RemoteLoads, 1)
+	case SourcePeer.String():
+		atomic.AddInt64(&h.stats.RemotePeerHops, 1)
+	}
+}
+
+// String 返回对等点的地址
+func (h *httpGetter) String() string {
+	return h.addr
 }
 
 func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	u := fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
+		escapeSegment(in.GetGroup()),
+		escapeSegment(in.GetKey()),
 	)
-	res, err := http.Get(u)
+	req, err := h.newRequest(http.MethodGet, u, in.GetGroup(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := clientOrDefault(h.client).Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
+	if err := peerResponseError(res); err != nil {
+		return err
+	}
 
+	if res.StatusCode == http.StatusNotFound && res.Header.Get(headerGroup) != "" {
+		return ErrNotFound
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		return ErrThrottled
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	h.recordSource(res.Header.Get(headerSource))
+
+	body := io.Reader(res.Body)
+	limit := h.maxResponseBytes(in.GetGroup())
+	if limit > 0 {
+		body = io.LimitReader(res.Body, limit+1)
+	}
+	bytes, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if limit > 0 && int64(len(bytes)) > limit {
+		return ErrValueTooLarge
+	}
+
+	if err = codecOrDefault(h.codec).Unmarshal(bytes, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	if res.Header.Get(headerUncacheable) != "" {
+		return Uncacheable(nil)
+	}
+
+	return nil
+}
+
+// Has 用 HEAD 请求查询对等点是否缓存了键
+func (h *httpGetter) Has(in *pb.Request, out *pb.HasResponse) error {
+	u := fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		escapeSegment(in.GetGroup()),
+		escapeSegment(in.GetKey()),
+	)
+	req, err := h.newRequest(http.MethodHead, u, in.GetGroup(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := clientOrDefault(h.client).Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+		out.Exists = true
+	case res.StatusCode == http.StatusNotFound && res.Header.Get(headerGroup) != "":
+		// 没有该头部的 404 表示对等点上不存在这个 group
+		out.Exists = false
+	default:
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+// Delete 用 DELETE 请求删除对等点上的键
+func (h *httpGetter) Delete(in *pb.Request, out *pb.DeleteResponse) error {
+	u := fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		escapeSegment(in.GetGroup()),
+		escapeSegment(in.GetKey()),
+	)
+	req, err := h.newRequest(http.MethodDelete, u, in.GetGroup(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := clientOrDefault(h.client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := peerResponseError(res); err != nil {
+		return err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return ErrReadOnly
+	default:
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+
+	if err = codecOrDefault(h.codec).Unmarshal(b, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+
+	return nil
+}
+
+// InvalidatePrefix 用带 prefix 参数的 DELETE 请求移除对等点上以 prefix 开头的键
+func (h *httpGetter) InvalidatePrefix(group, prefix string) (int, error) {
+	u := fmt.Sprintf(
+		"%v%v/%v?prefix=1",
+		h.baseURL,
+		escapeSegment(group),
+		escapeSegment(prefix),
+	)
+	req, err := h.newRequest(http.MethodDelete, u, group, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := clientOrDefault(h.client).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if err := peerResponseError(res); err != nil {
+		return 0, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned: %v", res.Status)
+	}
+	n, err := strconv.Atoi(res.Header.Get(headerRemoved))
+	if err != nil {
+		return 0, fmt.Errorf("decoding %s header: %v", headerRemoved, err)
+	}
+	return n, nil
+}
+
+// Pin 用带 pin 参数的 PUT 请求在对等点上固定键
+func (h *httpGetter) Pin(group, key string, ttl time.Duration) error {
+	u := fmt.Sprintf(
+		"%v%v/%v?pin=%d",
+		h.baseURL,
+		escapeSegment(group),
+		escapeSegment(key),
+		ttlMillis(ttl),
+	)
+	req, err := h.newRequest(http.MethodPut, u, group, nil)
+	if err != nil {
+		return err
+	}
+	res, err := clientOrDefault(h.client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := peerResponseError(res); err != nil {
+		return err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return nil
+	case res.StatusCode == http.StatusNotFound && res.Header.Get(headerGroup) != "":
+		return ErrNotFound
+	case res.StatusCode == http.StatusInsufficientStorage:
+		return ErrPinBudget
+	}
+	return fmt.Errorf("server returned: %v", res.Status)
+}
+
+func (h *httpGetter) Set(in *pb.SetRequest, out *pb.SetResponse) error {
+	u := fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		escapeSegment(in.GetGroup()),
+		escapeSegment(in.GetKey()),
+	)
+	body, err := codecOrDefault(h.codec).Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	req, err := h.newRequest(http.MethodPut, u, in.GetGroup(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", codecOrDefault(h.codec).ContentType())
+	res, err := clientOrDefault(h.client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := peerResponseError(res); err != nil {
+		return err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return ErrReadOnly
+	case http.StatusRequestEntityTooLarge:
+		return ErrValueTooLarge
+	case http.StatusUnprocessableEntity:
+		return ErrTTLNotAllowed
+	default:
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+
+	if err = codecOrDefault(h.codec).Unmarshal(b, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+
+	return nil
+}
+
+func (h *httpGetter) GetBatch(in *pb.BatchRequest, out *pb.BatchResponse) error {
+	u := fmt.Sprintf("%v%v/", h.baseURL, escapeSegment(in.GetGroup()))
+	body, err := codecOrDefault(h.codec).Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	req, err := h.newRequest(http.MethodPost, u, in.GetGroup(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", codecOrDefault(h.codec).ContentType())
+	res, err := clientOrDefault(h.client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := peerResponseError(res); err != nil {
+		return err
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return ErrThrottled
+	}
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("server returned: %v", res.Status)
 	}
 
-	bytes, err := ioutil.ReadAll(res.Body)
+	b, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return fmt.Errorf("reading response body: %v", err)
 	}
 
-	if err = proto.Unmarshal(bytes, out); err != nil {
+	if err = codecOrDefault(h.codec).Unmarshal(b, out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
 
@@ -140,3 +1096,9 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 }
 
 var _ PeerGetter = (*httpGetter)(nil)
+var _ BatchPeerGetter = (*httpGetter)(nil)
+var _ PeerSetter = (*httpGetter)(nil)
+var _ PeerChecker = (*httpGetter)(nil)
+var _ PeerDeleter = (*httpGetter)(nil)
+var _ PeerPrefixInvalidator = (*httpGetter)(nil)
+var _ PeerPinner = (*httpGetter)(nil)
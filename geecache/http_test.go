@@ -0,0 +1,1152 @@
+package geecache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestHTTPSet(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	gee := NewGroup("http-set", 2<<10, getter, WithMaxValueBytes(8))
+	NewGroup("http-set-ro", 2<<10, getter, WithReadOnly())
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	res := &pb.SetResponse{}
+	if err := peer.Set(&pb.SetRequest{Group: "http-set", Key: "Tom", Value: []byte("630")}, res); err != nil || !res.Stored {
+		t.Fatalf("set over http failed: %+v %v", res, err)
+	}
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("value set over http not cached")
+	}
+
+	if err := peer.Set(&pb.SetRequest{Group: "http-set", Key: "Jack", Value: []byte("0123456789")}, res); err != ErrValueTooLarge {
+		t.Fatalf("expect ErrValueTooLarge, but %v got", err)
+	}
+	if err := peer.Set(&pb.SetRequest{Group: "http-set-ro", Key: "Tom", Value: []byte("630")}, res); err != ErrReadOnly {
+		t.Fatalf("expect ErrReadOnly, but %v got", err)
+	}
+}
+
+func TestHTTPGetBatch(t *testing.T) {
+	NewGroup("http-batch", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "unknown" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte("v-" + key), nil
+	}))
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	res := &pb.BatchResponse{}
+	req := &pb.BatchRequest{Group: "http-batch", Keys: []string{"Tom", "Jack", "unknown"}}
+	if err := peer.GetBatch(req, res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Values["Tom"]) != "v-Tom" || string(res.Values["Jack"]) != "v-Jack" || res.Errors["unknown"] == "" {
+		t.Fatalf("unexpected batch response %v", res)
+	}
+}
+
+// ownerPeer 把所有键路由到 group 所在的 HTTP 服务端，使进程内另一个名字的 Group 可以把它当作拥有者
+type ownerPeer struct {
+	*httpGetter
+	group string
+}
+
+func (p ownerPeer) Get(in *pb.Request, out *pb.Response) error {
+	return p.httpGetter.Get(&pb.Request{Group: p.group, Key: in.GetKey()}, out)
+}
+
+func (p ownerPeer) GetBatch(in *pb.BatchRequest, out *pb.BatchResponse) error {
+	return p.httpGetter.GetBatch(&pb.BatchRequest{Group: p.group, Keys: in.GetKeys()}, out)
+}
+
+func (p ownerPeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, true
+}
+
+func TestHTTPUncacheable(t *testing.T) {
+	NewGroup("http-uncacheable", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if strings.HasPrefix(key, "partial") {
+			return []byte("part"), Uncacheable(nil)
+		}
+		return []byte("v-" + key), nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "http-uncacheable", Key: "partial"}, res); !uncacheableValue(err) || string(res.Value) != "part" {
+		t.Fatalf("expect the value marked uncacheable, got %q %v", res.Value, err)
+	}
+	if err := peer.Get(&pb.Request{Group: "http-uncacheable", Key: "Tom"}, res); err != nil {
+		t.Fatal(err)
+	}
+
+	// 请求方照常返回值，但只把可缓存的值写入 hotCache
+	gee := NewGroup("http-uncacheable-client", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("unexpected local load of %s", key)
+	}), WithHotCache(2<<10))
+	defer gee.Close()
+	gee.RegisterPeers(ownerPeer{peer, "http-uncacheable"})
+	if v, info, err := gee.GetWithInfo("partial"); err != nil || v.String() != "part" || !info.Uncacheable {
+		t.Fatalf("expect the uncacheable value from the owner, got %q %+v %v", v.String(), info, err)
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "v-Tom" {
+		t.Fatalf("expect v-Tom, got %q %v", v.String(), err)
+	}
+	values, err := gee.GetMulti([]string{"partial2", "Jack"})
+	if err != nil || values["partial2"].String() != "part" || values["Jack"].String() != "v-Jack" {
+		t.Fatalf("unexpected batch result %v %v", values, err)
+	}
+	for key, cached := range map[string]bool{"partial": false, "partial2": false, "Tom": true, "Jack": true} {
+		if _, ok := gee.hotCache.get(key); ok != cached {
+			t.Fatalf("%s: expect cached in hotCache to be %v", key, cached)
+		}
+	}
+}
+
+func TestRingChange(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	var changes [][2][]string
+	pool.OnRingChange(func(added, removed []string) {
+		changes = append(changes, [2][]string{added, removed})
+	})
+
+	pool.Set("http://a", "http://b")
+	pool.AddPeers("http://c", "http://b")
+	pool.RemovePeers("http://b", "http://d")
+	pool.Set("http://a", "http://d")
+
+	expect := [][2][]string{
+		{{"http://a", "http://b"}, nil},
+		{{"http://c"}, nil},
+		{nil, {"http://b"}},
+		{{"http://d"}, {"http://c"}},
+	}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("expect ring changes %v, but %v got", expect, changes)
+	}
+	if peer, ok := pool.PickPeer("any"); ok && peer.(*httpGetter).addr != "http://d" {
+		t.Fatalf("removed peer should not be picked")
+	}
+
+	ring := pool.Ring()
+	pool.AddPeers("http://e")
+	if ring.Contains("http://e") || !pool.Ring().Contains("http://e") {
+		t.Fatalf("expect Ring to return a snapshot")
+	}
+}
+
+func TestHTTPInfoHeaders(t *testing.T) {
+	NewGroup("http-info", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v-" + key), nil
+	}))
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	client := NewHTTPPool("client")
+	peer := client.newGetter(srv.URL)
+
+	for i, src := range []string{"local-load", "local-cache"} {
+		res, err := http.Get(srv.URL + defaultBasePath + "http-info/Tom")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		h := res.Header
+		if h.Get(headerSource) != src || h.Get(headerLen) != "5" || h.Get(headerGroup) != "http-info" {
+			t.Fatalf("request %d: unexpected headers %v", i, h)
+		}
+		if h.Get(headerExpire) != "" {
+			t.Fatalf("expect no expire header without ttl, but %q got", h.Get(headerExpire))
+		}
+	}
+
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "http-info", Key: "Jack"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Get(&pb.Request{Group: "http-info", Key: "Jack"}, res); err != nil {
+		t.Fatal(err)
+	}
+	stats := client.Stats()
+	if stats.PeerFetches != 2 || stats.RemoteLoads != 1 || stats.RemoteHits != 1 {
+		t.Fatalf("unexpected client stats %+v", stats)
+	}
+}
+
+func TestHTTPHas(t *testing.T) {
+	loads := 0
+	gee := NewGroup("http-has", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}))
+	gee.Set("Tom", []byte("630"), 0)
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	for key, expect := range map[string]bool{"Tom": true, "unknown": false} {
+		res := &pb.HasResponse{}
+		if err := peer.Has(&pb.Request{Group: "http-has", Key: key}, res); err != nil || res.Exists != expect {
+			t.Fatalf("Has(%s) = %v, %v, want %v", key, res.Exists, err, expect)
+		}
+	}
+	if loads != 0 {
+		t.Fatalf("HEAD must not load, but getter called %d times", loads)
+	}
+	if err := peer.Has(&pb.Request{Group: "no-such-group", Key: "Tom"}, &pb.HasResponse{}); err == nil {
+		t.Fatalf("expect error for unknown group")
+	}
+}
+
+func TestHTTPNotFound(t *testing.T) {
+	NewGroup("http-not-found", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}))
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	if err := peer.Get(&pb.Request{Group: "http-not-found", Key: "Tom"}, &pb.Response{}); err != ErrNotFound {
+		t.Fatalf("expect ErrNotFound from owner, but %v got", err)
+	}
+	if err := peer.Get(&pb.Request{Group: "no-such-group", Key: "Tom"}, &pb.Response{}); err == nil || err == ErrNotFound {
+		t.Fatalf("expect transport error for unknown group, but %v got", err)
+	}
+}
+
+func TestSetWithOptions(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	pool.Set("http://a", "http://b")
+	if pool.Replicas() != defaultReplicas || pool.RingSize() != 2*defaultReplicas {
+		t.Fatalf("unexpected default ring: %d replicas, %d vnodes", pool.Replicas(), pool.RingSize())
+	}
+
+	pool.SetWithOptions(PeerOptions{Replicas: 10, PeerReplicas: map[string]int{"http://c": 30}},
+		"http://a", "http://b", "http://c")
+	if pool.Replicas() != 10 || pool.RingSize() != 10+10+30 {
+		t.Fatalf("unexpected ring: %d replicas, %d vnodes", pool.Replicas(), pool.RingSize())
+	}
+
+	// 移除覆盖后恢复为按副本数计算
+	pool.SetWithOptions(PeerOptions{Replicas: 10}, "http://a", "http://b", "http://c")
+	if pool.RingSize() != 30 {
+		t.Fatalf("expect 30 vnodes, but %d got", pool.RingSize())
+	}
+
+	// 改变 seed 时哈希环按新的 seed 重建，成员没有变化也会通知
+	changes := 0
+	pool.OnRingChange(func(added, removed []string) { changes++ })
+	pool.SetWithOptions(PeerOptions{Replicas: 10, HashSeed: "tenant"}, "http://a", "http://b", "http://c")
+	if changes != 1 {
+		t.Fatalf("expect a rebuild to notify once, got %d", changes)
+	}
+	pool.SetWithOptions(PeerOptions{Replicas: 10, HashSeed: "tenant"}, "http://a", "http://b", "http://c")
+	if changes != 1 {
+		t.Fatalf("expect no notification without changes, got %d", changes)
+	}
+	expect := consistenthash.NewSeeded(10, nil, "tenant")
+	expect.Add("http://a", "http://b", "http://c")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key", i)
+		if addr, _ := pool.OwnerOf(key); addr != expect.Get(key) {
+			t.Fatalf("%s: expect the seeded ring to own it on %s, got %s", key, expect.Get(key), addr)
+		}
+		if addr, _ := pool.OwnerOf(key); expect.GetHashed(pool.KeyHash(key)) != addr {
+			t.Fatalf("%s: expect KeyHash to use the seeded hash", key)
+		}
+	}
+
+	// SetPeers 和重建都保留对等点文件中设置的权重
+	if err := pool.applyPeersFile([]byte(`["http://a", {"addr": "http://b", "weight": 3}]`)); err != nil {
+		t.Fatal(err)
+	}
+	pool.SetPeers("http://a", "http://b", "http://c")
+	if pool.RingSize() != 10+30+10 {
+		t.Fatalf("expect b to keep weight 3, got %d vnodes", pool.RingSize())
+	}
+	pool.SetWithOptions(PeerOptions{Replicas: 20}, "http://a", "http://b", "http://c")
+	if pool.RingSize() != 20+60+20 {
+		t.Fatalf("expect b to keep weight 3 after a rebuild, got %d vnodes", pool.RingSize())
+	}
+}
+
+func TestHTTPDelete(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	gee := NewGroup("http-delete", 2<<10, getter)
+	NewGroup("http-delete-ro", 2<<10, getter, WithReadOnly())
+	gee.Set("Tom", []byte("630"), 0)
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	res := &pb.DeleteResponse{}
+	if err := peer.Delete(&pb.Request{Group: "http-delete", Key: "Tom"}, res); err != nil || !res.Deleted {
+		t.Fatalf("delete over http failed: %+v %v", res, err)
+	}
+	if ok, _ := gee.Has("Tom"); ok {
+		t.Fatalf("expect Tom to be deleted")
+	}
+	if err := peer.Delete(&pb.Request{Group: "http-delete-ro", Key: "Tom"}, res); err != ErrReadOnly {
+		t.Fatalf("expect ErrReadOnly, but %v got", err)
+	}
+}
+
+// throttledPeer 总是返回 ErrThrottled
+type throttledPeer struct {
+	calls int32
+}
+
+func (p *throttledPeer) Get(in *pb.Request, out *pb.Response) error {
+	atomic.AddInt32(&p.calls, 1)
+	return ErrThrottled
+}
+
+func (p *throttledPeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, true
+}
+
+func TestHTTPRateLimit(t *testing.T) {
+	NewGroup("http-limited", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	NewGroup("http-unlimited", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+
+	pool := NewHTTPPool("self")
+	pool.SetRateLimits(map[string]RateLimit{"http-limited": {Rate: 20, Burst: 2}})
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	get := func(group string) error {
+		return peer.Get(&pb.Request{Group: group, Key: "Tom"}, &pb.Response{})
+	}
+	for i := 0; i < 2; i++ {
+		if err := get("http-limited"); err != nil {
+			t.Fatalf("expect burst to be allowed, got %v", err)
+		}
+	}
+	if err := get("http-limited"); err != ErrThrottled {
+		t.Fatalf("expect ErrThrottled over the limit, got %v", err)
+	}
+	res, err := http.Get(srv.URL + defaultBasePath + "http-limited/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "1" {
+		t.Fatalf("expect 429 with Retry-After, got %v %q", res.Status, res.Header.Get("Retry-After"))
+	}
+	// 其他 group 不受影响
+	if err := get("http-unlimited"); err != nil {
+		t.Fatal(err)
+	}
+	if n := pool.Stats().Throttled["http-limited"]; n != 2 {
+		t.Fatalf("expect 2 throttled requests, got %d", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := get("http-limited"); err != nil {
+		t.Fatalf("expect recovery after refill, got %v", err)
+	}
+}
+
+func TestThrottledPeerFallback(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local-" + key), nil
+	})
+	peer := &throttledPeer{}
+	gee := NewGroup("throttled-fallback", 2<<10, getter, WithPeerErrorPolicy(FallbackAfterRetries(3)))
+	gee.RegisterPeers(peer)
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "local-Tom" {
+		t.Fatalf("expect local fallback, got %v, %v", v, err)
+	}
+	if peer.calls != 1 {
+		t.Fatalf("expect no retries against a throttling peer, got %d calls", peer.calls)
+	}
+
+	failFast := NewGroup("throttled-failfast", 2<<10, getter, WithPeerErrorPolicy(FailFast))
+	failFast.RegisterPeers(peer)
+	if _, err := failFast.Get("Tom"); err != ErrThrottled {
+		t.Fatalf("expect ErrThrottled with FailFast, got %v", err)
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	healthy := httptest.NewServer(NewHTTPPool("healthy"))
+	defer healthy.Close()
+	var brokenCalls int32
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&brokenCalls, 1)
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+
+	pool := NewHTTPPool("self")
+	pool.Set("self", healthy.URL, broken.URL, downURL)
+	checks := pool.SelfCheck(context.Background())
+	if len(checks) != 3 {
+		t.Fatalf("expect 3 checks without self, got %+v", checks)
+	}
+	byPeer := make(map[string]PeerCheck)
+	for _, c := range checks {
+		byPeer[c.Peer] = c
+	}
+	if c := byPeer[healthy.URL]; !c.OK || !c.Compatible || c.Latency <= 0 || c.Error != "" {
+		t.Fatalf("unexpected check for healthy peer: %+v", c)
+	}
+	if c := byPeer[broken.URL]; c.OK || !c.Reachable || c.Compatible || c.Error == "" {
+		t.Fatalf("unexpected check for broken peer: %+v", c)
+	}
+	if c := byPeer[downURL]; c.OK || c.Reachable || c.Error == "" {
+		t.Fatalf("unexpected check for unreachable peer: %+v", c)
+	}
+	if ChecksPassed(checks) {
+		t.Fatal("expect self check to fail")
+	}
+
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	get := func(token string) int {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+defaultBasePath+selfCheckPath, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := get(""); code != http.StatusServiceUnavailable {
+		t.Fatalf("expect 503 from the debug route, got %d", code)
+	}
+
+	// 调试接口按 "_selfcheck" 的配置鉴权，短时间内的请求复用上一次的结果
+	pool.ConfigureGroup(selfCheckPath, GroupServeConfig{Token: "secret"})
+	calls := atomic.LoadInt32(&brokenCalls)
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 without the token, got %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := get("secret"); code != http.StatusServiceUnavailable {
+			t.Fatalf("expect 503 with the token, got %d", code)
+		}
+	}
+	if n := atomic.LoadInt32(&brokenCalls); n != calls {
+		t.Fatalf("expect repeated requests to reuse the last self check, peer pinged %d more times", n-calls)
+	}
+}
+
+func TestHTTPMeta(t *testing.T) {
+	gee := NewGroup("http-meta", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	meta := map[string]string{"encoding": "gzip"}
+	req := &pb.SetRequest{Group: "http-meta", Key: "Tom", Value: []byte("630"), Meta: meta}
+	if err := peer.Set(req, &pb.SetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, info, _ := gee.GetWithInfo("Tom"); info.Meta["encoding"] != "gzip" {
+		t.Fatalf("expect metadata set over http, got %v", info.Meta)
+	}
+
+	out := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "http-meta", Key: "Tom"}, out); err != nil || out.Meta["encoding"] != "gzip" {
+		t.Fatalf("expect metadata in response, got %v, %v", out.Meta, err)
+	}
+	res, err := http.Get(srv.URL + defaultBasePath + "http-meta/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if h := res.Header.Get(headerMetaPrefix + "encoding"); h != "gzip" {
+		t.Fatalf("expect metadata header, got %q", h)
+	}
+}
+
+func TestTopKeysEndpoint(t *testing.T) {
+	gee := NewGroup("top-keys-http", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("uncacheable")
+	}), WithLoadTracking(8, 0))
+	for i := 0; i < 3; i++ {
+		gee.Get("a")
+	}
+	gee.Get("b")
+
+	pool := NewHTTPPool("self")
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, defaultBasePath+"_topkeys/top-keys-http?n=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	var top []KeyCount
+	if err := json.Unmarshal(w.Body.Bytes(), &top); err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0] != (KeyCount{"a", 3}) {
+		t.Fatalf("unexpected top keys %+v", top)
+	}
+
+	// 与普通请求一样需要令牌
+	pool.ConfigureGroup("top-keys-http", GroupServeConfig{Token: "secret"})
+	w = httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, defaultBasePath+"_topkeys/top-keys-http", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 without a token, got %d", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, defaultBasePath+"_topkeys/top-keys-http", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	pool.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect 200 with the token, got %d", w.Code)
+	}
+	pool.ConfigureGroup("top-keys-http", GroupServeConfig{Disabled: true})
+	w = httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, defaultBasePath+"_topkeys/top-keys-http", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expect 403 for a disabled group, got %d", w.Code)
+	}
+}
+
+func TestPeerResponseLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := 8
+		if strings.HasSuffix(r.URL.Path, "/big") {
+			size = 1 << 20
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: bytes.Repeat([]byte("x"), size)})
+		w.Write(body)
+	}))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath, maxValueBytes: func(group string) int64 {
+		return 16
+	}}
+
+	out := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "limit", Key: "small"}, out); err != nil || len(out.GetValue()) != 8 {
+		t.Fatalf("expect a small value within the limit, got %d bytes %v", len(out.GetValue()), err)
+	}
+	if err := peer.Get(&pb.Request{Group: "limit", Key: "big"}, &pb.Response{}); err != ErrValueTooLarge {
+		t.Fatalf("expect ErrValueTooLarge for an oversized response, got %v", err)
+	}
+	// 没有上限的 group 照常读取完整的响应
+	peer.maxValueBytes = func(group string) int64 { return 0 }
+	if err := peer.Get(&pb.Request{Group: "limit", Key: "big"}, out); err != nil || len(out.GetValue()) != 1<<20 {
+		t.Fatalf("expect the full value without a limit, got %d bytes %v", len(out.GetValue()), err)
+	}
+
+	// 池创建的对等点使用本地 group 的 maxValueBytes
+	pool := NewHTTPPool("self")
+	pool.groups = func(name string) *Group {
+		return &Group{name: name, maxValueBytes: 16}
+	}
+	if got := pool.newGetter(srv.URL).maxResponseBytes("limit"); got <= 16 || got >= 1<<20 {
+		t.Fatalf("expect the pool to limit responses by the group's max value size, got %d", got)
+	}
+}
+
+func TestConfigureGroup(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	NewGroup("serve-auth", 2<<10, getter)
+	NewGroup("serve-ro", 2<<10, getter)
+	NewGroup("serve-off", 2<<10, getter)
+
+	pool := NewHTTPPool("self")
+	pool.ConfigureGroup("serve-auth", GroupServeConfig{Token: "secret", MaxValueBytes: 4})
+	pool.ConfigureGroup("serve-ro", GroupServeConfig{Methods: []string{http.MethodGet, http.MethodHead}})
+	pool.ConfigureGroup("serve-off", GroupServeConfig{Disabled: true})
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	// 与池创建的对等点一样，自动带上配置的令牌
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath, token: pool.groupToken}
+	anonymous := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	if err := peer.Get(&pb.Request{Group: "serve-auth", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatalf("expect the token to be accepted, got %v", err)
+	}
+	if err := anonymous.Get(&pb.Request{Group: "serve-auth", Key: "Tom"}, &pb.Response{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expect 401 without the token, got %v", err)
+	}
+	if err := peer.Set(&pb.SetRequest{Group: "serve-auth", Key: "k", Value: []byte("v")}, &pb.SetResponse{}); err != nil {
+		t.Fatalf("expect a small write to succeed, got %v", err)
+	}
+	if err := peer.Set(&pb.SetRequest{Group: "serve-auth", Key: "k", Value: []byte("too long")}, &pb.SetResponse{}); err != ErrValueTooLarge {
+		t.Fatalf("expect ErrValueTooLarge above MaxValueBytes, got %v", err)
+	}
+
+	if err := anonymous.Get(&pb.Request{Group: "serve-ro", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatalf("expect reads of the read-only group to succeed, got %v", err)
+	}
+	if err := anonymous.Set(&pb.SetRequest{Group: "serve-ro", Key: "k", Value: []byte("v")}, &pb.SetResponse{}); err == nil || !strings.Contains(err.Error(), "405") {
+		t.Fatalf("expect 405 for a write to the read-only group, got %v", err)
+	}
+
+	res, err := http.Get(srv.URL + defaultBasePath + "serve-off/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expect 403 for a disabled group, got %d", res.StatusCode)
+	}
+
+	expect := map[string]int64{"serve-auth": 4, "serve-ro": 2, "serve-off": 1}
+	if got := pool.Stats().GroupRequests; !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expect per-group requests %v, got %v", expect, got)
+	}
+}
+
+func TestHTTPCodec(t *testing.T) {
+	NewGroup("http-codec", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v-" + key), nil
+	}))
+	pool := NewHTTPPool("self")
+	pool.SetCodec(JSONCodec{})
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath, codec: JSONCodec{}}
+
+	out := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "http-codec", Key: "Tom"}, out); err != nil || string(out.GetValue()) != "v-Tom" {
+		t.Fatalf("expect v-Tom over JSON, got %q %v", out.GetValue(), err)
+	}
+	if err := peer.Set(&pb.SetRequest{Group: "http-codec", Key: "k", Value: []byte("set")}, &pb.SetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	batch := &pb.BatchResponse{}
+	if err := peer.GetBatch(&pb.BatchRequest{Group: "http-codec", Keys: []string{"k", "Jack"}}, batch); err != nil {
+		t.Fatal(err)
+	}
+	if v := batch.GetValues(); string(v["k"]) != "set" || string(v["Jack"]) != "v-Jack" {
+		t.Fatalf("unexpected batch values %q", v)
+	}
+
+	// 非 Go 的客户端可以直接读取 JSON 响应
+	res, err := http.Get(srv.URL + defaultBasePath + "http-codec/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct{ Value []byte }
+	err = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if err != nil || res.Header.Get("Content-Type") != "application/json" || string(body.Value) != "v-Tom" {
+		t.Fatalf("unexpected JSON response %q %q %v", res.Header.Get("Content-Type"), body.Value, err)
+	}
+
+	// 编码不一致的对等点得到 415
+	protoPeer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+	if err := protoPeer.Set(&pb.SetRequest{Group: "http-codec", Key: "k", Value: []byte("x")}, &pb.SetResponse{}); err == nil || !strings.Contains(err.Error(), "415") {
+		t.Fatalf("expect 415 for a mismatched codec, got %v", err)
+	}
+}
+
+// failingCodec 无法编码响应消息，用来模拟服务端编码失败
+type failingCodec struct{ ProtoCodec }
+
+func (failingCodec) Marshal(m proto.Message) ([]byte, error) {
+	if _, ok := m.(*pb.Response); ok {
+		return nil, errors.New("boom")
+	}
+	return proto.Marshal(m)
+}
+
+func TestHTTPResponseErrors(t *testing.T) {
+	NewGroup("http-resp-errors", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(strings.Repeat("x", len(key))), nil
+	}))
+	pool := NewHTTPPool("self")
+	pool.SetCodec(failingCodec{})
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+
+	err := peer.Get(&pb.Request{Group: "http-resp-errors", Key: "Tom"}, &pb.Response{})
+	if !errors.Is(err, ErrPeerCodec) || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expect ErrPeerCodec with the cause, got %v", err)
+	}
+
+	pool.SetCodec(nil)
+	pool.SetMaxResponseBytes(8)
+	if err := peer.Get(&pb.Request{Group: "http-resp-errors", Key: "small"}, &pb.Response{}); err != nil {
+		t.Fatalf("expect a small value to be served, got %v", err)
+	}
+	err = peer.Get(&pb.Request{Group: "http-resp-errors", Key: "a-very-long-key"}, &pb.Response{})
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("expect ErrResponseTooLarge, got %v", err)
+	}
+	err = peer.GetBatch(&pb.BatchRequest{Group: "http-resp-errors", Keys: []string{"small", "values"}}, &pb.BatchResponse{})
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("expect ErrResponseTooLarge for a batch over the limit, got %v", err)
+	}
+}
+
+func TestHTTPRequestLimits(t *testing.T) {
+	NewGroup("http-limits", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	pool := NewHTTPPool("self")
+	pool.SetRequestLimits(RequestLimits{MaxBytes: 1 << 10, BodyTimeout: 50 * time.Millisecond})
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	u := srv.URL + defaultBasePath + "http-limits/k"
+
+	// 声明的长度超过上限，不读取请求体就拒绝
+	res, err := http.DefaultClient.Do(mustRequest(t, http.MethodPut, u, bytes.NewReader(make([]byte, 4<<10))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bodyError
+	json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge || body.Limit != 1<<10 {
+		t.Fatalf("expect 413 with the limit, got %d %+v", res.StatusCode, body)
+	}
+
+	// 没有 Content-Length 的流式请求体
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(make([]byte, 4<<10))
+		pw.Close()
+	}()
+	res, err = http.DefaultClient.Do(mustRequest(t, http.MethodPut, u, pr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusLengthRequired {
+		t.Fatalf("expect 411 without Content-Length, got %d", res.StatusCode)
+	}
+
+	// 慢速客户端只发送部分请求体后停止
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "PUT %shttp-limits/k HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n\r\nonly ten..", defaultBasePath)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("expect a response before the client finishes the body, got %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestTimeout || time.Since(start) > time.Second {
+		t.Fatalf("expect a prompt 408, got %d after %v", res.StatusCode, time.Since(start))
+	}
+	// 超时由连接的读取截止时间触发，响应后连接被关闭
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect the connection closed after a 408, got %v", err)
+	}
+	if readDeadliner(struct{ http.ResponseWriter }{}) != nil {
+		t.Fatal("expect no read deadline support from a plain ResponseWriter")
+	}
+
+	if st := pool.Stats(); st.RejectedBodies != 2 || st.SlowBodies != 1 {
+		t.Fatalf("expect 2 rejected and 1 slow body, got %+v", st)
+	}
+}
+
+func mustRequest(t *testing.T, method, u string, body io.Reader) *http.Request {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestHTTPGetter(t *testing.T) {
+	// 超时的请求在服务端仍会继续，记录路径需要加锁
+	var mu sync.Mutex
+	var paths []string
+	requested := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.EscapedPath())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/users/Tom":
+			w.Write([]byte("630"))
+		case "/users/a b":
+			w.Write([]byte("space"))
+		case "/users/slow":
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte("late"))
+		case "/users/broken":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case "/users/big":
+			w.Write(bytes.Repeat([]byte("x"), 4<<10))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	getter := HTTPGetter(srv.URL+"/users/{key}", WithHTTPClient(srv.Client()), WithHTTPTimeout(20*time.Millisecond))
+	gee := NewGroup("http-getter", 2<<10, getter, WithNegativeTTL(time.Minute))
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("expect 630, got %v %v", v, err)
+	}
+	if v, err := gee.Get("a b"); err != nil || v.String() != "space" || requested()[1] != "/users/a%20b" {
+		t.Fatalf("expect escaped key, got %v %v %v", v, err, requested())
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := gee.Get("Jack"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect 404 as ErrNotFound, got %v", err)
+		}
+	}
+	if paths := requested(); len(paths) != 3 {
+		t.Fatalf("expect 404 to be negatively cached, got requests %v", paths)
+	}
+
+	var statusErr *HTTPStatusError
+	if _, err := gee.Get("broken"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect *HTTPStatusError with 500, got %v", err)
+	}
+	if _, err := gee.Get("slow"); err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+
+	// 响应体默认不超过 Group 的 WithMaxValueBytes，WithHTTPMaxBytes 可以单独设置
+	limited := NewGroup("http-getter-limit", 2<<10, HTTPGetter(srv.URL+"/users/{key}", WithHTTPClient(srv.Client())),
+		WithMaxValueBytes(1<<10))
+	if _, err := limited.Get("big"); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expect ErrValueTooLarge above the group's max value size, got %v", err)
+	}
+	if v, err := limited.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("expect a small response within the limit, got %v %v", v, err)
+	}
+	if _, err := HTTPGetter(srv.URL+"/users/{key}", WithHTTPMaxBytes(100)).Get("big"); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expect ErrValueTooLarge above WithHTTPMaxBytes, got %v", err)
+	}
+	if b, err := HTTPGetter(srv.URL + "/users/{key}").Get("big"); err != nil || len(b) != 4<<10 {
+		t.Fatalf("expect no limit outside a group, got %d bytes %v", len(b), err)
+	}
+
+	// WithHTTPClient(nil) 使用 http.DefaultClient
+	if b, err := HTTPGetter(srv.URL+"/users/{key}", WithHTTPClient(nil)).Get("Tom"); err != nil || string(b) != "630" {
+		t.Fatalf("expect the default client for a nil client, got %q %v", b, err)
+	}
+}
+
+func TestHTTPInvalidatePrefix(t *testing.T) {
+	gee := NewGroup("http-invalidate", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		gee.Get(key)
+	}
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+	if n, err := peer.InvalidatePrefix("http-invalidate", "a/"); err != nil || n != 2 {
+		t.Fatalf("invalidate prefix over http failed: %d %v", n, err)
+	}
+	if _, ok := gee.mainCache.get("b/1"); !ok {
+		t.Fatalf("expect b/1 to be kept")
+	}
+
+	pool := NewHTTPPool("self")
+	pool.Set("self", srv.URL, "http://other")
+	if peers := pool.ListPeers(); len(peers) != 2 || peerName(peers[0]) != srv.URL || peerName(peers[1]) != "http://other" {
+		t.Fatalf("expect remote peers in address order, got %v", peers)
+	}
+}
+
+func TestHTTPPin(t *testing.T) {
+	gee := NewGroup("http-pin", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		return []byte(key), nil
+	}), WithPinBudget(16))
+	defer gee.Close()
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+	if err := peer.Pin("http-pin", "a/1", time.Minute); err != nil {
+		t.Fatalf("pin over http failed: %v", err)
+	}
+	if _, ok, _ := gee.pins.get("a/1", gee.now()); !ok {
+		t.Fatalf("expect a/1 to be pinned")
+	}
+	if err := peer.Pin("http-pin", "missing", time.Minute); err != ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := peer.Pin("http-pin", "a-much-longer-key", time.Minute); err != ErrPinBudget {
+		t.Fatalf("expect ErrPinBudget, got %v", err)
+	}
+}
+
+var hostileNames = []struct{ group, key string }{
+	{"with space", "a b"},
+	{"slash/group", "a/b/c"},
+	{"ユニコード", "ключ"},
+	{"percent%2Fgroup", "%zz"},
+	{"plus+group", "+"},
+	{"query?x=1#y", "?q=1#frag"},
+	{"..", ".."},
+	{".", "."},
+	{"semi;colon", "../../etc"},
+}
+
+func TestHTTPHostileNamesHandler(t *testing.T) {
+	pool := NewHTTPPool("self")
+	for _, tt := range hostileNames {
+		g := NewGroup(tt.group, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return []byte("v-" + key), nil
+		}))
+		u := defaultBasePath + escapeSegment(tt.group) + "/" + escapeSegment(tt.key)
+		req := httptest.NewRequest(http.MethodGet, u, nil)
+		rec := httptest.NewRecorder()
+		pool.ServeHTTP(rec, req)
+		res := &pb.Response{}
+		if rec.Code != http.StatusOK || proto.Unmarshal(rec.Body.Bytes(), res) != nil || string(res.Value) != "v-"+tt.key {
+			t.Fatalf("%q/%q: unexpected response %d %q", tt.group, tt.key, rec.Code, rec.Body.String())
+		}
+
+		rec = httptest.NewRecorder()
+		pool.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, defaultBasePath+healthPath+"/"+escapeSegment(tt.group), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: health endpoint returned %d", tt.group, rec.Code)
+		}
+		g.Close()
+	}
+
+	for _, name := range []string{"", pingPath, healthPath, topKeysPath, selfCheckPath} {
+		if ValidGroupName(name) == nil {
+			t.Fatalf("expect group name %q to be rejected", name)
+		}
+	}
+}
+
+func TestHTTPHostileNamesClient(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{addr: srv.URL, baseURL: srv.URL + defaultBasePath}
+	for _, tt := range hostileNames {
+		g := NewGroup(tt.group, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return []byte("v-" + key), nil
+		}))
+		res := &pb.Response{}
+		if err := peer.Get(&pb.Request{Group: tt.group, Key: tt.key}, res); err != nil || string(res.Value) != "v-"+tt.key {
+			t.Fatalf("%q/%q: get failed: %q %v", tt.group, tt.key, res.Value, err)
+		}
+		if err := peer.Set(&pb.SetRequest{Group: tt.group, Key: tt.key, Value: []byte("set")}, &pb.SetResponse{}); err != nil {
+			t.Fatalf("%q/%q: set failed: %v", tt.group, tt.key, err)
+		}
+		if v, ok := g.mainCache.get(tt.key); !ok || v.String() != "set" {
+			t.Fatalf("%q/%q: expect the value stored under the original key", tt.group, tt.key)
+		}
+		has := &pb.HasResponse{}
+		if err := peer.Has(&pb.Request{Group: tt.group, Key: tt.key}, has); err != nil || !has.Exists {
+			t.Fatalf("%q/%q: has failed: %v %v", tt.group, tt.key, has.Exists, err)
+		}
+		del := &pb.DeleteResponse{}
+		if err := peer.Delete(&pb.Request{Group: tt.group, Key: tt.key}, del); err != nil || !del.Deleted {
+			t.Fatalf("%q/%q: delete failed: %v %v", tt.group, tt.key, del.Deleted, err)
+		}
+		g.Close()
+	}
+}
+
+func TestOwnerOf(t *testing.T) {
+	var mu sync.Mutex
+	var routed []string
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		var addr string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			routed = append(routed, addr)
+			mu.Unlock()
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+		addr = srv.URL
+		addrs = append(addrs, addr)
+	}
+	pool := NewHTTPPool("http://self")
+	if addr, self := pool.OwnerOf("Tom"); addr != "http://self" || !self {
+		t.Fatalf("expect every key owned by self without peers, got %s %v", addr, self)
+	}
+	pool.Set(append(addrs, "http://self")...)
+	g := NewGroup("owner-of", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer g.Close()
+	g.RegisterPeers(pool)
+
+	remote := 0
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprint("key", i)
+		routed = nil
+		g.Get(key)
+		addr, self := pool.OwnerOf(key)
+		if self != (addr == "http://self") || pool.peers.GetHashed(KeyHash(key)) != addr || pool.KeyHash(key) != KeyHash(key) {
+			t.Fatalf("%s: inconsistent owner %s %v", key, addr, self)
+		}
+		switch {
+		case self && len(routed) != 0:
+			t.Fatalf("%s: expect a key owned by self to be loaded locally, routed to %v", key, routed)
+		case !self && (len(routed) == 0 || routed[0] != addr):
+			t.Fatalf("%s: OwnerOf says %s, but routed to %v", key, addr, routed)
+		}
+		if !self {
+			remote++
+		}
+	}
+	if remote == 0 || remote == 50 {
+		t.Fatalf("expect keys spread across nodes, %d of 50 remote", remote)
+	}
+}
+
+func ExampleHTTPPool_OwnerOf() {
+	pool := NewHTTPPool("http://10.0.0.1:8001")
+	pool.Set("http://10.0.0.1:8001", "http://10.0.0.2:8001", "http://10.0.0.3:8001")
+	// 把处理用户数据的任务安排在缓存了它的节点上
+	if addr, self := pool.OwnerOf("user:42"); self {
+		fmt.Println("run locally")
+	} else {
+		fmt.Println("schedule on", addr)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var loads int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("v-" + key), nil
+	})
+	// 两个节点上的同名 group：b 接管数据，它的池只服务 b；a 最后创建，GetGroup 返回它
+	b := NewGroup("drain", 64<<10, getter, WithDefaultTTL(time.Minute))
+	defer b.Close()
+	srvB := httptest.NewServer(nil)
+	defer srvB.Close()
+	poolB := NewHTTPPool(srvB.URL)
+	poolB.groups = func(name string) *Group { return b }
+	srvB.Config.Handler = poolB
+
+	a := NewGroup("drain", 64<<10, getter, WithDefaultTTL(time.Minute))
+	defer a.Close()
+	srvA := httptest.NewServer(nil)
+	defer srvA.Close()
+	pool := NewHTTPPool(srvA.URL)
+	srvA.Config.Handler = pool
+
+	poolB.Set(srvA.URL, srvB.URL)
+	b.RegisterPeers(poolB)
+	pool.Set(srvA.URL, srvB.URL)
+	a.RegisterPeers(pool)
+	var keys []string
+	for i := 0; len(keys) < 50; i++ {
+		key := fmt.Sprint("key", i)
+		if _, remote := pool.PickPeer(key); !remote {
+			keys = append(keys, key)
+			a.Get(key)
+		}
+	}
+
+	// 限速后中途取消，再次调用从中断处继续
+	pool.SetDrainRate(200)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Drain(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect drain to stop at the deadline, got %v", err)
+	}
+	if p := pool.DrainProgress(); p.Pushed == 0 || p.Pushed+p.Remaining != 50 {
+		t.Fatalf("expect partial progress, got %+v", p)
+	}
+	pool.SetDrainRate(0)
+	if err := pool.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p := pool.DrainProgress(); p.Pushed != 50 || p.Remaining != 0 || p.Failed != 0 {
+		t.Fatalf("expect all entries pushed, got %+v", p)
+	}
+
+	// 推送之后被重新写入的条目在下一次 Drain 中再次推送
+	time.Sleep(time.Millisecond)
+	if res, err := a.SetE(keys[0], []byte("updated"), 0); err != nil || !res.Stored {
+		t.Fatalf("expect the rewrite stored on a, got %+v %v", res, err)
+	}
+	if err := pool.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p := pool.DrainProgress(); p.Pushed != 51 {
+		t.Fatalf("expect only the rewritten entry pushed again, got %+v", p)
+	}
+
+	// 把 a 从 b 的哈希环中移除并停止 a，b 直接命中迁移过来的条目，不再回源
+	poolB.Set(srvB.URL)
+	srvA.Close()
+	loaded := atomic.LoadInt32(&loads)
+	for i, key := range keys {
+		// 重新写入的条目没有 TTL
+		expect, ttl := "v-"+key, true
+		if i == 0 {
+			expect, ttl = "updated", false
+		}
+		view, info, err := b.GetWithInfo(key)
+		if err != nil || view.String() != expect || info.Source != SourceLocalCache || info.ExpireAt.IsZero() == ttl {
+			t.Fatalf("expect %s migrated with its TTL, got %q %+v %v", key, view.String(), info, err)
+		}
+	}
+	if n := atomic.LoadInt32(&loads) - loaded; n != 0 {
+		t.Fatalf("expect no origin loads after drain, got %d", n)
+	}
+}
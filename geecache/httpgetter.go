@@ -0,0 +1,119 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPStatusError 表示 HTTPGetter 请求的资源返回了非 200 的状态码。
+// 404 被视为 ErrNotFound，可以用 errors.Is 判断，也会被 WithNegativeTTL 负缓存。
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("geecache: GET %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Unwrap 在状态码为 404 时返回 ErrNotFound
+func (e *HTTPStatusError) Unwrap() error {
+	if e.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// HTTPGetterOption 配置 HTTPGetter 的可选项
+type HTTPGetterOption func(*httpSource)
+
+// WithHTTPClient 设置 HTTPGetter 使用的 http.Client，默认为 http.DefaultClient，传入 nil 时同样使用默认值
+func WithHTTPClient(c *http.Client) HTTPGetterOption {
+	return func(s *httpSource) {
+		if c == nil {
+			c = http.DefaultClient
+		}
+		s.client = c
+	}
+}
+
+// WithHTTPMaxBytes 设置 HTTPGetter 读取的响应体的最大字节数，超过时返回 ErrValueTooLarge。
+// 0 表示使用加载它的 Group 的 WithMaxValueBytes，负数表示不限制。
+func WithHTTPMaxBytes(n int64) HTTPGetterOption {
+	return func(s *httpSource) {
+		s.maxBytes = n
+	}
+}
+
+// WithHTTPTimeout 设置 HTTPGetter 每次请求的超时，包括读取响应体，0 表示不限制
+func WithHTTPTimeout(d time.Duration) HTTPGetterOption {
+	return func(s *httpSource) {
+		s.timeout = d
+	}
+}
+
+// httpSource 是 HTTPGetter 返回的 Getter
+type httpSource struct {
+	urlTemplate string
+	client      *http.Client
+	timeout     time.Duration
+	maxBytes    int64
+}
+
+// HTTPGetter 返回从 HTTP 资源加载数据的 Getter。urlTemplate 中的 {key} 被替换为转义后的键，
+// 例如 "http://legacy:8080/users/{key}"。响应 200 时返回响应体，其他状态码返回 *HTTPStatusError。
+// 返回的 Getter 同时实现了 CtxGetter，加载被取消时请求随之取消。
+func HTTPGetter(urlTemplate string, opts ...HTTPGetterOption) Getter {
+	s := &httpSource{urlTemplate: urlTemplate, client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *httpSource) Get(key string) ([]byte, error) {
+	return s.GetCtx(context.Background(), key)
+}
+
+func (s *httpSource) GetCtx(ctx context.Context, key string) ([]byte, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	u := strings.Replace(s.urlTemplate, "{key}", url.PathEscape(key), -1)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &HTTPStatusError{URL: u, StatusCode: res.StatusCode}
+	}
+	max := s.maxBytes
+	if max == 0 {
+		max = maxValueBytesFrom(ctx)
+	}
+	body := io.Reader(res.Body)
+	if max > 0 {
+		body = io.LimitReader(res.Body, max+1)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	if max > 0 && int64(len(b)) > max {
+		return nil, fmt.Errorf("%w: GET %s returned more than %d bytes", ErrValueTooLarge, u, max)
+	}
+	return b, nil
+}
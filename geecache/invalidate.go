@@ -0,0 +1,67 @@
+package geecache
+
+import (
+	"strings"
+	"sync"
+)
+
+// InvalidatePrefix 从本地所有缓存层中移除以 prefix 开头的键，返回移除的条目数，
+// 适用于 "user:123:profile"、"user:123:settings" 这样的分层键。prefix 为空时移除所有条目。
+// 被移除的键与 Delete 一样会被忘记进行中的加载并记录墓碑；尚未写入缓存的加载不受影响。
+// 只作用于本节点，需要同时清理对等点时使用 BroadcastInvalidatePrefix。
+func (g *Group) InvalidatePrefix(prefix string) int {
+	var keys []string
+	match := func(key string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	}
+	n := g.mainCache.removeFunc(match) + g.hotCache.removeFunc(match) + g.pins.removeFunc(match)
+	now := g.now()
+	for _, key := range keys {
+		g.loader.Forget(g.flightKey(key))
+		g.localLoader.Forget(g.flightKey(key))
+		g.tombstones.add(key, now)
+		g.logSet(key, ByteView{}, -1)
+	}
+	return n
+}
+
+// BroadcastInvalidatePrefix 在本地执行 InvalidatePrefix，并通知所有对等点移除以 prefix 开头的键，
+// 返回所有节点移除的条目总数。PeerPicker 需要实现 PeerLister，未实现 PeerPrefixInvalidator 的对等点被跳过。
+// 对等点并发通知，失败不影响其他节点，错误记录在以对等点名字为键的 MultiError 中。
+func (g *Group) BroadcastInvalidatePrefix(prefix string) (int, error) {
+	n := g.InvalidatePrefix(prefix)
+	lister, ok := g.peerPicker().(PeerLister)
+	if !ok {
+		return n, nil
+	}
+	errs := make(MultiError)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range lister.ListPeers() {
+		invalidator, ok := peer.(PeerPrefixInvalidator)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(peer PeerPrefixInvalidator) {
+			defer wg.Done()
+			removed, err := peer.InvalidatePrefix(g.name, prefix)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[peerName(peer)] = err
+				return
+			}
+			n += removed
+		}(invalidator)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return n, errs
+	}
+	return n, nil
+}
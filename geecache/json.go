@@ -0,0 +1,33 @@
+package geecache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// GetJSON 获取键的值并用 DecodeJSON 解码到 out。
+// Get 的错误原样返回，解码错误以 *DecodeError 返回。
+func (g *Group) GetJSON(key string, out interface{}) error {
+	return g.GetJSONCtx(context.Background(), key, out)
+}
+
+// GetJSONCtx 与 GetJSON 相同，但调用者可以通过 ctx 放弃等待加载，见 GetCtx
+func (g *Group) GetJSONCtx(ctx context.Context, key string, out interface{}) error {
+	v, err := g.GetCtx(ctx, key)
+	if err != nil {
+		return err
+	}
+	return v.DecodeJSON(out)
+}
+
+// SetJSON 用 json.Marshal 编码 v 并写入缓存，可选 TTL。
+// 编码错误是 json.Marshal 返回的错误，写入的错误与 SetE 相同。
+func (g *Group) SetJSON(key string, v interface{}, ttl time.Duration) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = g.SetE(key, b, ttl)
+	return err
+}
@@ -0,0 +1,149 @@
+package geecache
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Logger 是 geecache 内部日志的输出接口，*log.Logger 满足该接口。
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type loggerHolder struct {
+	Logger
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(loggerHolder{log.New(os.Stderr, "", log.LstdFlags)})
+}
+
+// SetLogger 设置 geecache 使用的日志输出，传入 nil 时丢弃所有日志。
+func SetLogger(l Logger) {
+	if l == nil {
+		l = discardLogger{}
+	}
+	defaultLogger.Store(loggerHolder{l})
+}
+
+func getLogger() Logger {
+	return defaultLogger.Load().(loggerHolder).Logger
+}
+
+// pkgLogger 将日志转发给当前配置的 Logger
+type pkgLogger struct{}
+
+var logger Logger = pkgLogger{}
+
+func (pkgLogger) Printf(format string, v ...interface{}) {
+	getLogger().Printf(format, v...)
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
+
+// defaultLogInterval 是同一来源的重复错误日志的默认最小间隔
+const defaultLogInterval = 10 * time.Second
+
+// peerLog 对远程节点的错误日志按节点限流，避免节点宕机时每个请求都输出一条日志
+var peerLog = newRateLimitedLogger(defaultLogInterval)
+
+// SetLogInterval 设置同一远程节点的错误日志的最小间隔，小于等于 0 时不限流。
+// 间隔内被抑制的日志条数会附在下一条输出的日志中，间隔结束时没有新日志则单独输出。
+func SetLogInterval(d time.Duration) {
+	peerLog.setInterval(d)
+}
+
+// rateLimitedLogger 对每个来源在一个间隔内只输出第一条日志
+type rateLimitedLogger struct {
+	mu       sync.Mutex
+	interval time.Duration
+	sources  map[string]*logSource
+	now      func() time.Time
+	// afterFunc 在间隔结束时输出被抑制的日志，测试中可替换
+	afterFunc func(d time.Duration, f func())
+}
+
+type logSource struct {
+	last       time.Time
+	suppressed int
+	// 最近一条被抑制的日志，以及是否已安排在间隔结束时输出
+	format  string
+	args    []interface{}
+	pending bool
+}
+
+func newRateLimitedLogger(interval time.Duration) *rateLimitedLogger {
+	return &rateLimitedLogger{
+		interval: interval,
+		sources:  make(map[string]*logSource),
+		now:      time.Now,
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+	}
+}
+
+func (l *rateLimitedLogger) setInterval(d time.Duration) {
+	l.mu.Lock()
+	l.interval = d
+	l.mu.Unlock()
+}
+
+// Printf 输出来源 source 的一条日志，距离该来源上次输出不足间隔时只计数
+func (l *rateLimitedLogger) Printf(source, format string, v ...interface{}) {
+	l.mu.Lock()
+	now := l.now()
+	s, ok := l.sources[source]
+	if !ok {
+		s = &logSource{}
+		l.sources[source] = s
+	}
+	if ok && l.interval > 0 && now.Sub(s.last) < l.interval {
+		s.suppressed++
+		s.format, s.args = format, v
+		if !s.pending {
+			s.pending = true
+			l.afterFunc(l.interval-now.Sub(s.last), func() { l.flush(source) })
+		}
+		l.mu.Unlock()
+		return
+	}
+	suppressed := s.suppressed
+	s.last, s.suppressed = now, 0
+	l.mu.Unlock()
+
+	printSuppressed(format, v, suppressed)
+}
+
+// flush 在间隔结束时输出来源 source 最近一条被抑制的日志，避免之后没有新日志时计数丢失
+func (l *rateLimitedLogger) flush(source string) {
+	l.mu.Lock()
+	s := l.sources[source]
+	s.pending = false
+	if s.suppressed == 0 {
+		// 间隔结束后的新日志已经带上了计数
+		l.mu.Unlock()
+		return
+	}
+	format, v, suppressed := s.format, s.args, s.suppressed-1
+	s.last, s.suppressed = l.now(), 0
+	s.format, s.args = "", nil
+	l.mu.Unlock()
+
+	printSuppressed(format, v, suppressed)
+}
+
+func printSuppressed(format string, v []interface{}, suppressed int) {
+	if suppressed > 0 {
+		format += " (%d similar messages suppressed)"
+		v = append(v, suppressed)
+	}
+	logger.Printf(format, v...)
+}
@@ -0,0 +1,60 @@
+package lru
+
+import (
+	"io"
+	"os"
+)
+
+// FileValue 是以磁盘文件保存数据的 Value，缓存只管理它的元数据、TTL 和淘汰，
+// Len 返回文件大小，用于字节统计。
+type FileValue struct {
+	path string
+	f    *os.File
+	size int64
+}
+
+var _ Value = (*FileValue)(nil)
+var _ io.ReaderAt = (*FileValue)(nil)
+
+// OpenFileValue 打开 path 指向的文件作为 Value
+func OpenFileValue(path string) (*FileValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileValue{path: path, f: f, size: fi.Size()}, nil
+}
+
+// Len 返回文件的字节数
+func (v *FileValue) Len() int {
+	return int(v.size)
+}
+
+// ReadAt 从文件的 off 处读取数据
+func (v *FileValue) ReadAt(p []byte, off int64) (int, error) {
+	return v.f.ReadAt(p, off)
+}
+
+// Path 返回文件路径
+func (v *FileValue) Path() string {
+	return v.path
+}
+
+// Remove 关闭并删除文件
+func (v *FileValue) Remove() error {
+	v.f.Close()
+	return os.Remove(v.path)
+}
+
+// RemoveFileOnEvicted 可用作 OnEvicted，条目被清除或被新值替换时删除 FileValue 对应的文件。
+// 替换时新值必须使用另一个文件。
+func RemoveFileOnEvicted(key string, value Value) {
+	if fv, ok := value.(*FileValue); ok {
+		fv.Remove()
+	}
+}
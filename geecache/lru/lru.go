@@ -3,6 +3,9 @@ package lru
 import (
 	"container/heap"
 	"container/list"
+	"log"
+	"reflect"
+	"strings"
 	"time"
 )
 
@@ -13,14 +16,69 @@ type Cache struct {
 	ll         *list.List
 	cache      map[string]*list.Element
 	expireHeap *expireHeap
-	// 可选的，当条目被清除时执行。
+	// 为 true 时条目永不过期，见 NewNoExpiry
+	noExpiry bool
+	// 可选的，当条目被清除时执行。回调中的 panic 会被恢复并记录，不会破坏缓存状态。
+	// Add 替换已有的键时也以被替换的旧值调用，再次写入同一个值时不调用。
 	OnEvicted func(key string, value Value)
+	// 可选的，用于记录内部错误，默认使用标准库 log。
+	Logger Logger
+	// 可选的，返回当前时间，默认为 time.Now，便于测试注入时钟。
+	Now func() time.Time
+	// 可选的，条目自写入起的最大存活时间，超过后视为未命中，与 TTL 无关。
+	MaxAge time.Duration
+	// 可选的，决定容量不足时淘汰哪个条目，为 nil 时淘汰最近最少使用的条目。
+	// 必须在写入第一个条目之前设置。
+	Policy Policy
+	// 可选的，每次 Add 最多同步淘汰的条目数，0 表示不限制。一次写入大值时可能需要淘汰大量小条目，
+	// 限制后剩余的淘汰推迟到之后的 Add 或 CleanExpired，期间字节数可能暂时超过上限，
+	// 但超出的部分不会超过 EvictionSlack：超过时 Add 继续淘汰，直到回到 EvictionSlack 以内。
+	MaxEvictionsPerAdd int
+	// 设置了 MaxEvictionsPerAdd 时允许暂时超过上限的字节数
+	EvictionSlack int64
+	// 可选的，容量不足需要淘汰时跳过返回 false 的条目，优先淘汰其他条目，例如保护重新计算代价高的值。
+	// 每次淘汰最多检查 EvictionScanDepth 个候选，都被跳过时仍淘汰第一个候选，保证淘汰总能推进。
+	// 设置了实现 CandidatePolicy 的 Policy 时候选按 Policy 的淘汰顺序选出，否则按最近使用顺序。
+	// 只影响容量淘汰和 Trim，不影响过期、Remove 和 RemoveOldest。
+	EvictionFilter func(key string, value Value) bool
+	// 设置了 EvictionFilter 时每次淘汰最多检查的候选数，0 表示默认值 16
+	EvictionScanDepth int
+	// 可选的，按占 maxBytes 的比例批量淘汰：字节数超过 HighWatermark 时一次淘汰到 LowWatermark 以下，
+	// 持续写入时减少进入淘汰的次数。不在 (0, 1) 内的值视为 1，LowWatermark 不低于 HighWatermark 时
+	// 与 HighWatermark 相同；默认两者都是 maxBytes，每次只淘汰到不超过上限。
+	HighWatermark float64
+	LowWatermark  float64
+	// 可选的，命中的条目在最近 PromoteDistance 次移动之内已被移到最近使用的位置时不再移动，
+	// 即它离队首不超过 PromoteDistance 个位置。热点小值的命中省去链表操作，淘汰顺序变为近似 LRU。
+	// 0 表示每次命中都移动。
+	PromoteDistance int
+
+	// 移到最近使用位置的总次数，条目记录自己最后一次移动时的值
+	promotions uint64
 }
 
+// defaultEvictionScanDepth 是 EvictionScanDepth 的默认值
+const defaultEvictionScanDepth = 16
+
 type entry struct {
-	key      string
-	value    Value
-	expireAt time.Time
+	key       string
+	value     Value
+	expireAt  time.Time
+	createdAt time.Time
+	flags     uint32
+	touched   uint64 // 最后一次移到最近使用位置时的 promotions
+}
+
+// EntryInfo 描述条目的元数据
+type EntryInfo struct {
+	CreatedAt time.Time // 写入时间
+	ExpireAt  time.Time // 过期时间，零值表示没有 TTL
+	Flags     uint32    // AddWithFlags 写入的标志位
+}
+
+// Logger 是记录内部错误的接口，*log.Logger 满足该接口。
+type Logger interface {
+	Printf(format string, v ...interface{})
 }
 
 // Value 使用 Len 计算占用多少字节
@@ -64,81 +122,534 @@ func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
 	}
 }
 
-// Add 向缓存中添加值。
-func (c *Cache) Add(key string, value Value, ttl time.Duration) {
-	var expireAt time.Time
-	if ttl > 0 {
-		expireAt = time.Now().Add(ttl)
+// NewNoExpiry 创建条目永不过期的 Cache，用于值不会改变的场景。
+// 它不分配过期堆，读写时不读取时钟：Add 忽略正的 ttl，MaxAge 不生效，
+// EntryInfo 的 CreatedAt 和 ExpireAt 总是零值。负的 ttl 仍然移除已有的条目。
+func NewNoExpiry(maxBytes int64, onEvicted func(string, Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		ll:        list.New(),
+		cache:     make(map[string]*list.Element),
+		noExpiry:  true,
+		OnEvicted: onEvicted,
+	}
+}
+
+// now 返回当前时间
+func (c *Cache) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// clock 返回判断过期使用的当前时间，条目永不过期时不读取时钟
+func (c *Cache) clock() time.Time {
+	if c.noExpiry {
+		return time.Time{}
+	}
+	return c.now()
+}
+
+// expired 判断条目是否已过期或超过最大存活时间
+func (c *Cache) expired(kv *entry, now time.Time) bool {
+	if c.noExpiry {
+		return false
+	}
+	if !kv.expireAt.IsZero() && now.After(kv.expireAt) {
+		return true
+	}
+	return c.MaxAge > 0 && now.Sub(kv.createdAt) > c.MaxAge
+}
+
+// Add 向缓存中添加值，返回因超出容量而被淘汰的条目数。
+// ttl 为 0 表示不过期；ttl 为负数表示立即过期，即移除已有的条目且不写入。
+func (c *Cache) Add(key string, value Value, ttl time.Duration) (evicted int) {
+	return c.AddWithFlags(key, value, ttl, 0)
+}
+
+// AddWithFlags 与 Add 相同，同时为条目记录调用方定义的标志位。
+func (c *Cache) AddWithFlags(key string, value Value, ttl time.Duration, flags uint32) (evicted int) {
+	if ttl < 0 {
+		c.Remove(key)
+		return 0
+	}
+	var now, expireAt time.Time
+	if !c.noExpiry {
+		now = c.now()
+		if ttl > 0 {
+			expireAt = now.Add(ttl)
+		}
 	}
 	if ele, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(ele)
+		c.stamp(ele)
 		kv := ele.Value.(*entry)
+		old := kv.value
 		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
 		kv.value = value
 		kv.expireAt = expireAt
+		kv.createdAt = now
+		kv.flags = flags
 		if !expireAt.IsZero() {
-			heap.Push(c.expireHeap, expireItem{expireAt, key})
+			// 使用已保存的键，不引用调用者传入的字符串
+			heap.Push(c.expireHeap, expireItem{expireAt, kv.key})
+		}
+		if c.Policy != nil {
+			c.Policy.OnGet(key)
+		}
+		// 旧值不再被缓存引用，例如 FileValue 的文件需要删除
+		if c.OnEvicted != nil && !sameValue(old, value) {
+			c.onEvicted(kv.key, old)
 		}
 	} else {
-		ele := c.ll.PushFront(&entry{key, value, expireAt})
+		key = cloneKey(key)
+		ele := c.ll.PushFront(&entry{key, value, expireAt, now, flags, 0})
+		c.stamp(ele)
 		c.cache[key] = ele
 		c.nbytes += int64(len(key)) + int64(value.Len())
 		if !expireAt.IsZero() {
 			heap.Push(c.expireHeap, expireItem{expireAt, key})
 		}
+		// 淘汰完成后才通知 Policy，避免新条目被选为淘汰对象
+		if c.Policy != nil {
+			defer func() {
+				if _, ok := c.cache[key]; ok {
+					c.Policy.OnAdd(key)
+				}
+			}()
+		}
 	}
-	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
-		c.RemoveOldest()
+	return c.evictBounded()
+}
+
+// evictBounded 与 evictToFit 相同，但在字节数不超过高水位加 EvictionSlack 时最多淘汰 MaxEvictionsPerAdd 个条目
+func (c *Cache) evictBounded() (evicted int) {
+	if c.MaxEvictionsPerAdd <= 0 {
+		return c.evictToFit()
+	}
+	high, low := c.evictionBounds()
+	if c.maxBytes <= 0 || c.nbytes <= high {
+		return 0
+	}
+	for c.nbytes > low && c.ll.Len() > 0 {
+		if evicted >= c.MaxEvictionsPerAdd && c.nbytes <= high+c.EvictionSlack {
+			break
+		}
+		c.evict()
+		evicted++
+	}
+	return evicted
+}
+
+// sameValue 报告 a 和 b 是否是同一个值，不可比较的类型视为不同的值。
+// 可比较的结构体中的接口字段可能保存切片等不可比较的值，此时 == 会 panic，同样视为不同的值
+func sameValue(a, b Value) (same bool) {
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
 	}
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// cloneKey 复制键。键可能是调用者大缓冲区的子串，直接保存会使整个缓冲区无法被回收
+func cloneKey(key string) string {
+	var b strings.Builder
+	b.Grow(len(key))
+	b.WriteString(key)
+	return b.String()
+}
+
+// Resize 将缓存的最大字节数改为 maxBytes，0 或负数表示不限制，此后 Add 不再淘汰条目。
+// 从不限制改为有限值、或缩小上限后，立即淘汰条目直到不超过新的上限，返回淘汰的数量。
+func (c *Cache) Resize(maxBytes int64) (evicted int) {
+	c.maxBytes = maxBytes
+	return c.evictToFit()
+}
+
+// evictionBounds 返回触发淘汰的字节数 high 和一次淘汰到的字节数 low
+func (c *Cache) evictionBounds() (high, low int64) {
+	high = c.maxBytes
+	if c.HighWatermark > 0 && c.HighWatermark < 1 {
+		high = int64(float64(c.maxBytes) * c.HighWatermark)
+	}
+	low = high
+	if c.LowWatermark > 0 && c.LowWatermark < 1 {
+		if l := int64(float64(c.maxBytes) * c.LowWatermark); l < high {
+			low = l
+		}
+	}
+	return high, low
+}
+
+// evictToFit 字节数超过高水位时淘汰条目直到不超过低水位，返回淘汰的数量
+func (c *Cache) evictToFit() (evicted int) {
+	high, low := c.evictionBounds()
+	if c.maxBytes <= 0 || c.nbytes <= high {
+		return 0
+	}
+	for c.nbytes > low && c.ll.Len() > 0 {
+		c.evict()
+		evicted++
+	}
+	return evicted
+}
+
+// Bytes 返回缓存中所有键和值的字节数
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+// Touch 将未过期的条目移到最近使用的位置，不返回值也不刷新 TTL。
+// 键不存在或已过期时返回 false。
+func (c *Cache) Touch(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		if c.expired(ele.Value.(*entry), c.clock()) {
+			c.removeElement(ele)
+			return false
+		}
+		c.promote(ele)
+		if c.Policy != nil {
+			c.Policy.OnGet(key)
+		}
+		return true
+	}
+	return false
+}
+
+// promote 把命中的条目移到最近使用的位置，设置了 PromoteDistance 且条目离队首足够近时不移动
+func (c *Cache) promote(ele *list.Element) {
+	// 排在条目前面的只有它之后被移动的条目，所以它离队首不超过 promotions-touched 个位置
+	if c.PromoteDistance > 0 && c.promotions-ele.Value.(*entry).touched < uint64(c.PromoteDistance) {
+		return
+	}
+	c.ll.MoveToFront(ele)
+	c.stamp(ele)
+}
+
+// stamp 记录条目刚被移到了最近使用的位置
+func (c *Cache) stamp(ele *list.Element) {
+	c.promotions++
+	ele.Value.(*entry).touched = c.promotions
+}
+
+// Contains 判断键是否在缓存中，不改变其最近使用位置
+func (c *Cache) Contains(key string) bool {
+	ele, ok := c.cache[key]
+	return ok && !c.expired(ele.Value.(*entry), c.clock())
+}
+
+// Peek 返回键的条目信息，不改变条目的顺序，也不通知 Policy；过期的条目当作不存在但不移除
+func (c *Cache) Peek(key string) (info EntryInfo, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return EntryInfo{}, false
+	}
+	kv := ele.Value.(*entry)
+	if c.expired(kv, c.clock()) {
+		return EntryInfo{}, false
+	}
+	return EntryInfo{CreatedAt: kv.createdAt, ExpireAt: kv.expireAt, Flags: kv.flags}, true
 }
 
 // Get 查找键的值
 func (c *Cache) Get(key string) (value Value, ok bool) {
+	value, _, ok = c.GetWithInfo(key)
+	return
+}
+
+// GetWithInfo 查找键的值，并返回条目的元数据
+func (c *Cache) GetWithInfo(key string) (value Value, info EntryInfo, ok bool) {
 	if ele, ok := c.cache[key]; ok {
 		kv := ele.Value.(*entry)
-		if !kv.expireAt.IsZero() && time.Now().After(kv.expireAt) {
+		if c.expired(kv, c.clock()) {
 			c.removeElement(ele)
-			return nil, false
+			return nil, EntryInfo{}, false
 		}
-		c.ll.MoveToFront(ele)
-		return kv.value, true
+		c.promote(ele)
+		if c.Policy != nil {
+			c.Policy.OnGet(key)
+		}
+		return kv.value, EntryInfo{CreatedAt: kv.createdAt, ExpireAt: kv.expireAt, Flags: kv.flags}, true
 	}
 	return
 }
 
-// RemoveOldest 移除最旧的条目
+// GetAll 查找多个键，返回其中存在且未过期的键的值。命中的条目与 Get 一样被移到最近使用的位置，
+// 按 keys 的顺序依次移动，最后一个命中的键成为最近使用的条目。
+func (c *Cache) GetAll(keys []string) map[string]Value {
+	values := make(map[string]Value, len(keys))
+	for _, key := range keys {
+		if v, ok := c.Get(key); ok {
+			values[key] = v
+		}
+	}
+	return values
+}
+
+// Remove 移除键对应的条目，返回条目是否存在
+func (c *Cache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+		return true
+	}
+	return false
+}
+
+// RemoveOldest 移除最旧的条目；设置了 Policy 时移除 Policy 选出的条目
 func (c *Cache) RemoveOldest() {
 	ele := c.ll.Back()
+	if c.Policy != nil {
+		// Policy 返回未知的键时退回到 LRU，保证淘汰总能推进
+		if key, ok := c.Policy.Victim(); ok && c.cache[key] != nil {
+			ele = c.cache[key]
+		}
+	}
 	if ele != nil {
 		c.removeElement(ele)
 	}
 }
 
+// evict 淘汰一个条目。第一个候选是 RemoveOldest 会移除的条目，被 EvictionFilter 跳过时
+// 按从旧到新的顺序继续检查，最多检查 EvictionScanDepth 个候选，都被跳过时淘汰第一个候选。
+func (c *Cache) evict() {
+	if c.EvictionFilter == nil {
+		c.RemoveOldest()
+		return
+	}
+	depth := c.EvictionScanDepth
+	if depth <= 0 {
+		depth = defaultEvictionScanDepth
+	}
+	if p, ok := c.Policy.(CandidatePolicy); ok {
+		if victim := c.filterCandidates(p.Victims(depth)); victim != nil {
+			c.removeElement(victim)
+			return
+		}
+	}
+	first := c.ll.Back()
+	if c.Policy != nil {
+		if key, ok := c.Policy.Victim(); ok && c.cache[key] != nil {
+			first = c.cache[key]
+		}
+	}
+	if first == nil {
+		return
+	}
+	victim := first
+	if kv := first.Value.(*entry); !c.EvictionFilter(kv.key, kv.value) {
+		for ele, n := c.ll.Back(), 1; ele != nil && n < depth; ele = ele.Prev() {
+			if ele == first {
+				continue
+			}
+			n++
+			if kv := ele.Value.(*entry); c.EvictionFilter(kv.key, kv.value) {
+				victim = ele
+				break
+			}
+		}
+	}
+	c.removeElement(victim)
+}
+
+// filterCandidates 返回 Policy 给出的候选中第一个通过 EvictionFilter 的条目，
+// 都被跳过时返回第一个候选，没有已知的候选时返回 nil
+func (c *Cache) filterCandidates(keys []string) *list.Element {
+	var first *list.Element
+	for _, key := range keys {
+		ele := c.cache[key]
+		if ele == nil {
+			continue
+		}
+		if first == nil {
+			first = ele
+		}
+		if kv := ele.Value.(*entry); c.EvictionFilter(kv.key, kv.value) {
+			return ele
+		}
+	}
+	return first
+}
+
+// Trim 淘汰最旧的条目直到条目数不超过 targetLen，返回淘汰的数量。
+// 与按字节数淘汰不同，它按条目数收缩缓存；设置了 Policy 时由 Policy 选择淘汰的条目，并遵循 EvictionFilter。
+func (c *Cache) Trim(targetLen int) int {
+	if targetLen < 0 {
+		targetLen = 0
+	}
+	n := 0
+	for c.ll.Len() > targetLen {
+		c.evict()
+		n++
+	}
+	return n
+}
+
 // removeElement 移除给定的元素
 func (c *Cache) removeElement(ele *list.Element) {
 	c.ll.Remove(ele)
 	kv := ele.Value.(*entry)
 	delete(c.cache, kv.key)
+	if c.Policy != nil {
+		c.Policy.OnRemove(kv.key)
+	}
 	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
 	if c.OnEvicted != nil {
-		c.OnEvicted(kv.key, kv.value)
+		c.onEvicted(kv.key, kv.value)
+	}
+}
+
+// onEvicted 调用 OnEvicted，并恢复其中的 panic
+func (c *Cache) onEvicted(key string, value Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logf("[lru] OnEvicted panic for key %s: %v", key, r)
+		}
+	}()
+	c.OnEvicted(key, value)
+}
+
+func (c *Cache) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// RemoveFunc 移除 fn 返回 true 的所有条目，返回移除的数量
+func (c *Cache) RemoveFunc(fn func(key string, value Value) bool) int {
+	removed := 0
+	for ele := c.ll.Front(); ele != nil; {
+		next := ele.Next()
+		kv := ele.Value.(*entry)
+		if fn(kv.key, kv.value) {
+			c.removeElement(ele)
+			removed++
+		}
+		ele = next
 	}
+	return removed
 }
 
-// CleanExpired 移除过期的条目
+// CleanExpired 移除过期的条目，并完成被 MaxEvictionsPerAdd 推迟的淘汰
 func (c *Cache) CleanExpired() {
-	now := time.Now()
+	defer c.evictToFit()
+	if c.noExpiry {
+		return
+	}
+	now := c.now()
 	for c.expireHeap.Len() > 0 {
 		item := (*c.expireHeap)[0]
 		if now.After(item.expireAt) {
 			heap.Pop(c.expireHeap)
-			if ele, ok := c.cache[item.key]; ok {
+			// 条目可能已被重新写入，只移除过期时间一致的条目
+			if ele, ok := c.cache[item.key]; ok && ele.Value.(*entry).expireAt.Equal(item.expireAt) {
 				c.removeElement(ele)
 			}
 		} else {
 			break
 		}
 	}
+	c.compactExpireHeap()
+}
+
+// 堆的容量不低于该值时才考虑压缩
+const minHeapCompactCap = 64
+
+// compactExpireHeap 丢弃已失效的堆项，并在长度远小于容量时重新分配底层数组，
+// 避免一次 TTL 写入高峰永久占用内存。
+func (c *Cache) compactExpireHeap() {
+	h := *c.expireHeap
+	if len(h) > 2*len(c.cache)+minHeapCompactCap {
+		live := h[:0]
+		for _, item := range h {
+			if ele, ok := c.cache[item.key]; ok && ele.Value.(*entry).expireAt.Equal(item.expireAt) {
+				live = append(live, item)
+			}
+		}
+		for i := len(live); i < len(h); i++ {
+			h[i] = expireItem{}
+		}
+		h = live
+		*c.expireHeap = h
+		heap.Init(c.expireHeap)
+	}
+	if cap(h) >= minHeapCompactCap && len(h) < cap(h)/4 {
+		compacted := make(expireHeap, len(h), 2*len(h))
+		copy(compacted, h)
+		*c.expireHeap = compacted
+	}
+}
+
+// PendingExpiry 返回过期堆的长度和最早的过期时间，堆为空时 next 为零值。
+// 堆顶已失效的项会被顺带丢弃，堆中其余位置仍可能含有失效项，因此 n 是上界。
+func (c *Cache) PendingExpiry() (n int, next time.Time) {
+	if c.noExpiry {
+		return 0, time.Time{}
+	}
+	for c.expireHeap.Len() > 0 {
+		item := (*c.expireHeap)[0]
+		if ele, ok := c.cache[item.key]; ok && ele.Value.(*entry).expireAt.Equal(item.expireAt) {
+			return c.expireHeap.Len(), item.expireAt
+		}
+		heap.Pop(c.expireHeap)
+	}
+	return 0, time.Time{}
+}
+
+// Range 从最旧到最新依次对未过期的条目调用 fn，fn 返回 false 时停止。
+// Range 不改变条目的顺序，fn 中不能修改缓存。
+func (c *Cache) Range(fn func(key string, value Value, info EntryInfo) bool) {
+	now := c.clock()
+	for ele := c.ll.Back(); ele != nil; ele = ele.Prev() {
+		kv := ele.Value.(*entry)
+		if c.expired(kv, now) {
+			continue
+		}
+		if !fn(kv.key, kv.value, EntryInfo{CreatedAt: kv.createdAt, ExpireAt: kv.expireAt, Flags: kv.flags}) {
+			return
+		}
+	}
+}
+
+// Newest 返回最近使用的未过期条目，不改变条目的顺序
+func (c *Cache) Newest() (key string, value Value, ok bool) {
+	now := c.clock()
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		if kv := ele.Value.(*entry); !c.expired(kv, now) {
+			return kv.key, kv.value, true
+		}
+	}
+	return "", nil, false
+}
+
+// Oldest 返回最久未使用的未过期条目，不改变条目的顺序
+func (c *Cache) Oldest() (key string, value Value, ok bool) {
+	c.Range(func(k string, v Value, _ EntryInfo) bool {
+		key, value, ok = k, v, true
+		return false
+	})
+	return
+}
+
+// OldestN 按淘汰顺序返回最多 n 个未过期条目的键，不改变条目的顺序。
+// 顺序按最近使用计算，不考虑 Policy。
+func (c *Cache) OldestN(n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	keys := make([]string, 0, n)
+	c.Range(func(key string, _ Value, _ EntryInfo) bool {
+		keys = append(keys, key)
+		return len(keys) < n
+	})
+	return keys
 }
 
 // Len 缓存条目的数量
@@ -1,8 +1,17 @@
 package lru
 
 import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 type String string
@@ -63,3 +72,731 @@ func TestAdd(t *testing.T) {
 		t.Fatal("expected 6 but got", lru.nbytes)
 	}
 }
+
+func TestMaxAge(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lru := New(int64(0), nil)
+	lru.Now = func() time.Time { return now }
+	lru.MaxAge = time.Minute
+	lru.Add("key1", String("1234"), time.Hour)
+
+	now = now.Add(30 * time.Second)
+	if _, info, ok := lru.GetWithInfo("key1"); !ok || !info.CreatedAt.Equal(time.Unix(1000, 0)) {
+		t.Fatalf("cache hit key1 with createdAt failed")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 0 {
+		t.Fatalf("key1 should be removed after MaxAge")
+	}
+}
+
+func TestOnEvictedPanic(t *testing.T) {
+	callback := func(key string, value Value) {
+		panic("bad callback")
+	}
+	lru := New(int64(10), callback)
+	lru.Add("key1", String("123456"), 0)
+	lru.Add("k2", String("k2"), 0)
+	lru.Add("k3", String("k3"), 0)
+
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 2 {
+		t.Fatalf("eviction with panicking OnEvicted failed")
+	}
+	if lru.nbytes != int64(len("k2k2k3k3")) {
+		t.Fatalf("expected nbytes %d but got %d", len("k2k2k3k3"), lru.nbytes)
+	}
+}
+
+func TestFileValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func(name, content string) *FileValue {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		v, err := OpenFileValue(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	lru := New(int64(24), RemoveFileOnEvicted)
+	v1 := open("f1", "0123456789")
+	lru.Add("f1", v1, 0)
+	lru.Add("f2", open("f2", "abcdefgh"), 0)
+	if lru.nbytes != int64(len("f1")+10+len("f2")+8) {
+		t.Fatalf("expected nbytes 22 but got %d", lru.nbytes)
+	}
+
+	v, ok := lru.Get("f2")
+	if !ok {
+		t.Fatalf("cache hit f2 failed")
+	}
+	buf := make([]byte, 3)
+	if _, err := v.(*FileValue).ReadAt(buf, 2); err != nil || string(buf) != "cde" {
+		t.Fatalf("ReadAt f2 failed: %q %v", buf, err)
+	}
+
+	lru.Add("f3", open("f3", "xy"), 0)
+	if _, ok := lru.Get("f1"); ok || lru.Len() != 2 {
+		t.Fatalf("Removeoldest f1 failed")
+	}
+	if _, err := os.Stat(v1.Path()); !os.IsNotExist(err) {
+		t.Fatalf("file of evicted f1 should be removed")
+	}
+
+	// 替换时删除旧文件，再次写入同一个值时保留
+	v2, _ := lru.Get("f2")
+	lru.Add("f2", v2, 0)
+	if _, err := os.Stat(v2.(*FileValue).Path()); err != nil {
+		t.Fatalf("file of re-added f2 should be kept: %v", err)
+	}
+	lru.Add("f2", open("f2-new", "abc"), 0)
+	if _, err := os.Stat(v2.(*FileValue).Path()); !os.IsNotExist(err) {
+		t.Fatalf("file of replaced f2 should be removed")
+	}
+	if lru.nbytes != int64(len("f2")+3+len("f3")+2) {
+		t.Fatalf("expected nbytes 9 after replace but got %d", lru.nbytes)
+	}
+}
+
+func TestRemoveFunc(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("a1", String("1"), 0)
+	lru.Add("b1", String("2"), 0)
+	lru.Add("a2", String("3"), 0)
+
+	n := lru.RemoveFunc(func(key string, value Value) bool {
+		return key[0] == 'a'
+	})
+	if n != 2 || lru.Len() != 1 || lru.nbytes != int64(len("b12")) {
+		t.Fatalf("RemoveFunc failed, removed %d", n)
+	}
+}
+
+func TestFlagsAndNegativeTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lru := New(int64(0), nil)
+	lru.Now = func() time.Time { return now }
+
+	lru.AddWithFlags("missing", String(""), time.Minute, 1)
+	lru.Add("empty", String(""), time.Minute)
+	if _, info, ok := lru.GetWithInfo("missing"); !ok || info.Flags != 1 {
+		t.Fatalf("flags of missing should be 1")
+	}
+	if _, info, ok := lru.GetWithInfo("empty"); !ok || info.Flags != 0 {
+		t.Fatalf("flags of empty should be 0")
+	}
+
+	// 负的 TTL 移除已有的条目且不写入，堆中残留的项不会影响后续写入
+	lru.Add("empty", String("v"), -1)
+	if _, ok := lru.Get("empty"); ok || lru.Len() != 1 {
+		t.Fatalf("negative ttl should remove the entry")
+	}
+	lru.Add("empty", String("v"), 0)
+	now = now.Add(2 * time.Minute)
+	lru.CleanExpired()
+	if v, ok := lru.Get("empty"); !ok || string(v.(String)) != "v" {
+		t.Fatalf("stale expire item should not remove the re-added entry")
+	}
+	if _, ok := lru.Get("missing"); ok {
+		t.Fatalf("missing should expire")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lru := New(int64(len("k1v1k2v2")), nil)
+	lru.Now = func() time.Time { return now }
+	lru.Add("k1", String("v1"), time.Minute)
+	lru.Add("k2", String("v2"), 0)
+
+	if !lru.Touch("k1") || lru.Touch("k3") {
+		t.Fatalf("Touch should only report live keys")
+	}
+	// k1 被 Touch 过，容量不足时淘汰的是 k2
+	lru.Add("k3", String("v3"), 0)
+	if _, ok := lru.Get("k2"); ok {
+		t.Fatalf("k2 should be evicted")
+	}
+	_, info, _ := lru.GetWithInfo("k1")
+	if !info.ExpireAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Touch should not refresh TTL")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if lru.Touch("k1") {
+		t.Fatalf("Touch on expired key should return false")
+	}
+}
+
+// boxed 是可比较的结构体，但接口字段可能保存不可比较的值
+type boxed struct {
+	v interface{}
+}
+
+func (b boxed) Len() int {
+	return 1
+}
+
+func TestAddUncomparableValue(t *testing.T) {
+	evicted := 0
+	lru := New(0, func(key string, value Value) { evicted++ })
+	lru.Add("k", boxed{[]byte("v1")}, 0)
+	// 覆盖写入时比较新旧值不应 panic，不同的切片按不同的值通知 OnEvicted
+	lru.Add("k", boxed{[]byte("v2")}, 0)
+	if evicted != 1 {
+		t.Fatalf("expect the old value reported once, got %d", evicted)
+	}
+	same := boxed{"v"}
+	lru.Add("k", same, 0)
+	lru.Add("k", same, 0)
+	if evicted != 2 {
+		t.Fatalf("expect an identical value not reported, got %d", evicted)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lru := New(int64(len("k1v1k2v2")), nil)
+	lru.Now = func() time.Time { return now }
+	lru.AddWithFlags("k1", String("v1"), time.Minute, 4)
+	lru.Add("k2", String("v2"), 0)
+
+	info, ok := lru.Peek("k1")
+	if !ok || info.Flags != 4 || !info.ExpireAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected Peek(k1) = %+v, %v", info, ok)
+	}
+	// Peek 不改变顺序，容量不足时淘汰的仍是 k1
+	lru.Add("k3", String("v3"), 0)
+	if _, ok := lru.Peek("k1"); ok {
+		t.Fatalf("k1 should be evicted")
+	}
+
+	now = now.Add(2 * time.Minute)
+	lru.Add("k4", String("v4"), time.Second)
+	now = now.Add(2 * time.Second)
+	if _, ok := lru.Peek("k4"); ok || lru.Len() != 2 {
+		t.Fatalf("Peek should treat expired keys as missing without removing them")
+	}
+}
+
+func TestExpireHeapCompaction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lru := New(int64(0), nil)
+	lru.Now = func() time.Time { return now }
+	for i := 0; i < 10000; i++ {
+		lru.Add(strconv.Itoa(i), String("v"), time.Minute)
+	}
+	lru.Add("long", String("v"), time.Hour)
+	if cap(*lru.expireHeap) < 10000 {
+		t.Fatalf("expect heap capacity to grow during the burst")
+	}
+
+	now = now.Add(2 * time.Minute)
+	lru.CleanExpired()
+	if lru.Len() != 1 || lru.expireHeap.Len() != 1 {
+		t.Fatalf("expect only the long-lived entry to remain")
+	}
+	if c := cap(*lru.expireHeap); c >= minHeapCompactCap {
+		t.Fatalf("expect heap capacity to shrink after expiry, but %d got", c)
+	}
+
+	// 反复覆盖同一个键产生的失效堆项也会被压缩掉
+	for i := 0; i < 1000; i++ {
+		lru.Add("long", String("v"), time.Hour+time.Duration(i))
+	}
+	lru.CleanExpired()
+	if n := lru.expireHeap.Len(); n != 1 {
+		t.Fatalf("expect stale heap items to be dropped, but %d remain", n)
+	}
+}
+
+func TestPendingExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lru := New(int64(0), nil)
+	lru.Now = func() time.Time { return now }
+	if n, next := lru.PendingExpiry(); n != 0 || !next.IsZero() {
+		t.Fatalf("expect empty heap, but %d %v got", n, next)
+	}
+
+	lru.Add("k1", String("v"), time.Minute)
+	lru.Add("k2", String("v"), time.Hour)
+	lru.Add("k3", String("v"), 0)
+	if n, next := lru.PendingExpiry(); n != 2 || !next.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expect 2 pending with next in 1m, but %d %v got", n, next)
+	}
+
+	// 堆顶失效后应报告下一个真实的过期时间
+	lru.Remove("k1")
+	if n, next := lru.PendingExpiry(); n != 1 || !next.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expect 1 pending with next in 1h, but %d %v got", n, next)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	// 每个条目占 3 字节，容量为 3 个条目
+	cases := []struct {
+		name   string
+		policy Policy
+		evict  string
+	}{
+		{"lru", NewLRUPolicy(), "k2"},
+		{"fifo", NewFIFOPolicy(), "k1"},
+		{"lfu", NewLFUPolicy(), "k3"}, // k1 与 k3 都访问了 2 次，k3 更早被使用
+	}
+	for _, c := range cases {
+		lru := New(int64(9), nil)
+		lru.Policy = c.policy
+		lru.Add("k1", String("v"), 0)
+		lru.Add("k2", String("v"), 0)
+		lru.Add("k3", String("v"), 0)
+		lru.Get("k2")
+		lru.Get("k2")
+		lru.Get("k3")
+		lru.Get("k1")
+		lru.Add("k4", String("v"), 0)
+		if _, ok := lru.Get(c.evict); ok || lru.Len() != 3 {
+			t.Fatalf("%s: expect %s to be evicted", c.name, c.evict)
+		}
+		lru.Remove("k4")
+		if key, ok := c.policy.Victim(); !ok || key == "k4" || key == c.evict {
+			t.Fatalf("%s: policy not notified of removal, victim %s", c.name, key)
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	var evicted []string
+	lru := New(int64(0), func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	for i := 0; i < 5; i++ {
+		lru.Add("k"+strconv.Itoa(i), String("v"), 0)
+	}
+	lru.Get("k0")
+
+	if n := lru.Trim(2); n != 3 || lru.Len() != 2 {
+		t.Fatalf("expect 3 entries trimmed to 2, got %d removed and %d left", n, lru.Len())
+	}
+	if expect := []string{"k1", "k2", "k3"}; !reflect.DeepEqual(evicted, expect) {
+		t.Fatalf("expect oldest entries evicted %v, got %v", expect, evicted)
+	}
+	if n := lru.Trim(5); n != 0 {
+		t.Fatalf("expect no eviction below target, got %d", n)
+	}
+}
+
+func TestResizeUnlimited(t *testing.T) {
+	var evicted []string
+	lru := New(int64(8), func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	lru.Add("k1", String("v1"), 0)
+	lru.Add("k2", String("v2"), 0)
+
+	// 改为不限制后，字节数超过原上限也不再淘汰
+	if n := lru.Resize(0); n != 0 {
+		t.Fatalf("expect no eviction when removing the limit, got %d", n)
+	}
+	for i := 3; i <= 6; i++ {
+		if n := lru.Add("k"+strconv.Itoa(i), String("v"+strconv.Itoa(i)), 0); n != 0 {
+			t.Fatalf("expect no eviction while unlimited, got %d", n)
+		}
+	}
+	if lru.Len() != 6 || lru.Bytes() != 24 || len(evicted) != 0 {
+		t.Fatalf("expect all 6 entries kept, got %d entries, %d bytes, evicted %v", lru.Len(), lru.Bytes(), evicted)
+	}
+
+	// 恢复为小于当前字节数的上限时，立即淘汰最旧的条目
+	lru.Get("k1")
+	if n := lru.Resize(12); n != 3 || lru.Bytes() != 12 {
+		t.Fatalf("expect 3 entries evicted down to 12 bytes, got %d evicted, %d bytes", n, lru.Bytes())
+	}
+	if expect := []string{"k2", "k3", "k4"}; !reflect.DeepEqual(evicted, expect) {
+		t.Fatalf("expect oldest entries evicted %v, got %v", expect, evicted)
+	}
+	if n := lru.Add("k7", String("v7"), 0); n != 1 {
+		t.Fatalf("expect the limit to apply to later adds, got %d evicted", n)
+	}
+
+	// 负数与 0 相同，表示不限制
+	if n := lru.Resize(-1); n != 0 || lru.Len() != 3 {
+		t.Fatalf("expect negative limit to mean unlimited, got %d evicted, %d left", n, lru.Len())
+	}
+}
+
+func TestNewestOldest(t *testing.T) {
+	lru := New(int64(0), nil)
+	if _, _, ok := lru.Oldest(); ok {
+		t.Fatalf("expect no oldest entry in an empty cache")
+	}
+	for _, k := range []string{"k1", "k2", "k3", "k4"} {
+		lru.Add(k, String("v"+k[1:]), 0)
+	}
+	lru.Get("k2")
+	lru.Add("k1", String("v1"), 0)
+	// 顺序从新到旧为 k1 k2 k4 k3
+
+	if k, v, ok := lru.Newest(); !ok || k != "k1" || string(v.(String)) != "v1" {
+		t.Fatalf("expect newest k1, got %s %v %v", k, v, ok)
+	}
+	if k, v, ok := lru.Oldest(); !ok || k != "k3" || string(v.(String)) != "v3" {
+		t.Fatalf("expect oldest k3, got %s %v %v", k, v, ok)
+	}
+	if keys := lru.OldestN(3); !reflect.DeepEqual(keys, []string{"k3", "k4", "k2"}) {
+		t.Fatalf("unexpected eviction order %v", keys)
+	}
+	if keys := lru.OldestN(10); !reflect.DeepEqual(keys, []string{"k3", "k4", "k2", "k1"}) {
+		t.Fatalf("unexpected eviction order %v", keys)
+	}
+	// 查看两端不改变顺序
+	lru.RemoveOldest()
+	if _, ok := lru.Get("k3"); ok {
+		t.Fatalf("expect k3 to remain the oldest after peeking")
+	}
+}
+
+func TestKeyDoesNotPinCallerMemory(t *testing.T) {
+	const bufSize = 64 << 20
+	lru := New(int64(0), nil)
+	func() {
+		buf := strings.Repeat("k", bufSize)
+		// 键是大缓冲区的子串，带 TTL 使它同时进入过期堆
+		lru.Add(buf[:8], String("v"), time.Hour)
+		lru.Add(buf[8:16], String("v"), 0)
+		lru.Add(buf[8:16], String("v2"), time.Hour)
+	}()
+
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapAlloc >= bufSize {
+		t.Fatalf("expect the source buffer to be collectible, heap still holds %d bytes", ms.HeapAlloc)
+	}
+	if v, ok := lru.Get("kkkkkkkk"); !ok || string(v.(String)) != "v2" {
+		t.Fatalf("expect the cloned key to still be found, got %v %v", v, ok)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	now := time.Unix(0, 0)
+	lru := New(int64(0), nil)
+	lru.Now = func() time.Time { return now }
+	lru.Add("k1", String("v1"), 0)
+	lru.Add("k2", String("v2"), time.Second)
+	lru.Add("k3", String("v3"), 0)
+	lru.Add("k4", String("v4"), 0)
+	now = now.Add(2 * time.Second)
+
+	values := lru.GetAll([]string{"k1", "k2", "missing", "k3"})
+	expect := map[string]Value{"k1": String("v1"), "k3": String("v3")}
+	if !reflect.DeepEqual(values, expect) {
+		t.Fatalf("expect only live keys %v, got %v", expect, values)
+	}
+	// 命中的键被移到最近使用的位置，k4 成为最旧的条目
+	if keys := lru.OldestN(3); !reflect.DeepEqual(keys, []string{"k4", "k1", "k3"}) {
+		t.Fatalf("expect hits promoted in order, got %v", keys)
+	}
+}
+
+func TestNoExpiry(t *testing.T) {
+	lru := NewNoExpiry(int64(0), nil)
+	lru.Now = func() time.Time {
+		t.Fatalf("clock should not be read")
+		return time.Time{}
+	}
+	lru.MaxAge = time.Nanosecond
+	lru.Add("k1", String("v1"), time.Nanosecond)
+	lru.Add("k2", String("v2"), 0)
+	lru.CleanExpired()
+	if v, info, ok := lru.GetWithInfo("k1"); !ok || v != String("v1") || !info.ExpireAt.IsZero() {
+		t.Fatalf("expect k1 to ignore ttl, got %v %+v %v", v, info, ok)
+	}
+	if n, _ := lru.PendingExpiry(); n != 0 || lru.expireHeap != nil {
+		t.Fatalf("expect no expire heap, got %d pending", n)
+	}
+	lru.Add("k2", nil, -1)
+	if lru.Contains("k2") || lru.Len() != 1 {
+		t.Fatalf("negative ttl should still remove k2")
+	}
+}
+
+func benchmarkAdd(b *testing.B, lru *Cache) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lru.Add(keys[i%len(keys)], String("value"), time.Hour)
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	benchmarkAdd(b, New(int64(0), nil))
+}
+
+func BenchmarkAddNoExpiry(b *testing.B) {
+	benchmarkAdd(b, NewNoExpiry(int64(0), nil))
+}
+
+func TestMaxEvictionsPerAdd(t *testing.T) {
+	var callbacks int
+	fill := func(limit int) *Cache {
+		lru := New(int64(10000*len("k0000v")), func(string, Value) { callbacks++ })
+		lru.MaxEvictionsPerAdd = limit
+		lru.EvictionSlack = 8 << 10
+		for i := 0; i < 10000; i++ {
+			lru.Add(fmt.Sprintf("k%04d", i), String("v"), 0)
+		}
+		callbacks = 0
+		return lru
+	}
+
+	// 不限制时写入大值同步淘汰约 800 个条目
+	if n := fill(0).Add("big", String(strings.Repeat("x", 4<<10)), 0); n < 500 || callbacks != n {
+		t.Fatalf("expect hundreds of inline evictions without a limit, got %d with %d callbacks", n, callbacks)
+	}
+
+	// 限制后每次写入同步触发的回调不超过 MaxEvictionsPerAdd
+	lru := fill(100)
+	if n := lru.Add("big", String(strings.Repeat("x", 4<<10)), 0); n != 100 || callbacks != 100 {
+		t.Fatalf("expect 100 inline evictions, got %d with %d callbacks", n, callbacks)
+	}
+	if over := lru.Bytes() - lru.maxBytes; over <= 0 || over > lru.EvictionSlack {
+		t.Fatalf("expect bytes within slack, over by %d", over)
+	}
+	lru.CleanExpired()
+	if lru.Bytes() > lru.maxBytes || !lru.Contains("big") {
+		t.Fatalf("expect deferred evictions to finish on cleanup, %d bytes", lru.Bytes())
+	}
+
+	for i := 0; i < 4; i++ {
+		callbacks = 0
+		lru.Add(fmt.Sprintf("mid%d", i), String(strings.Repeat("x", 2<<10)), 0)
+		if callbacks > lru.MaxEvictionsPerAdd {
+			t.Fatalf("expect at most %d callbacks per Add, got %d", lru.MaxEvictionsPerAdd, callbacks)
+		}
+	}
+	lru.CleanExpired()
+
+	// 超过 slack 时继续淘汰，直到回到 slack 以内
+	callbacks = 0
+	lru.Add("huge", String(strings.Repeat("x", 16<<10)), 0)
+	if lru.Bytes() > lru.maxBytes+lru.EvictionSlack || callbacks <= 100 {
+		t.Fatalf("expect eviction back within slack, %d bytes after %d callbacks", lru.Bytes(), callbacks)
+	}
+}
+
+func TestEvictionFilter(t *testing.T) {
+	var evicted []string
+	lru := New(int64(10*len("k00v")), func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	lru.EvictionFilter = func(key string, value Value) bool {
+		return key[0] != 'p'
+	}
+	add := func(prefix string, from, to int) {
+		for i := from; i < to; i++ {
+			lru.Add(fmt.Sprintf("%s%02d", prefix, i), String("v"), 0)
+		}
+	}
+	// 受保护的条目最旧，仍然先淘汰其他条目
+	add("p", 0, 3)
+	add("u", 0, 14)
+	add("p", 3, 10)
+	if len(evicted) != 14 {
+		t.Fatalf("expect 14 evictions, got %v", evicted)
+	}
+	for _, key := range evicted {
+		if key[0] == 'p' {
+			t.Fatalf("expect protected entries to be kept while others remain, got %v", evicted)
+		}
+	}
+	// 只剩受保护的条目时按原来的顺序淘汰，保证淘汰能推进
+	add("p", 10, 11)
+	if evicted[len(evicted)-1] != "p00" || lru.Len() != 10 {
+		t.Fatalf("expect oldest protected entry evicted, got %v", evicted)
+	}
+
+	// 超过扫描深度的候选不再检查
+	evicted = nil
+	lru = New(int64(4*len("k00v")), func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	lru.EvictionFilter = func(key string, value Value) bool {
+		return key[0] != 'p'
+	}
+	lru.EvictionScanDepth = 2
+	add("p", 0, 3)
+	add("u", 0, 2)
+	if len(evicted) != 1 || evicted[0] != "p00" {
+		t.Fatalf("expect scan depth to bound the search, got %v", evicted)
+	}
+}
+
+func TestEvictionFilterPolicy(t *testing.T) {
+	var evicted []string
+	lru := New(int64(4*len("k0v")), func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	policy := NewLFUPolicy()
+	lru.Policy = policy
+	lru.EvictionFilter = func(key string, value Value) bool {
+		return key[0] != 'p'
+	}
+	for _, key := range []string{"p0", "b1", "c2", "d3"} {
+		lru.Add(key, String("v"), 0)
+	}
+	// 访问次数 p0=1 c2=2 b1=4 d3=4，最近使用顺序为 p0 b1 c2 d3
+	for _, key := range []string{"b1", "b1", "b1", "c2", "d3", "d3", "d3"} {
+		lru.Get(key)
+	}
+	if got := policy.(CandidatePolicy).Victims(4); !reflect.DeepEqual(got, []string{"p0", "c2", "b1", "d3"}) {
+		t.Fatalf("expect candidates in LFU order, got %v", got)
+	}
+	// p0 受保护时淘汰 LFU 顺序中的下一个 c2，而不是最近使用顺序中的 b1
+	lru.Add("e4", String("v"), 0)
+	if len(evicted) != 1 || evicted[0] != "c2" {
+		t.Fatalf("expect the next LFU candidate to be evicted, got %v", evicted)
+	}
+}
+
+func TestEvictionWatermarks(t *testing.T) {
+	var evicted int
+	lru := New(int64(100*len("k00v")), func(string, Value) { evicted++ })
+	lru.HighWatermark, lru.LowWatermark = 0.9, 0.5
+	for i := 0; i < 90; i++ {
+		lru.Add(fmt.Sprintf("k%02d", i), String("v"), 0)
+	}
+	if evicted != 0 {
+		t.Fatalf("expect no eviction at the high watermark, got %d", evicted)
+	}
+	// 越过高水位时一次淘汰到低水位
+	if n := lru.Add("k90", String("v"), 0); n != 41 || lru.Len() != 50 {
+		t.Fatalf("expect batch eviction down to 50 entries, got %d evictions and %d entries", n, lru.Len())
+	}
+	for i := 0; i < 40; i++ {
+		lru.Add(fmt.Sprintf("n%02d", i), String("v"), 0)
+	}
+	if evicted != 41 {
+		t.Fatalf("expect no eviction until the high watermark again, got %d", evicted)
+	}
+
+	// 默认只淘汰到不超过上限
+	lru = New(int64(10*len("k00v")), nil)
+	for i := 0; i < 11; i++ {
+		lru.Add(fmt.Sprintf("k%02d", i), String("v"), 0)
+	}
+	if lru.Len() != 10 {
+		t.Fatalf("expect default to evict one entry, got %d entries", lru.Len())
+	}
+}
+
+func benchmarkSteadyPressure(b *testing.B, high, low float64) {
+	var evictions int
+	lru := New(int64(10000*len("k0000000v")), func(string, Value) { evictions++ })
+	lru.HighWatermark, lru.LowWatermark = high, low
+	keys := make([]string, 1<<16)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%07d", i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lru.Add(keys[i&(len(keys)-1)], String("v"), 0)
+	}
+	b.ReportMetric(float64(evictions)/float64(b.N), "evictions/op")
+}
+
+func BenchmarkAddSteadyPressure(b *testing.B) {
+	benchmarkSteadyPressure(b, 0, 0)
+}
+
+func BenchmarkAddSteadyPressureWatermarks(b *testing.B) {
+	benchmarkSteadyPressure(b, 0.95, 0.85)
+}
+
+func TestPromoteDistance(t *testing.T) {
+	lru := New(0, nil)
+	lru.PromoteDistance = 2
+	for _, k := range []string{"a", "b", "c", "d"} {
+		lru.Add(k, String("v"), 0)
+	}
+	// d、c 离队首不超过 2 个位置，命中时不移动；a 在队尾，命中时移到队首
+	lru.Get("c")
+	lru.Get("a")
+	var order []string
+	for lru.Len() > 0 {
+		order = append(order, lru.ll.Back().Value.(*entry).key)
+		lru.RemoveOldest()
+	}
+	if !reflect.DeepEqual(order, []string{"b", "c", "d", "a"}) {
+		t.Fatalf("unexpected eviction order %v", order)
+	}
+}
+
+func TestPromoteDistanceConsistency(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	lru := New(64*40, nil)
+	lru.PromoteDistance = 8
+	present := make(map[string]bool)
+	lru.OnEvicted = func(key string, value Value) { delete(present, key) }
+	for i := 0; i < 100000; i++ {
+		key := "k" + strconv.Itoa(rnd.Intn(200))
+		switch rnd.Intn(4) {
+		case 0:
+			lru.Add(key, String(strings.Repeat("v", 32)), 0)
+			present[key] = true
+		case 1:
+			lru.Remove(key)
+		default:
+			if _, ok := lru.Get(key); ok != present[key] {
+				t.Fatalf("op %d: Get(%s) = %v, expect %v", i, key, ok, present[key])
+			}
+		}
+	}
+	// 近似只改变顺序：链表、索引和字节统计保持一致，条目只因淘汰或删除而消失
+	var nbytes int64
+	for ele := lru.ll.Front(); ele != nil; ele = ele.Next() {
+		kv := ele.Value.(*entry)
+		if lru.cache[kv.key] != ele || !present[kv.key] {
+			t.Fatalf("list and index disagree on %s", kv.key)
+		}
+		nbytes += int64(len(kv.key) + kv.value.Len())
+	}
+	if lru.ll.Len() != len(lru.cache) || len(lru.cache) != len(present) {
+		t.Fatalf("expect %d entries, list has %d, index %d", len(present), lru.ll.Len(), len(lru.cache))
+	}
+	if nbytes != lru.Bytes() || nbytes > 64*40 {
+		t.Fatalf("byte accounting corrupted: counted %d, recorded %d", nbytes, lru.Bytes())
+	}
+}
+
+func benchmarkGetHit(b *testing.B, promoteDistance int) {
+	lru := New(0, nil)
+	lru.PromoteDistance = promoteDistance
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		lru.Add(keys[i], String(strings.Repeat("v", 32)), 0)
+	}
+	// 少数热点键占大部分命中
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lru.Get(keys[i%8])
+	}
+}
+
+func BenchmarkGetHit(b *testing.B)            { benchmarkGetHit(b, 0) }
+func BenchmarkGetHitApproximate(b *testing.B) { benchmarkGetHit(b, 16) }
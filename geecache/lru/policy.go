@@ -0,0 +1,203 @@
+package lru
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// Policy 决定容量不足时淘汰哪个条目。Cache 在条目写入、被访问和被移除时通知 Policy，
+// 需要淘汰时调用 Victim。Policy 只需要维护键，条目本身仍由 Cache 保存。
+type Policy interface {
+	// OnAdd 在新条目写入、且因它触发的淘汰完成后调用
+	OnAdd(key string)
+	// OnGet 在条目被访问或被覆盖写入后调用
+	OnGet(key string)
+	// OnRemove 在条目因任何原因被移除后调用
+	OnRemove(key string)
+	// Victim 返回下一个应被淘汰的键，没有条目时 ok 为 false
+	Victim() (key string, ok bool)
+}
+
+// CandidatePolicy 是可选的扩展，按淘汰顺序返回多个候选键。设置了 EvictionFilter 时，
+// Cache 在这些候选中跳过被保护的条目；Policy 没有实现它时退回到按最近使用顺序查找。
+type CandidatePolicy interface {
+	Policy
+	// Victims 按淘汰顺序返回至多 n 个键，第一个与 Victim 相同
+	Victims(n int) []string
+}
+
+// listPolicy 用链表维护顺序，moveOnGet 决定访问时是否移到队首
+type listPolicy struct {
+	ll        *list.List
+	elems     map[string]*list.Element
+	moveOnGet bool
+}
+
+// NewLRUPolicy 返回淘汰最近最少使用条目的 Policy，与 Cache 的默认行为相同
+func NewLRUPolicy() Policy {
+	return &listPolicy{ll: list.New(), elems: make(map[string]*list.Element), moveOnGet: true}
+}
+
+// NewFIFOPolicy 返回按写入顺序淘汰条目的 Policy，访问不影响顺序
+func NewFIFOPolicy() Policy {
+	return &listPolicy{ll: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (p *listPolicy) OnAdd(key string) {
+	if ele, ok := p.elems[key]; ok {
+		p.ll.MoveToFront(ele)
+		return
+	}
+	p.elems[key] = p.ll.PushFront(key)
+}
+
+func (p *listPolicy) OnGet(key string) {
+	if ele, ok := p.elems[key]; ok && p.moveOnGet {
+		p.ll.MoveToFront(ele)
+	}
+}
+
+func (p *listPolicy) OnRemove(key string) {
+	if ele, ok := p.elems[key]; ok {
+		p.ll.Remove(ele)
+		delete(p.elems, key)
+	}
+}
+
+func (p *listPolicy) Victim() (string, bool) {
+	if ele := p.ll.Back(); ele != nil {
+		return ele.Value.(string), true
+	}
+	return "", false
+}
+
+func (p *listPolicy) Victims(n int) []string {
+	keys := make([]string, 0, n)
+	for ele := p.ll.Back(); ele != nil && len(keys) < n; ele = ele.Prev() {
+		keys = append(keys, ele.Value.(string))
+	}
+	return keys
+}
+
+// lfuItem 是 LFU 堆中的一项，seq 用于在访问次数相同时淘汰较早使用的条目
+type lfuItem struct {
+	key   string
+	count int
+	seq   uint64
+	index int
+}
+
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].seq < h[j].seq
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	item := x.(*lfuItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+type lfuPolicy struct {
+	h     lfuHeap
+	items map[string]*lfuItem
+	seq   uint64
+}
+
+// NewLFUPolicy 返回淘汰访问次数最少条目的 Policy，次数相同时淘汰较早使用的条目
+func NewLFUPolicy() Policy {
+	return &lfuPolicy{items: make(map[string]*lfuItem)}
+}
+
+func (p *lfuPolicy) OnAdd(key string) {
+	if _, ok := p.items[key]; ok {
+		p.OnGet(key)
+		return
+	}
+	p.seq++
+	item := &lfuItem{key: key, count: 1, seq: p.seq}
+	heap.Push(&p.h, item)
+	p.items[key] = item
+}
+
+func (p *lfuPolicy) OnGet(key string) {
+	if item, ok := p.items[key]; ok {
+		p.seq++
+		item.count++
+		item.seq = p.seq
+		heap.Fix(&p.h, item.index)
+	}
+}
+
+func (p *lfuPolicy) OnRemove(key string) {
+	if item, ok := p.items[key]; ok {
+		heap.Remove(&p.h, item.index)
+		delete(p.items, key)
+	}
+}
+
+func (p *lfuPolicy) Victim() (string, bool) {
+	if len(p.h) == 0 {
+		return "", false
+	}
+	return p.h[0].key, true
+}
+
+// Victims 从堆顶开始按顺序展开，只访问至多 n 个元素的子节点，不改变堆本身
+func (p *lfuPolicy) Victims(n int) []string {
+	keys := make([]string, 0, n)
+	if len(p.h) == 0 {
+		return keys
+	}
+	frontier := &lfuFrontier{h: p.h, idx: []int{0}}
+	for frontier.Len() > 0 && len(keys) < n {
+		i := heap.Pop(frontier).(int)
+		keys = append(keys, p.h[i].key)
+		for _, c := range [2]int{2*i + 1, 2*i + 2} {
+			if c < len(p.h) {
+				heap.Push(frontier, c)
+			}
+		}
+	}
+	return keys
+}
+
+// lfuFrontier 是保存 lfuHeap 下标的最小堆，用于按顺序列出堆中最小的几个元素
+type lfuFrontier struct {
+	h   lfuHeap
+	idx []int
+}
+
+func (f *lfuFrontier) Len() int           { return len(f.idx) }
+func (f *lfuFrontier) Less(i, j int) bool { return f.h.Less(f.idx[i], f.idx[j]) }
+func (f *lfuFrontier) Swap(i, j int)      { f.idx[i], f.idx[j] = f.idx[j], f.idx[i] }
+
+func (f *lfuFrontier) Push(x interface{}) {
+	f.idx = append(f.idx, x.(int))
+}
+
+func (f *lfuFrontier) Pop() interface{} {
+	n := len(f.idx)
+	i := f.idx[n-1]
+	f.idx = f.idx[:n-1]
+	return i
+}
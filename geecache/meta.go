@@ -0,0 +1,42 @@
+package geecache
+
+import (
+	"errors"
+	"time"
+)
+
+// maxMetaBytes 是一个条目的元数据（所有键和值的长度之和）的上限
+const maxMetaBytes = 1 << 10
+
+// ErrMetaTooLarge 表示元数据超过了 maxMetaBytes
+var ErrMetaTooLarge = errors.New("geecache: metadata too large")
+
+// SetWithMeta 与 SetE 相同，同时为条目附加少量元数据，例如内容类型或编码。
+// 元数据与值一起保存、计入缓存的字节数，并通过 GetWithInfo 返回；总大小不能超过 1KB。
+// 预写日志和快照只保存值，不保存元数据。
+func (g *Group) SetWithMeta(key string, value []byte, ttl time.Duration, meta map[string]string) (SetResult, error) {
+	if metaBytes(meta) > maxMetaBytes {
+		return SetResult{}, ErrMetaTooLarge
+	}
+	return g.set(key, value, ttl, cloneMeta(meta))
+}
+
+func metaBytes(meta map[string]string) int {
+	n := 0
+	for k, v := range meta {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+// cloneMeta 复制元数据，空的元数据返回 nil
+func cloneMeta(meta map[string]string) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(meta))
+	for k, v := range meta {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,333 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "geecache/geecachepb"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MultiError 记录批量操作中每个失败键的错误
+type MultiError map[string]error
+
+func (e MultiError) Error() string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	msgs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", k, e[k]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// GetMulti 批量获取多个键的值，返回成功获取的值。
+// keys 中重复的键只查找和加载一次，返回的 map 以不同的键为索引。
+// 注册了对等点时，缺失的键按所属节点分组，每个节点只发起一次批量请求；
+// 批量请求失败的键回退到本地加载。本地加载经过 singleflight，与并发的 Get 和 GetMulti 合并。
+// 加载失败的键记录在返回的 MultiError 中。
+func (g *Group) GetMulti(keys []string) (map[string]ByteView, error) {
+	result := make(map[string]ByteView, len(keys))
+	errs := make(MultiError)
+	var mu sync.Mutex
+	record := func(key string, v ByteView, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs[key] = err
+			return
+		}
+		result[key] = v
+	}
+
+	var missing []string
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if key == "" {
+			errs[key] = fmt.Errorf("key is required")
+			continue
+		}
+		if g.ownerReads && g.ownedByPeer(key) {
+			// 与 Get 相同，远程节点拥有的键总是从拥有者读取
+			atomic.AddInt64(&g.stats.Gets, 1)
+		} else if v, info, _, ok := g.lookupCache(key); ok {
+			if info.Flags&flagNotFound != 0 {
+				errs[key] = ErrNotFound
+			} else {
+				result[key] = v
+			}
+			continue
+		}
+		missing = append(missing, key)
+	}
+
+	var local []string
+	byPeer := make(map[PeerGetter][]string)
+	for _, key := range missing {
+		if g.peerPicker() != nil {
+			if peer, ok := g.peerPicker().PickPeer(key); ok {
+				byPeer[peer] = append(byPeer[peer], key)
+				continue
+			}
+		}
+		local = append(local, key)
+	}
+
+	var wg sync.WaitGroup
+	for peer, keys := range byPeer {
+		wg.Add(1)
+		go func(peer PeerGetter, keys []string) {
+			defer wg.Done()
+			g.getMultiFromPeer(peer, keys, record)
+		}(peer, keys)
+	}
+	// 本地加载与 serveBatch 一样最多同时进行 maxBatchLoads 个
+	forEachBounded(local, func(key string) {
+		v, err := g.loadLocally(key)
+		record(key, v, err)
+	})
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+// SetItem 是 SetManyWithTTL 中的一个值及其 TTL
+type SetItem struct {
+	Value []byte
+	TTL   time.Duration
+}
+
+// SetMany 以相同的 TTL 批量写入多个键值对，例如从数据源批量取回之后，值会被复制。
+// 每个键与 SetE 一样检查并写入；注册了对等点时按所属节点分组，每个节点的键由一个协程依次转发。
+// 返回成功写入的键的结果，失败的键记录在返回的 MultiError 中，不影响其他键。
+func (g *Group) SetMany(items map[string][]byte, ttl time.Duration) (map[string]SetResult, error) {
+	withTTL := make(map[string]SetItem, len(items))
+	for key, value := range items {
+		withTTL[key] = SetItem{Value: value, TTL: ttl}
+	}
+	return g.SetManyWithTTL(withTTL)
+}
+
+// SetManyWithTTL 与 SetMany 相同，但每个键使用各自的 TTL
+func (g *Group) SetManyWithTTL(items map[string]SetItem) (map[string]SetResult, error) {
+	results := make(map[string]SetResult, len(items))
+	errs := make(MultiError)
+	var mu sync.Mutex
+	record := func(key string, res SetResult, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs[key] = err
+			return
+		}
+		results[key] = res
+	}
+
+	var local []string
+	byPeer := make(map[PeerSetter][]string)
+	for key, item := range items {
+		if err := g.checkSet(key, item.Value, item.TTL); err != nil {
+			errs[key] = err
+			continue
+		}
+		if setter := g.ownerSetter(key); setter != nil {
+			byPeer[setter] = append(byPeer[setter], key)
+			continue
+		}
+		local = append(local, key)
+	}
+
+	var wg sync.WaitGroup
+	for setter, keys := range byPeer {
+		wg.Add(1)
+		go func(setter PeerSetter, keys []string) {
+			defer wg.Done()
+			for _, key := range keys {
+				res, err := g.setToPeer(setter, key, items[key].Value, items[key].TTL, nil)
+				record(key, res, err)
+			}
+		}(setter, keys)
+	}
+	for _, key := range local {
+		item := items[key]
+		record(key, g.setOwned(key, g.arena.view(item.Value), item.TTL), nil)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return results, errs
+	}
+	return results, nil
+}
+
+// pb.BatchResponse.Errors 中每个键的错误编码为 "<code>:<message>"，请求方据此区分键不存在和其他错误。
+// batchUncacheable 与 Values 中的值同时出现，表示值有效但请求方不应缓存。
+const (
+	batchNotFound    = "not_found:"
+	batchFailed      = "error:"
+	batchUncacheable = "uncacheable:"
+)
+
+// EncodeBatchError 把一个键的错误编码为 pb.BatchResponse.Errors 中的值。
+// 自行实现 BatchPeerGetter 的服务端应使用它，请求方才能识别 ErrNotFound 而不回退到本地加载；
+// err 为 Uncacheable(nil) 时值仍写入 Values，请求方不会把它写入 hotCache。
+func EncodeBatchError(err error) string {
+	switch {
+	case uncacheableValue(err):
+		return batchUncacheable
+	case errors.Is(err, ErrNotFound):
+		return batchNotFound + err.Error()
+	}
+	return batchFailed + err.Error()
+}
+
+// decodeBatchError 还原 EncodeBatchError 编码的错误，没有编码的错误信息按其他错误处理
+func decodeBatchError(s string) error {
+	switch {
+	case strings.HasPrefix(s, batchNotFound), s == ErrNotFound.Error():
+		return ErrNotFound
+	case s == batchUncacheable:
+		return Uncacheable(nil)
+	case strings.HasPrefix(s, batchFailed):
+		return errors.New(s[len(batchFailed):])
+	}
+	return errors.New(s)
+}
+
+// getMultiFromPeer 向一个对等点发起批量请求，失败的键按 PeerErrorPolicy 回退到本地加载
+func (g *Group) getMultiFromPeer(peer PeerGetter, keys []string, record func(string, ByteView, error)) {
+	atomic.AddInt64(&g.stats.Loads, int64(len(keys)))
+	batcher, ok := peer.(BatchPeerGetter)
+	if !ok {
+		for _, key := range keys {
+			v, err := g.getFromPeerWithPolicy(peer, key)
+			switch {
+			case err == nil || uncacheableValue(err):
+				atomic.AddInt64(&g.stats.PeerLoads, 1)
+				if err == nil {
+					g.populateHotCache(key, v)
+				}
+				err = nil
+			case g.peerPolicy.shouldFallback(err):
+				atomic.AddInt64(&g.stats.PeerFallbacks, 1)
+				v, err = g.loadLocally(key)
+			}
+			record(key, v, err)
+		}
+		return
+	}
+
+	req := &pb.BatchRequest{Group: g.name, Keys: keys}
+	res := &pb.BatchResponse{}
+	if err := g.getBatchWithPolicy(batcher, req, res); err != nil {
+		if !g.peerPolicy.shouldFallback(err) {
+			for _, key := range keys {
+				record(key, ByteView{}, err)
+			}
+			return
+		}
+		res.Reset()
+	}
+	for _, key := range keys {
+		if value, ok := res.GetValues()[key]; ok {
+			atomic.AddInt64(&g.stats.PeerLoads, 1)
+			view := ByteView{b: value}
+			// 拥有者在 Errors 中把不可缓存的值标记为 batchUncacheable
+			if msg, ok := res.GetErrors()[key]; !ok || !uncacheableValue(decodeBatchError(msg)) {
+				g.populateHotCache(key, view)
+			}
+			record(key, view, nil)
+			continue
+		}
+		if msg, ok := res.GetErrors()[key]; ok {
+			// 与单个键的 Get 一样，拥有者返回的 ErrNotFound 不回退，其他错误按策略处理
+			err := decodeBatchError(msg)
+			if !errors.Is(err, ErrNotFound) {
+				atomic.AddInt64(&g.stats.PeerErrors, 1)
+			}
+			if !g.peerPolicy.shouldFallback(err) {
+				record(key, ByteView{}, err)
+				continue
+			}
+		}
+		atomic.AddInt64(&g.stats.PeerFallbacks, 1)
+		v, err := g.loadLocally(key)
+		record(key, v, err)
+	}
+}
+
+// loadLocally 只通过本地 Getter 加载，仍然使用 singleflight 去重
+func (g *Group) loadLocally(key string) (ByteView, error) {
+	// 与 load 共用 singleflight，结果类型必须一致
+	viewi, err := g.loader.Do(g.flightKey(key), func() (interface{}, error) {
+		value, cacheable, err := g.getLocally(context.Background(), key)
+		return loaded{view: value, source: SourceLocalLoad, uncacheable: !cacheable}, err
+	})
+	if err != nil {
+		return ByteView{}, err
+	}
+	return viewi.(loaded).view, nil
+}
+
+// maxBatchLoads 是处理一个批量请求时同时加载的键数上限
+const maxBatchLoads = 16
+
+// forEachBounded 并发地对每个键调用 fn，最多 maxBatchLoads 个协程，
+// 大量的键不会同时占用大量协程和数据源连接。所有调用返回后才返回
+func forEachBounded(keys []string, fn func(key string)) {
+	workers := len(keys)
+	if workers > maxBatchLoads {
+		workers = maxBatchLoads
+	}
+	next := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range next {
+				fn(key)
+			}
+		}()
+	}
+	for _, key := range keys {
+		next <- key
+	}
+	close(next)
+	wg.Wait()
+}
+
+// serveBatch 在拥有这些键的节点上并发获取它们的值，最多同时加载 maxBatchLoads 个键
+func (g *Group) serveBatch(in *pb.BatchRequest, out *pb.BatchResponse) {
+	keys := in.GetKeys()
+	out.Values = make(map[string][]byte, len(keys))
+	out.Errors = make(map[string]string)
+	var mu sync.Mutex
+	forEachBounded(keys, func(key string) {
+		view, info, err := g.getWithInfo(context.Background(), key)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			out.Errors[key] = EncodeBatchError(err)
+			return
+		}
+		out.Values[key] = g.responseBytes(view)
+		if info.Uncacheable {
+			out.Errors[key] = EncodeBatchError(Uncacheable(nil))
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package geecache
+
+import (
+	"fmt"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetMultiFromPeers(t *testing.T) {
+	var originCalls int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&originCalls, 1)
+		if key == "unknown" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte("v-" + key), nil
+	})
+
+	names := []string{"A", "B", "C"}
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add(names...)
+	nodes := make(map[string]*testPeer)
+	for _, name := range names {
+		g := NewGroup("multi", 2<<10, getter)
+		g.RegisterPeers(&testPicker{self: name, ring: ring, nodes: nodes})
+		nodes[name] = &testPeer{name: name, g: g}
+	}
+
+	var keys []string
+	for i := 0; i < 30; i++ {
+		keys = append(keys, fmt.Sprintf("key%d", i))
+	}
+	values, err := nodes["A"].g.GetMulti(append(keys, "unknown"))
+	if merr, ok := err.(MultiError); !ok || len(merr) != 1 || merr["unknown"] == nil {
+		t.Fatalf("expect only unknown to fail, but %v got", err)
+	}
+	for _, key := range keys {
+		if values[key].String() != "v-"+key {
+			t.Fatalf("GetMulti %s failed", key)
+		}
+	}
+	for _, name := range []string{"B", "C"} {
+		if n := atomic.LoadInt32(&nodes[name].batches); n != 1 {
+			t.Fatalf("expect 1 batch to %s, but %d got", name, n)
+		}
+	}
+	if n := atomic.LoadInt32(&originCalls); n != int32(len(keys)+1) {
+		t.Fatalf("expect %d origin calls, but %d got", len(keys)+1, n)
+	}
+
+	// B 宕机时，属于 B 的键回退到本地加载
+	nodes["B"].down = true
+	var more []string
+	for i := 30; i < 60; i++ {
+		more = append(more, fmt.Sprintf("key%d", i))
+	}
+	values, err = nodes["A"].g.GetMulti(more)
+	if err != nil || len(values) != len(more) {
+		t.Fatalf("expect fallback to local load, but %d values and %v got", len(values), err)
+	}
+}
+
+func TestGetMultiDuplicateKeys(t *testing.T) {
+	var calls int32
+	g := NewGroup("multi-dup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return []byte("v-" + key), nil
+	}))
+
+	// 两个并发的 GetMulti 请求同一组键，每个键只加载一次
+	var wg sync.WaitGroup
+	results := make([]map[string]ByteView, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = g.GetMulti([]string{"a", "b", "a", "", "b", "a", ""})
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expect each distinct key loaded once, got %d loads", n)
+	}
+	if n := g.Stats().Gets; n != 4 {
+		t.Fatalf("expect duplicates to be looked up once, got %d lookups for two calls", n)
+	}
+	for i := range results {
+		merr, ok := errs[i].(MultiError)
+		if !ok || len(merr) != 1 || merr[""] == nil {
+			t.Fatalf("expect only the empty key to fail once, got %v", errs[i])
+		}
+		if len(results[i]) != 2 || results[i]["a"].String() != "v-a" || results[i]["b"].String() != "v-b" {
+			t.Fatalf("unexpected values %v", results[i])
+		}
+	}
+}
+
+func TestBatchLoadsBounded(t *testing.T) {
+	var running, peak int32
+	g := NewGroup("batch-loads-bounded", 64<<10, GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		return []byte("v-" + key), nil
+	}))
+	defer g.Close()
+	in := &pb.BatchRequest{Group: g.name}
+	for i := 0; i < 10*maxBatchLoads; i++ {
+		in.Keys = append(in.Keys, fmt.Sprint("key", i))
+	}
+	out := &pb.BatchResponse{}
+	g.serveBatch(in, out)
+	if len(out.Values) != len(in.Keys) || len(out.Errors) != 0 {
+		t.Fatalf("expect every key served, got %d values and %v", len(out.Values), out.Errors)
+	}
+	if p := atomic.LoadInt32(&peak); p > maxBatchLoads {
+		t.Fatalf("expect at most %d concurrent loads, got %d", maxBatchLoads, p)
+	}
+
+	// GetMulti 的本地加载同样有上限
+	atomic.StoreInt32(&peak, 0)
+	keys := make([]string, 0, 10*maxBatchLoads)
+	for i := 0; i < 10*maxBatchLoads; i++ {
+		keys = append(keys, fmt.Sprint("multi", i))
+	}
+	values, err := g.GetMulti(keys)
+	if err != nil || len(values) != len(keys) {
+		t.Fatalf("expect every key loaded, got %d values and %v", len(values), err)
+	}
+	if p := atomic.LoadInt32(&peak); p > maxBatchLoads {
+		t.Fatalf("expect at most %d concurrent loads in GetMulti, got %d", maxBatchLoads, p)
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	})
+	names := []string{"A", "B", "C"}
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add(names...)
+	nodes := make(map[string]*testPeer)
+	for _, name := range names {
+		g := NewGroup("set-many", 2<<10, getter, WithMaxValueBytes(16))
+		g.RegisterPeers(&testPicker{self: name, ring: ring, nodes: nodes})
+		nodes[name] = &testPeer{name: name, g: g}
+	}
+
+	items := make(map[string][]byte)
+	for i := 0; i < 30; i++ {
+		items[fmt.Sprintf("key%d", i)] = []byte(fmt.Sprintf("v%d", i))
+	}
+	items["big"] = make([]byte, 17)
+	items[""] = []byte("v")
+	results, err := nodes["A"].g.SetMany(items, time.Minute)
+	merr, ok := err.(MultiError)
+	if !ok || len(merr) != 2 || merr["big"] != ErrValueTooLarge || merr[""] == nil {
+		t.Fatalf("expect only big and the empty key to fail, but %v got", err)
+	}
+	if len(results) != 30 {
+		t.Fatalf("expect 30 results, but %d got", len(results))
+	}
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owner := nodes[ring.Get(key)].g
+		v, ok := owner.mainCache.get(key)
+		if !ok || v.String() != fmt.Sprintf("v%d", i) {
+			t.Fatalf("expect %s stored on its owner %s", key, ring.Get(key))
+		}
+		if routed := results[key].RoutedToPeer; (ring.Get(key) == "A") != (routed == "") {
+			t.Fatalf("%s owned by %s routed to %q", key, ring.Get(key), routed)
+		}
+	}
+
+	// 每个键可以有各自的 TTL，值被复制
+	value := []byte("short")
+	owner := nodes[ring.Get("ttl")].g
+	owner.SetManyWithTTL(map[string]SetItem{"ttl": {Value: value, TTL: -1}, "keep": {Value: value}})
+	value[0] = 'X'
+	if _, ok := owner.mainCache.get("ttl"); ok {
+		t.Fatalf("expect a negative ttl to delete the key")
+	}
+	if v, err := nodes[ring.Get("keep")].g.Get("keep"); err != nil || v.String() != "short" {
+		t.Fatalf("expect keep to be stored with a copied value, got %q %v", v.String(), err)
+	}
+}
@@ -0,0 +1,312 @@
+package geecache
+
+import (
+	"errors"
+	"geecache/lru"
+	"time"
+)
+
+// GroupOption 配置 Group 的可选项
+type GroupOption func(*Group)
+
+// WithClock 设置 Group 使用的时钟，便于测试
+func WithClock(now func() time.Time) GroupOption {
+	return func(g *Group) {
+		g.now = now
+	}
+}
+
+// WithDefaultTTL 设置加载的值写入缓存时使用的 TTL，默认为 0，即不过期。Set 仍然使用调用者给出的 TTL。
+// 不可变的 Group 忽略该设置。运行时可以用 SetDefaultTTL 修改。
+func WithDefaultTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.loadTTL = int64(ttl)
+	}
+}
+
+// WithMaxEntryAge 设置条目的最大存活时间，超过后即使 TTL 未到也视为未命中并重新加载
+func WithMaxEntryAge(maxAge time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.maxAge = maxAge
+	}
+}
+
+// WithReplication 开启热备复制：本节点拥有的键被加载或 Set 后，
+// 异步推送到哈希环上的后继节点。queueSize 为待推送队列长度，队列满时丢弃并计数。
+func WithReplication(queueSize int) GroupOption {
+	return func(g *Group) {
+		g.replicas = make(chan replica, queueSize)
+	}
+}
+
+// WithMaxValueBytes 设置单个值的最大字节数，超过的值不会被缓存，Set 会返回 ErrValueTooLarge
+func WithMaxValueBytes(n int64) GroupOption {
+	return func(g *Group) {
+		g.maxValueBytes = n
+	}
+}
+
+// WithReadOnly 将 Group 设为只读，Set 会返回 ErrReadOnly，数据只能通过 Getter 加载
+func WithReadOnly() GroupOption {
+	return func(g *Group) {
+		g.readOnly = true
+	}
+}
+
+// WithImmutable 将 Group 标记为不可变：值一旦写入就不会改变，例如以内容哈希为键的数据。
+// 缓存不再维护过期信息，也不参与后台过期清理；带 TTL 的 Set 返回 ErrTTLNotAllowed，
+// WithMaxEntryAge 和 WithNegativeTTL 不生效。本地缓存的值在响应远程节点时不再复制。
+func WithImmutable() GroupOption {
+	return func(g *Group) {
+		g.immutable = true
+	}
+}
+
+// WithTombstones 开启删除墓碑：Delete 之后的 window 内，该键的加载结果和来自后继节点的副本写入
+// 不会写回缓存，调用者仍然得到加载的值。墓碑随删除一起传给拥有者和后继节点。
+// 显式的 Set 表示比删除更新的写入，会清除墓碑。
+func WithTombstones(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.tombstones = newTombstones(window)
+	}
+}
+
+// WithCleanupInterval 设置后台清理过期条目的最小间隔，默认为 100ms。后台清理在最早的条目过期之后运行，
+// 没有带 TTL 的条目时不运行；间隔内过期的条目合并为一次清理。
+func WithCleanupInterval(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.cleanupInterval = d
+	}
+}
+
+// WithCleanupTicker 用 t 驱动这个 Group 的过期清理，代替按 WithCleanupInterval 运行的共享调度器，
+// 主要用于测试中确定性地触发清理。Group 关闭时 t 被停止。
+func WithCleanupTicker(t Ticker) GroupOption {
+	return func(g *Group) {
+		g.sweeper = &sweeper{ticker: t, g: g}
+	}
+}
+
+// WithPreciseExpiry 让带 TTL 的条目在到期后约 10ms 内被移出 mainCache，而不是等到下一次周期性清理，
+// 代替 WithCleanupInterval 和 WithCleanupTicker。每个 Group 多占用一个协程，适合依赖条目及时移除的场景。
+func WithPreciseExpiry() GroupOption {
+	return func(g *Group) {
+		g.precise = newPreciseExpiry(g)
+	}
+}
+
+// WithSmallValueFastPath 为大量很小（不超过 64 字节）的热点值优化：这些值从共享的块（最大 4KB）中分配，
+// 代替每个值单独分配；命中离最近使用位置不超过 promoteDistance 的条目时不移动它，省去链表操作，
+// 淘汰顺序因此变为近似 LRU。promoteDistance 不大于 0 时使用默认值 16。
+// 块中的值全部被淘汰后块才会被回收，因此仍有值被缓存的块整块计入缓存的字节上限。
+func WithSmallValueFastPath(promoteDistance int) GroupOption {
+	return func(g *Group) {
+		if promoteDistance <= 0 {
+			promoteDistance = defaultPromoteDistance
+		}
+		g.arena = newSmallArena(g.mainCache.cacheBytes)
+		g.mainCache.arena = g.arena
+		g.mainCache.promoteDistance = promoteDistance
+	}
+}
+
+// WithNegativeTTL 开启负缓存：Getter 返回 ErrNotFound 时，在 ttl 内直接返回 ErrNotFound 而不再加载
+func WithNegativeTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+	}
+}
+
+// WithHotCache 开启 hotCache，缓存最多 cacheBytes 字节从远程节点获取的值
+func WithHotCache(cacheBytes int64) GroupOption {
+	return func(g *Group) {
+		g.hotCache.cacheBytes = cacheBytes
+	}
+}
+
+// WithTierOrder 设置 Get 查找 mainCache 和 hotCache 的顺序，默认为 TierOrderByOwner
+func WithTierOrder(order TierOrder) GroupOption {
+	return func(g *Group) {
+		g.tierOrder = order
+	}
+}
+
+// WithOwnerReads 开启严格一致的读：远程节点拥有的键不使用本地缓存，每次都从拥有者读取。
+// 以延迟换取跨节点的读己之写。
+func WithOwnerReads() GroupOption {
+	return func(g *Group) {
+		g.ownerReads = true
+	}
+}
+
+// WithLoadRetry 为本地 Getter 的加载开启有限次数的重试，用于应对数据源的瞬时错误。
+// attempts 为总尝试次数，每次重试前等待带抖动的指数退避时间，首次约为 baseBackoff。
+// retryIf 为 nil 时重试除 ErrNotFound 以外的所有错误。
+func WithLoadRetry(attempts int, baseBackoff time.Duration, retryIf func(error) bool) GroupOption {
+	return func(g *Group) {
+		if attempts <= 1 {
+			g.retry = nil
+			return
+		}
+		if retryIf == nil {
+			retryIf = func(err error) bool { return !errors.Is(err, ErrNotFound) }
+		}
+		g.retry = &retryPolicy{attempts: attempts, backoff: baseBackoff, retryIf: retryIf}
+	}
+}
+
+// WithPeerErrorPolicy 设置从远程节点获取失败后的行为，默认为 Fallback
+func WithPeerErrorPolicy(p PeerErrorPolicy) GroupOption {
+	return func(g *Group) {
+		g.peerPolicy = p
+	}
+}
+
+// WithMaxPrefetches 设置同时进行的预取数量上限，默认为 16
+func WithMaxPrefetches(n int) GroupOption {
+	return func(g *Group) {
+		if n < 1 {
+			n = 1
+		}
+		g.prefetchSem = make(chan struct{}, n)
+	}
+}
+
+// WithEvictionPolicy 设置本地缓存的淘汰策略，默认为 LRU。
+// newPolicy 为 mainCache 和 hotCache 分别创建实例，例如 lru.NewLFUPolicy。
+func WithEvictionPolicy(newPolicy func() lru.Policy) GroupOption {
+	return func(g *Group) {
+		g.mainCache.newPolicy = newPolicy
+		g.hotCache.newPolicy = newPolicy
+	}
+}
+
+// WithEvictionLimit 限制每次写入同步淘汰的条目数，避免写入大值时长时间持有锁、阻塞并发的 Get。
+// 剩余的淘汰推迟到之后的写入或后台过期清理，期间缓存最多暂时超过上限 slack 字节；
+// 超过 slack 时写入仍会继续淘汰。不可变的 Group 没有后台清理，推迟的淘汰只在之后的写入中完成。
+func WithEvictionLimit(perAdd int, slack int64) GroupOption {
+	return func(g *Group) {
+		g.mainCache.maxEvictions, g.mainCache.evictionSlack = perAdd, slack
+		g.hotCache.maxEvictions, g.hotCache.evictionSlack = perAdd, slack
+	}
+}
+
+// WithEvictionWatermarks 开启批量淘汰：缓存用量超过 cacheBytes 的 high 比例时，一次淘汰到 low 比例以下，
+// 适合持续写入的场景，避免每次写入都淘汰一两个条目。默认 high 和 low 都是 1，即只淘汰到不超过上限。
+// 与只用于通知的 WithUsageAlerts 无关。
+func WithEvictionWatermarks(high, low float64) GroupOption {
+	return func(g *Group) {
+		g.mainCache.highWatermark, g.mainCache.lowWatermark = high, low
+		g.hotCache.highWatermark, g.hotCache.lowWatermark = high, low
+	}
+}
+
+// WithEvictionFilter 设置淘汰过滤：缓存已满需要淘汰时，fn 返回 false 的条目被跳过，优先淘汰其他条目，
+// 例如保护重新计算代价高的值。每次淘汰最多检查 16 个候选，都被跳过时仍然淘汰，保证缓存不超过上限。
+// fn 在持有缓存锁时调用，不能访问该 Group；开启压缩时被检查的值需要先解压。
+func WithEvictionFilter(fn func(key string, value ByteView) bool) GroupOption {
+	return func(g *Group) {
+		g.mainCache.evictionFilter = fn
+		g.hotCache.evictionFilter = fn
+	}
+}
+
+// WithCacheShards 把 mainCache 分成 n 个分片，每个分片有自己的锁和 lru，按键的哈希选择，减少并发读写之间的锁争用。
+// 字节上限平均分给各分片，余数分给前面的分片，各分片的上限之和等于 mainCache 的上限，Resize 时重新分配。
+// LRU 顺序和淘汰只在分片内部成立。过期清理每次只处理一部分分片，轮流进行，大量条目同时过期时清理分摊到多次。
+// Stats 的 CacheShards 返回各分片的快照。n 小于 1 时 panic，1 表示不分片。
+func WithCacheShards(n int) GroupOption {
+	if n < 1 {
+		panic("geecache: WithCacheShards needs at least one shard")
+	}
+	return func(g *Group) {
+		g.mainCache.shardCount = n
+	}
+}
+
+// WithBatchWindow 在 Getter 实现了 CoalescingGetter 时开启批量加载：
+// 未命中的键最多等待 window，或凑够 maxKeys 个后，合并为一次 GetBatch 调用。
+// 每个键仍然经过 singleflight，调用者各自得到自己的值或错误。
+func WithBatchWindow(window time.Duration, maxKeys int) GroupOption {
+	return func(g *Group) {
+		cg, ok := g.getter.(CoalescingGetter)
+		if !ok {
+			return
+		}
+		if maxKeys < 1 {
+			maxKeys = 1
+		}
+		g.coalescer = &coalescer{getter: cg, window: window, maxKeys: maxKeys, stats: &g.stats}
+	}
+}
+
+// WithWaitForPeers 让 RegisterPeers 之前的加载等待，避免节点刚启动、对等点尚未注册时
+// 所有请求都在本地加载而冲击数据源。timeout 从 NewGroup 开始计算，超时之后不使用对等点继续加载并计数，
+// 之后的加载也不再等待。
+func WithWaitForPeers(timeout time.Duration) GroupOption {
+	return func(g *Group) {
+		g.peersWait = timeout
+	}
+}
+
+// WithSharedBudget 让 Group 的 mainCache 从 a 管理的共享预算中分配字节数，
+// NewGroup 的 cacheBytes 参数被忽略。
+func WithSharedBudget(a *BudgetArbiter) GroupOption {
+	return func(g *Group) {
+		g.budget = a
+	}
+}
+
+// WithLoadTimeout 设置本地 Getter 加载的默认超时，包含重试在内，0 表示不限制
+func WithLoadTimeout(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.loadTimeout = d
+	}
+}
+
+// WithKeyTimeout 为 match 返回 true 的键设置本地加载的超时，优先于 WithLoadTimeout。
+// 可以多次使用，按添加的顺序取第一条匹配的规则。
+func WithKeyTimeout(match func(key string) bool, d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.keyTimeouts = append(g.keyTimeouts, keyTimeout{match, d})
+	}
+}
+
+// WithMaxAbandonedLoads 设置超时后仍未返回的 Getter 调用数的上限，默认为 64。
+// 不支持 ctx 的 Getter 在加载超时后无法被中止，调用它的协程会一直等到它返回；
+// 达到上限后新的加载直接返回 ErrLoadBacklog，避免数据源挂起时协程无限堆积。
+func WithMaxAbandonedLoads(n int) GroupOption {
+	return func(g *Group) {
+		g.maxAbandonedLoads = int32(n)
+	}
+}
+
+// WithLoadTracking 开启回源加载的热点统计：最多跟踪 capacity 个键，统计最近一到两个 window 内的加载，
+// window 为 0 时统计自创建以来的全部加载。结果通过 TopLoadedKeys 读取。
+func WithLoadTracking(capacity int, window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.loadTracker = newLoadTracker(capacity, window, g.now())
+	}
+}
+
+// WithHealthWindow 设置 Health 统计错误率的时间窗口（默认一分钟）以及保留的最近错误数（默认 10）
+func WithHealthWindow(window time.Duration, recentErrors int) GroupOption {
+	return func(g *Group) {
+		g.health = newHealthTracker(window, recentErrors)
+	}
+}
+
+// WithPinBudget 允许用 Pin 和 PinRemote 固定条目，固定的总字节数不超过 maxBytes。
+// 固定的条目不占用 mainCache 的容量；没有设置时 Pin 返回 ErrPinBudget。
+func WithPinBudget(maxBytes int64) GroupOption {
+	return func(g *Group) {
+		g.pins = &pins{budget: maxBytes, m: make(map[string]pinned)}
+	}
+}
+
+// WithFlight 用 f 代替默认的 singleflight.Group 合并加载，主要用于测试
+func WithFlight(f Flight) GroupOption {
+	return func(g *Group) {
+		g.loader = f
+	}
+}
@@ -0,0 +1,42 @@
+package geecache
+
+import "sync/atomic"
+
+// OwnershipGranting 是 Getter 可选实现的接口。GrantsOwnership 返回 true 时，
+// Getter 保证 Get 成功返回的切片只为这一次调用创建，之后既不保留也不修改它，
+// Group 因此直接缓存该切片而不再复制一份。违反约定会让缓存中的值被悄悄改写，
+// 可以在测试中用 SetOwnershipDebug 检查。
+type OwnershipGranting interface {
+	GrantsOwnership() bool
+}
+
+// poisonByte 是调试模式下覆盖原切片所用的字节
+const poisonByte = 0xde
+
+var ownershipDebug int32
+
+// SetOwnershipDebug 开启时，Group 仍然复制 OwnershipGranting Getter 返回的切片，
+// 并用 0xde 覆盖原切片，使保留并继续使用该切片的 Getter 在测试中暴露出来。
+func SetOwnershipDebug(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&ownershipDebug, v)
+}
+
+// viewFromGetter 把 Getter 返回的切片包装为 ByteView，Getter 未转移所有权时复制一份
+func (g *Group) viewFromGetter(b []byte) ByteView {
+	og, ok := g.getter.(OwnershipGranting)
+	if !ok || !og.GrantsOwnership() {
+		return g.arena.view(b)
+	}
+	if atomic.LoadInt32(&ownershipDebug) == 0 {
+		return ByteView{b: b}
+	}
+	v := ByteView{b: cloneBytes(b)}
+	for i := range b {
+		b[i] = poisonByte
+	}
+	return v
+}
@@ -1,6 +1,9 @@
 package geecache
 
-import pb "geecache/geecachepb"
+import (
+	pb "geecache/geecachepb"
+	"time"
+)
 
 // PeerPicker 是必须实现的接口，用于定位拥有特定键的对等点。
 type PeerPicker interface {
@@ -11,3 +14,51 @@ type PeerPicker interface {
 type PeerGetter interface {
 	Get(in *pb.Request, out *pb.Response) error
 }
+
+// BatchPeerGetter 是对等点可选实现的接口，用一次请求获取多个键。
+// 获取失败的键记录在 out.Errors 中。
+type BatchPeerGetter interface {
+	GetBatch(in *pb.BatchRequest, out *pb.BatchResponse) error
+}
+
+// PeerChecker 是对等点可选实现的接口，只查询键是否已被缓存，不传输值也不触发加载。
+type PeerChecker interface {
+	Has(in *pb.Request, out *pb.HasResponse) error
+}
+
+// PeerDeleter 是对等点可选实现的接口，用于删除远程节点上的键。
+type PeerDeleter interface {
+	Delete(in *pb.Request, out *pb.DeleteResponse) error
+}
+
+// PeerSetter 是对等点可选实现的接口，用于把值写入远程节点的本地缓存。
+type PeerSetter interface {
+	Set(in *pb.SetRequest, out *pb.SetResponse) error
+}
+
+// SuccessorPicker 是 PeerPicker 可选实现的接口。
+// 当本节点拥有该键时，返回它在哈希环上的后继节点，用于热备复制。
+type SuccessorPicker interface {
+	PickSuccessor(key string) (peer PeerSetter, ok bool)
+}
+
+// PeerLister 是 PeerPicker 可选实现的接口，返回除本节点以外的所有对等点，用于广播。
+type PeerLister interface {
+	ListPeers() []PeerGetter
+}
+
+// PeerPrefixInvalidator 是对等点可选实现的接口，移除远程节点本地缓存中以 prefix 开头的键，返回移除的数量。
+type PeerPrefixInvalidator interface {
+	InvalidatePrefix(group, prefix string) (int, error)
+}
+
+// PeerPinner 是对等点可选实现的接口，在远程节点上固定键 ttl 时长，键不存在时远程节点先加载它。
+type PeerPinner interface {
+	Pin(group, key string, ttl time.Duration) error
+}
+
+// OwnerLocator 是 PeerPicker 可选实现的接口，只查询拥有 key 的节点，不记录日志也不返回连接，
+// 用于判断键归属的热路径。
+type OwnerLocator interface {
+	OwnerOf(key string) (addr string, self bool)
+}
@@ -0,0 +1,165 @@
+package geecache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// peerEntry 是 JSON 格式对等点文件中的一项
+type peerEntry struct {
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight"`
+}
+
+// parsePeers 解析对等点文件，支持每行一个 URL（# 开头为注释），
+// 或 JSON 数组，元素为 URL 字符串或 {"addr": ..., "weight": ...}。
+func parsePeers(data []byte) (map[string]int, error) {
+	weights := make(map[string]int)
+	add := func(addr string, weight int) error {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return fmt.Errorf("invalid peer %q: %v", addr, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid peer %q: want http(s)://host[:port]", addr)
+		}
+		if weight < 1 {
+			return fmt.Errorf("invalid weight %d for peer %q", weight, addr)
+		}
+		if _, ok := weights[addr]; ok {
+			return fmt.Errorf("duplicate peer %q", addr)
+		}
+		weights[addr] = weight
+		return nil
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("decoding peers: %v", err)
+		}
+		for _, r := range raw {
+			var addr string
+			if err := json.Unmarshal(r, &addr); err == nil {
+				if err := add(addr, 1); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			e := peerEntry{Weight: 1}
+			if err := json.Unmarshal(r, &e); err != nil {
+				return nil, fmt.Errorf("decoding peer %s: %v", r, err)
+			}
+			if err := add(e.Addr, e.Weight); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := add(line, 1); err != nil {
+				return nil, err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(weights) == 0 {
+		return nil, fmt.Errorf("no peers found")
+	}
+	return weights, nil
+}
+
+// LoadPeersFile 从文件加载对等点列表并应用到池上。
+// 文件格式有误时整体拒绝，保留原来的哈希环，错误同时记录在 LastReloadError 中。
+func (p *HTTPPool) LoadPeersFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = p.applyPeersFile(data)
+	}
+	p.mu.Lock()
+	p.lastReloadErr = err
+	p.mu.Unlock()
+	if err != nil {
+		p.Log("reload peers from %s failed: %v", path, err)
+	}
+	return err
+}
+
+func (p *HTTPPool) applyPeersFile(data []byte) error {
+	weights, err := parsePeers(data)
+	if err != nil {
+		return err
+	}
+	p.setWeightedPeers(weights, false)
+	return nil
+}
+
+// LastReloadError 返回最近一次加载对等点文件的错误，成功时为 nil
+func (p *HTTPPool) LastReloadError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastReloadErr
+}
+
+// WatchPeersFile 加载对等点文件，之后每隔 interval 检查文件内容，
+// 或在收到 SIGHUP 时重新加载。返回的 stop 用于停止监视，可以多次调用。
+func (p *HTTPPool) WatchPeersFile(path string, interval time.Duration) (stop func(), err error) {
+	if err := p.LoadPeersFile(path); err != nil {
+		return nil, err
+	}
+	last, _ := ioutil.ReadFile(path)
+	failed := false
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				p.LoadPeersFile(path)
+			case <-ticker.C:
+				data, err := ioutil.ReadFile(path)
+				if err != nil {
+					// 文件不可读时只记录一次，直到它恢复
+					if !failed {
+						failed = true
+						p.LoadPeersFile(path)
+					}
+					continue
+				}
+				if !failed && bytes.Equal(data, last) {
+					continue
+				}
+				failed = false
+				last = data
+				p.LoadPeersFile(path)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }, nil
+}
@@ -0,0 +1,95 @@
+package geecache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePeers(t *testing.T) {
+	good := map[string]map[string]int{
+		"# peers\nhttp://a:8001\n\nhttp://b:8002\n":                 {"http://a:8001": 1, "http://b:8002": 1},
+		`["http://a:8001", {"addr": "http://b:8002", "weight": 3}]`: {"http://a:8001": 1, "http://b:8002": 3},
+		`[{"addr": "https://a:8001"}]`:                              {"https://a:8001": 1},
+	}
+	for data, expect := range good {
+		if weights, err := parsePeers([]byte(data)); err != nil || !reflect.DeepEqual(weights, expect) {
+			t.Errorf("parsePeers(%q) = %v, %v", data, weights, err)
+		}
+	}
+
+	bad := []string{
+		"",
+		"a:8001",
+		"http://a:8001\nhttp://a:8001",
+		`["http://a:8001"`,
+		`[{"addr": "http://a:8001", "weight": 0}]`,
+	}
+	for _, data := range bad {
+		if _, err := parsePeers([]byte(data)); err == nil {
+			t.Errorf("parsePeers(%q) should fail", data)
+		}
+	}
+}
+
+func TestWatchPeersFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers.txt")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	owners := func(p *HTTPPool) map[string]bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		owners := make(map[string]bool)
+		for i := 0; i < 100; i++ {
+			owners[p.peers.Get(string(rune('a'+i%26))+string(rune('a'+i/26)))] = true
+		}
+		return owners
+	}
+
+	write("http://a\nhttp://b\n")
+	pool := NewHTTPPool("http://a")
+	stop, err := pool.WatchPeersFile(path, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stop 可以重复调用
+	defer stop()
+	defer stop()
+	if o := owners(pool); !o["http://a"] || !o["http://b"] {
+		t.Fatalf("expect keys routed to a and b, but %v got", o)
+	}
+
+	// 格式错误的文件被整体拒绝，保留原来的哈希环
+	write("http://a\nnot a url\n")
+	time.Sleep(50 * time.Millisecond)
+	if pool.LastReloadError() == nil {
+		t.Fatalf("expect reload error for malformed file")
+	}
+	if o := owners(pool); !o["http://a"] || !o["http://b"] {
+		t.Fatalf("malformed file should keep the old ring, but %v got", o)
+	}
+
+	write("http://a\nhttp://c\n")
+	deadline := time.Now().Add(time.Second)
+	for {
+		o := owners(pool)
+		if o["http://c"] && !o["http://b"] && pool.LastReloadError() == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("routing did not change after rewrite, %v", o)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,243 @@
+package geecache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrPinBudget 表示固定条目会使 Group 固定的总字节数超过 WithPinBudget 设置的上限，
+// 没有设置上限的 Group 不接受固定
+var ErrPinBudget = errors.New("geecache: pin budget exceeded")
+
+// pinned 是一个被固定的条目
+type pinned struct {
+	view  ByteView
+	at    time.Time // 固定的时间
+	until time.Time // 到期后自动解除固定
+}
+
+// pins 保存被固定的条目。它们不在 mainCache 的淘汰范围内，总字节数不超过 budget。
+type pins struct {
+	mu     sync.Mutex
+	budget int64
+	bytes  int64
+	m      map[string]pinned
+}
+
+func pinSize(key string, v ByteView) int64 {
+	return int64(len(key) + v.Len() + metaBytes(v.meta))
+}
+
+// add 固定 key，已固定的 key 更新值并延长到 until
+func (p *pins) add(key string, v ByteView, now, until time.Time) error {
+	if p == nil {
+		return ErrPinBudget
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bytes := p.bytes + pinSize(key, v)
+	if old, ok := p.m[key]; ok {
+		bytes -= pinSize(key, old.view)
+	}
+	if bytes > p.budget {
+		return ErrPinBudget
+	}
+	p.bytes = bytes
+	p.m[key] = pinned{view: v, at: now, until: until}
+	return nil
+}
+
+// get 返回固定的值。固定已到期时解除固定，expired 为 true 并返回原来的值
+func (p *pins) get(key string, now time.Time) (e pinned, ok, expired bool) {
+	if p == nil {
+		return pinned{}, false, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok = p.m[key]
+	if ok && !now.Before(e.until) {
+		p.removeLocked(key)
+		return e, false, true
+	}
+	return e, ok, false
+}
+
+// update 在 key 被固定时替换固定的值，新值超过上限时解除固定
+func (p *pins) update(key string, v ByteView) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old, ok := p.m[key]
+	if !ok {
+		return
+	}
+	p.removeLocked(key)
+	if p.bytes+pinSize(key, v) <= p.budget {
+		p.bytes += pinSize(key, v)
+		p.m[key] = pinned{view: v, at: old.at, until: old.until}
+	}
+}
+
+// remove 解除 key 的固定，返回原来的值
+func (p *pins) remove(key string) (ByteView, bool) {
+	if p == nil {
+		return ByteView{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.m[key]
+	p.removeLocked(key)
+	return e.view, ok
+}
+
+// removeFunc 解除 fn 返回 true 的键的固定，返回解除的数量
+func (p *pins) removeFunc(fn func(key string) bool) int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for key := range p.m {
+		if fn(key) {
+			p.removeLocked(key)
+			n++
+		}
+	}
+	return n
+}
+
+// expire 解除所有已到期的固定，返回它们原来的值
+func (p *pins) expire(now time.Time) map[string]ByteView {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var expired map[string]ByteView
+	for key, e := range p.m {
+		if !now.Before(e.until) {
+			if expired == nil {
+				expired = make(map[string]ByteView)
+			}
+			expired[key] = e.view
+			p.removeLocked(key)
+		}
+	}
+	return expired
+}
+
+// nextExpiry 返回最早的固定到期时间，没有固定的条目时为零值
+func (p *pins) nextExpiry() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var next time.Time
+	for _, e := range p.m {
+		if next.IsZero() || e.until.Before(next) {
+			next = e.until
+		}
+	}
+	return next
+}
+
+func (p *pins) removeLocked(key string) {
+	if e, ok := p.m[key]; ok {
+		p.bytes -= pinSize(key, e.view)
+		delete(p.m, key)
+	}
+}
+
+// Pin 在本节点固定 key 的值 ttl 时长，期间它不会因容量不足被淘汰，也不会过期。
+// 值不在本地缓存中时先按 Get 的方式获取。再次固定会更新到期时间；到期后值回到 mainCache，
+// 像普通条目一样被淘汰。Set 和 Delete 同样作用于固定的值。
+// 固定的总字节数受 WithPinBudget 限制，超过时返回 ErrPinBudget。
+func (g *Group) Pin(key string, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("geecache: pin ttl must be positive")
+	}
+	if g.pins == nil {
+		return ErrPinBudget
+	}
+	view, err := g.Get(key)
+	if err != nil {
+		return err
+	}
+	now := g.now()
+	if err := g.pins.add(key, view, now, now.Add(ttl)); err != nil {
+		return err
+	}
+	if g.mainCache.onExpiry != nil {
+		g.mainCache.onExpiry(now.Add(ttl))
+	}
+	return nil
+}
+
+// PinRemote 与 Pin 相同，但像 Get 一样把请求转发给拥有 key 的节点，在拥有者上固定。
+// 拥有者不支持固定时返回错误。
+func (g *Group) PinRemote(key string, ttl time.Duration) error {
+	if g.peerPicker() != nil {
+		if peer, ok := g.peerPicker().PickPeer(key); ok {
+			pinner, ok := peer.(PeerPinner)
+			if !ok {
+				return fmt.Errorf("geecache: peer %s does not support pinning", peerName(peer))
+			}
+			return pinner.Pin(g.name, key, ttl)
+		}
+	}
+	return g.Pin(key, ttl)
+}
+
+// Unpin 解除本节点上 key 的固定，返回 key 之前是否被固定。值回到 mainCache。
+func (g *Group) Unpin(key string) bool {
+	view, ok := g.pins.remove(key)
+	if ok {
+		g.releasePin(key, view)
+	}
+	return ok
+}
+
+// cachedPin 查找固定的值，返回固定的时间。固定已到期时把值放回 mainCache，仍然算作命中
+func (g *Group) cachedPin(key string) (ByteView, bool, time.Time) {
+	e, ok, expired := g.pins.get(key, g.now())
+	if expired {
+		g.releasePin(key, e.view)
+		return e.view, true, e.at
+	}
+	return e.view, ok, e.at
+}
+
+// releasePin 把解除固定的值放回 mainCache
+func (g *Group) releasePin(key string, view ByteView) {
+	if _, ok := g.mainCache.get(key); !ok && !g.tooLarge(view.Len()) {
+		g.mainCache.add(key, view, g.defaultTTL())
+	}
+}
+
+// nextExpiry 返回 mainCache 中最早的过期时间与最早的固定到期时间中较早的一个，都没有时为零值
+func (g *Group) nextExpiry() time.Time {
+	_, next := g.mainCache.pendingExpiry()
+	if until := g.pins.nextExpiry(); !until.IsZero() && (next.IsZero() || until.Before(next)) {
+		next = until
+	}
+	return next
+}
+
+// cleanExpired 清理 mainCache 中过期的条目，并解除到期的固定；
+// 两层缓存中被 MaxEvictionsPerAdd 推迟的淘汰也在这里完成
+func (g *Group) cleanExpired() {
+	g.mainCache.cleanExpired()
+	g.hotCache.cleanExpired()
+	for key, view := range g.pins.expire(g.now()) {
+		g.releasePin(key, view)
+	}
+}
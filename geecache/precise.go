@@ -0,0 +1,81 @@
+package geecache
+
+import (
+	"sync"
+	"time"
+)
+
+// preciseExpiry 在 mainCache 中最早的条目到期时立即清理，代替周期性的清理。
+// 一个协程睡眠到过期堆的堆顶时间；写入的条目比计划的时间更早到期时，通过 nudge 唤醒它重新计算。
+type preciseExpiry struct {
+	g        *Group
+	nudge    chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu   sync.Mutex
+	next time.Time // 协程计划醒来的时间，零值表示没有待过期的条目
+}
+
+func newPreciseExpiry(g *Group) *preciseExpiry {
+	return &preciseExpiry{g: g, nudge: make(chan struct{}, 1), stop: make(chan struct{})}
+}
+
+func (p *preciseExpiry) start() {
+	go p.loop()
+}
+
+func (p *preciseExpiry) close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// schedule 在写入的条目将于 at 过期时调用，at 早于计划的时间时唤醒协程
+func (p *preciseExpiry) schedule(at time.Time) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	earlier := p.next.IsZero() || at.Before(p.next)
+	if earlier {
+		p.next = at
+	}
+	p.mu.Unlock()
+	if earlier {
+		select {
+		case p.nudge <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *preciseExpiry) loop() {
+	for {
+		p.g.cleanExpired()
+		// 在 p.mu 下读取堆顶：之后写入的更早的条目一定能看到新的 next 并唤醒协程
+		p.mu.Lock()
+		_, p.next = p.g.mainCache.pendingExpiry()
+		next := p.next
+		p.mu.Unlock()
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if !next.IsZero() {
+			// 条目在晚于过期时间之后才算过期，多等 1ms
+			timer = time.NewTimer(next.Sub(p.g.now()) + time.Millisecond)
+			fire = timer.C
+		}
+		select {
+		case <-fire:
+		case <-p.nudge:
+		case <-p.stop:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+	}
+}
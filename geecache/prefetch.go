@@ -0,0 +1,65 @@
+package geecache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// 默认同时进行的预取数量上限
+const defaultMaxPrefetches = 16
+
+// flagPrefetched 标记由预取写入的条目，用于统计预取命中
+const flagPrefetched uint32 = 1 << 1
+
+// Prefetcher 根据刚被读取的键预测接下来可能被读取的键
+type Prefetcher func(key string) []string
+
+// RegisterPrefetcher 注册预取函数。每次 Get 加载成功后，
+// 组会在后台预热 fn 预测的、由本节点拥有且尚未缓存的键。
+func (g *Group) RegisterPrefetcher(fn Prefetcher) {
+	if g.prefetcher != nil {
+		panic("RegisterPrefetcher called more than once")
+	}
+	if g.prefetchSem == nil {
+		g.prefetchSem = make(chan struct{}, defaultMaxPrefetches)
+	}
+	g.prefetcher = fn
+}
+
+// prefetch 为 key 预测的键启动后台加载，超过并发上限的预取被丢弃
+func (g *Group) prefetch(key string) {
+	if g.prefetcher == nil {
+		return
+	}
+	for _, k := range g.prefetcher(key) {
+		if k == "" || k == key || g.ownedByPeer(k) || g.hasLocally(k) {
+			continue
+		}
+		select {
+		case g.prefetchSem <- struct{}{}:
+		default:
+			atomic.AddInt64(&g.stats.PrefetchDropped, 1)
+			continue
+		}
+		go func(k string) {
+			defer func() { <-g.prefetchSem }()
+			g.prefetchOne(k)
+		}(k)
+	}
+}
+
+// prefetchOne 通过 singleflight 加载一个键，与同时进行的 Get 共享结果
+func (g *Group) prefetchOne(key string) {
+	g.loader.Do(g.flightKey(key), func() (interface{}, error) {
+		value, cacheable, err := g.fetchLocally(context.Background(), key)
+		if err != nil {
+			return loaded{}, err
+		}
+		atomic.AddInt64(&g.stats.Prefetches, 1)
+		if cacheable && !g.tooLarge(value.Len()) && !g.buried(key) {
+			g.mainCache.addWithFlags(key, value, g.defaultTTL(), flagPrefetched)
+			g.replicate(key, value, g.defaultTTL())
+		}
+		return loaded{view: value, source: SourceLocalLoad, uncacheable: !cacheable}, nil
+	})
+}
@@ -0,0 +1,188 @@
+package geecache
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPressureInterval = time.Second
+	defaultShrinkFactor     = 0.5
+)
+
+// MemoryPressure 配置按进程内存压力自动调整 mainCache 的字节上限
+type MemoryPressure struct {
+	// HighHeapBytes 是触发收缩的堆大小（runtime.MemStats.HeapAlloc）
+	HighHeapBytes uint64
+	// LowHeapBytes 是恢复原上限的堆大小，应小于 HighHeapBytes 以免来回调整
+	LowHeapBytes uint64
+	// ShrinkFactor 是每次收缩后保留的上限比例，取值 (0, 1)，默认 0.5
+	ShrinkFactor float64
+	// MinBytes 是收缩的下限
+	MinBytes int64
+	// Interval 是检查堆大小的间隔，默认一秒
+	Interval time.Duration
+}
+
+// pressureMonitor 按堆大小调整 Group 的 mainCache 上限，由共享的 pressureHub 定期调用
+type pressureMonitor struct {
+	cfg  MemoryPressure
+	g    *Group
+	base int64     // 配置的上限，压力缓解后恢复到它，原子地读写
+	last time.Time // 上一次检查的时间，由 pressureHub.mu 保护
+}
+
+// WithMemoryPressure 开启按内存压力自动调整：堆超过 HighHeapBytes 时，mainCache 的上限
+// 按 ShrinkFactor 逐次收缩并立即淘汰，直到 MinBytes；堆回落到 LowHeapBytes 以下时恢复为 NewGroup 的 cacheBytes。
+// HighHeapBytes 必须大于 0，否则 panic；LowHeapBytes 大于 HighHeapBytes 时按 HighHeapBytes 处理。
+// 所有开启的 Group 共享一个读取堆大小的协程，runtime.ReadMemStats 会短暂暂停整个程序，每个间隔只调用一次。
+// 不应与 WithSharedBudget 同时使用。
+func WithMemoryPressure(cfg MemoryPressure) GroupOption {
+	if cfg.HighHeapBytes == 0 {
+		panic("geecache: MemoryPressure.HighHeapBytes must be positive")
+	}
+	return func(g *Group) {
+		if cfg.LowHeapBytes > cfg.HighHeapBytes {
+			cfg.LowHeapBytes = cfg.HighHeapBytes
+		}
+		if cfg.ShrinkFactor <= 0 || cfg.ShrinkFactor >= 1 {
+			cfg.ShrinkFactor = defaultShrinkFactor
+		}
+		if cfg.Interval <= 0 {
+			cfg.Interval = defaultPressureInterval
+		}
+		g.pressure = &pressureMonitor{cfg: cfg, g: g}
+	}
+}
+
+func heapAlloc() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func (m *pressureMonitor) start() {
+	atomic.StoreInt64(&m.base, m.g.mainCache.limit())
+	memoryPressure.add(m)
+}
+
+func (m *pressureMonitor) close() {
+	memoryPressure.remove(m)
+}
+
+// check 按一次读取的堆大小收缩或恢复上限
+func (m *pressureMonitor) check(heap uint64) {
+	c := &m.g.mainCache
+	cur := c.limit()
+	switch {
+	case heap > m.cfg.HighHeapBytes:
+		if cur == 0 {
+			// 没有上限时从当前占用开始收缩
+			cur = c.bytes()
+		}
+		next := int64(float64(cur) * m.cfg.ShrinkFactor)
+		if next < m.cfg.MinBytes {
+			next = m.cfg.MinBytes
+		}
+		if next < 1 || next >= cur {
+			return
+		}
+		c.resize(next)
+		atomic.AddInt64(&m.g.stats.PressureShrinks, 1)
+	case heap < m.cfg.LowHeapBytes && cur != atomic.LoadInt64(&m.base):
+		c.resize(atomic.LoadInt64(&m.base))
+		atomic.AddInt64(&m.g.stats.PressureRestores, 1)
+	}
+}
+
+// pressureHub 用一个协程为所有 pressureMonitor 读取堆大小。协程按最短的 Interval 运行，
+// 每次读取一次堆大小，交给到期的 monitor；没有 monitor 时退出
+type pressureHub struct {
+	mu       sync.Mutex
+	heap     func() uint64 // 返回当前的堆大小，便于测试
+	now      func() time.Time
+	monitors map[*pressureMonitor]struct{}
+	interval time.Duration // 当前协程的间隔
+	stop     chan struct{} // 关闭时当前协程退出，没有协程时为 nil
+}
+
+var memoryPressure = &pressureHub{heap: heapAlloc, now: time.Now}
+
+func (h *pressureHub) add(m *pressureMonitor) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.monitors == nil {
+		h.monitors = make(map[*pressureMonitor]struct{})
+	}
+	m.last = h.now()
+	h.monitors[m] = struct{}{}
+	if h.stop == nil || m.cfg.Interval < h.interval {
+		h.restartLocked()
+	}
+}
+
+func (h *pressureHub) remove(m *pressureMonitor) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.monitors[m]; !ok {
+		return
+	}
+	delete(h.monitors, m)
+	h.restartLocked()
+}
+
+// restartLocked 停止当前的协程，按剩余 monitor 中最短的间隔启动新的协程
+func (h *pressureHub) restartLocked() {
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	if len(h.monitors) == 0 {
+		return
+	}
+	h.interval = 0
+	for m := range h.monitors {
+		if h.interval == 0 || m.cfg.Interval < h.interval {
+			h.interval = m.cfg.Interval
+		}
+	}
+	h.stop = make(chan struct{})
+	go h.loop(h.interval, h.stop)
+}
+
+func (h *pressureHub) loop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.tick(interval)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tick 读取一次堆大小并检查到期的 monitor。间隔较长的 monitor 允许提前半个 tick，避免计时抖动使它推迟一整个 tick
+func (h *pressureHub) tick(interval time.Duration) {
+	h.mu.Lock()
+	now := h.now()
+	var due []*pressureMonitor
+	for m := range h.monitors {
+		if now.Sub(m.last) >= m.cfg.Interval-interval/2 {
+			m.last = now
+			due = append(due, m)
+		}
+	}
+	heap := h.heap
+	h.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+	sample := heap()
+	for _, m := range due {
+		m.check(sample)
+	}
+}
@@ -0,0 +1,95 @@
+package geecache
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit 配置一个 group 在本节点上接受对等点请求的速率
+type RateLimit struct {
+	Rate  float64 // 每秒补充的请求数
+	Burst int     // 令牌桶的容量，即允许的突发请求数，小于 1 时视为 1
+}
+
+// tokenBucket 是一个 group 的令牌桶
+type tokenBucket struct {
+	throttled int64 // 被拒绝的请求数，放在首位以保证原子操作的 64 位对齐
+	mu        sync.Mutex
+	limit     RateLimit
+	tokens    float64
+	last      time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// take 取出一个令牌，没有令牌时返回需要等待的时间
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	atomic.AddInt64(&b.throttled, 1)
+	if b.limit.Rate <= 0 {
+		return false, time.Minute
+	}
+	return false, time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// SetRateLimits 设置各 group 接受对等点请求的速率，limits 中没有的 group 不限流。
+// 超过速率的请求得到 429 和 Retry-After 头部，对等点会将其视为 ErrThrottled。
+func (p *HTTPPool) SetRateLimits(limits map[string]RateLimit) {
+	now := time.Now()
+	buckets := make(map[string]*tokenBucket, len(limits))
+	for group, limit := range limits {
+		buckets[group] = newTokenBucket(limit, now)
+	}
+	p.mu.Lock()
+	p.limiters = buckets
+	p.mu.Unlock()
+}
+
+// allow 判断 group 的请求是否在速率限制内，超过时写入 429 响应
+func (p *HTTPPool) allow(w http.ResponseWriter, group string) bool {
+	p.mu.Lock()
+	b := p.limiters[group]
+	p.mu.Unlock()
+	if b == nil {
+		return true
+	}
+	ok, wait := b.take(time.Now())
+	if ok {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, ErrThrottled.Error(), http.StatusTooManyRequests)
+	return false
+}
+
+// throttledStats 返回各 group 被限流的请求数
+func (p *HTTPPool) throttledStats() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.limiters) == 0 {
+		return nil
+	}
+	m := make(map[string]int64, len(p.limiters))
+	for group, b := range p.limiters {
+		m[group] = atomic.LoadInt64(&b.throttled)
+	}
+	return m
+}
@@ -0,0 +1,78 @@
+package geecache
+
+import (
+	pb "geecache/geecachepb"
+	"sync/atomic"
+	"time"
+)
+
+type replica struct {
+	key   string
+	value ByteView
+	ttl   time.Duration
+}
+
+// replicate 尽力将键值放入复制队列，队列满或 Group 已关闭时直接丢弃
+func (g *Group) replicate(key string, value ByteView, ttl time.Duration) {
+	if g.replicas == nil || g.peerPicker() == nil {
+		return
+	}
+	select {
+	case <-g.replicaStop:
+		atomic.AddInt64(&g.stats.ReplicaDropped, 1)
+		return
+	default:
+	}
+	select {
+	case g.replicas <- replica{key: key, value: value, ttl: ttl}:
+	default:
+		atomic.AddInt64(&g.stats.ReplicaDropped, 1)
+	}
+}
+
+// replicateLoop 推送队列中的副本，Close 之后丢弃队列中剩余的副本并退出
+func (g *Group) replicateLoop() {
+	for {
+		var r replica
+		select {
+		case r = <-g.replicas:
+		case <-g.replicaStop:
+			g.drainReplicas()
+			return
+		}
+		picker, ok := g.peerPicker().(SuccessorPicker)
+		if !ok {
+			continue
+		}
+		peer, ok := picker.PickSuccessor(r.key)
+		if !ok {
+			continue
+		}
+		req := &pb.SetRequest{
+			Group:   g.name,
+			Key:     r.key,
+			Value:   r.value.b,
+			TtlMs:   ttlMillis(r.ttl),
+			Replica: true,
+			Meta:    r.value.meta,
+		}
+		if err := peer.Set(req, &pb.SetResponse{}); err != nil {
+			atomic.AddInt64(&g.stats.ReplicaErrors, 1)
+			peerLog.Printf(peerName(peer), "[GeeCache] Failed to replicate key %s to %s: %v", r.key, peerName(peer), err)
+			continue
+		}
+		atomic.AddInt64(&g.stats.ReplicaPushes, 1)
+	}
+}
+
+// drainReplicas 丢弃队列中尚未推送的副本并计数
+func (g *Group) drainReplicas() {
+	for {
+		select {
+		case <-g.replicas:
+			atomic.AddInt64(&g.stats.ReplicaDropped, 1)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,644 @@
+package geecache
+
+import (
+	"errors"
+	"fmt"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPeer 在进程内直接调用另一个节点的 Group
+type testPeer struct {
+	name    string
+	g       *Group
+	down    bool  // 为 true 时所有请求都失败
+	batches int32 // GetBatch 调用次数
+	fails   int32 // 接下来的 Get 中有多少次以传输错误失败
+	gets    int32 // Get 调用次数
+}
+
+func (p *testPeer) Get(in *pb.Request, out *pb.Response) error {
+	atomic.AddInt32(&p.gets, 1)
+	if p.down || atomic.AddInt32(&p.fails, -1) >= 0 {
+		return fmt.Errorf("peer %s is down", p.name)
+	}
+	view, err := p.g.Get(in.GetKey())
+	if err != nil {
+		return err
+	}
+	out.Value = view.ByteSlice()
+	return nil
+}
+
+func (p *testPeer) Set(in *pb.SetRequest, out *pb.SetResponse) error {
+	return p.g.setFromPeer(in, out)
+}
+
+func (p *testPeer) GetBatch(in *pb.BatchRequest, out *pb.BatchResponse) error {
+	atomic.AddInt32(&p.batches, 1)
+	if p.down {
+		return fmt.Errorf("peer %s is down", p.name)
+	}
+	p.g.serveBatch(in, out)
+	return nil
+}
+
+func (p *testPeer) Has(in *pb.Request, out *pb.HasResponse) error {
+	if p.down {
+		return fmt.Errorf("peer %s is down", p.name)
+	}
+	out.Exists = p.g.hasLocally(in.GetKey())
+	return nil
+}
+
+func (p *testPeer) Delete(in *pb.Request, out *pb.DeleteResponse) error {
+	if p.down {
+		return fmt.Errorf("peer %s is down", p.name)
+	}
+	return p.g.deleteFromPeer(in, out)
+}
+
+func (p *testPeer) String() string {
+	return p.name
+}
+
+// testPicker 使用共享的哈希环在进程内节点之间路由
+type testPicker struct {
+	self  string
+	ring  *consistenthash.Map
+	nodes map[string]*testPeer
+}
+
+func (p *testPicker) PickPeer(key string) (PeerGetter, bool) {
+	if peer := p.ring.Get(key); peer != "" && peer != p.self {
+		return p.nodes[peer], true
+	}
+	return nil, false
+}
+
+func (p *testPicker) PickSuccessor(key string) (PeerSetter, bool) {
+	nodes := p.ring.GetN(key, 2)
+	if len(nodes) < 2 || nodes[0] != p.self {
+		return nil, false
+	}
+	return p.nodes[nodes[1]], true
+}
+
+func TestReplicationToSuccessor(t *testing.T) {
+	var originCalls int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&originCalls, 1)
+		return []byte("v-" + key), nil
+	})
+
+	names := []string{"A", "B", "C"}
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add(names...)
+	nodes := make(map[string]*testPeer)
+	for _, name := range names {
+		g := NewGroup("replicas", 2<<10, getter, WithReplication(16))
+		g.RegisterPeers(&testPicker{self: name, ring: ring, nodes: nodes})
+		nodes[name] = &testPeer{name: name, g: g}
+	}
+
+	var key string
+	for i := 0; ; i++ {
+		if key = fmt.Sprintf("key%d", i); ring.Get(key) == "A" {
+			break
+		}
+	}
+	successor := ring.GetN(key, 2)[1]
+
+	if view, err := nodes["A"].g.Get(key); err != nil || view.String() != "v-"+key {
+		t.Fatalf("get %s via owner failed: %v", key, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := nodes[successor].g.mainCache.get(key); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("key %s was not replicated to %s", key, successor)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A 下线后，所有节点都应从后继节点拿到热数据，而不回源
+	ring.Remove("A")
+	for _, name := range []string{"B", "C"} {
+		if view, err := nodes[name].g.Get(key); err != nil || view.String() != "v-"+key {
+			t.Fatalf("get %s via %s failed: %v", key, name, err)
+		}
+	}
+	if n := atomic.LoadInt32(&originCalls); n != 1 {
+		t.Fatalf("expect 1 origin call, but %d got", n)
+	}
+	if s := nodes["A"].g.Stats(); s.ReplicaPushes != 1 || s.ReplicaDropped != 0 {
+		t.Fatalf("unexpected replica stats %+v", s)
+	}
+}
+
+func TestReplicationStopsOnClose(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil })
+	before := runtime.NumGoroutine()
+	var groups []*Group
+	for i := 0; i < 50; i++ {
+		groups = append(groups, NewGroup(fmt.Sprintf("replicas-close-%d", i), 2<<10, getter, WithReplication(4)))
+	}
+	for _, g := range groups {
+		g.Close()
+		g.Close()
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect replication goroutines to exit on Close, %d remain", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 关闭之后的复制直接丢弃
+	g := groups[0]
+	g.RegisterPeers(&testPicker{self: "A", ring: consistenthash.New(defaultReplicas, nil)})
+	g.replicate("Tom", ByteView{b: []byte("630")}, 0)
+	if s := g.Stats(); s.ReplicaDropped != 1 {
+		t.Fatalf("expect the replica dropped after Close, got %+v", s)
+	}
+}
+
+func TestEvictUnowned(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("A")
+	g := NewGroup("evict-unowned", 2<<10, getter)
+	g.RegisterPeers(&testPicker{self: "A", ring: ring, nodes: map[string]*testPeer{}})
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		g.Get(key)
+	}
+
+	ring.Add("B")
+	moved := 0
+	for _, key := range keys {
+		if ring.Get(key) == "B" {
+			moved++
+		}
+	}
+	if n := g.EvictUnowned(); n != moved || g.mainCache.lru.Len() != len(keys)-moved {
+		t.Fatalf("expect %d keys evicted, but %d got", moved, n)
+	}
+}
+
+func TestGetWithSource(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("A", "B")
+	nodes := map[string]*testPeer{
+		"A": {name: "A", g: NewGroup("source", 2<<10, getter, WithHotCache(2<<10))},
+		"B": {name: "B", g: NewGroup("source", 2<<10, getter)},
+	}
+	nodes["A"].g.RegisterPeers(&testPicker{self: "A", ring: ring, nodes: nodes})
+
+	var ownedByA, ownedByB string
+	for i := 0; ownedByA == "" || ownedByB == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if ring.Get(key) == "A" {
+			ownedByA = key
+		} else {
+			ownedByB = key
+		}
+	}
+
+	expect := []struct {
+		key string
+		src Source
+	}{
+		{ownedByA, SourceLocalLoad},
+		{ownedByA, SourceLocalCache},
+		{ownedByB, SourcePeer},
+		{ownedByB, SourceHotCache},
+	}
+	for _, e := range expect {
+		if view, src, err := nodes["A"].g.GetWithSource(e.key); err != nil || view.String() != e.key || src != e.src {
+			t.Fatalf("GetWithSource(%s) = %v, %v, %v, want source %v", e.key, view, src, err, e.src)
+		}
+	}
+}
+
+func TestOwnerReads(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v1"), nil
+	})
+
+	for _, ownerReads := range []bool{false, true} {
+		name := fmt.Sprintf("owner-reads-%v", ownerReads)
+		opts := []GroupOption{WithHotCache(2 << 10)}
+		if ownerReads {
+			opts = append(opts, WithOwnerReads())
+		}
+		ring := consistenthash.New(defaultReplicas, nil)
+		ring.Add("A", "B")
+		nodes := map[string]*testPeer{
+			"A": {name: "A", g: NewGroup(name, 2<<10, getter, opts...)},
+			"B": {name: "B", g: NewGroup(name, 2<<10, getter, opts...)},
+		}
+		for self, node := range nodes {
+			node.g.RegisterPeers(&testPicker{self: self, ring: ring, nodes: nodes})
+		}
+		var key string
+		for i := 0; ; i++ {
+			if key = fmt.Sprintf("key%d", i); ring.Get(key) == "A" {
+				break
+			}
+		}
+
+		if view, err := nodes["B"].g.Get(key); err != nil || view.String() != "v1" {
+			t.Fatalf("first get failed: %v", err)
+		}
+		nodes["A"].g.Set(key, []byte("v2"), 0)
+		view, err := nodes["B"].g.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ownerReads && view.String() != "v2" {
+			t.Fatalf("expect v2 with owner reads, but %s got", view)
+		}
+		if !ownerReads && view.String() != "v1" {
+			t.Fatalf("expect stale v1 from hot cache, but %s got", view)
+		}
+
+		// GetMulti 遵循同样的规则，本地缓存中的旧值（例如哈希环变化前留下的）被跳过
+		nodes["A"].g.Set(key, []byte("v3"), 0)
+		nodes["B"].g.mainCache.add(key, ByteView{b: []byte("stale")}, 0)
+		values, err := nodes["B"].g.GetMulti([]string{key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ownerReads && values[key].String() != "v3" {
+			t.Fatalf("expect v3 from GetMulti with owner reads, but %s got", values[key])
+		}
+		if !ownerReads && values[key].String() == "v3" {
+			t.Fatalf("expect a locally cached value from GetMulti, but %s got", values[key])
+		}
+	}
+}
+
+func TestHas(t *testing.T) {
+	var loads int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte(key), nil
+	})
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("A", "B")
+	nodes := map[string]*testPeer{
+		"A": {name: "A", g: NewGroup("has", 2<<10, getter)},
+		"B": {name: "B", g: NewGroup("has", 2<<10, getter)},
+	}
+	nodes["A"].g.RegisterPeers(&testPicker{self: "A", ring: ring, nodes: nodes})
+
+	var ownedByA, ownedByB string
+	for i := 0; ownedByA == "" || ownedByB == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if ring.Get(key) == "A" {
+			ownedByA = key
+		} else {
+			ownedByB = key
+		}
+	}
+	nodes["A"].g.Set(ownedByA, []byte("v"), 0)
+	nodes["B"].g.Set(ownedByB, []byte("v"), 0)
+
+	a := nodes["A"].g
+	for key, expect := range map[string]bool{ownedByA: true, ownedByB: true, "unknown": false} {
+		if ok, err := a.Has(key); err != nil || ok != expect {
+			t.Fatalf("Has(%s) = %v, %v, want %v", key, ok, err, expect)
+		}
+	}
+	if loads != 0 {
+		t.Fatalf("Has must not load, but getter called %d times", loads)
+	}
+
+	nodes["B"].down = true
+	if _, err := a.Has(ownedByB); err == nil {
+		t.Fatalf("expect error when owner is down")
+	}
+}
+
+func TestDeleteAllTiers(t *testing.T) {
+	var originCalls int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&originCalls, 1)
+		return nil, ErrNotFound
+	})
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("A", "B")
+	nodes := map[string]*testPeer{
+		"A": {name: "A", g: NewGroup("delete", 2<<10, getter, WithHotCache(2<<10))},
+		"B": {name: "B", g: NewGroup("delete", 2<<10, getter)},
+	}
+	picker := &testPicker{self: "A", ring: ring, nodes: nodes}
+	nodes["A"].g.RegisterPeers(picker)
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); ring.Get(k) == "B" {
+			key = k
+		}
+	}
+
+	a, b := nodes["A"].g, nodes["B"].g
+	if res, err := a.SetE(key, []byte("v"), 0); err != nil || res.RoutedToPeer != "B" {
+		t.Fatalf("expect set routed to B, got %+v %v", res, err)
+	}
+	if _, src, err := a.GetWithSource(key); err != nil || src != SourcePeer {
+		t.Fatalf("expect read from peer, got %v %v", src, err)
+	}
+	if _, ok := a.hotCache.get(key); !ok {
+		t.Fatalf("expect hotCache to be populated")
+	}
+
+	if deleted, err := a.Delete(key); err != nil || !deleted {
+		t.Fatalf("expect delete to succeed, got %v %v", deleted, err)
+	}
+	if _, ok := a.hotCache.get(key); ok {
+		t.Fatalf("key still in A's hotCache")
+	}
+	if _, ok := a.mainCache.get(key); ok {
+		t.Fatalf("key still in A's mainCache")
+	}
+	if _, ok := b.mainCache.get(key); ok {
+		t.Fatalf("key still on owner B")
+	}
+	if _, err := a.Get(key); !errors.Is(err, ErrNotFound) || originCalls != 1 {
+		t.Fatalf("expect reload from origin after delete, got %v with %d origin calls", err, originCalls)
+	}
+	if deleted, _ := a.Delete("unknown"); deleted {
+		t.Fatalf("expect deleting an unknown key to report false")
+	}
+}
+
+// countingPicker 统计 PickPeer 的调用次数
+type countingPicker struct {
+	PeerPicker
+	picks int32
+}
+
+func (p *countingPicker) PickPeer(key string) (PeerGetter, bool) {
+	atomic.AddInt32(&p.picks, 1)
+	return p.PeerPicker.PickPeer(key)
+}
+
+func TestTierOrder(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) { return []byte("origin"), nil })
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("A", "B")
+	keyOwnedBy := func(owner string) string {
+		for i := 0; ; i++ {
+			if k := fmt.Sprintf("key%d", i); ring.Get(k) == owner {
+				return k
+			}
+		}
+	}
+	owned, remote := keyOwnedBy("A"), keyOwnedBy("B")
+	var picker *countingPicker
+	setup := func(opts ...GroupOption) *Group {
+		g := NewGroup("tiers", 2<<10, getter, append(opts, WithHotCache(2<<10))...)
+		picker = &countingPicker{PeerPicker: &testPicker{self: "A", ring: ring, nodes: map[string]*testPeer{"B": {name: "B", down: true}}}}
+		g.RegisterPeers(picker)
+		// 两层中都有值，mainCache 中是权威的副本，hotCache 中是旧的副本
+		for _, key := range []string{owned, remote} {
+			g.mainCache.add(key, ByteView{b: []byte("main")}, 0)
+			g.hotCache.add(key, ByteView{b: []byte("hot")}, 0)
+		}
+		return g
+	}
+	get := func(g *Group, key string) (string, Source) {
+		v, src, err := g.GetWithSource(key)
+		if err != nil {
+			t.Fatalf("get %s: %v", key, err)
+		}
+		return v.String(), src
+	}
+
+	// 默认按拥有者决定顺序
+	g := setup()
+	if v, src := get(g, owned); v != "main" || src != SourceLocalCache {
+		t.Fatalf("expect mainCache to win for an owned key, got %s from %v", v, src)
+	}
+	if v, src := get(g, remote); v != "hot" || src != SourceHotCache {
+		t.Fatalf("expect hotCache to win for a remote key, got %s from %v", v, src)
+	}
+	// 前一层未命中时查找另一层，只命中一层时不需要判断键的归属
+	g.mainCache.remove(owned)
+	g.hotCache.remove(remote)
+	atomic.StoreInt32(&picker.picks, 0)
+	if v, src := get(g, owned); v != "hot" || src != SourceHotCache {
+		t.Fatalf("expect fallback to hotCache, got %s from %v", v, src)
+	}
+	if v, src := get(g, remote); v != "main" || src != SourceLocalCache {
+		t.Fatalf("expect fallback to mainCache, got %s from %v", v, src)
+	}
+	if n := atomic.LoadInt32(&picker.picks); n != 0 {
+		t.Fatalf("expect single-tier hits not to consult the ring, got %d PickPeer calls", n)
+	}
+	g.Close()
+
+	g = setup(WithTierOrder(TierOrderMainFirst))
+	if v, _ := get(g, remote); v != "main" {
+		t.Fatalf("expect mainCache first, got %s", v)
+	}
+	g.Close()
+	g = setup(WithTierOrder(TierOrderHotFirst))
+	if v, _ := get(g, owned); v != "hot" {
+		t.Fatalf("expect hotCache first, got %s", v)
+	}
+	g.Close()
+}
+
+func TestGetLocalOnly(t *testing.T) {
+	var originCalls int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&originCalls, 1)
+		return []byte("v-" + key), nil
+	})
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("A", "B")
+	b := &testPeer{name: "B", g: NewGroup("local-only", 2<<10, getter)}
+	a := NewGroup("local-only", 2<<10, getter)
+	a.RegisterPeers(&testPicker{self: "A", ring: ring, nodes: map[string]*testPeer{"B": b}})
+
+	var key string
+	for i := 0; ; i++ {
+		if key = fmt.Sprintf("key%d", i); ring.Get(key) == "B" {
+			break
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if view, err := a.GetLocalOnly(key); err != nil || view.String() != "v-"+key {
+			t.Fatalf("GetLocalOnly(%s) = %v, %v", key, view, err)
+		}
+	}
+	if b.gets != 0 || originCalls != 1 {
+		t.Fatalf("expect one local load without peer, got %d peer gets and %d origin calls", b.gets, originCalls)
+	}
+	if s := a.Stats(); s.CacheHits != 1 || s.LocalLoads != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestWaitForPeers(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v-" + key), nil
+	})
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("A", "B")
+	var key string
+	for i := 0; ; i++ {
+		if key = fmt.Sprintf("key%d", i); ring.Get(key) == "B" {
+			break
+		}
+	}
+	waitLoads := func(g *Group, n int64) {
+		for g.Stats().Loads < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 注册对等点之前开始的加载在注册后从对等点获取
+	b := &testPeer{name: "B", g: NewGroup("wait-peers", 2<<10, getter)}
+	a := NewGroup("wait-peers", 2<<10, getter, WithWaitForPeers(time.Hour))
+	sources := make(chan Source, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, src, _ := a.GetWithSource(key)
+			sources <- src
+		}()
+	}
+	waitLoads(a, 5)
+	select {
+	case <-sources:
+		t.Fatal("expect loads to wait for RegisterPeers")
+	case <-time.After(10 * time.Millisecond):
+	}
+	a.RegisterPeers(&testPicker{self: "A", ring: ring, nodes: map[string]*testPeer{"B": b}})
+	for i := 0; i < 5; i++ {
+		if src := <-sources; src != SourcePeer {
+			t.Fatalf("expect value from peer after registration, got %v", src)
+		}
+	}
+	if s := a.Stats(); s.LocalLoads != 0 || s.LoadsWithoutPeers != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// 超时后不使用对等点继续加载，超时从 NewGroup 开始计算，之后的加载不再等待
+	c := NewGroup("wait-peers-timeout", 2<<10, getter, WithWaitForPeers(20*time.Millisecond))
+	defer c.Close()
+	if _, src, _ := c.GetWithSource(key); src != SourceLocalLoad {
+		t.Fatalf("expect local load after timeout, got %v", src)
+	}
+	start := time.Now()
+	if _, err := c.Get("another"); err != nil || time.Since(start) > 10*time.Millisecond {
+		t.Fatalf("expect loads after the timeout not to wait, took %v: %v", time.Since(start), err)
+	}
+	if s := c.Stats(); s.LoadsWithoutPeers != 2 {
+		t.Fatalf("expect two loads without peers, got %+v", s)
+	}
+
+	// 并发的 RegisterPeers 只有一个生效，其余的 panic
+	d := NewGroup("wait-peers-register", 2<<10, getter, WithWaitForPeers(time.Hour))
+	defer d.Close()
+	var panics int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if recover() != nil {
+					atomic.AddInt32(&panics, 1)
+				}
+			}()
+			d.RegisterPeers(&testPicker{self: "A", ring: ring, nodes: map[string]*testPeer{"B": b}})
+		}()
+	}
+	wg.Wait()
+	if panics != 3 {
+		t.Fatalf("expect 3 duplicate registrations to panic, got %d", panics)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+	var loads int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("stale"), nil
+	})
+
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("A", "B")
+	nodes := map[string]*testPeer{
+		"A": {name: "A", g: NewGroup("tombstones", 2<<10, getter, WithClock(clock), WithTombstones(time.Minute))},
+		"B": {name: "B", g: NewGroup("tombstones", 2<<10, getter, WithClock(clock), WithTombstones(time.Minute))},
+	}
+	nodes["A"].g.RegisterPeers(&testPicker{self: "A", ring: ring, nodes: nodes})
+	nodes["B"].g.RegisterPeers(&testPicker{self: "B", ring: ring, nodes: nodes})
+	a, b := nodes["A"].g, nodes["B"].g
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); ring.Get(k) == "B" {
+			key = k
+		}
+	}
+
+	a.Get(key)
+	if _, ok := b.mainCache.get(key); !ok || loads != 1 {
+		t.Fatalf("expect owner to cache the first load")
+	}
+	// 删除经由 A 转发给拥有者 B，B 在窗口期内不再缓存加载结果
+	if _, err := a.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if v, err := a.Get(key); err != nil || v.String() != "stale" {
+			t.Fatalf("expect loads to still return values, got %v %v", v, err)
+		}
+	}
+	if _, ok := b.mainCache.get(key); ok || loads != 3 {
+		t.Fatalf("expect loads not cached within the window, %d loads", loads)
+	}
+	res := &pb.SetResponse{}
+	b.setFromPeer(&pb.SetRequest{Group: "tombstones", Key: key, Value: []byte("old"), Replica: true}, res)
+	if res.Stored || b.Stats().TombstoneSuppressed != 3 {
+		t.Fatalf("expect replica write suppressed, got %+v", b.Stats())
+	}
+
+	// 显式写入清除墓碑
+	if res, err := a.SetE(key, []byte("fresh"), 0); err != nil || !res.Stored {
+		t.Fatalf("set after delete failed: %+v %v", res, err)
+	}
+	if v, ok := b.mainCache.get(key); !ok || v.String() != "fresh" {
+		t.Fatalf("expect explicit set to be cached")
+	}
+
+	b.Delete(key)
+	now = now.Add(time.Minute)
+	b.Get(key)
+	if _, ok := b.mainCache.get(key); !ok {
+		t.Fatalf("expect loads cached again after the window")
+	}
+}
@@ -0,0 +1,83 @@
+package geecache
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// retryPolicy 描述本地加载失败后的重试策略
+type retryPolicy struct {
+	attempts int              // 总尝试次数，包含第一次
+	backoff  time.Duration    // 第一次重试前的等待时间，之后每次翻倍
+	retryIf  func(error) bool // 判断错误是否值得重试
+}
+
+// getWithRetry 调用 Getter，并按 retryPolicy 重试可重试的错误
+// ctx 被取消时停止重试并返回最后一次的错误。
+func (g *Group) getWithRetry(ctx context.Context, key string) (ByteView, error) {
+	view, err := g.callGetter(ctx, key)
+	if g.retry == nil {
+		return view, err
+	}
+	backoff := g.retry.backoff
+	for i := 1; err != nil && i < g.retry.attempts && g.retry.retryIf(err); i++ {
+		if _, cacheable := splitUncacheable(err); !cacheable {
+			break
+		}
+		t := time.NewTimer(jitter(backoff))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return view, err
+		}
+		backoff *= 2
+		atomic.AddInt64(&g.stats.LoadRetries, 1)
+		view, err = g.callGetter(ctx, key)
+	}
+	return view, err
+}
+
+// callGetter 调用 Getter，开启了批量窗口时交给 coalescer，Getter 实现了 StreamGetter 时先读取流，
+// 实现了 ETagGetter 时同时取得 ETag，实现了 CtxGetter 或 ETagCtxGetter 时传入 ctx。
+// 设置了加载超时时，不支持 ctx 的 Getter 在超时后被放弃等待。
+func (g *Group) callGetter(ctx context.Context, key string) (ByteView, error) {
+	if g.coalescer != nil {
+		return g.wrapGetter(g.coalescer.get(ctx, key))
+	}
+	if sg, ok := g.getter.(StreamGetter); ok {
+		if view, err := g.getStream(ctx, sg, key); err != ErrUseGetter {
+			return view, err
+		}
+	}
+	if eg, ok := g.getter.(ETagGetter); ok {
+		return g.getWithETag(ctx, eg, key)
+	}
+	if cg, ok := g.getter.(CtxGetter); ok {
+		return g.wrapGetter(cg.GetCtx(ctx, key))
+	}
+	if g.timeoutFor(key) > 0 {
+		// 只为 Group 自己的加载超时放弃等待，调用者的 ctx 只影响它自己在 singleflight 中的等待
+		return g.wrapGetter(g.getAsync(ctx, key, func() ([]byte, error) { return g.getter.Get(key) }))
+	}
+	return g.wrapGetter(g.getter.Get(key))
+}
+
+// wrapGetter 把 Getter 的返回值包装为 ByteView，err 为 Uncacheable(nil) 时同样保留值
+func (g *Group) wrapGetter(b []byte, err error) (ByteView, error) {
+	if err != nil && !uncacheableValue(err) {
+		return ByteView{}, err
+	}
+	return g.viewFromGetter(b), err
+}
+
+// jitter 返回 [d/2, d) 之间的随机时长，避免多个调用者同时重试
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
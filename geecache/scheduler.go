@@ -0,0 +1,211 @@
+package geecache
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultCleanupInterval 是同一个 Group 两次清理之间的默认最小间隔
+	defaultCleanupInterval = 100 * time.Millisecond
+	// 每轮最多清理的 Group 数，剩余的到期 Group 在下一轮继续
+	defaultCleanupsPerTick = 64
+	// expirySlack 是清理晚于过期时间的余量，条目在晚于过期时间之后才算过期
+	expirySlack = time.Millisecond
+)
+
+// scheduler 在单个协程中依次执行各 Group 的过期清理，避免每个 Group 各自持有一个定时协程。
+// 每个 Group 在它最早的条目过期之后被清理，没有带 TTL 的条目时不会被唤醒；
+// 写入更早过期的条目时通过 expireAt 提前计划。同一个 Group 两次清理之间至少间隔 interval，
+// 过期时间相近的条目合并为一次清理。
+type scheduler struct {
+	mu         sync.Mutex
+	now        func() time.Time
+	queue      scheduleQueue
+	items      map[*Group]*scheduleItem
+	wake       chan struct{}
+	maxPerTick int
+	running    bool
+}
+
+type scheduleItem struct {
+	g        *Group
+	interval time.Duration // 两次清理之间的最小间隔
+	next     time.Time     // 计划的清理时间，index 为 -1 时没有计划
+	last     time.Time     // 上一次清理或注册的时间
+	index    int
+}
+
+type scheduleQueue []*scheduleItem
+
+func (q scheduleQueue) Len() int           { return len(q) }
+func (q scheduleQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x interface{}) {
+	item := x.(*scheduleItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *scheduleQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[0 : n-1]
+	return item
+}
+
+var cleanupScheduler = newScheduler(time.Now)
+
+func newScheduler(now func() time.Time) *scheduler {
+	return &scheduler{
+		now:        now,
+		items:      make(map[*Group]*scheduleItem),
+		wake:       make(chan struct{}, 1),
+		maxPerTick: defaultCleanupsPerTick,
+	}
+}
+
+// add 注册 Group，第一次运行时启动调度协程。interval 不大于 0 时使用默认的最小间隔
+func (s *scheduler) add(g *Group, interval time.Duration) {
+	s.mu.Lock()
+	if _, ok := s.items[g]; ok {
+		s.mu.Unlock()
+		return
+	}
+	if interval <= 0 {
+		interval = defaultCleanupInterval
+	}
+	s.items[g] = &scheduleItem{g: g, interval: interval, last: s.now(), index: -1}
+	if !s.running {
+		s.running = true
+		go s.loop()
+	}
+	s.mu.Unlock()
+	// 注册之前写入的条目（例如从快照恢复的）也需要计划
+	s.expireAt(g, g.nextExpiry())
+}
+
+// remove 取消 Group 的清理
+func (s *scheduler) remove(g *Group) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.items[g]; ok {
+		if item.index >= 0 {
+			heap.Remove(&s.queue, item.index)
+		}
+		atomic.StoreInt64(&g.sweepAt, 0)
+		delete(s.items, g)
+		s.notify()
+	}
+}
+
+// expireAt 告诉调度器 Group 有条目在 at（按 Group 的时钟）过期，at 早于已计划的时间时提前清理。
+// at 为零值时不做任何事；at 不早于已计划的时间时不获取锁。
+func (s *scheduler) expireAt(g *Group, at time.Time) {
+	if at.IsZero() {
+		return
+	}
+	if planned := atomic.LoadInt64(&g.sweepAt); planned != 0 && at.UnixNano() >= planned {
+		return
+	}
+	// 按相对时长换算到调度器的时钟，Group 可能使用注入的时钟
+	delay := at.Sub(g.now())
+	if delay < 0 {
+		delay = 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[g]
+	if !ok {
+		return
+	}
+	next := s.now().Add(delay + expirySlack)
+	if earliest := item.last.Add(item.interval); next.Before(earliest) {
+		next = earliest
+	}
+	if item.index >= 0 && !next.Before(item.next) {
+		return
+	}
+	atomic.StoreInt64(&g.sweepAt, at.UnixNano())
+	item.next = next
+	if item.index < 0 {
+		heap.Push(&s.queue, item)
+	} else {
+		heap.Fix(&s.queue, item.index)
+	}
+	if item.index == 0 {
+		s.notify()
+	}
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runDue 清理在 now 之前到期的 Group，最多 maxPerTick 个，返回清理的数量。
+// 清理之后按 Group 剩余的最早过期时间重新计划，没有剩余时 Group 不再被唤醒。
+func (s *scheduler) runDue(now time.Time) int {
+	var due []*Group
+	s.mu.Lock()
+	for len(due) < s.maxPerTick && s.queue.Len() > 0 && !s.queue[0].next.After(now) {
+		item := heap.Pop(&s.queue).(*scheduleItem)
+		item.index = -1
+		// 清理之后按剩余的最早过期时间重新计划，此后的写入都要经过锁
+		atomic.StoreInt64(&item.g.sweepAt, 0)
+		item.last = now
+		due = append(due, item.g)
+	}
+	s.mu.Unlock()
+
+	for _, g := range due {
+		g.cleanExpired()
+		s.expireAt(g, g.nextExpiry())
+	}
+	return len(due)
+}
+
+// nextDue 返回最早到期的时间，没有 Group 时 ok 为 false
+func (s *scheduler) nextDue() (next time.Time, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queue.Len() == 0 {
+		return time.Time{}, false
+	}
+	return s.queue[0].next, true
+}
+
+func (s *scheduler) loop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.runDue(s.now())
+
+		wait := time.Hour
+		if next, ok := s.nextDue(); ok {
+			wait = next.Sub(s.now())
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package geecache
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerGoroutines(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil })
+	scheduled := func() int {
+		cleanupScheduler.mu.Lock()
+		defer cleanupScheduler.mu.Unlock()
+		return len(cleanupScheduler.items)
+	}
+	before, beforeItems := runtime.NumGoroutine(), scheduled()
+	var created []*Group
+	for i := 0; i < 1000; i++ {
+		created = append(created, NewGroup(fmt.Sprintf("tenant-%d", i), 2<<10, getter))
+	}
+	if after := runtime.NumGoroutine(); after-before > 2 {
+		t.Fatalf("expect O(1) goroutines for 1000 groups, but %d new got", after-before)
+	}
+	for _, g := range created {
+		g.Close()
+	}
+	if n := scheduled(); n != beforeItems {
+		t.Fatalf("closed groups should leave the scheduler, %d remain", n-beforeItems)
+	}
+}
+
+func TestSchedulerIntervals(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+	s := newScheduler(clock)
+	s.running = true // 测试中手动驱动 runDue
+
+	getter := GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil })
+	fast := NewGroup("sched-fast", 2<<10, getter, WithClock(clock))
+	slow := NewGroup("sched-slow", 2<<10, getter, WithClock(clock))
+	idle := NewGroup("sched-idle", 2<<10, getter, WithClock(clock))
+	defer fast.Close()
+	defer slow.Close()
+	defer idle.Close()
+	// 只由测试中的调度器清理
+	for _, g := range []*Group{fast, slow, idle} {
+		cleanupScheduler.remove(g)
+	}
+	fast.Set("k", []byte("v"), 30*time.Second)
+	slow.Set("k", []byte("v"), 30*time.Second)
+	idle.Set("k", []byte("v"), 0)
+	s.add(fast, time.Minute)
+	s.add(slow, 5*time.Minute)
+	s.add(idle, time.Minute)
+
+	// 条目在 30s 后过期，但两次清理之间至少间隔 interval
+	now = now.Add(31 * time.Second)
+	if n := s.runDue(now); n != 0 {
+		t.Fatalf("expect the minimum gap to hold back cleanups, but %d ran", n)
+	}
+	now = now.Add(30 * time.Second)
+	if n := s.runDue(now); n != 1 {
+		t.Fatalf("expect only the fast group to be due, but %d ran", n)
+	}
+	if fast.mainCache.lru.Len() != 0 || slow.mainCache.lru.Len() != 1 {
+		t.Fatalf("expect only the fast group to be swept")
+	}
+
+	// 没有待过期条目的 Group 不再被唤醒
+	now = now.Add(4 * time.Minute)
+	if n := s.runDue(now); n != 1 {
+		t.Fatalf("expect only the slow group to be due, but %d ran", n)
+	}
+	if slow.mainCache.lru.Len() != 0 {
+		t.Fatalf("expect the slow group to be swept")
+	}
+	if _, ok := s.nextDue(); ok {
+		t.Fatalf("expect no wakeups without pending expiry")
+	}
+
+	// 写入更早过期的条目时提前计划
+	fast.Set("later", []byte("v"), time.Hour)
+	s.expireAt(fast, now.Add(time.Hour))
+	fast.Set("sooner", []byte("v"), 2*time.Minute)
+	s.expireAt(fast, now.Add(2*time.Minute))
+	if next, _ := s.nextDue(); !next.Equal(now.Add(2*time.Minute + expirySlack)) {
+		t.Fatalf("expect the sooner entry to move the cleanup earlier, got %v", next.Sub(now))
+	}
+	now = now.Add(2*time.Minute + expirySlack)
+	if n := s.runDue(now); n != 1 || fast.mainCache.lru.Len() != 1 {
+		t.Fatalf("expect the sooner entry swept, but %d ran", n)
+	}
+	if next, _ := s.nextDue(); !next.Equal(now.Add(58 * time.Minute)) {
+		t.Fatalf("expect the next cleanup at the remaining entry, got %v", next.Sub(now))
+	}
+
+	// 每轮清理的数量有上限
+	s.maxPerTick = 1
+	slow.Set("k", []byte("v"), time.Minute)
+	s.expireAt(slow, now.Add(time.Minute))
+	now = now.Add(2 * time.Hour)
+	if n := s.runDue(now); n != 1 {
+		t.Fatalf("expect at most 1 cleanup per tick, but %d ran", n)
+	}
+	if n := s.runDue(now); n != 1 {
+		t.Fatalf("expect the remaining group in the next tick, but %d ran", n)
+	}
+	if idle.mainCache.lru.Len() != 1 {
+		t.Fatalf("expect the idle group untouched")
+	}
+}
+
+func TestSchedulerWakesAtExpiry(t *testing.T) {
+	g := NewGroup("sched-wake", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithCleanupInterval(time.Millisecond))
+	defer g.Close()
+	g.Set("k", []byte("v"), 20*time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for g.Stats().PendingExpiry != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the entry swept shortly after its deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSchedulerSkipsLaterExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	g := NewGroup("sched-skip", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithClock(func() time.Time { return now }))
+	defer g.Close()
+
+	g.Set("sooner", []byte("v"), time.Minute)
+	planned := atomic.LoadInt64(&g.sweepAt)
+	if planned != now.Add(time.Minute).UnixNano() {
+		t.Fatalf("expect the cleanup planned at the first expiry, got %d", planned)
+	}
+	// 更晚过期的条目不改变计划，也不需要获取调度器的锁
+	g.Set("later", []byte("v"), time.Hour)
+	if n := atomic.LoadInt64(&g.sweepAt); n != planned {
+		t.Fatalf("expect the plan unchanged by a later expiry, got %d", n)
+	}
+	g.Set("soonest", []byte("v"), time.Second)
+	if n := atomic.LoadInt64(&g.sweepAt); n != now.Add(time.Second).UnixNano() {
+		t.Fatalf("expect an earlier expiry to move the plan, got %d", n)
+	}
+	cleanupScheduler.remove(g)
+	if n := atomic.LoadInt64(&g.sweepAt); n != 0 {
+		t.Fatalf("expect the plan cleared on removal, got %d", n)
+	}
+}
@@ -0,0 +1,176 @@
+package geecache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// protocolVersion 是节点间 HTTP 协议的版本，不兼容的修改需要递增
+	protocolVersion = 1
+	// maxClockSkew 是自检允许的最大时钟偏差，TTL 以绝对时间跨节点传递时需要时钟基本一致
+	maxClockSkew = 5 * time.Second
+	// selfCheckInterval 内调试接口复用上一次自检的结果，避免每个请求都 ping 所有对等点
+	selfCheckInterval = 5 * time.Second
+
+	pingPath      = "_ping"
+	selfCheckPath = "_selfcheck"
+)
+
+// pingResponse 是 ping 接口的响应体
+type pingResponse struct {
+	Time    int64 `json:"time"` // 服务端时间，Unix 纳秒
+	Version int   `json:"version"`
+}
+
+// PeerCheck 是对一个对等点的自检结果
+type PeerCheck struct {
+	Peer       string        `json:"peer"`
+	Reachable  bool          `json:"reachable"`  // 是否收到了 ping 响应
+	Compatible bool          `json:"compatible"` // 协议版本是否一致
+	Latency    time.Duration `json:"latency"`    // ping 的往返时间
+	ClockSkew  time.Duration `json:"clock_skew"` // 对等点时钟减去本地时钟的估计值
+	OK         bool          `json:"ok"`         // 可达、兼容且时钟偏差在允许范围内
+	Error      string        `json:"error,omitempty"`
+}
+
+// selfCheckCache 保存调试接口最近一次完成的自检
+type selfCheckCache struct {
+	mu     sync.Mutex // 自检期间持有，同时到达的请求等待并复用结果
+	at     time.Time
+	checks []PeerCheck
+}
+
+// ChecksPassed 判断自检结果是否全部通过
+func ChecksPassed(checks []PeerCheck) bool {
+	for _, c := range checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// SelfCheck 并发地 ping 每个已配置的对等点（不含自身），检查连通性、协议版本和时钟偏差。
+// 适合在启动时调用，尽早发现写错的地址和被防火墙拦截的端口。结果按对等点排序。
+func (p *HTTPPool) SelfCheck(ctx context.Context) []PeerCheck {
+	p.mu.Lock()
+	var peers []string
+	for peer := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, peer)
+		}
+	}
+	p.mu.Unlock()
+	sort.Strings(peers)
+
+	checks := make([]PeerCheck, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			checks[i] = p.checkPeer(ctx, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+	return checks
+}
+
+func (p *HTTPPool) checkPeer(ctx context.Context, peer string) PeerCheck {
+	c := PeerCheck{Peer: peer}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+p.basePath+pingPath, nil)
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	start := time.Now()
+	res, err := clientOrDefault(p.client).Do(req)
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	defer res.Body.Close()
+	c.Latency = time.Since(start)
+	c.Reachable = true
+
+	if res.StatusCode != http.StatusOK {
+		c.Error = fmt.Sprintf("server returned: %v", res.Status)
+		return c
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.Error = fmt.Sprintf("reading response body: %v", err)
+		return c
+	}
+	var ping pingResponse
+	if err = json.Unmarshal(body, &ping); err != nil {
+		c.Error = fmt.Sprintf("decoding response body: %v", err)
+		return c
+	}
+	if ping.Version != protocolVersion {
+		c.Error = fmt.Sprintf("protocol version %d, want %d", ping.Version, protocolVersion)
+		return c
+	}
+	c.Compatible = true
+	// 假设服务端在往返时间的中点读取时钟
+	c.ClockSkew = time.Unix(0, ping.Time).Sub(start.Add(c.Latency / 2))
+	if c.ClockSkew > maxClockSkew || c.ClockSkew < -maxClockSkew {
+		c.Error = fmt.Sprintf("clock skew %v exceeds %v", c.ClockSkew, maxClockSkew)
+		return c
+	}
+	c.OK = true
+	return c
+}
+
+// servePing 返回本节点的时间和协议版本
+func (p *HTTPPool) servePing(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, pingResponse{Time: time.Now().UnixNano(), Version: protocolVersion})
+}
+
+// serveSelfCheck 对所有对等点执行自检，有未通过的检查时返回 503。
+// 访问控制按名为 "_selfcheck" 的 group 配置，见 ConfigureGroup；selfCheckInterval 内的请求复用上一次的结果。
+func (p *HTTPPool) serveSelfCheck(w http.ResponseWriter, r *http.Request) {
+	if _, ok := p.admit(w, r, selfCheckPath); !ok {
+		return
+	}
+	checks := p.cachedSelfCheck(r.Context())
+	status := http.StatusOK
+	if !ChecksPassed(checks) {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, checks)
+}
+
+// cachedSelfCheck 返回 selfCheckInterval 内的上一次自检结果，过期时重新自检。
+// ctx 被取消时的结果不完整，不会被保存
+func (p *HTTPPool) cachedSelfCheck(ctx context.Context) []PeerCheck {
+	c := &p.selfCheck
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checks != nil && time.Since(c.at) < selfCheckInterval {
+		return c.checks
+	}
+	checks := p.SelfCheck(ctx)
+	if ctx.Err() == nil {
+		c.checks, c.at = checks, time.Now()
+	}
+	return checks
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,175 @@
+package geecache
+
+import (
+	"sort"
+	"time"
+)
+
+// shardCleanRounds 是分片的缓存清理一遍所有分片的次数：每次过期清理处理约 1/shardCleanRounds 的分片，
+// 大量条目同时过期时清理的工作分摊到多次
+const shardCleanRounds = 4
+
+// ShardStats 是 mainCache 一个分片的统计快照，只在读取该分片时持有它的锁
+type ShardStats struct {
+	Bytes         int64 // 当前占用的字节数
+	MaxBytes      int64 // 分到的字节上限，0 表示不限制
+	Entries       int   // 条目数
+	PendingExpiry int   // 过期堆的长度
+}
+
+// sharded 报告缓存是否分片，第一次调用时按当前的配置创建分片
+func (c *cache) sharded() bool {
+	if c.shardCount <= 1 {
+		return false
+	}
+	c.shardsOnce.Do(c.initShards)
+	return true
+}
+
+// initShards 创建分片，各分片使用与缓存相同的配置，字节上限和 EvictionSlack 按 splitBudget 分配。
+// 用量通知由缓存汇总所有分片后发出，分片本身不通知
+func (c *cache) initShards() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	budgets := splitBudget(c.cacheBytes, c.shardCount)
+	slacks := splitBudget(c.evictionSlack, c.shardCount)
+	c.shards = make([]*cache, c.shardCount)
+	for i := range c.shards {
+		c.shards[i] = &cache{
+			cacheBytes:     budgets[i],
+			now:            c.now,
+			maxAge:         c.maxAge,
+			newPolicy:      c.newPolicy,
+			noExpiry:       c.noExpiry,
+			maxEvictions:   c.maxEvictions,
+			evictionSlack:  slacks[i],
+			highWatermark:  c.highWatermark,
+			lowWatermark:   c.lowWatermark,
+			evictionFilter: c.evictionFilter,
+			compression:    c.compression,
+			// NewGroup 在配置之后才设置 onExpiry，分片可能在此之前创建
+			onExpiry: func(at time.Time) {
+				if c.onExpiry != nil {
+					c.onExpiry(at)
+				}
+			},
+			promoteDistance: c.promoteDistance,
+			arena:           c.arena,
+			arenaShare:      int64(c.shardCount),
+		}
+	}
+}
+
+// splitBudget 把 total 字节分给 n 个分片：每个分片分到 total/n，余数依次多分给前面的分片各 1 字节，
+// 总和等于 total。total 不大于 0 表示不限制，所有分片都不限制；total 小于 n 时分不到字节的分片
+// 得到 1 字节，以免 0 被当作不限制，此时总和比 total 多出不到 n 字节
+func splitBudget(total int64, n int) []int64 {
+	budgets := make([]int64, n)
+	if total <= 0 {
+		return budgets
+	}
+	base, rem := total/int64(n), total%int64(n)
+	for i := range budgets {
+		budgets[i] = base
+		if int64(i) < rem {
+			budgets[i]++
+		}
+		if budgets[i] == 0 {
+			budgets[i] = 1
+		}
+	}
+	return budgets
+}
+
+// shardFor 按键的 FNV-1a 哈希选择分片，同一个键总是落在同一个分片上
+func (c *cache) shardFor(key string) *cache {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+// cleanShards 从上次停下的位置开始清理一部分分片，每次只持有一个分片的锁
+func (c *cache) cleanShards() {
+	n := len(c.shards)
+	per := (n + shardCleanRounds - 1) / shardCleanRounds
+	c.mu.Lock()
+	start := c.nextClean
+	c.nextClean = (start + per) % n
+	c.mu.Unlock()
+	for i := 0; i < per; i++ {
+		c.shards[(start+i)%n].cleanExpired()
+	}
+	c.observeUsage()
+}
+
+// resizeShards 修改缓存的字节上限并重新分给各分片。持有 c.mu 直到所有分片都已调整，
+// 并发的 resize 不会让各分片的上限来自不同的总量
+func (c *cache) resizeShards(cacheBytes int64) {
+	defer c.observeUsage()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cacheBytes = cacheBytes
+	for i, b := range splitBudget(cacheBytes, len(c.shards)) {
+		c.shards[i].resize(b)
+	}
+}
+
+// shardStats 逐个分片读取统计，不同时持有多个分片的锁。缓存没有分片时返回 nil
+func (c *cache) shardStats() []ShardStats {
+	if !c.sharded() {
+		return nil
+	}
+	stats := make([]ShardStats, len(c.shards))
+	for i, s := range c.shards {
+		s.mu.Lock()
+		stats[i].MaxBytes = s.cacheBytes
+		if s.lru != nil {
+			stats[i].Bytes = s.bytesLocked()
+			stats[i].Entries = s.lru.Len()
+			stats[i].PendingExpiry, _ = s.lru.PendingExpiry()
+		}
+		s.mu.Unlock()
+	}
+	return stats
+}
+
+// oldestShards 轮流从各分片的淘汰顺序中取键，最多 n 个。淘汰只在分片内部进行，
+// 因此跨分片的顺序是近似的
+func (c *cache) oldestShards(n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	lists := make([][]string, len(c.shards))
+	for i, s := range c.shards {
+		lists[i] = s.oldest(n)
+	}
+	keys := make([]string, 0, n)
+	for depth := 0; len(keys) < n; depth++ {
+		added := false
+		for _, l := range lists {
+			if depth < len(l) && len(keys) < n {
+				keys = append(keys, l[depth])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	return keys
+}
+
+// shardEntries 返回所有分片的条目，按写入时间从早到晚排列
+func (c *cache) shardEntries() []cacheEntry {
+	var es []cacheEntry
+	for _, s := range c.shards {
+		es = append(es, s.entries()...)
+	}
+	sort.SliceStable(es, func(i, j int) bool {
+		return es[i].info.CreatedAt.Before(es[j].info.CreatedAt)
+	})
+	return es
+}
@@ -0,0 +1,118 @@
+package geecache
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSplitBudget(t *testing.T) {
+	for _, c := range []struct {
+		total int64
+		n     int
+		want  []int64
+	}{
+		{1000, 7, []int64{143, 143, 143, 143, 143, 143, 142}},
+		{8, 4, []int64{2, 2, 2, 2}},
+		{0, 3, []int64{0, 0, 0}},
+		{2, 4, []int64{1, 1, 1, 1}},
+	} {
+		got := splitBudget(c.total, c.n)
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Fatalf("splitBudget(%d, %d) = %v, want %v", c.total, c.n, got, c.want)
+		}
+	}
+}
+
+func TestCacheShardsResize(t *testing.T) {
+	gee := NewGroup("shards-resize", 1000, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithCacheShards(7))
+	defer gee.Close()
+	for i := 0; i < 200; i++ {
+		gee.Set(fmt.Sprintf("k%03d", i), []byte("0123456789"), 0)
+	}
+
+	for _, total := range []int64{1000, 999, 12345, 301, 0, 701} {
+		gee.Resize(total)
+		var sum, used int64
+		entries := 0
+		for _, s := range gee.Stats().CacheShards {
+			sum += s.MaxBytes
+			used += s.Bytes
+			entries += s.Entries
+			if total > 0 && s.Bytes > s.MaxBytes {
+				t.Fatalf("expect shard within its budget after Resize(%d), got %+v", total, s)
+			}
+		}
+		if sum != total {
+			t.Fatalf("expect shard budgets to sum to %d, got %d", total, sum)
+		}
+		if used != gee.mainCache.bytes() || entries == 0 {
+			t.Fatalf("expect shard stats to add up, got %d bytes in %d entries", used, entries)
+		}
+	}
+}
+
+func TestCacheShardsCleanup(t *testing.T) {
+	now := time.Unix(1000, 0)
+	gee := NewGroup("shards-cleanup", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithClock(func() time.Time { return now }), WithCacheShards(8))
+	defer gee.Close()
+	const n = 800
+	for i := 0; i < n; i++ {
+		gee.Set(fmt.Sprintf("k%03d", i), []byte("v"), time.Minute)
+	}
+	gee.Set("kept", []byte("v"), 0)
+	if v, err := gee.Get("k042"); err != nil || v.String() != "v" {
+		t.Fatalf("expect sharded get to hit, got %v %v", v, err)
+	}
+
+	// 所有条目同时过期，每次清理只处理一部分分片
+	now = now.Add(2 * time.Minute)
+	remaining := []int{}
+	for i := 0; i < shardCleanRounds; i++ {
+		gee.mainCache.cleanExpired()
+		pending, _ := gee.mainCache.pendingExpiry()
+		remaining = append(remaining, pending)
+	}
+	for i, pending := range remaining[:shardCleanRounds-1] {
+		if pending == 0 || pending >= n || (i > 0 && pending >= remaining[i-1]) {
+			t.Fatalf("expect the mass expiry to be spread over several cleanups, got %v", remaining)
+		}
+	}
+	if remaining[shardCleanRounds-1] != 0 {
+		t.Fatalf("expect all shards cleaned after %d rounds, got %v", shardCleanRounds, remaining)
+	}
+	if ok, _ := gee.Has("kept"); !ok {
+		t.Fatalf("expect entries without ttl to stay")
+	}
+}
+
+func TestCacheShardsEntries(t *testing.T) {
+	now := time.Unix(1000, 0)
+	gee := NewGroup("shards-entries", 0, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithClock(func() time.Time { return now }), WithCacheShards(4))
+	defer gee.Close()
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Second)
+		gee.Set(fmt.Sprintf("k%02d", i), []byte("v"), 0)
+	}
+	if keys := gee.EvictionPreview(5); len(keys) != 5 {
+		t.Fatalf("expect 5 eviction candidates across shards, got %v", keys)
+	}
+
+	var buf bytes.Buffer
+	if n, err := gee.SaveSnapshot(&buf, false); err != nil || n != 20 {
+		t.Fatalf("expect all shards saved, got %d %v", n, err)
+	}
+	es := gee.mainCache.entries()
+	for i := 1; i < len(es); i++ {
+		if es[i].info.CreatedAt.Before(es[i-1].info.CreatedAt) {
+			t.Fatalf("expect entries ordered by creation time, got %s before %s", es[i-1].key, es[i].key)
+		}
+	}
+}
@@ -1,42 +1,224 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 分片数量，必须是 2 的幂
+const numShards = 32
+
+// 单个分片的峰值不低于该值时才考虑重建 map
+const minShrinkPeak = 64
+
+// cacheLineSize 是分片之间填充的字节数，常见 CPU 的缓存行大小
+const cacheLineSize = 64
 
 // call 是一个正在进行或已完成的 Do 调用
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{} // fn 返回后关闭
+	val  interface{}
+	err  error
+
+	// 以下字段由所在分片的 mu 保护
+	refs   int                // 仍在等待结果的调用者数量
+	cancel context.CancelFunc // 非 nil 时，取消传给 fn 的共享 context
+}
+
+// shard 保存一部分键的调用，各分片有独立的锁，不同键的 Do 很少相互阻塞
+type shard struct {
+	mu   sync.Mutex       // protects m and peak
+	m    map[string]*call // lazily initialized
+	peak int              // m 自上次重建以来的最大长度
+
+	// 相邻分片的字段之间至少相隔一个缓存行，不同分片的锁不会因为伪共享而相互争用
+	_ [cacheLineSize]byte
 }
 
 // Group 表示一类工作，并形成一个命名空间，其中工作单元可以执行重复抑制。
+// 零值可以直接使用。
 type Group struct {
-	mu sync.Mutex       // protects m
-	m  map[string]*call // lazily initialized
+	shards [numShards]shard
+}
+
+// shardFor 按键的 FNV-1a 哈希选择分片，同一个键总是落在同一个分片上
+func (g *Group) shardFor(key string) *shard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &g.shards[h&(numShards-1)]
 }
 
 // Do 执行并返回给定函数的结果，确保对于给定键，一次只有一个执行正在进行。如果有重复进来，重复调用者等待原始完成并接收相同的结果。
+// 如果 fn panic，等待者会收到一个错误，panic 会继续在发起调用的协程中传播。
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
-	g.mu.Lock()
-	if g.m == nil {
-		g.m = make(map[string]*call)
+	s := g.shardFor(key)
+	s.mu.Lock()
+	if s.m == nil {
+		s.m = make(map[string]*call)
+	}
+	if c, ok := s.m[key]; ok {
+		// Do 的调用者无法放弃等待，它持有的引用不会释放
+		c.refs++
+		s.mu.Unlock()
+		<-c.done
+		return c.val, c.err
+	}
+	c := s.newCallLocked(key)
+	s.mu.Unlock()
+
+	s.doCall(c, key, fn)
+	return c.val, c.err
+}
+
+// DoContext 与 Do 相同，但每个调用者可以通过自己的 ctx 放弃等待。
+//
+// 放弃等待的调用者立即返回 ctx.Err()，不影响其他调用者。fn 收到的是所有调用者共享的
+// context，它带有发起者 ctx 中的值（如请求 ID、追踪信息），但不继承任何调用者的取消和截止时间：
+// 只有当所有调用者（包括发起者）都已放弃时才会被取消，
+// 此时该调用也会被移除，之后的 DoContext 会重新执行 fn。跟随者 ctx 中的值不会传给 fn。
+// 通过 Do 加入的调用者无法放弃，因此只要有它们在等待，共享 context 就不会被取消。
+//
+// ctx 不可取消时 fn 在当前协程中执行，panic 语义与 Do 相同；否则 fn 在新的协程中执行，
+// 其中的 panic 会被恢复并作为错误返回给所有调用者。
+func (g *Group) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	s := g.shardFor(key)
+	s.mu.Lock()
+	if s.m == nil {
+		s.m = make(map[string]*call)
+	}
+	if c, ok := s.m[key]; ok {
+		c.refs++
+		s.mu.Unlock()
+		return s.wait(ctx, c, key)
+	}
+	c := s.newCallLocked(key)
+	if ctx.Done() == nil {
+		s.mu.Unlock()
+		s.doCall(c, key, func() (interface{}, error) {
+			return fn(ctx)
+		})
+		return c.val, c.err
+	}
+	shared, cancel := context.WithCancel(detached{ctx})
+	c.cancel = cancel
+	s.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		defer func() {
+			if r := recover(); r != nil {
+				c.err = fmt.Errorf("singleflight: panic in fn for key %s: %v", key, r)
+				s.finish(c, key)
+			}
+		}()
+		c.val, c.err = fn(shared)
+		s.finish(c, key)
+	}()
+	return s.wait(ctx, c, key)
+}
+
+// detached 保留父 context 的值，但不继承它的取消和截止时间
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (deadline time.Time, ok bool) { return }
+func (detached) Done() <-chan struct{}                   { return nil }
+func (detached) Err() error                              { return nil }
+func (d detached) Value(key interface{}) interface{}     { return d.parent.Value(key) }
+
+// newCallLocked 为键创建一个新的调用，调用者持有 s.mu
+func (s *shard) newCallLocked(key string) *call {
+	c := &call{done: make(chan struct{}), refs: 1}
+	s.m[key] = c
+	if len(s.m) > s.peak {
+		s.peak = len(s.m)
 	}
-	if c, ok := g.m[key]; ok {
-		g.mu.Unlock()
-		c.wg.Wait()
+	return c
+}
+
+// wait 等待调用完成或 ctx 被取消，最后一个放弃的调用者会取消共享 context
+func (s *shard) wait(ctx context.Context, c *call, key string) (interface{}, error) {
+	select {
+	case <-c.done:
 		return c.val, c.err
+	case <-ctx.Done():
 	}
-	c := new(call)
-	c.wg.Add(1)
-	g.m[key] = c
-	g.mu.Unlock()
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.refs--
+	if c.refs == 0 && c.cancel != nil {
+		c.cancel()
+		// 已被放弃的调用不再接受新的等待者
+		if s.m[key] == c {
+			delete(s.m, key)
+		}
+		s.shrinkLocked()
+	}
+	return nil, ctx.Err()
+}
+
+// doCall 执行 fn，无论正常返回还是 panic 都会唤醒等待者并删除该调用
+func (s *shard) doCall(c *call, key string, fn func() (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("singleflight: panic in fn for key %s: %v", key, r)
+			s.finish(c, key)
+			panic(r)
+		}
+	}()
 	c.val, c.err = fn()
-	c.wg.Done()
+	s.finish(c, key)
+}
 
-	g.mu.Lock()
-	delete(g.m, key)
-	g.mu.Unlock()
+func (s *shard) finish(c *call, key string) {
+	close(c.done)
 
-	return c.val, c.err
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 调用可能已被 Forget，同名的新调用不能被误删
+	if s.m[key] == c {
+		delete(s.m, key)
+	}
+	s.shrinkLocked()
+}
+
+// shrinkLocked 在 map 远小于峰值时重建它，释放 Go map 不会归还的桶内存
+func (s *shard) shrinkLocked() {
+	if s.peak < minShrinkPeak || len(s.m) > s.peak/4 {
+		return
+	}
+	m := make(map[string]*call, len(s.m))
+	for k, c := range s.m {
+		m[k] = c
+	}
+	s.m = m
+	s.peak = len(m)
+}
+
+// Forget 使 Group 忘记键对应的调用，之后的 Do 会重新执行 fn，而不是等待进行中的调用。
+func (g *Group) Forget(key string) {
+	s := g.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+	s.shrinkLocked()
+}
+
+// Size 返回正在进行的调用数量
+func (g *Group) Size() int {
+	n := 0
+	for i := range g.shards {
+		s := &g.shards[i]
+		s.mu.Lock()
+		n += len(s.m)
+		s.mu.Unlock()
+	}
+	return n
 }
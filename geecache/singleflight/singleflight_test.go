@@ -1,7 +1,13 @@
 package singleflight
 
 import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestDo(t *testing.T) {
@@ -14,3 +20,343 @@ func TestDo(t *testing.T) {
 		t.Errorf("Do v = %v, error = %v", v, err)
 	}
 }
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("panic should propagate to the caller")
+			}
+		}()
+		g.Do("key", func() (interface{}, error) {
+			panic("boom")
+		})
+	}()
+
+	if g.Size() != 0 {
+		t.Fatalf("panicked call should be removed, size = %d", g.Size())
+	}
+	v, err := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil {
+		t.Errorf("Do after panic v = %v, error = %v", v, err)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+			return "first", nil
+		})
+		close(done)
+	}()
+	<-started
+
+	g.Forget("key")
+	v, _ := g.Do("key", func() (interface{}, error) {
+		return "second", nil
+	})
+	if v != "second" {
+		t.Errorf("Do after Forget should run fn again, got %v", v)
+	}
+	close(release)
+	<-done
+	if g.Size() != 0 {
+		t.Fatalf("expect empty map, size = %d", g.Size())
+	}
+}
+
+func TestShrinkAfterBurst(t *testing.T) {
+	var g Group
+	const n = 4 * minShrinkPeak * numShards
+	var started, finished sync.WaitGroup
+	release := make(chan struct{})
+	started.Add(n)
+	finished.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer finished.Done()
+			g.Do(strconv.Itoa(i), func() (interface{}, error) {
+				started.Done()
+				<-release
+				return nil, nil
+			})
+		}(i)
+	}
+	started.Wait()
+	if g.Size() != n {
+		t.Fatalf("expect %d in-flight calls, got %d", n, g.Size())
+	}
+	close(release)
+	finished.Wait()
+
+	for i := range g.shards {
+		s := &g.shards[i]
+		s.mu.Lock()
+		if len(s.m) != 0 || s.peak >= minShrinkPeak {
+			t.Fatalf("shard %d map should be recreated after the burst, peak = %d", i, s.peak)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestSequentialKeysMemory(t *testing.T) {
+	var g Group
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	// 先制造一次大量不同键同时进行的高峰，相当于同样数量的 Do 阻塞在 fn 中，
+	// 不重建 map 时高峰留下的桶会一直保留
+	const burst = 200000
+	calls := make([]*call, burst)
+	for i := range calls {
+		s := g.shardFor(strconv.Itoa(i))
+		s.mu.Lock()
+		if s.m == nil {
+			s.m = make(map[string]*call)
+		}
+		calls[i] = s.newCallLocked(strconv.Itoa(i))
+		s.mu.Unlock()
+	}
+	if g.Size() != burst {
+		t.Fatalf("expect %d in-flight calls, got %d", burst, g.Size())
+	}
+	for i, c := range calls {
+		g.shardFor(strconv.Itoa(i)).finish(c, strconv.Itoa(i))
+	}
+	calls = nil
+
+	for i := 0; i < 1000000; i++ {
+		g.Do(strconv.Itoa(i), func() (interface{}, error) {
+			return nil, nil
+		})
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	if delta := int64(after.HeapAlloc) - int64(before.HeapAlloc); delta > 1<<20 {
+		t.Fatalf("singleflight retained %d bytes of the peak map after 1M distinct keys", delta)
+	}
+	if g.Size() != 0 {
+		t.Fatalf("expect empty map, size = %d", g.Size())
+	}
+}
+
+// mutexGroup 是分片之前的实现：所有键共用一个锁和一个 map，作为 BenchmarkDoDistinctKeys 的基准。
+// 分片只在多核上减少争用，应以 -cpu 指定多个 P 比较两者
+type mutexGroup struct {
+	mu sync.Mutex
+	m  map[string]*call
+}
+
+func (g *mutexGroup) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.val, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	g.m[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	close(c.done)
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+	return c.val, c.err
+}
+
+func benchmarkDoDistinctKeys(b *testing.B, do func(key string, fn func() (interface{}, error)) (interface{}, error)) {
+	var n int64
+	b.RunParallel(func(pb *testing.PB) {
+		// 每个协程使用自己的一组键，使不同协程之间没有重复
+		id := atomic.AddInt64(&n, 1)
+		keys := make([]string, 1024)
+		for i := range keys {
+			keys[i] = strconv.FormatInt(id, 10) + "-" + strconv.Itoa(i)
+		}
+		for i := 0; pb.Next(); i++ {
+			do(keys[i%len(keys)], func() (interface{}, error) {
+				return nil, nil
+			})
+		}
+	})
+}
+
+func BenchmarkDoDistinctKeys(b *testing.B) {
+	var g Group
+	benchmarkDoDistinctKeys(b, g.Do)
+}
+
+func BenchmarkDoDistinctKeysSingleMutex(b *testing.B) {
+	var g mutexGroup
+	benchmarkDoDistinctKeys(b, g.Do)
+}
+
+// waitRefs 等待键的调用有 n 个调用者
+func waitRefs(g *Group, key string, n int) {
+	s := g.shardFor(key)
+	for {
+		s.mu.Lock()
+		refs := 0
+		if c := s.m[key]; c != nil {
+			refs = c.refs
+		}
+		s.mu.Unlock()
+		if refs == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDoContextLeaderCancelled(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fnCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) (interface{}, error) {
+		fnCtx <- ctx
+		close(started)
+		<-release
+		return "bar", nil
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := g.DoContext(leaderCtx, "key", fn)
+		leaderErr <- err
+	}()
+	<-started
+
+	followerRes := make(chan interface{}, 1)
+	go func() {
+		v, _ := g.DoContext(context.WithValue(context.Background(), "k", "v"), "key", fn)
+		followerRes <- v
+	}()
+	waitRefs(&g, "key", 2)
+
+	// 发起者放弃后，仍有调用者在等待，共享 context 不能被取消
+	cancelLeader()
+	if err := <-leaderErr; err != context.Canceled {
+		t.Fatalf("expect leader to get context.Canceled, got %v", err)
+	}
+	ctx := <-fnCtx
+	if ctx.Err() != nil {
+		t.Fatalf("shared context cancelled while a caller is still waiting")
+	}
+	close(release)
+	if v := <-followerRes; v != "bar" {
+		t.Fatalf("expect follower to get bar, got %v", v)
+	}
+}
+
+func TestDoContextAllCancelled(t *testing.T) {
+	var g Group
+	aborted := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	var calls int32
+	fn := func(shared context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-shared.Done()
+		close(aborted)
+		return nil, shared.Err()
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := g.DoContext(ctx, "key", fn)
+		errs <- err
+	}()
+	waitRefs(&g, "key", 1)
+	go func() {
+		_, err := g.DoContext(ctx2, "key", fn)
+		errs <- err
+	}()
+	waitRefs(&g, "key", 2)
+	cancel()
+	<-errs
+	cancel2()
+	<-errs
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatalf("fn was not cancelled after all callers gave up")
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expect fn to run once, got %d", calls)
+	}
+
+	// 被放弃的调用已被移除，新的调用会重新执行 fn
+	v, err := g.DoContext(context.Background(), "key", func(context.Context) (interface{}, error) {
+		return "new", nil
+	})
+	if v != "new" || err != nil {
+		t.Fatalf("expect a fresh call after abandonment, got %v %v", v, err)
+	}
+}
+
+type requestIDKey struct{}
+
+func TestDoContextValues(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fnCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) (interface{}, error) {
+		fnCtx <- ctx
+		close(started)
+		<-release
+		return "bar", ctx.Err()
+	}
+
+	leaderCtx, cancelLeader := context.WithTimeout(context.WithValue(context.Background(), requestIDKey{}, "req-1"), time.Minute)
+	defer cancelLeader()
+	leaderRes := make(chan interface{}, 1)
+	go func() {
+		v, _ := g.DoContext(leaderCtx, "key", fn)
+		leaderRes <- v
+	}()
+	<-started
+
+	followerCtx, cancelFollower := context.WithCancel(context.WithValue(context.Background(), requestIDKey{}, "req-2"))
+	followerErr := make(chan error, 1)
+	go func() {
+		_, err := g.DoContext(followerCtx, "key", fn)
+		followerErr <- err
+	}()
+	waitRefs(&g, "key", 2)
+
+	// 跟随者放弃不影响共享的加载
+	cancelFollower()
+	if err := <-followerErr; err != context.Canceled {
+		t.Fatalf("expect follower to get context.Canceled, got %v", err)
+	}
+	ctx := <-fnCtx
+	if id := ctx.Value(requestIDKey{}); id != "req-1" {
+		t.Fatalf("expect fn to see the leader's request id, got %v", id)
+	}
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatalf("expect fn not to inherit the leader's deadline")
+	}
+	close(release)
+	if v := <-leaderRes; v != "bar" {
+		t.Fatalf("expect leader to get bar, got %v", v)
+	}
+}
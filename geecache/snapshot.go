@@ -0,0 +1,303 @@
+package geecache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// 快照由连续的记录组成，每条记录依次为：
+//
+//	uvarint 键长度 | 键 | uvarint 值长度 | 值 | varint 过期时间（Unix 纳秒，0 表示没有 TTL）
+//
+// 过期时间使用绝对时间，导入时已过期的记录会被跳过。
+
+// 单个键或值的最大长度，防止损坏的数据导致巨大的内存分配
+const maxRecordBytes = 1 << 30
+
+// errCorruptRecord 表示快照中的记录无法解析
+var errCorruptRecord = errors.New("geecache: corrupt snapshot record")
+
+// WriteRecord 以快照格式写入一条记录，expireAt 为零值表示没有 TTL
+func WriteRecord(w io.Writer, key string, value []byte, expireAt time.Time) error {
+	var buf [binary.MaxVarintLen64]byte
+	var exp int64
+	if !expireAt.IsZero() {
+		exp = expireAt.UnixNano()
+	}
+	n := binary.PutUvarint(buf[:], uint64(len(key)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, key); err != nil {
+		return err
+	}
+	n = binary.PutUvarint(buf[:], uint64(len(value)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	if _, err := w.Write(value); err != nil {
+		return err
+	}
+	n = binary.PutVarint(buf[:], exp)
+	_, err := w.Write(buf[:n])
+	return err
+}
+
+// readRecord 读取一条记录，数据正好结束时返回 io.EOF
+func readRecord(r *bufio.Reader) (key string, value []byte, expireAt time.Time, err error) {
+	keyLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	kb, err := readBytes(r, keyLen)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	valueLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, time.Time{}, unexpectedEOF(err)
+	}
+	value, err = readBytes(r, valueLen)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	exp, err := binary.ReadVarint(r)
+	if err != nil {
+		return "", nil, time.Time{}, unexpectedEOF(err)
+	}
+	if exp != 0 {
+		expireAt = time.Unix(0, exp)
+	}
+	return string(kb), value, expireAt, nil
+}
+
+// readBytes 读取 n 个字节。缓冲区随读取的数据增长，损坏的长度不会导致一次巨大的分配
+func readBytes(r *bufio.Reader, n uint64) ([]byte, error) {
+	if n > maxRecordBytes {
+		return nil, errCorruptRecord
+	}
+	b, err := readSized(r, int64(n))
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return b, nil
+}
+
+// writeMeta 以快照帧中的格式写入元数据：uvarint 个数 | 每项的 uvarint 键长度 | 键 | uvarint 值长度 | 值，按键排序
+func writeMeta(w *bytes.Buffer, meta map[string]string) {
+	var buf [binary.MaxVarintLen64]byte
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(keys)))])
+	for _, k := range keys {
+		for _, s := range []string{k, meta[k]} {
+			w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+			w.WriteString(s)
+		}
+	}
+}
+
+// readMeta 读取 writeMeta 写入的元数据，没有元数据时返回 nil
+func readMeta(r *bufio.Reader) (map[string]string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n == 0 {
+		return nil, unexpectedEOF(err)
+	}
+	meta := make(map[string]string)
+	for i := uint64(0); i < n; i++ {
+		var kv [2]string
+		for j := range kv {
+			size, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			b, err := readBytes(r, size)
+			if err != nil {
+				return nil, err
+			}
+			kv[j] = string(b)
+		}
+		meta[kv[0]] = kv[1]
+	}
+	return meta, nil
+}
+
+// unexpectedEOF 把记录中间的 io.EOF 转换为 io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Preload 从 r 读取 WriteRecord 写入的记录并写入本地缓存，返回写入的条目数。
+// 已过期的记录和超过最大字节数的值会被跳过；记录不会被转发给对等点或复制。
+// WriteRecord 的记录不带元数据；r 以 SaveSnapshot 的文件头开始时按 LoadSnapshot 读取，元数据同样被恢复，
+// 因此需要保留元数据时应使用 SaveSnapshot。
+func (g *Group) Preload(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(snapshotMagic)); string(head) == snapshotMagic {
+		return g.LoadSnapshot(br)
+	}
+	loaded := 0
+	for {
+		key, value, expireAt, err := readRecord(br)
+		if err == io.EOF {
+			return loaded, nil
+		}
+		if err != nil {
+			return loaded, fmt.Errorf("preload record %d: %w", loaded, err)
+		}
+		if g.preloadRecord(key, value, nil, expireAt) {
+			loaded++
+		}
+	}
+}
+
+// preloadRecord 把一条记录写入 mainCache，返回它是否被保存
+func (g *Group) preloadRecord(key string, value []byte, meta map[string]string, expireAt time.Time) bool {
+	var ttl time.Duration
+	if !expireAt.IsZero() {
+		if ttl = expireAt.Sub(g.now()); ttl <= 0 {
+			return false
+		}
+	}
+	if key == "" || g.tooLarge(len(value)) {
+		return false
+	}
+	_, stored := g.mainCache.add(key, ByteView{b: value, meta: meta}, ttl)
+	return stored
+}
+
+// SaveSnapshot 写入的快照以文件头开始：
+//
+//	"GCSNAP" | 版本号（1 字节） | 标志位（1 字节，bit 0 表示之后的数据经过 gzip 压缩）
+//
+// 之后是连续的帧，每帧为 uvarint 长度 | 负载，负载以一条上述格式的记录开头，之后是条目的元数据（见 writeMeta），
+// 没有元数据的帧可以在记录之后直接结束。新版本可以在元数据之后追加字段，旧版本的读取方按长度跳过它们。
+
+const (
+	snapshotMagic   = "GCSNAP"
+	snapshotVersion = 1
+
+	snapshotFlagGzip = 1 << 0
+)
+
+var (
+	// ErrSnapshotFormat 表示数据不是 SaveSnapshot 写入的快照
+	ErrSnapshotFormat = errors.New("geecache: not a snapshot")
+	// ErrSnapshotVersion 表示快照的版本比当前代码支持的更新
+	ErrSnapshotVersion = errors.New("geecache: unsupported snapshot version")
+)
+
+// SaveSnapshot 将 mainCache 中未过期的条目写入 w，返回写入的条目数。
+// compress 为 true 时用 gzip 压缩条目部分。条目的元数据一同保存，负缓存条目和 hotCache 不会被保存。
+func (g *Group) SaveSnapshot(w io.Writer, compress bool) (int, error) {
+	var flags byte
+	if compress {
+		flags |= snapshotFlagGzip
+	}
+	header := append([]byte(snapshotMagic), snapshotVersion, flags)
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	var out io.Writer = bw
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(bw)
+		out = zw
+	}
+	var frame bytes.Buffer
+	var lenBuf [binary.MaxVarintLen64]byte
+	saved := 0
+	for _, e := range g.mainCache.entries() {
+		if e.info.Flags&flagNotFound != 0 {
+			continue
+		}
+		frame.Reset()
+		WriteRecord(&frame, e.key, e.view.b, e.info.ExpireAt)
+		writeMeta(&frame, e.view.meta)
+		n := binary.PutUvarint(lenBuf[:], uint64(frame.Len()))
+		if _, err := out.Write(lenBuf[:n]); err != nil {
+			return saved, err
+		}
+		if _, err := out.Write(frame.Bytes()); err != nil {
+			return saved, err
+		}
+		saved++
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return saved, err
+		}
+	}
+	return saved, bw.Flush()
+}
+
+// LoadSnapshot 读取 SaveSnapshot 写入的快照并写入本地缓存，返回写入的条目数。
+// 文件头不正确时返回 ErrSnapshotFormat，版本不受支持时返回包装了 ErrSnapshotVersion 的错误。
+// 与 Preload 一样，已过期的记录和超过最大字节数的值会被跳过；条目的元数据被恢复。
+func (g *Group) LoadSnapshot(r io.Reader) (int, error) {
+	header := make([]byte, len(snapshotMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, ErrSnapshotFormat
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, ErrSnapshotFormat
+	}
+	if v := header[len(snapshotMagic)]; v != snapshotVersion {
+		return 0, fmt.Errorf("%w: %d", ErrSnapshotVersion, v)
+	}
+	flags := header[len(snapshotMagic)+1]
+
+	br := bufio.NewReader(r)
+	if flags&snapshotFlagGzip != 0 {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return 0, fmt.Errorf("load snapshot: %w", err)
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+	loaded := 0
+	for i := 0; ; i++ {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return loaded, nil
+		}
+		if err != nil {
+			return loaded, fmt.Errorf("load snapshot frame %d: %w", i, unexpectedEOF(err))
+		}
+		payload, err := readBytes(br, size)
+		if err != nil {
+			return loaded, fmt.Errorf("load snapshot frame %d: %w", i, err)
+		}
+		// 只解析已知的记录和元数据，负载中之后的字段留给更新的版本
+		pr := bufio.NewReader(bytes.NewReader(payload))
+		key, value, expireAt, err := readRecord(pr)
+		if err != nil {
+			return loaded, fmt.Errorf("load snapshot frame %d: %w", i, unexpectedEOF(err))
+		}
+		var meta map[string]string
+		if _, err := pr.Peek(1); err == nil {
+			if meta, err = readMeta(pr); err != nil {
+				return loaded, fmt.Errorf("load snapshot frame %d: %w", i, err)
+			}
+		}
+		if g.preloadRecord(key, value, meta, expireAt) {
+			loaded++
+		}
+	}
+}
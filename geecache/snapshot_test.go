@@ -0,0 +1,148 @@
+package geecache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreload(t *testing.T) {
+	now := time.Unix(1000, 0)
+	loads := 0
+	gee := NewGroup("preload", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return nil, ErrNotFound
+	}), WithClock(func() time.Time { return now }), WithMaxValueBytes(8))
+
+	var buf bytes.Buffer
+	WriteRecord(&buf, "Tom", []byte("630"), time.Time{})
+	WriteRecord(&buf, "Jack", []byte("589"), now.Add(time.Minute))
+	WriteRecord(&buf, "Sam", []byte("567"), now.Add(-time.Minute))
+	WriteRecord(&buf, "Big", []byte("0123456789"), time.Time{})
+
+	n, err := gee.Preload(&buf)
+	if err != nil || n != 2 {
+		t.Fatalf("expect 2 records loaded, got %d %v", n, err)
+	}
+	for k, v := range map[string]string{"Tom": "630", "Jack": "589"} {
+		if view, err := gee.Get(k); err != nil || view.String() != v {
+			t.Fatalf("expect %s=%s from preload, got %v %v", k, v, view, err)
+		}
+	}
+	if loads != 0 {
+		t.Fatalf("expect no getter calls, got %d", loads)
+	}
+	if _, info, _ := gee.GetWithInfo("Jack"); !info.ExpireAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expect ttl to be kept, got expire at %v", info.ExpireAt)
+	}
+	for _, k := range []string{"Sam", "Big"} {
+		if ok, _ := gee.Has(k); ok {
+			t.Fatalf("expect %s to be skipped", k)
+		}
+	}
+
+	// 截断的数据返回已写入的数量和错误
+	buf.Reset()
+	WriteRecord(&buf, "Lily", []byte("1"), time.Time{})
+	WriteRecord(&buf, "Lucy", []byte("2"), time.Time{})
+	data := buf.Bytes()[:buf.Len()-2]
+	if n, err := gee.Preload(bytes.NewReader(data)); n != 1 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expect 1 record and ErrUnexpectedEOF, got %d %v", n, err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := WithClock(func() time.Time { return now })
+	src := NewGroup("snapshot-src", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), clock, WithNegativeTTL(time.Minute))
+	src.Set("Tom", []byte("630"), 0)
+	src.SetWithMeta("Jack", []byte("589"), time.Minute, map[string]string{"type": "score", "v": "2"})
+	src.Get("missing")
+
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		if n, err := src.SaveSnapshot(&buf, compress); err != nil || n != 2 {
+			t.Fatalf("expect 2 entries saved, got %d %v", n, err)
+		}
+		dst := NewGroup("snapshot-dst", 2<<10, src.getter, clock)
+		if n, err := dst.LoadSnapshot(&buf); err != nil || n != 2 {
+			t.Fatalf("expect 2 entries loaded with compress=%v, got %d %v", compress, n, err)
+		}
+		if v, info, err := dst.GetWithInfo("Jack"); err != nil || v.String() != "589" || !info.ExpireAt.Equal(now.Add(time.Minute)) ||
+			info.Meta["type"] != "score" || info.Meta["v"] != "2" {
+			t.Fatalf("unexpected entry %v %+v %v", v, info, err)
+		}
+		if v, info, err := dst.GetWithInfo("Tom"); err != nil || v.String() != "630" || len(info.Meta) != 0 {
+			t.Fatalf("unexpected entry %v %+v %v", v, info, err)
+		}
+		dst.Close()
+
+		// Preload 同样接受 SaveSnapshot 的输出
+		buf.Reset()
+		src.SaveSnapshot(&buf, compress)
+		pre := NewGroup("snapshot-preload", 2<<10, src.getter, clock)
+		if n, err := pre.Preload(&buf); err != nil || n != 2 {
+			t.Fatalf("expect Preload to read a snapshot with compress=%v, got %d %v", compress, n, err)
+		}
+		if _, info, err := pre.GetWithInfo("Jack"); err != nil || info.Meta["type"] != "score" {
+			t.Fatalf("expect Preload to keep the metadata, got %+v %v", info, err)
+		}
+		pre.Close()
+	}
+}
+
+func TestSnapshotCorruptLength(t *testing.T) {
+	gee := NewGroup("snapshot-corrupt", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	defer gee.Close()
+	// 声明了接近上限的长度却很快结束的记录不会一次分配全部内存
+	var buf bytes.Buffer
+	buf.Write([]byte{3, 'T', 'o', 'm'})
+	var lenBuf [binary.MaxVarintLen64]byte
+	buf.Write(lenBuf[:binary.PutUvarint(lenBuf[:], maxRecordBytes)])
+	buf.WriteString("short")
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := gee.Preload(bytes.NewReader(buf.Bytes())); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expect io.ErrUnexpectedEOF, got %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 8<<20 {
+		t.Fatalf("expect a bounded allocation for a corrupt length, allocated %d bytes", n)
+	}
+}
+
+func TestSnapshotVersioning(t *testing.T) {
+	gee := NewGroup("snapshot-version", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	if _, err := gee.LoadSnapshot(strings.NewReader("not a snapshot")); err != ErrSnapshotFormat {
+		t.Fatalf("expect ErrSnapshotFormat, got %v", err)
+	}
+	if _, err := gee.LoadSnapshot(strings.NewReader(snapshotMagic + "\x09\x00")); !errors.Is(err, ErrSnapshotVersion) {
+		t.Fatalf("expect ErrSnapshotVersion, got %v", err)
+	}
+
+	// 更新的写入方在记录之后追加的字段被跳过
+	var frame, buf bytes.Buffer
+	WriteRecord(&frame, "Tom", []byte("630"), time.Time{})
+	writeMeta(&frame, nil)
+	frame.WriteString("future fields")
+	buf.WriteString(snapshotMagic + "\x01\x00")
+	buf.WriteByte(byte(frame.Len()))
+	buf.Write(frame.Bytes())
+	if n, err := gee.LoadSnapshot(&buf); err != nil || n != 1 {
+		t.Fatalf("expect frame with unknown fields to load, got %d %v", n, err)
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("unexpected entry %v %v", v, err)
+	}
+}
@@ -0,0 +1,129 @@
+package geecache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats 是 Group 的统计计数
+type Stats struct {
+	Gets                int64 // 所有 Get 请求
+	CacheHits           int64 // 命中本地缓存
+	Loads               int64 // 未命中后进入加载流程
+	LoadsAvoided        int64 // 进入 singleflight 后发现值已被写入缓存而省去的加载
+	PeerLoads           int64 // 从远程节点获取成功
+	PeerErrors          int64 // 从远程节点获取失败，每次重试都计数
+	PeerFallbacks       int64 // 远程节点失败后回退到本地加载
+	LoadsWithoutPeers   int64 // 等待对等点注册超时后继续的加载
+	LocalLoads          int64 // 从本地 Getter 加载成功
+	LocalLoadErrs       int64 // 从本地 Getter 加载失败（重试后仍失败只计一次）
+	LoadRetries         int64 // 本地加载的重试次数
+	Batches             int64 // 合并后的 GetBatch 调用次数
+	ReplicaPushes       int64 // 成功推送到后继节点的副本
+	ReplicaDropped      int64 // 因队列已满而丢弃的副本
+	ReplicaErrors       int64 // 推送副本失败
+	Prefetches          int64 // 预取加载成功
+	PrefetchHits        int64 // 由预取写入的条目命中的次数
+	PrefetchDropped     int64 // 因并发数达到上限而放弃的预取
+	CompressedIn        int64 // 压缩存储的值压缩前的字节数
+	CompressedOut       int64 // 压缩存储的值压缩后的字节数
+	CompressSkipped     int64 // 因压缩效果差或压缩失败而按原样存储的值
+	PressureShrinks     int64 // 因内存压力收缩上限的次数
+	PressureRestores    int64 // 内存压力缓解后恢复上限的次数
+	TombstoneSuppressed int64 // 因键刚被删除而没有写回缓存的加载和副本写入
+
+	// 以下字段不是计数，而是取快照时读取的瞬时值
+	PendingExpiry int       // 主缓存过期堆的长度，即带 TTL 的条目数的上界
+	NextExpiry    time.Time // 主缓存中最早的过期时间，零值表示没有带 TTL 的条目
+	// 开启 WithCacheShards 时主缓存各分片的快照，逐个分片读取，分片之间不是同一时刻的值
+	CacheShards []ShardStats
+}
+
+// Stats 返回 Group 当前统计计数的快照
+func (g *Group) Stats() Stats {
+	st := g.counters(atomic.LoadInt64)
+	st.PendingExpiry, st.NextExpiry = g.mainCache.pendingExpiry()
+	st.CacheShards = g.mainCache.shardStats()
+	return st
+}
+
+// ResetStats 将所有计数清零并返回清零前的值，不影响缓存内容。
+// 每个计数在读取的同时被清零，因此定期调用 ResetStats 得到的是各区间内互不重叠的增量，
+// 不会丢失两次调用之间的计数。瞬时值字段与 Stats 相同。
+func (g *Group) ResetStats() Stats {
+	st := g.counters(func(addr *int64) int64 {
+		return atomic.SwapInt64(addr, 0)
+	})
+	st.PendingExpiry, st.NextExpiry = g.mainCache.pendingExpiry()
+	st.CacheShards = g.mainCache.shardStats()
+	return st
+}
+
+// CompressionRatio 返回压缩存储的值压缩前后的字节数之比，没有压缩过的值时返回 0
+func (s Stats) CompressionRatio() float64 {
+	if s.CompressedOut == 0 {
+		return 0
+	}
+	return float64(s.CompressedIn) / float64(s.CompressedOut)
+}
+
+// counters 用 read 读取每个计数
+func (g *Group) counters(read func(addr *int64) int64) Stats {
+	s := &g.stats
+	return Stats{
+		Gets:                read(&s.Gets),
+		CacheHits:           read(&s.CacheHits),
+		Loads:               read(&s.Loads),
+		LoadsAvoided:        read(&s.LoadsAvoided),
+		PeerLoads:           read(&s.PeerLoads),
+		PeerErrors:          read(&s.PeerErrors),
+		PeerFallbacks:       read(&s.PeerFallbacks),
+		LoadsWithoutPeers:   read(&s.LoadsWithoutPeers),
+		LocalLoads:          read(&s.LocalLoads),
+		LocalLoadErrs:       read(&s.LocalLoadErrs),
+		LoadRetries:         read(&s.LoadRetries),
+		Batches:             read(&s.Batches),
+		ReplicaPushes:       read(&s.ReplicaPushes),
+		ReplicaDropped:      read(&s.ReplicaDropped),
+		ReplicaErrors:       read(&s.ReplicaErrors),
+		Prefetches:          read(&s.Prefetches),
+		PrefetchHits:        read(&s.PrefetchHits),
+		PrefetchDropped:     read(&s.PrefetchDropped),
+		CompressedIn:        read(&s.CompressedIn),
+		CompressedOut:       read(&s.CompressedOut),
+		CompressSkipped:     read(&s.CompressSkipped),
+		PressureShrinks:     read(&s.PressureShrinks),
+		PressureRestores:    read(&s.PressureRestores),
+		TombstoneSuppressed: read(&s.TombstoneSuppressed),
+	}
+}
+
+// PoolStats 是 HTTPPool 的统计计数
+type PoolStats struct {
+	ServerRequests int64 // 作为服务端处理的 Get 请求
+	PeerFetches    int64 // 作为客户端成功从对等点获取的次数
+	RemoteHits     int64 // 其中对等点命中自身缓存的次数
+	RemoteLoads    int64 // 其中对等点回源加载的次数
+	RemotePeerHops int64 // 其中对等点又从其他节点获取的次数
+	RejectedBodies int64 // 因缺少 Content-Length 或超过大小上限而被拒绝的请求体
+	SlowBodies     int64 // 因读取超时而被拒绝的请求体
+
+	Throttled     map[string]int64 // 按 group 统计的因限流被拒绝的请求
+	GroupRequests map[string]int64 // 按 group 统计的作为服务端收到的请求，包括被拒绝的
+}
+
+// Stats 返回池当前统计计数的快照
+func (p *HTTPPool) Stats() PoolStats {
+	s := &p.stats
+	return PoolStats{
+		ServerRequests: atomic.LoadInt64(&s.ServerRequests),
+		PeerFetches:    atomic.LoadInt64(&s.PeerFetches),
+		RemoteHits:     atomic.LoadInt64(&s.RemoteHits),
+		RemoteLoads:    atomic.LoadInt64(&s.RemoteLoads),
+		RemotePeerHops: atomic.LoadInt64(&s.RemotePeerHops),
+		RejectedBodies: atomic.LoadInt64(&s.RejectedBodies),
+		SlowBodies:     atomic.LoadInt64(&s.SlowBodies),
+		Throttled:      p.throttledStats(),
+		GroupRequests:  p.groupRequests(),
+	}
+}
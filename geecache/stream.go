@@ -0,0 +1,103 @@
+package geecache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUseGetter 由 StreamGetter.GetStream 返回，表示这个键改用普通的 Get 加载，例如已知值很小时
+var ErrUseGetter = errors.New("geecache: use Get instead of stream")
+
+// StreamGetter 是 Getter 可选实现的接口，用于加载很大的值。GetStream 返回值的数据流和字节数，
+// 字节数未知时为 -1。Group 直接把流读入缓存的值，已知字节数时按它预分配（最多 1MB，之后随读取增长），
+// 不会像 Get 那样先拼出完整的切片再复制一份。设置了 WithMaxValueBytes 时，超过上限的值在读完之前就被放弃，
+// 加载返回 ErrValueTooLarge。GetStream 返回 ErrUseGetter 时改用 Get 或 GetCtx 加载。
+type StreamGetter interface {
+	GetStream(ctx context.Context, key string) (r io.ReadCloser, size int64, err error)
+}
+
+// maxStreamPrealloc 是按流声明的字节数预分配的上限，更大的值随读取的数据增长，
+// 声明了很大长度却很快结束的流不会先占用全部内存
+const maxStreamPrealloc = 1 << 20
+
+// getStream 读取 StreamGetter 返回的流。与 wrapGetter 一样，err 为 Uncacheable(nil) 时照常读取流，
+// 返回值的同时保留 err；无论结果如何，返回的 r 都会被关闭。
+func (g *Group) getStream(ctx context.Context, sg StreamGetter, key string) (ByteView, error) {
+	r, size, err := sg.GetStream(ctx, key)
+	if r != nil {
+		defer r.Close()
+	}
+	if err != nil && !uncacheableValue(err) {
+		return ByteView{}, err
+	}
+	if r == nil {
+		return ByteView{}, fmt.Errorf("geecache: GetStream returned no stream for %s", key)
+	}
+	if g.maxValueBytes > 0 && size > g.maxValueBytes {
+		return ByteView{}, ErrValueTooLarge
+	}
+
+	var src io.Reader = r
+	if g.maxValueBytes > 0 {
+		// 多读一个字节，用于发现超过上限的流
+		src = io.LimitReader(r, g.maxValueBytes+1)
+	}
+	var b []byte
+	if size >= 0 {
+		var rerr error
+		if b, rerr = readSized(src, size); rerr != nil {
+			return ByteView{}, fmt.Errorf("geecache: reading stream of %s: %v", key, rerr)
+		}
+		// 流比声明的更长时视为错误，避免缓存被截断的值
+		var extra [1]byte
+		if n, _ := src.Read(extra[:]); n > 0 {
+			return ByteView{}, fmt.Errorf("geecache: stream of %s is longer than %d bytes", key, size)
+		}
+	} else {
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(src); err != nil {
+			return ByteView{}, fmt.Errorf("geecache: reading stream of %s: %v", key, err)
+		}
+		// bytes.Buffer 按倍数增长，复制一份去掉多余的容量，缓存只按长度计算占用
+		if b = buf.Bytes(); cap(b) > len(b) {
+			b = cloneBytes(b)
+		}
+	}
+	if g.tooLarge(len(b)) {
+		return ByteView{}, ErrValueTooLarge
+	}
+	return ByteView{b: b}, err
+}
+
+// readSized 从 r 读取恰好 size 个字节，数据不足时返回 io.ErrUnexpectedEOF。
+// 先分配不超过 maxStreamPrealloc 的缓冲区，按倍数增长，最后一次增长到 size 为止，返回的切片没有多余的容量
+func readSized(r io.Reader, size int64) ([]byte, error) {
+	n := size
+	if n > maxStreamPrealloc {
+		n = maxStreamPrealloc
+	}
+	b := make([]byte, 0, n)
+	for int64(len(b)) < size {
+		if len(b) == cap(b) {
+			next := 2 * int64(cap(b))
+			if next > size {
+				next = size
+			}
+			grown := make([]byte, len(b), next)
+			copy(grown, b)
+			b = grown
+		}
+		m, err := io.ReadFull(r, b[len(b):cap(b)])
+		b = b[:len(b)+m]
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,59 @@
+package geecache
+
+import (
+	"sync"
+	"time"
+)
+
+// Ticker 是驱动过期清理的时钟，每从 C 收到一次就清理一次，Group 关闭时调用 Stop。
+// 测试可以注入手动触发的 Ticker 来确定性地驱动清理，配合 WithClock 无需等待真实时间。
+// Ticker 可以额外实现 Swept() 方法，每次清理完成后被调用，便于测试等待清理结束。
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realTicker 用 time.Ticker 实现 Ticker
+type realTicker struct {
+	t *time.Ticker
+}
+
+// NewTicker 返回每隔 d 触发一次的 Ticker
+func NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// sweeper 按注入的 Ticker 清理一个 Group，代替共享的 cleanupScheduler
+type sweeper struct {
+	ticker   Ticker
+	g        *Group
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func (s *sweeper) start() {
+	s.stop = make(chan struct{})
+	go s.loop()
+}
+
+func (s *sweeper) close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *sweeper) loop() {
+	defer s.ticker.Stop()
+	for {
+		select {
+		case <-s.ticker.C():
+			s.g.cleanExpired()
+			if n, ok := s.ticker.(interface{ Swept() }); ok {
+				n.Swept()
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// keyTimeout 是一条按键匹配的加载超时规则
+type keyTimeout struct {
+	match   func(key string) bool
+	timeout time.Duration
+}
+
+// timeoutFor 返回键的加载超时，0 表示不限制
+func (g *Group) timeoutFor(key string) time.Duration {
+	for _, kt := range g.keyTimeouts {
+		if kt.match(key) {
+			return kt.timeout
+		}
+	}
+	return g.loadTimeout
+}
+
+// defaultMaxAbandonedLoads 是超时后仍未返回的 Getter 调用数的默认上限
+const defaultMaxAbandonedLoads = 64
+
+// 调用 Getter 的协程的状态
+const (
+	asyncWaiting int32 = iota
+	asyncAbandoned
+	asyncDone
+)
+
+// getAsync 在新的协程中调用不支持 ctx 的加载函数 get，ctx 结束时不再等待它返回。
+// 被放弃但仍未返回的调用计入 abandonedLoads，达到上限时直接返回 ErrLoadBacklog。
+func (g *Group) getAsync(ctx context.Context, key string, get func() ([]byte, error)) ([]byte, error) {
+	max := g.maxAbandonedLoads
+	if max <= 0 {
+		max = defaultMaxAbandonedLoads
+	}
+	if atomic.LoadInt32(&g.abandonedLoads) >= max {
+		return nil, fmt.Errorf("geecache: load %s: %w", key, ErrLoadBacklog)
+	}
+	type result struct {
+		b   []byte
+		err error
+	}
+	ch := make(chan result, 1)
+	state := asyncWaiting
+	go func() {
+		b, err := get()
+		ch <- result{b, err}
+		if !atomic.CompareAndSwapInt32(&state, asyncWaiting, asyncDone) {
+			// 调用者已经放弃
+			atomic.AddInt32(&g.abandonedLoads, -1)
+		}
+	}()
+	select {
+	case r := <-ch:
+		return r.b, r.err
+	case <-ctx.Done():
+		// 先计数，协程看到 asyncAbandoned 后才会减去
+		atomic.AddInt32(&g.abandonedLoads, 1)
+		if !atomic.CompareAndSwapInt32(&state, asyncWaiting, asyncAbandoned) {
+			// Getter 恰好在 ctx 结束时返回
+			atomic.AddInt32(&g.abandonedLoads, -1)
+			r := <-ch
+			return r.b, r.err
+		}
+		return nil, fmt.Errorf("geecache: load %s: %w", key, ctx.Err())
+	}
+}
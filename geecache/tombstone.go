@@ -0,0 +1,83 @@
+package geecache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 墓碑数量不低于该值时才考虑清理
+const minTombstoneSweep = 64
+
+// tombstones 记录最近被删除的键。在窗口期内，加载结果和副本写入不会写回缓存，
+// 避免删除之前开始的加载或其他节点上的旧副本把已删除的值重新填充回来。
+// 零值不可用，nil 表示未开启。
+type tombstones struct {
+	mu      sync.Mutex
+	window  time.Duration
+	deleted map[string]time.Time // 键到删除时间
+	sweepAt int                  // 墓碑数量达到该值时清理过期的墓碑
+}
+
+func newTombstones(window time.Duration) *tombstones {
+	return &tombstones{window: window, deleted: make(map[string]time.Time), sweepAt: minTombstoneSweep}
+}
+
+// add 记录键在 now 被删除
+func (t *tombstones) add(key string, now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deleted[cloneString(key)] = now
+	if len(t.deleted) >= t.sweepAt {
+		t.sweepLocked(now)
+		t.sweepAt = 2*len(t.deleted) + minTombstoneSweep
+	}
+}
+
+// active 判断键在 now 是否仍处于删除后的窗口期内
+func (t *tombstones) active(key string, now time.Time) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	at, ok := t.deleted[key]
+	if !ok {
+		return false
+	}
+	if now.Sub(at) >= t.window {
+		delete(t.deleted, key)
+		return false
+	}
+	return true
+}
+
+// clear 移除键的墓碑，用于删除之后的显式写入
+func (t *tombstones) clear(key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.deleted, key)
+	t.mu.Unlock()
+}
+
+func (t *tombstones) sweepLocked(now time.Time) {
+	for key, at := range t.deleted {
+		if now.Sub(at) >= t.window {
+			delete(t.deleted, key)
+		}
+	}
+}
+
+// buried 判断键是否处于删除后的窗口期内，是则计数，调用方应放弃写入缓存
+func (g *Group) buried(key string) bool {
+	if !g.tombstones.active(key, g.now()) {
+		return false
+	}
+	atomic.AddInt64(&g.stats.TombstoneSuppressed, 1)
+	return true
+}
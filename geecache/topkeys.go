@@ -0,0 +1,197 @@
+package geecache
+
+import (
+	"container/heap"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// topKeysPath 是查看回源热点键的调试接口，完整路径为 <basePath>_topkeys/<group>?n=10
+const topKeysPath = "_topkeys"
+
+// defaultTopKeys 是调试接口未指定 n 时返回的键数
+const defaultTopKeys = 10
+
+// KeyCount 是一个键及其被回源加载的次数。Count 是保证的下界，真实次数不少于它。
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// loadTracker 用 Space-Saving 算法统计回源加载最多的键。每个窗口最多记录 capacity 个键，
+// 内存与键的总数无关；窗口内加载次数超过总次数 1/capacity 的键一定会被保留。
+// 窗口到期时当前的统计成为上一窗口，查询时合并两个窗口，近似于一个滑动窗口。
+type loadTracker struct {
+	mu       sync.Mutex
+	capacity int
+	window   time.Duration
+	start    time.Time
+	cur      *spaceSaving
+	prev     *spaceSaving
+}
+
+func newLoadTracker(capacity int, window time.Duration, now time.Time) *loadTracker {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &loadTracker{
+		capacity: capacity,
+		window:   window,
+		start:    now,
+		cur:      newSpaceSaving(capacity),
+	}
+}
+
+// record 记录一次回源加载
+func (t *loadTracker) record(key string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rotateLocked(now)
+	t.cur.add(key)
+}
+
+// top 返回两个窗口内加载次数最多的 n 个键，按次数从多到少排序
+func (t *loadTracker) top(n int, now time.Time) []KeyCount {
+	t.mu.Lock()
+	t.rotateLocked(now)
+	counts := make(map[string]int64, 2*t.capacity)
+	for _, s := range []*spaceSaving{t.prev, t.cur} {
+		if s == nil {
+			continue
+		}
+		for _, c := range s.heap {
+			counts[c.key] += c.count - c.err
+		}
+	}
+	t.mu.Unlock()
+
+	list := make([]KeyCount, 0, len(counts))
+	for key, count := range counts {
+		list = append(list, KeyCount{key, count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Key < list[j].Key
+	})
+	if n >= 0 && n < len(list) {
+		list = list[:n]
+	}
+	return list
+}
+
+func (t *loadTracker) rotateLocked(now time.Time) {
+	if t.window <= 0 || now.Sub(t.start) < t.window {
+		return
+	}
+	if now.Sub(t.start) < 2*t.window {
+		t.prev = t.cur
+	} else {
+		// 超过两个窗口没有记录，之前的统计都已过时
+		t.prev = nil
+	}
+	t.cur = newSpaceSaving(t.capacity)
+	t.start = now
+}
+
+// spaceSaving 是容量固定的计数器集合，满时新键替换次数最少的键并继承它的次数，
+// 继承的部分记为误差，count-err 是真实次数的下界
+type spaceSaving struct {
+	capacity int
+	index    map[string]*ssCounter
+	heap     ssHeap // 按次数排列的最小堆
+}
+
+type ssCounter struct {
+	key   string
+	count int64
+	err   int64 // 从被替换的键继承的次数
+	pos   int   // 在堆中的位置
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, index: make(map[string]*ssCounter, capacity)}
+}
+
+func (s *spaceSaving) add(key string) {
+	if c, ok := s.index[key]; ok {
+		c.count++
+		heap.Fix(&s.heap, c.pos)
+		return
+	}
+	key = cloneString(key)
+	if len(s.heap) < s.capacity {
+		c := &ssCounter{key: key, count: 1}
+		s.index[key] = c
+		heap.Push(&s.heap, c)
+		return
+	}
+	c := s.heap[0]
+	delete(s.index, c.key)
+	c.key = key
+	c.err = c.count
+	c.count++
+	s.index[key] = c
+	heap.Fix(&s.heap, 0)
+}
+
+type ssHeap []*ssCounter
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *ssHeap) Push(x interface{}) {
+	c := x.(*ssCounter)
+	c.pos = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *ssHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// TopLoadedKeys 返回最近回源加载次数最多的 n 个键，用于找出 TTL 过短或无法缓存的键。
+// 需要用 WithLoadTracking 开启，否则返回 nil。
+func (g *Group) TopLoadedKeys(n int) []KeyCount {
+	if g.loadTracker == nil {
+		return nil
+	}
+	return g.loadTracker.top(n, g.now())
+}
+
+// serveTopKeys 以 JSON 返回 group 回源加载最多的键，与普通请求一样受 group 的服务端配置限制
+func (p *HTTPPool) serveTopKeys(w http.ResponseWriter, r *http.Request, name string) {
+	group := p.lookupGroup(name)
+	if group == nil {
+		http.Error(w, "no such group: "+name, http.StatusNotFound)
+		return
+	}
+	if _, ok := p.admit(w, r, name); !ok {
+		return
+	}
+	n := defaultTopKeys
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 0 {
+			http.Error(w, "bad n: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+	top := group.TopLoadedKeys(n)
+	if top == nil {
+		top = []KeyCount{}
+	}
+	writeJSON(w, http.StatusOK, top)
+}
@@ -0,0 +1,43 @@
+package geecache
+
+import "errors"
+
+// uncacheableError 包装 Getter 返回的错误，表示这次加载的结果只交给调用者，不写入缓存
+type uncacheableError struct {
+	err error
+}
+
+func (e *uncacheableError) Error() string {
+	if e.err == nil {
+		return "geecache: uncacheable result"
+	}
+	return e.err.Error()
+}
+
+func (e *uncacheableError) Unwrap() error {
+	return e.err
+}
+
+// Uncacheable 包装 Getter 返回的错误，使这次加载的结果不写入任何缓存，也不会被重试。
+// err 为 nil 时 Getter 同时返回的值照常交给调用者，以及合并到这次加载的其他调用者，
+// 例如 Getter 发现 ctx 已取消、只拿到了部分数据时；err 不为 nil 时调用者得到 err，
+// ErrNotFound 也不会被负缓存。PeerGetter.Get 也可以在填好 out 后返回 Uncacheable(nil)，
+// 表示拥有者没有缓存这个值，请求方不会把它写入 hotCache。
+func Uncacheable(err error) error {
+	return &uncacheableError{err: err}
+}
+
+// splitUncacheable 去掉 Uncacheable 的包装，返回原来的错误，以及结果是否可以缓存
+func splitUncacheable(err error) (cause error, cacheable bool) {
+	var u *uncacheableError
+	if errors.As(err, &u) {
+		return u.err, false
+	}
+	return err, true
+}
+
+// uncacheableValue 判断 err 是否为 Uncacheable(nil)，即 Getter 返回的值有效但不应缓存
+func uncacheableValue(err error) bool {
+	cause, cacheable := splitUncacheable(err)
+	return !cacheable && cause == nil
+}
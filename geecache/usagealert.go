@@ -0,0 +1,91 @@
+package geecache
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultAlertHigh     = 0.9
+	defaultAlertLow      = 0.8
+	defaultAlertCooldown = time.Minute
+)
+
+// usageAlerts 在 mainCache 的用量越过高低水位时通知调用者，在淘汰频繁发生之前给出预警
+type usageAlerts struct {
+	high, low float64 // 占 cacheBytes 的比例
+	cooldown  time.Duration
+	onHigh    func(used, max int64)
+	onLow     func(used, max int64)
+
+	mu       sync.Mutex
+	above    bool // 上次越过的是高水位
+	lastHigh time.Time
+	lastLow  time.Time
+}
+
+// usageAlerts 返回 mainCache 的用量通知设置，第一次调用时按默认值创建，只在构造 Group 时调用
+func (g *Group) usageAlerts() *usageAlerts {
+	if g.mainCache.alerts == nil {
+		g.mainCache.alerts = &usageAlerts{
+			high:     defaultAlertHigh,
+			low:      defaultAlertLow,
+			cooldown: defaultAlertCooldown,
+		}
+	}
+	return g.mainCache.alerts
+}
+
+// OnHighWatermark 在 mainCache 的用量升到高水位（默认为 cacheBytes 的 90%）以上时调用 fn，
+// 每次越过只调用一次，且两次调用之间至少间隔冷却时间（默认一分钟），冷却期间的越过不通知。
+// fn 在缓存锁之外、写入缓存的协程中调用。
+func OnHighWatermark(fn func(used, max int64)) GroupOption {
+	return func(g *Group) {
+		g.usageAlerts().onHigh = fn
+	}
+}
+
+// OnLowWatermark 在越过高水位之后，mainCache 的用量回落到低水位（默认为 cacheBytes 的 80%）以下时调用 fn，
+// 冷却规则与 OnHighWatermark 相同。
+func OnLowWatermark(fn func(used, max int64)) GroupOption {
+	return func(g *Group) {
+		g.usageAlerts().onLow = fn
+	}
+}
+
+// WithUsageAlerts 设置 OnHighWatermark 和 OnLowWatermark 的高低水位比例和冷却时间，
+// low 应小于 high 以免在阈值附近反复通知。只影响通知，批量淘汰见 WithEvictionWatermarks
+func WithUsageAlerts(high, low float64, cooldown time.Duration) GroupOption {
+	return func(g *Group) {
+		w := g.usageAlerts()
+		w.high, w.low, w.cooldown = high, low, cooldown
+	}
+}
+
+// observe 根据当前用量判断是否越过水位，需要时在锁外调用回调
+func (w *usageAlerts) observe(used, max int64, now time.Time) {
+	if w == nil || max <= 0 {
+		return
+	}
+	ratio := float64(used) / float64(max)
+	var fn func(used, max int64)
+	w.mu.Lock()
+	switch {
+	case !w.above && ratio >= w.high:
+		w.above = true
+		if w.lastHigh.IsZero() || now.Sub(w.lastHigh) >= w.cooldown {
+			w.lastHigh = now
+			fn = w.onHigh
+		}
+	case w.above && ratio < w.low:
+		w.above = false
+		if w.lastLow.IsZero() || now.Sub(w.lastLow) >= w.cooldown {
+			w.lastLow = now
+			fn = w.onLow
+		}
+	}
+	w.mu.Unlock()
+	if fn != nil {
+		fn(used, max)
+	}
+}
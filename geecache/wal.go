@@ -0,0 +1,475 @@
+package geecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 预写日志（WAL）记录通过 Set 和 Delete 显式写入的数据，进程崩溃后可以用 RecoverFromWAL 恢复。
+// 通过 Getter 加载的数据可以重新获取，不写入日志；淘汰也不会删除日志记录。
+//
+// 每条记录的格式为：
+//
+//	uint32 负载长度 | 负载 | uint32 负载的 CRC32
+//
+// 负载为：
+//
+//	操作 | uvarint 键长度 | 键 | varint 过期时间（Unix 纳秒，0 表示没有 TTL） | [元数据] | 值
+//
+// 只有 walOpSetMeta 带有元数据，格式为 uvarint 项数，每项为 uvarint 长度加键、uvarint 长度加值。
+//
+// 设置了 RotateBytes 时，写满的日志文件依次改名为 path.1、path.2……，编号越大越新，
+// 当前的记录总是追加到 path。
+
+const (
+	walOpSet     byte = 1
+	walOpDelete  byte = 2
+	walOpSetMeta byte = 3
+)
+
+// ErrCorruptWAL 表示日志中间的记录损坏。文件末尾不完整的记录视为崩溃时未写完，不返回该错误
+var ErrCorruptWAL = errors.New("geecache: corrupt wal record")
+
+// WALOptions 配置预写日志
+type WALOptions struct {
+	// 为 true 时每次写入后都调用 fsync，否则由操作系统决定何时落盘
+	Sync bool
+	// 大于 0 时，所有日志文件的总大小超过该字节数后会被压缩为一个文件，只保留仍然有效的键
+	MaxBytes int64
+	// 大于 0 时，当前日志文件超过该字节数后被轮转，之后的记录写入新的文件
+	RotateBytes int64
+}
+
+type wal struct {
+	mu       sync.Mutex
+	path     string
+	opts     WALOptions
+	f        *os.File
+	segments []string // 已轮转的文件，从旧到新
+	nextSeq  int      // 下一个轮转文件的编号
+	active   int64    // 当前文件的大小
+	size     int64    // 所有文件的总大小
+	compact  int64    // 下次压缩的大小阈值
+	now      func() time.Time
+}
+
+type walRecord struct {
+	op       byte
+	key      string
+	expireAt time.Time
+	meta     map[string]string
+	value    []byte
+}
+
+// OpenWAL 为 Group 开启预写日志，之后的 Set 和 Delete 会先追加到 path 再修改缓存。
+// 文件末尾不完整的记录会被截断；日志中间有损坏的记录时返回 ErrCorruptWAL，不修改任何文件。
+// 应在 RecoverFromWAL 之后、开始服务之前调用。
+func (g *Group) OpenWAL(path string, opts WALOptions) error {
+	if g.wal != nil {
+		return fmt.Errorf("geecache: wal already open for group %s", g.name)
+	}
+	segments, seq, err := walSegments(path)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, seg := range segments {
+		n, err := scanWAL(seg, func(walRecord) {})
+		if err != nil {
+			return err
+		}
+		size += n
+	}
+	valid, err := scanWAL(path, func(walRecord) {})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err = f.Truncate(valid); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err = f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	g.wal = &wal{path: path, opts: opts, f: f, segments: segments, nextSeq: seq + 1,
+		active: valid, size: size + valid, compact: opts.MaxBytes, now: g.now}
+	return nil
+}
+
+// RecoverFromWAL 依次重放轮转的文件和 path 中的记录并写入本地缓存，返回重放的记录数。
+// 已过期的记录被跳过；末尾损坏或不完整的记录被忽略，视为崩溃时未写完；
+// 日志中间有损坏的记录时返回已重放的记录数和 ErrCorruptWAL。
+func (g *Group) RecoverFromWAL(path string) (int, error) {
+	segments, _, err := walSegments(path)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	replay := func(rec walRecord) {
+		n++
+		if rec.op == walOpDelete {
+			g.mainCache.remove(rec.key)
+			return
+		}
+		var ttl time.Duration
+		if !rec.expireAt.IsZero() {
+			if ttl = rec.expireAt.Sub(g.now()); ttl <= 0 {
+				g.mainCache.remove(rec.key)
+				return
+			}
+		}
+		g.mainCache.add(rec.key, ByteView{b: rec.value, meta: rec.meta}, ttl)
+	}
+	for _, seg := range segments {
+		if _, err := scanWAL(seg, replay); err != nil {
+			return n, err
+		}
+	}
+	_, err = scanWAL(path, replay)
+	return n, err
+}
+
+// walSegments 返回 path 已轮转的文件，按编号从旧到新排列，以及最大的编号
+func walSegments(path string) ([]string, int, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, 0, err
+	}
+	seqs := make([]int, 0, len(matches))
+	for _, m := range matches {
+		// 跳过压缩用的临时文件等不是编号的文件
+		if seq, err := strconv.Atoi(m[len(path)+1:]); err == nil && seq > 0 {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	segments := make([]string, len(seqs))
+	for i, seq := range seqs {
+		segments[i] = walSegment(path, seq)
+	}
+	last := 0
+	if len(seqs) > 0 {
+		last = seqs[len(seqs)-1]
+	}
+	return segments, last, nil
+}
+
+func walSegment(path string, seq int) string {
+	return path + "." + strconv.Itoa(seq)
+}
+
+// CompactWAL 重写日志，每个键只保留最新且未过期的值
+func (g *Group) CompactWAL() error {
+	if g.wal == nil {
+		return nil
+	}
+	g.wal.mu.Lock()
+	defer g.wal.mu.Unlock()
+	return g.wal.compactLocked()
+}
+
+// logSet 在写入缓存前记录一次 Set，ttl 为负数时记录为删除
+func (g *Group) logSet(key string, value ByteView, ttl time.Duration) {
+	if g.wal == nil {
+		return
+	}
+	rec := walRecord{op: walOpSet, key: key, value: value.b}
+	if len(value.meta) > 0 {
+		rec.op, rec.meta = walOpSetMeta, value.meta
+	}
+	switch {
+	case ttl < 0:
+		rec = walRecord{op: walOpDelete, key: key}
+	case ttl > 0:
+		rec.expireAt = g.now().Add(ttl)
+	}
+	if err := g.wal.append(rec); err != nil {
+		logger.Printf("[GeeCache] Failed to append to wal %s: %v", g.wal.path, err)
+	}
+}
+
+func (w *wal) append(rec walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	buf := encodeWALRecord(rec)
+	if _, err := w.f.Write(buf); err != nil {
+		return err
+	}
+	w.size += int64(len(buf))
+	w.active += int64(len(buf))
+	if w.opts.Sync {
+		if err := w.f.Sync(); err != nil {
+			return err
+		}
+	}
+	if w.compact > 0 && w.size > w.compact {
+		return w.compactLocked()
+	}
+	if w.opts.RotateBytes > 0 && w.active > w.opts.RotateBytes {
+		return w.rotateLocked()
+	}
+	return nil
+}
+
+// rotateLocked 把当前文件改名为下一个编号的轮转文件，之后的记录写入新的 path
+func (w *wal) rotateLocked() error {
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	seg := walSegment(w.path, w.nextSeq)
+	if err := os.Rename(w.path, seg); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		// 保持原来的文件句柄，之后的记录继续写入改名后的文件
+		return err
+	}
+	w.f.Close()
+	w.f = f
+	w.segments = append(w.segments, seg)
+	w.nextSeq++
+	w.active = 0
+	return nil
+}
+
+// compactLocked 把有效的记录写入临时文件后原子地替换日志，再删除轮转的文件
+func (w *wal) compactLocked() error {
+	live := make(map[string]walRecord)
+	var order []string
+	now := w.now()
+	collect := func(rec walRecord) {
+		if _, ok := live[rec.key]; !ok {
+			order = append(order, rec.key)
+		}
+		live[rec.key] = rec
+	}
+	for _, seg := range w.segments {
+		if _, err := scanWAL(seg, collect); err != nil {
+			return err
+		}
+	}
+	if _, err := scanWAL(w.path, collect); err != nil {
+		return err
+	}
+
+	tmp := w.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	var size int64
+	for _, key := range order {
+		rec := live[key]
+		if rec.op == walOpDelete || (!rec.expireAt.IsZero() && !rec.expireAt.After(now)) {
+			if len(w.segments) == 0 {
+				continue
+			}
+			// 删除轮转文件之前崩溃时，重放旧文件中的值之后还要再删除
+			rec = walRecord{op: walOpDelete, key: key}
+		}
+		n, _ := bw.Write(encodeWALRecord(rec))
+		size += int64(n)
+	}
+	if err = bw.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, w.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	w.f.Close()
+	w.f = f
+	for _, seg := range w.segments {
+		os.Remove(seg)
+	}
+	w.segments = nil
+	w.size, w.active = size, size
+	// 压缩后仍然很大时提高阈值，避免每次写入都压缩
+	if w.opts.MaxBytes > 0 {
+		w.compact = w.opts.MaxBytes
+		if 2*size > w.compact {
+			w.compact = 2 * size
+		}
+	}
+	return nil
+}
+
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+func encodeWALRecord(rec walRecord) []byte {
+	var exp int64
+	if !rec.expireAt.IsZero() {
+		exp = rec.expireAt.UnixNano()
+	}
+	payload := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(rec.key)+len(rec.value))
+	payload = append(payload, rec.op)
+	payload = appendUvarint(payload, uint64(len(rec.key)))
+	payload = append(payload, rec.key...)
+	payload = appendVarint(payload, exp)
+	if rec.op == walOpSetMeta {
+		keys := make([]string, 0, len(rec.meta))
+		for k := range rec.meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		payload = appendUvarint(payload, uint64(len(keys)))
+		for _, k := range keys {
+			payload = appendUvarint(payload, uint64(len(k)))
+			payload = append(payload, k...)
+			payload = appendUvarint(payload, uint64(len(rec.meta[k])))
+			payload = append(payload, rec.meta[k]...)
+		}
+	}
+	payload = append(payload, rec.value...)
+
+	buf := make([]byte, 4, 8+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	buf = append(buf, payload...)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(payload))
+	return append(buf, sum[:]...)
+}
+
+func decodeWALPayload(payload []byte) (walRecord, error) {
+	if len(payload) < 1 {
+		return walRecord{}, ErrCorruptWAL
+	}
+	rec := walRecord{op: payload[0]}
+	if rec.op != walOpSet && rec.op != walOpDelete && rec.op != walOpSetMeta {
+		return walRecord{}, ErrCorruptWAL
+	}
+	p := payload[1:]
+	key, p, ok := readWALString(p)
+	if !ok {
+		return walRecord{}, ErrCorruptWAL
+	}
+	rec.key = key
+	exp, n := binary.Varint(p)
+	if n <= 0 {
+		return walRecord{}, ErrCorruptWAL
+	}
+	if exp != 0 {
+		rec.expireAt = time.Unix(0, exp)
+	}
+	p = p[n:]
+	if rec.op == walOpSetMeta {
+		count, n := binary.Uvarint(p)
+		// 每项至少占两个字节
+		if n <= 0 || count > uint64(len(p[n:])/2) {
+			return walRecord{}, ErrCorruptWAL
+		}
+		p = p[n:]
+		rec.meta = make(map[string]string, count)
+		for i := uint64(0); i < count; i++ {
+			var k, v string
+			if k, p, ok = readWALString(p); ok {
+				v, p, ok = readWALString(p)
+			}
+			if !ok {
+				return walRecord{}, ErrCorruptWAL
+			}
+			rec.meta[k] = v
+		}
+	}
+	rec.value = p
+	return rec, nil
+}
+
+// readWALString 读取 uvarint 长度加内容的字符串，返回剩余的字节
+func readWALString(p []byte) (string, []byte, bool) {
+	l, n := binary.Uvarint(p)
+	if n <= 0 || uint64(len(p)-n) < l {
+		return "", nil, false
+	}
+	p = p[n:]
+	return string(p[:l]), p[l:], true
+}
+
+// scanWAL 依次读取 path 中的完整记录，返回最后一条有效记录之后的偏移量。
+// 损坏的记录延伸到文件末尾时视为崩溃时未写完，停止读取但不视为错误；
+// 损坏的记录之后还有数据时返回 ErrCorruptWAL。
+func scanWAL(path string, fn func(walRecord)) (valid int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := fi.Size()
+	r := bufio.NewReader(f)
+	var head [4]byte
+	for valid < end {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return valid, nil
+		}
+		size := int64(binary.BigEndian.Uint32(head[:]))
+		next := valid + int64(len(head)) + size + 4
+		if next > end {
+			// 长度超出文件末尾：最后一条记录没有写完
+			return valid, nil
+		}
+		if size > maxRecordBytes {
+			return valid, fmt.Errorf("%w: %s at offset %d", ErrCorruptWAL, path, valid)
+		}
+		buf := make([]byte, size+4)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return valid, err
+		}
+		payload := buf[:size]
+		rec, err := walRecord{}, ErrCorruptWAL
+		if crc32.ChecksumIEEE(payload) == binary.BigEndian.Uint32(buf[size:]) {
+			rec, err = decodeWALPayload(payload)
+		}
+		if err != nil {
+			if next == end {
+				return valid, nil
+			}
+			return valid, fmt.Errorf("%w: %s at offset %d", ErrCorruptWAL, path, valid)
+		}
+		fn(rec)
+		valid = next
+	}
+	return valid, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
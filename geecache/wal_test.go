@@ -0,0 +1,209 @@
+package geecache
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWALRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geecache-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scores.wal")
+
+	now := time.Unix(1000, 0)
+	clock := WithClock(func() time.Time { return now })
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	})
+
+	gee := NewGroup("wal", 2<<10, getter, clock)
+	if err := gee.OpenWAL(path, WALOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	gee.Set("Tom", []byte("630"), 0)
+	gee.Set("Jack", []byte("589"), time.Minute)
+	gee.Set("Sam", []byte("567"), time.Second)
+	gee.Set("Tom", []byte("631"), 0)
+	gee.Set("Lily", []byte("1"), 0)
+	gee.Set("Lily", nil, -1)
+	gee.SetWithMeta("Lucy", []byte("1"), 0, map[string]string{"etag": `"l1"`, "type": "score"})
+	gee.Close()
+
+	// 模拟崩溃时写了一半的记录
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte{0, 0, 0, 9, walOpSet, 3})
+	f.Close()
+
+	now = now.Add(2 * time.Second)
+	gee = NewGroup("wal", 2<<10, getter, clock)
+	n, err := gee.RecoverFromWAL(path)
+	if err != nil || n != 7 {
+		t.Fatalf("expect 7 records replayed, got %d %v", n, err)
+	}
+	if _, info, err := gee.GetWithInfo("Lucy"); err != nil || !reflect.DeepEqual(info.Meta, map[string]string{"etag": `"l1"`, "type": "score"}) {
+		t.Fatalf("expect metadata recovered, got %v %v", info.Meta, err)
+	}
+	for k, v := range map[string]string{"Tom": "631", "Jack": "589"} {
+		if view, err := gee.Get(k); err != nil || view.String() != v {
+			t.Fatalf("expect %s=%s after recovery, got %v %v", k, v, view, err)
+		}
+	}
+	for _, k := range []string{"Sam", "Lily"} {
+		if ok, _ := gee.Has(k); ok {
+			t.Fatalf("expect %s to be gone after recovery", k)
+		}
+	}
+
+	// 重新打开时截断损坏的尾部，新的记录可以被正常读取
+	if err := gee.OpenWAL(path, WALOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	gee.Set("Lucy", []byte("2"), 0)
+	if err := gee.CompactWAL(); err != nil {
+		t.Fatal(err)
+	}
+	gee.Close()
+
+	gee = NewGroup("wal", 2<<10, getter, clock)
+	if n, err := gee.RecoverFromWAL(path); err != nil || n != 3 {
+		t.Fatalf("expect 3 live records after compaction, got %d %v", n, err)
+	}
+	if view, err := gee.Get("Lucy"); err != nil || view.String() != "2" {
+		t.Fatalf("expect Lucy=2 after compaction, got %v %v", view, err)
+	}
+}
+
+func TestWALCorruptRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geecache-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "corrupt.wal")
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	})
+
+	gee := NewGroup("wal-corrupt", 2<<10, getter)
+	if err := gee.OpenWAL(path, WALOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	gee.Set("Tom", []byte("630"), 0)
+	gee.Set("Jack", []byte("589"), 0)
+	gee.Close()
+
+	// 损坏第一条记录的值，之后还有完整的记录，不能当作未写完的尾部截断
+	b, _ := ioutil.ReadFile(path)
+	b[10] ^= 0xff
+	ioutil.WriteFile(path, b, 0644)
+
+	gee = NewGroup("wal-corrupt", 2<<10, getter)
+	defer gee.Close()
+	if _, err := gee.RecoverFromWAL(path); !errors.Is(err, ErrCorruptWAL) {
+		t.Fatalf("expect ErrCorruptWAL, got %v", err)
+	}
+	if err := gee.OpenWAL(path, WALOptions{}); !errors.Is(err, ErrCorruptWAL) {
+		t.Fatalf("expect OpenWAL to refuse a corrupt log, got %v", err)
+	}
+	if fi, _ := os.Stat(path); fi.Size() != int64(len(b)) {
+		t.Fatalf("expect the corrupt log left untouched, got %d bytes", fi.Size())
+	}
+}
+
+func TestWALRotateBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geecache-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rotate.wal")
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	})
+
+	gee := NewGroup("wal-rotate", 2<<10, getter)
+	if err := gee.OpenWAL(path, WALOptions{RotateBytes: 64}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		gee.Set(fmt.Sprintf("k%02d", i), []byte("0123456789"), 0)
+	}
+	gee.Set("k00", nil, -1)
+	gee.Close()
+	segments, _, err := walSegments(path)
+	if err != nil || len(segments) < 2 {
+		t.Fatalf("expect the log rotated into several files, got %v %v", segments, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() > 64 {
+		t.Fatalf("expect the active file below the rotation size, got %v %v", fi, err)
+	}
+
+	// 重放所有文件，重新打开后继续编号，压缩后合并为一个文件
+	gee = NewGroup("wal-rotate", 2<<10, getter)
+	if n, err := gee.RecoverFromWAL(path); err != nil || n != 21 {
+		t.Fatalf("expect 21 records replayed from all files, got %d %v", n, err)
+	}
+	if ok, _ := gee.Has("k00"); ok {
+		t.Fatalf("expect the delete in a later file to win")
+	}
+	if err := gee.OpenWAL(path, WALOptions{RotateBytes: 64}); err != nil {
+		t.Fatal(err)
+	}
+	gee.Set("k20", []byte("0123456789"), 0)
+	gee.Set("k21", []byte("0123456789"), 0)
+	if more, _, _ := walSegments(path); len(more) <= len(segments) {
+		t.Fatalf("expect rotation to continue after reopening, got %v", more)
+	}
+	if err := gee.CompactWAL(); err != nil {
+		t.Fatal(err)
+	}
+	gee.Close()
+	if left, _, _ := walSegments(path); len(left) != 0 {
+		t.Fatalf("expect compaction to remove rotated files, got %v", left)
+	}
+	gee = NewGroup("wal-rotate", 2<<10, getter)
+	defer gee.Close()
+	// 合并时有轮转的文件，被删除的 k00 保留为删除记录
+	if n, err := gee.RecoverFromWAL(path); err != nil || n != 22 {
+		t.Fatalf("expect 21 live records and a delete after compaction, got %d %v", n, err)
+	}
+	if ok, _ := gee.Has("k00"); ok {
+		t.Fatalf("expect k00 to stay deleted after compaction")
+	}
+}
+
+func TestWALCompactBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geecache-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rotate.wal")
+
+	gee := NewGroup("wal-rotate", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	if err := gee.OpenWAL(path, WALOptions{MaxBytes: 256}); err != nil {
+		t.Fatal(err)
+	}
+	defer gee.Close()
+	for i := 0; i < 1000; i++ {
+		gee.Set("Tom", []byte("0123456789"), 0)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > 256 {
+		t.Fatalf("expect wal to be compacted below 256 bytes, got %d", fi.Size())
+	}
+}
@@ -6,6 +6,9 @@ $ curl "http://localhost:9999/api?key=Tom"
 
 $ curl "http://localhost:9999/api?key=kkk"
 kkk not exist
+
+$ curl -I "http://localhost:9999/api/scores/Tom"
+HTTP/1.1 200 OK
 */
 
 import (
@@ -14,6 +17,7 @@ import (
 	"geecache"
 	"log"
 	"net/http"
+	"strings"
 )
 
 var db = map[string]string{
@@ -54,6 +58,31 @@ func startAPIServer(apiAddr string, gee *geecache.Group) {
 			w.Write(view.ByteSlice())
 
 		}))
+	// HEAD /api/<group>/<key> 只判断键是否已被缓存，不触发加载
+	http.Handle("/api/", http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodHead {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/"), "/", 2)
+			if len(parts) != 2 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			g := geecache.GetGroup(parts[0])
+			if g == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			ok, err := g.Has(parts[1])
+			switch {
+			case err != nil:
+				w.WriteHeader(http.StatusInternalServerError)
+			case !ok:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
 	log.Println("fontend server is running at", apiAddr)
 	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
 